@@ -0,0 +1,9 @@
+// Command weather-go serves the current temperature of a city averaged over
+// several weather providers.
+package main
+
+import "github.com/im-kulikov/weather-go-external-api/internal/server"
+
+func main() {
+  server.Main()
+}
@@ -0,0 +1,3 @@
+module github.com/im-kulikov/weather-go-external-api
+
+go 1.23
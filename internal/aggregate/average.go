@@ -0,0 +1,55 @@
+// Package aggregate combines provider readings into one temperature:
+// weighting, outlier rejection and sampling of who to ask; and their wind
+// into one wind.
+package aggregate
+
+import (
+  "errors"
+  "fmt"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+// ErrAllExcluded is an average with nothing left to average.
+var ErrAllExcluded = errors.New("every reading was excluded from the average")
+
+// Average is the weighted aggregate of readings, leaving out excluded
+// outliers; like temperature, any failed provider fails the aggregate.
+// The error is the first provider's that failed on its own, rather than
+// one a fail-fast fan-out cancelled.
+func Average(rs []providers.Reading) (float64, error) {
+  var failed error
+  for _, r := range rs {
+    switch {
+    case r.Err != nil && !errors.Is(r.Err, providers.ErrCancelled):
+      return 0, fmt.Errorf("%s: %w", r.Provider, r.Err)
+    case r.Err == nil && r.Error != "":
+      return 0, fmt.Errorf("%s: %s", r.Provider, r.Error)
+    case r.Err != nil && failed == nil:
+      failed = fmt.Errorf("%s: %w", r.Provider, r.Err)
+    }
+  }
+
+  if failed != nil {
+    return 0, failed
+  }
+
+  sum, wsum := 0.0, 0.0
+  for _, r := range rs {
+    if r.Excluded == "" {
+      w := r.Weight
+      if w == 0 {
+        w = 1
+      }
+
+      sum += w * r.Kelvin
+      wsum += w
+    }
+  }
+
+  if wsum == 0 {
+    return 0, ErrAllExcluded
+  }
+
+  return sum / wsum, nil
+}
@@ -0,0 +1,77 @@
+package aggregate
+
+import (
+  "context"
+  "io"
+  "log"
+  "testing"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// recorded is a fan-out to the providers recorded for Oslo, replayed.
+func recorded(b *testing.B) []providers.Reading {
+  b.Helper()
+
+  f, err := upstream.ReplayFixtures("../providers/testdata/oslo.json")
+  if err != nil {
+    b.Fatal(err)
+  }
+
+  prev, out := upstream.Client.Transport, log.Writer()
+  upstream.Client.Transport = f
+  log.SetOutput(io.Discard)
+  defer func() {
+    upstream.Client.Transport = prev
+    log.SetOutput(out)
+  }()
+
+  loc := geo.Location{Name: "Oslo", Lat: 59.9139, Lon: 10.7522}
+  rs := providers.Multi{providers.OpenMeteo{}, providers.MetNo{}}.Gather(context.Background(), loc, providers.CollectAll)
+  for _, r := range rs {
+    if r.Err != nil {
+      b.Fatalf("%s: %s", r.Provider, r.Err)
+    }
+  }
+
+  return rs
+}
+
+func BenchmarkAverage(b *testing.B) {
+  rs := recorded(b)
+  outliers := Outliers{Kelvin: 3, Sigma: 3}
+  weights := NewWeights(nil, true)
+  staleness := Staleness{MaxAge: time.Hour}
+
+  b.Run("plain", func(b *testing.B) {
+    b.ReportAllocs()
+    for i := 0; i < b.N; i++ {
+      if _, err := Average(rs); err != nil {
+        b.Fatal(err)
+      }
+    }
+  })
+
+  // Every step from the readings to the answer, on a copy as the steps
+  // mark the readings they leave out; as of when they were recorded, so
+  // the fixture doesn't go stale.
+  at := rs[0].Observed
+  b.Run("pipeline", func(b *testing.B) {
+    b.ReportAllocs()
+    work := make([]providers.Reading, len(rs))
+    for i := 0; i < b.N; i++ {
+      copy(work, rs)
+      staleness.Exclude(work, at)
+      outliers.Exclude(work)
+      weights.Assign(work)
+      if _, err := Average(work); err != nil {
+        b.Fatal(err)
+      }
+
+      weights.Learn(work)
+    }
+  })
+}
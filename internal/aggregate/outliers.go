@@ -0,0 +1,113 @@
+package aggregate
+
+import (
+  "fmt"
+  "math"
+  "sort"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+// Outliers excludes readings that disagree with the other providers
+// before averaging: more than Kelvin from the median, or more than Sigma
+// standard deviations from the mean of the others. Zero disables a test.
+type Outliers struct {
+  Kelvin float64
+  Sigma  float64
+}
+
+// Below this many readings there is no consensus to disagree with, and at
+// least half must always survive.
+const minConsensus = 3
+
+// Spread of agreeing providers is often near zero; don't let that turn a
+// fraction of a degree into many standard deviations.
+const minSigma = 0.5
+
+// Exclude marks outliers in rs with the reason, leaving failed and already
+// excluded readings alone, and says what it decided: "off", "too few readings", "no
+// majority" when the outliers it found were kept after all, or "tested".
+func (o Outliers) Exclude(rs []providers.Reading) string {
+  if o.Kelvin <= 0 && o.Sigma <= 0 {
+    return "off"
+  }
+
+  var ok []int
+  for i, r := range rs {
+    if r.Error == "" && r.Excluded == "" {
+      ok = append(ok, i)
+    }
+  }
+
+  if len(ok) < minConsensus {
+    return "too few readings"
+  }
+
+  values := make([]float64, len(ok))
+  for j, i := range ok {
+    values[j] = rs[i].Kelvin
+  }
+
+  med := Median(values)
+
+  var out []int
+  for j, i := range ok {
+    if o.Kelvin > 0 && math.Abs(values[j]-med) > o.Kelvin {
+      rs[i].Excluded = fmt.Sprintf("%.1fK from the median", math.Abs(values[j]-med))
+      out = append(out, i)
+      continue
+    }
+
+    if o.Sigma > 0 {
+      mean, sd := meanStdDev(values, j)
+      if z := math.Abs(values[j]-mean) / math.Max(sd, minSigma); z > o.Sigma {
+        rs[i].Excluded = fmt.Sprintf("%.1f standard deviations from the others", z)
+        out = append(out, i)
+      }
+    }
+  }
+
+  if 2*len(out) > len(ok) {
+    // No majority agrees; averaging everything beats picking a side.
+    for _, i := range out {
+      rs[i].Excluded = ""
+    }
+
+    return "no majority"
+  }
+
+  return "tested"
+}
+
+// Median of vs, which it leaves unsorted.
+func Median(vs []float64) float64 {
+  s := append([]float64(nil), vs...)
+  sort.Float64s(s)
+  if n := len(s); n%2 == 1 {
+    return s[n/2]
+  }
+
+  return (s[len(s)/2-1] + s[len(s)/2]) / 2
+}
+
+// meanStdDev of vs leaving out vs[skip].
+func meanStdDev(vs []float64, skip int) (float64, float64) {
+  var sum, n float64
+  for i, v := range vs {
+    if i != skip {
+      sum += v
+      n++
+    }
+  }
+
+  mean := sum / n
+
+  var sq float64
+  for i, v := range vs {
+    if i != skip {
+      sq += (v - mean) * (v - mean)
+    }
+  }
+
+  return mean, math.Sqrt(sq / n)
+}
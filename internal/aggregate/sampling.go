@@ -0,0 +1,127 @@
+package aggregate
+
+import (
+  "context"
+  "math"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+var samplingSkipped = metrics.NewCounter("sampling_skipped_total", "Provider calls saved by adaptive sampling, by provider.", "provider")
+
+// Sampler cuts upstream calls for places refreshed in the background (the
+// pre-warmer and stream pollers): each refresh queries only a rotating
+// fraction of the providers and blends in the others' last readings, as
+// long as those are younger than maxAge. A fraction of 1 queries everyone.
+type Sampler struct {
+  fraction float64
+  maxAge   time.Duration
+
+  mu     sync.Mutex
+  places map[string]*sampleState
+}
+
+type sampleState struct {
+  next int // rotation offset into the provider list
+  last map[string]providers.Reading
+  at   map[string]time.Time
+}
+
+// NewSampler queries fraction of the providers per refresh.
+func NewSampler(fraction float64, maxAge time.Duration) *Sampler {
+  return &Sampler{fraction: fraction, maxAge: maxAge, places: make(map[string]*sampleState)}
+}
+
+// Readings is Multi.Gather for a background refresh of loc.
+func (s *Sampler) Readings(ctx context.Context, ps providers.Multi, loc geo.Location, policy providers.ErrorPolicy) []providers.Reading {
+  if s.fraction >= 1 || len(ps) < 2 {
+    return ps.Gather(ctx, loc, policy)
+  }
+
+  now := time.Now()
+  key := loc.Key()
+
+  s.mu.Lock()
+  st, ok := s.places[key]
+  if !ok {
+    st = &sampleState{last: make(map[string]providers.Reading), at: make(map[string]time.Time)}
+    s.places[key] = st
+  }
+
+  // Providers without a usable reading must be asked; the rotation fills
+  // the rest of this refresh's share.
+  want := int(math.Ceil(s.fraction * float64(len(ps))))
+  ask := make([]bool, len(ps))
+  n := 0
+  for i, p := range ps {
+    if now.Sub(st.at[p.Name()]) > s.maxAge {
+      ask[i] = true
+      n++
+    }
+  }
+
+  for j := 0; j < len(ps) && n < want; j++ {
+    if i := (st.next + j) % len(ps); !ask[i] {
+      ask[i] = true
+      n++
+    }
+  }
+
+  st.next = (st.next + want) % len(ps)
+  s.mu.Unlock()
+
+  var asked providers.Multi
+  for i, p := range ps {
+    if ask[i] {
+      asked = append(asked, p)
+    }
+  }
+
+  fresh := asked.Gather(ctx, loc, policy)
+
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  rs := make([]providers.Reading, 0, len(ps))
+  for i, p := range ps {
+    if ask[i] {
+      r := fresh[0]
+      fresh = fresh[1:]
+      if r.Error == "" {
+        st.last[p.Name()], st.at[p.Name()] = r, now
+      }
+
+      rs = append(rs, r)
+      continue
+    }
+
+    r := st.last[p.Name()]
+    r.Took, r.Carried = 0, true
+    rs = append(rs, r)
+    samplingSkipped.Inc(p.Name())
+  }
+
+  s.prune(now)
+  return rs
+}
+
+// prune forgets places that haven't been refreshed within maxAge.
+func (s *Sampler) prune(now time.Time) {
+  for key, st := range s.places {
+    stale := true
+    for _, at := range st.at {
+      if now.Sub(at) <= s.maxAge {
+        stale = false
+        break
+      }
+    }
+
+    if stale && len(st.at) > 0 {
+      delete(s.places, key)
+    }
+  }
+}
@@ -0,0 +1,33 @@
+package aggregate
+
+import (
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+// Staleness excludes readings observed more than MaxAge before the
+// fan-out, such as a provider serving a day-old cache, before the outlier
+// test sees them. Readings the provider doesn't date are kept; zero
+// disables it.
+type Staleness struct {
+  MaxAge time.Duration
+}
+
+// Exclude marks stale readings in rs as of now, leaving failed readings
+// alone.
+func (s Staleness) Exclude(rs []providers.Reading, now time.Time) {
+  if s.MaxAge <= 0 {
+    return
+  }
+
+  for i, r := range rs {
+    if r.Error != "" || r.Observed.IsZero() {
+      continue
+    }
+
+    if age := now.Sub(r.Observed); age > s.MaxAge {
+      rs[i].Excluded = "observed " + age.Round(time.Minute).String() + " ago, over the " + s.MaxAge.String() + " limit"
+    }
+  }
+}
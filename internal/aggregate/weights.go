@@ -0,0 +1,196 @@
+package aggregate
+
+import (
+  "fmt"
+  "math"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+// WeightSet is the static weight of each provider, 1 unless set, and
+// doubles as a repeatable flag: -provider.weight=open-meteo=2.
+type WeightSet map[string]float64
+
+func (ws WeightSet) String() string {
+  var s []string
+  for name, w := range ws {
+    s = append(s, name+"="+strconv.FormatFloat(w, 'g', -1, 64))
+  }
+
+  sort.Strings(s)
+  return strings.Join(s, ",")
+}
+
+func (ws WeightSet) Set(v string) error {
+  name, raw, ok := strings.Cut(v, "=")
+  w, err := strconv.ParseFloat(raw, 64)
+  if !ok || name == "" || err != nil || w <= 0 {
+    return fmt.Errorf("want provider=<positive weight>, got %q", v)
+  }
+
+  ws[name] = w
+  return nil
+}
+
+// Dynamic weighting tracks each provider's typical distance from the
+// consensus (the median of the included readings) as a moving average.
+// A provider usually agreementScale kelvin off counts half as much as
+// one that always agrees.
+const (
+  agreementScale = 1.0
+  agreementAlpha = 0.1
+)
+
+// Weights assigns each reading its effective weight: the static weight,
+// scaled down by recent disagreement with the consensus when dynamic, or
+// by the disagreement learned from history in the reading's region.
+type Weights struct {
+  static  WeightSet
+  dynamic bool
+
+  mu      sync.Mutex
+  dev     map[string]float64            // moving average of |reading - consensus|, K
+  learned map[string]map[string]float64 // by region, then provider, K
+}
+
+// NewWeights scales static weights by agreement when dynamic.
+func NewWeights(static WeightSet, dynamic bool) *Weights {
+  return &Weights{static: static, dynamic: dynamic, dev: make(map[string]float64)}
+}
+
+// Assign sets each reading's effective weight.
+func (w *Weights) Assign(rs []providers.Reading) { w.AssignIn("", rs) }
+
+// AssignIn sets each reading's effective weight for a place in region.
+func (w *Weights) AssignIn(region string, rs []providers.Reading) {
+  w.mu.Lock()
+  defer w.mu.Unlock()
+
+  for i := range rs {
+    weight, ok := w.static[rs[i].Provider]
+    if !ok {
+      weight = 1
+    }
+
+    if dev, ok := w.deviation(region, rs[i].Provider); ok {
+      weight /= 1 + dev/agreementScale
+    }
+
+    rs[i].Weight = weight
+  }
+}
+
+// deviation is what provider's weight is scaled down by in region: the
+// learned deviation there, else the moving average when dynamic.
+func (w *Weights) deviation(region, provider string) (float64, bool) {
+  if dev, ok := w.learned[region][provider]; ok {
+    return dev, true
+  }
+
+  return w.dev[provider], w.dynamic
+}
+
+// Dynamic reports whether weights follow agreement, recent or learned.
+func (w *Weights) Dynamic() bool {
+  w.mu.Lock()
+  defer w.mu.Unlock()
+
+  return w.dynamic || w.learned != nil
+}
+
+// Basis is what a provider's weight is made of: its static weight and, when
+// dynamic, its recent distance from the consensus in kelvin.
+func (w *Weights) Basis(provider string) (static, deviation float64) {
+  return w.BasisIn("", provider)
+}
+
+// BasisIn is Basis for a place in region, where a learned deviation takes
+// the recent one's place.
+func (w *Weights) BasisIn(region, provider string) (static, deviation float64) {
+  w.mu.Lock()
+  defer w.mu.Unlock()
+
+  static, ok := w.static[provider]
+  if !ok {
+    static = 1
+  }
+
+  deviation, _ = w.deviation(region, provider)
+  return static, deviation
+}
+
+// Weight is provider's effective weight in region as Assign would give it.
+func (w *Weights) Weight(region, provider string) float64 {
+  static, dev := w.BasisIn(region, provider)
+  return static / (1 + dev/agreementScale)
+}
+
+// SetLearned replaces the learned deviations, by region then provider, in
+// kelvin. Regions and providers it lacks fall back to the moving average.
+func (w *Weights) SetLearned(devs map[string]map[string]float64) {
+  w.mu.Lock()
+  defer w.mu.Unlock()
+
+  if devs == nil {
+    devs = map[string]map[string]float64{}
+  }
+
+  w.learned = devs
+}
+
+// Recent is each provider's moving-average deviation, in kelvin.
+func (w *Weights) Recent() map[string]float64 {
+  w.mu.Lock()
+  defer w.mu.Unlock()
+
+  out := make(map[string]float64, len(w.dev))
+  for name, d := range w.dev {
+    out[name] = d
+  }
+
+  return out
+}
+
+// Learn updates the agreement of every fresh reading in an aggregate.
+func (w *Weights) Learn(rs []providers.Reading) {
+  if !w.dynamic {
+    return
+  }
+
+  var values []float64
+  for _, r := range rs {
+    if r.Error == "" && r.Excluded == "" {
+      values = append(values, r.Kelvin)
+    }
+  }
+
+  if len(values) < 2 {
+    return
+  }
+
+  consensus := Median(values)
+
+  w.mu.Lock()
+  defer w.mu.Unlock()
+
+  for _, r := range rs {
+    if r.Error != "" || r.Reused() {
+      continue
+    }
+
+    // Excluded outliers learn too, so a provider that keeps being wrong
+    // carries less weight once it slips back under the outlier limit.
+    d, seen := w.dev[r.Provider]
+    dev := math.Abs(r.Kelvin - consensus)
+    if !seen {
+      w.dev[r.Provider] = dev
+      continue
+    }
+
+    w.dev[r.Provider] = (1-agreementAlpha)*d + agreementAlpha*dev
+  }
+}
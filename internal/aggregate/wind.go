@@ -0,0 +1,90 @@
+package aggregate
+
+import (
+  "math"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+// Below this share of the mean length left in the vector sum, providers
+// disagree too much about where the wind or waves come from to name a
+// direction.
+const minSteadiness = 0.1
+
+// Wind is the providers' wind together, nil where none of them says.
+type Wind struct {
+  Speed     *float64 // m/s, the mean of the speeds
+  Direction *float64 // degrees clockwise from north it blows from
+  Gust      *float64 // m/s
+}
+
+// AverageWind combines the wind of cs. Directions are averaged as vectors,
+// each weighted by its speed, so 350° and 10° make 0° rather than 180°;
+// calm air has no direction, nor has wind the providers place on opposite
+// sides. Gusts are the mean of the providers that report them, and never
+// below the mean speed.
+func AverageWind(cs []providers.Condition) Wind {
+  var w Wind
+  w.Speed = meanOf(cs, func(c providers.Condition) *float64 { return c.Wind })
+  w.Gust = meanOf(cs, func(c providers.Condition) *float64 { return c.Gust })
+  if w.Gust != nil && w.Speed != nil && *w.Gust < *w.Speed {
+    *w.Gust = *w.Speed
+  }
+
+  // Providers that give a direction but no speed count as blowing at the
+  // mean speed.
+  fallback := 1.0
+  if w.Speed != nil {
+    fallback = *w.Speed
+  }
+
+  var degrees, weights []float64
+  for _, c := range cs {
+    if c.WindDirection == nil {
+      continue
+    }
+
+    weight := fallback
+    if c.Wind != nil {
+      weight = *c.Wind
+    }
+
+    degrees, weights = append(degrees, *c.WindDirection), append(weights, weight)
+  }
+
+  w.Direction = MeanDirection(degrees, weights)
+  return w
+}
+
+// MeanDirection averages degrees as vectors, each as long as its weight;
+// nil when there are none or they point too many ways to have a mean.
+func MeanDirection(degrees, weights []float64) *float64 {
+  x, y, wsum := 0.0, 0.0, 0.0
+  for i, d := range degrees {
+    rad := d * math.Pi / 180
+    x, y, wsum = x+weights[i]*math.Sin(rad), y+weights[i]*math.Cos(rad), wsum+weights[i]
+  }
+
+  if wsum == 0 || math.Hypot(x, y)/wsum < minSteadiness {
+    return nil
+  }
+
+  deg := math.Mod(math.Atan2(x, y)*180/math.Pi+360, 360)
+  return &deg
+}
+
+func meanOf(cs []providers.Condition, v func(providers.Condition) *float64) *float64 {
+  sum, n := 0.0, 0
+  for _, c := range cs {
+    if x := v(c); x != nil {
+      sum, n = sum+*x, n+1
+    }
+  }
+
+  if n == 0 {
+    return nil
+  }
+
+  m := sum / float64(n)
+  return &m
+}
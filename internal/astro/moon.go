@@ -0,0 +1,38 @@
+package astro
+
+import (
+  "math"
+  "time"
+)
+
+// Moon is the phase of the moon at an instant, the same everywhere.
+type Moon struct {
+  Phase        string  // new moon, waxing crescent, first quarter, ...
+  Age          float64 // days since the last new moon
+  Illumination float64 // lit fraction of the disc, 0 to 1
+}
+
+const synodicMonth = 29.530588853 // days, new moon to new moon
+
+// A new moon to count lunations from: 2000-01-06 18:14 UTC.
+var knownNewMoon = time.Date(2000, 1, 6, 18, 14, 0, 0, time.UTC)
+
+var phases = []string{"new moon", "waxing crescent", "first quarter", "waxing gibbous", "full moon", "waning gibbous", "last quarter", "waning crescent"}
+
+// MoonAt is the phase at t, from the mean lunation; actual phases are off
+// by up to about 14 hours.
+func MoonAt(t time.Time) Moon {
+  days := t.Sub(knownNewMoon).Hours() / 24
+  age := math.Mod(days, synodicMonth)
+  if age < 0 {
+    age += synodicMonth
+  }
+
+  frac := age / synodicMonth
+  return Moon{
+    // Each named phase is an eighth of the month, centred on its point.
+    Phase:        phases[int(math.Floor(frac*8+0.5))%8],
+    Age:          age,
+    Illumination: (1 - math.Cos(2*math.Pi*frac)) / 2,
+  }
+}
@@ -0,0 +1,87 @@
+// Package astro computes sunrise, sunset and the phase of the moon from
+// coordinates alone, precise to about a minute away from the poles.
+package astro
+
+import (
+  "math"
+  "time"
+)
+
+// Sun is the sun's day at a place.
+type Sun struct {
+  Rise  time.Time // zero in polar day and night
+  Set   time.Time
+  Noon  time.Time // solar noon, when the sun is highest
+  Day   time.Duration
+  Polar string // "day" when the sun never sets, "night" when it never rises
+}
+
+const (
+  julianUnixEpoch = 2440587.5 // Julian date of 1970-01-01T00:00Z
+  julian2000      = 2451545.0 // J2000.0
+  obliquity       = 23.4397   // of the ecliptic, degrees
+  horizon         = -0.833    // sun's altitude at rise and set: refraction and its radius
+)
+
+// SunOn computes the sun's day on date's UTC calendar day at lat/lon,
+// using the sunrise equation.
+func SunOn(date time.Time, lat, lon float64) Sun {
+  y, mo, d := date.UTC().Date()
+  noonUTC := time.Date(y, mo, d, 12, 0, 0, 0, time.UTC)
+
+  n := math.Round(julian(noonUTC) - julian2000 + 0.0008)
+  jstar := n - lon/360
+
+  m := math.Mod(357.5291+0.98560028*jstar, 360) // mean anomaly
+  mr := radians(m)
+  center := 1.9148*math.Sin(mr) + 0.02*math.Sin(2*mr) + 0.0003*math.Sin(3*mr)
+  ecliptic := radians(math.Mod(m+center+180+102.9372, 360)) // longitude
+  transit := julian2000 + jstar + 0.0053*math.Sin(mr) - 0.0069*math.Sin(2*ecliptic)
+
+  declination := math.Asin(math.Sin(ecliptic) * math.Sin(radians(obliquity)))
+  phi := radians(lat)
+  cosHour := (math.Sin(radians(horizon)) - math.Sin(phi)*math.Sin(declination)) / (math.Cos(phi) * math.Cos(declination))
+
+  s := Sun{Noon: fromJulian(transit)}
+  switch {
+  case cosHour < -1:
+    s.Polar, s.Day = "day", 24*time.Hour
+  case cosHour > 1:
+    s.Polar = "night"
+  default:
+    hour := degrees(math.Acos(cosHour)) / 360
+    s.Rise, s.Set = fromJulian(transit-hour), fromJulian(transit+hour)
+    s.Day = s.Set.Sub(s.Rise)
+  }
+
+  return s
+}
+
+// Night reports whether the sun is down at t at lat/lon. The UTC days
+// either side are checked too, as far from Greenwich a local day's
+// sunrise or sunset falls on another UTC date.
+func Night(t time.Time, lat, lon float64) bool {
+  for _, d := range []int{-1, 0, 1} {
+    s := SunOn(t.AddDate(0, 0, d), lat, lon)
+    if d == 0 && s.Polar == "day" {
+      return false
+    }
+
+    if s.Polar == "" && !t.Before(s.Rise) && t.Before(s.Set) {
+      return false
+    }
+  }
+
+  return true
+}
+
+func julian(t time.Time) float64 {
+  return float64(t.Unix())/86400 + julianUnixEpoch
+}
+
+func fromJulian(j float64) time.Time {
+  return time.Unix(0, int64((j-julianUnixEpoch)*86400*1e9)).UTC().Round(time.Second)
+}
+
+func radians(d float64) float64 { return d * math.Pi / 180 }
+func degrees(r float64) float64 { return r * 180 / math.Pi }
@@ -0,0 +1,211 @@
+// Package cache keeps aggregate readings per place.
+package cache
+
+import (
+  "encoding/json"
+  "log"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/condition"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+  "github.com/im-kulikov/weather-go-external-api/internal/storage"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+var cacheRequests = metrics.NewCounter("cache_requests_total", "Aggregate cache lookups by result.", "result")
+
+// Entry is a cached aggregate reading for one place.
+type Entry struct {
+  Key       string
+  Loc       geo.Location
+  Kelvin    float64
+  Providers int                    // how many readings were averaged into kelvin
+  Condition condition.Code         // the providers' consensus
+  Credit    []upstream.Attribution // of the providers that produced kelvin
+  Observed  time.Time              // the oldest observation averaged, zero when none is dated
+  Sources   []Source               // the readings averaged into kelvin
+  Stored    time.Time
+}
+
+// Source is one provider's reading that went into an entry, as it came.
+type Source struct {
+  Provider string
+  Kelvin   float64
+  Weight   float64
+  Observed time.Time // zero when the provider doesn't date it
+  Fetched  time.Time
+}
+
+const bucket = "readings"
+
+// Readings holds aggregate temperatures by location for ttl. City and
+// coordinate queries for the same place share an entry. Expired entries
+// are kept for another staleFor, to be served when the service degrades.
+// Entries live in a storage backend; one that fails makes lookups miss.
+type Readings struct {
+  mu       sync.RWMutex
+  ttl      time.Duration
+  staleFor time.Duration
+  entries  storage.Backend
+}
+
+// New caches readings for ttl in b, in memory if b is nil, and keeps them
+// staleFor longer.
+func New(ttl, staleFor time.Duration, b storage.Backend) *Readings {
+  if b == nil {
+    b = storage.NewMemory()
+  }
+
+  c := &Readings{ttl: ttl, staleFor: staleFor, entries: b}
+  go c.evict()
+  return c
+}
+
+// TTL is how long entries stay fresh.
+func (c *Readings) TTL() time.Duration {
+  c.mu.RLock()
+  defer c.mu.RUnlock()
+  return c.ttl
+}
+
+// StaleFor is how long expired entries are kept.
+func (c *Readings) StaleFor() time.Duration {
+  c.mu.RLock()
+  defer c.mu.RUnlock()
+  return c.staleFor
+}
+
+// SetTTL changes both, for entries already stored as well.
+func (c *Readings) SetTTL(ttl, staleFor time.Duration) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.ttl, c.staleFor = ttl, staleFor
+}
+
+func (c *Readings) load(key string) (Entry, bool) {
+  raw, ok, err := c.entries.Get(bucket, key)
+  var e Entry
+  if err == nil && ok {
+    err = json.Unmarshal(raw, &e)
+  }
+
+  if err != nil {
+    log.Printf("cache: %s: %s", key, err)
+    cacheRequests.Inc("error")
+    return Entry{}, false
+  }
+
+  return e, ok
+}
+
+// Get returns the fresh entry for loc.
+func (c *Readings) Get(loc geo.Location) (Entry, bool) {
+  ttl := c.TTL()
+  if ttl <= 0 {
+    return Entry{}, false
+  }
+
+  e, ok := c.load(loc.Key())
+  if !ok || time.Since(e.Stored) > ttl {
+    cacheRequests.Inc("miss")
+    return Entry{}, false
+  }
+
+  cacheRequests.Inc("hit")
+  return e, true
+}
+
+// Stale returns an expired entry that hasn't been evicted yet.
+func (c *Readings) Stale(loc geo.Location) (Entry, bool) {
+  e, ok := c.load(loc.Key())
+  if ok {
+    cacheRequests.Inc("stale")
+  }
+
+  return e, ok
+}
+
+// Put stores the aggregate of e.Loc, as of now unless e.Stored is set.
+func (c *Readings) Put(e Entry) {
+  if c.TTL() <= 0 {
+    return
+  }
+
+  e.Key = e.Loc.Key()
+  if e.Stored.IsZero() {
+    e.Stored = time.Now()
+  }
+
+  raw, _ := json.Marshal(e)
+  if err := c.entries.Put(bucket, e.Key, raw); err != nil {
+    log.Printf("cache: %s: %s", e.Key, err)
+  }
+}
+
+// Expires is when e stops being fresh.
+func (c *Readings) Expires(e Entry) time.Time {
+  return e.Stored.Add(c.TTL())
+}
+
+// Match returns the entries for which keep is true. It reads every entry,
+// which for a remote backend is a round trip each.
+func (c *Readings) Match(keep func(Entry) bool) []Entry {
+  keys, err := c.entries.Keys(bucket, "")
+  if err != nil {
+    log.Printf("cache: %s", err)
+    return nil
+  }
+
+  var es []Entry
+  for _, k := range keys {
+    if e, ok := c.load(k); ok && keep(e) {
+      es = append(es, e)
+    }
+  }
+
+  return es
+}
+
+// Within returns the fresh entries of places inside the box, for queries
+// by area rather than by place.
+func (c *Readings) Within(minLat, minLon, maxLat, maxLon float64) []Entry {
+  ttl := c.TTL()
+  if ttl <= 0 {
+    return nil
+  }
+
+  return c.Match(func(e Entry) bool {
+    return time.Since(e.Stored) <= ttl &&
+      e.Loc.Lat >= minLat && e.Loc.Lat <= maxLat &&
+      e.Loc.Lon >= minLon && e.Loc.Lon <= maxLon
+  })
+}
+
+// Remove drops es, e.g. from Match.
+func (c *Readings) Remove(es []Entry) {
+  keys := make([]string, 0, len(es))
+  for _, e := range es {
+    keys = append(keys, e.Key)
+  }
+
+  if err := c.entries.Delete(bucket, keys...); err != nil {
+    log.Printf("cache: %s", err)
+  }
+}
+
+// evict drops entries past their stale time every ttl, or every minute
+// while caching is off, since SetTTL may turn it back on.
+func (c *Readings) evict() {
+  for {
+    ttl := c.TTL()
+    if ttl <= 0 {
+      ttl = time.Minute
+    }
+
+    time.Sleep(ttl)
+    keep := c.TTL() + c.StaleFor()
+    c.Remove(c.Match(func(e Entry) bool { return time.Since(e.Stored) > keep }))
+  }
+}
@@ -0,0 +1,155 @@
+package cache
+
+import (
+  "fmt"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+var providerCacheRequests = metrics.NewCounter("provider_cache_requests_total", "Per-provider reading cache lookups by provider and result.", "provider", "result")
+
+// TTLSet is how long each provider's readings are kept, overriding its
+// cadence, and doubles as a repeatable flag: -provider.ttl=met.no=30m.
+// Zero turns caching off for the provider.
+type TTLSet map[string]time.Duration
+
+func (ts TTLSet) String() string {
+  var s []string
+  for name, ttl := range ts {
+    s = append(s, name+"="+ttl.String())
+  }
+
+  sort.Strings(s)
+  return strings.Join(s, ",")
+}
+
+func (ts TTLSet) Set(v string) error {
+  name, raw, ok := strings.Cut(v, "=")
+  ttl, err := time.ParseDuration(raw)
+  if !ok || name == "" || err != nil || ttl < 0 {
+    return fmt.Errorf("want provider=<duration>, got %q", v)
+  }
+
+  ts[name] = ttl
+  return nil
+}
+
+// TTL is how long p's readings are kept under ts.
+func (ts TTLSet) TTL(p providers.Provider) time.Duration {
+  if ttl, ok := ts[p.Name()]; ok {
+    return ttl
+  }
+
+  if cp, ok := p.(providers.Cadenced); ok {
+    return cp.Cadence()
+  }
+
+  return 0
+}
+
+// ProviderReadings keeps each provider's last reading of a place for as
+// long as the provider takes to publish a new one, so refreshing an
+// aggregate only asks the providers whose reading has expired.
+type ProviderReadings struct {
+  mu      sync.Mutex
+  ttls    TTLSet
+  entries map[string]providerEntry // provider/place
+}
+
+type providerEntry struct {
+  reading providers.Reading
+  stored  time.Time
+  ttl     time.Duration
+}
+
+// NewProviderReadings caches readings for ttls, or each provider's cadence.
+func NewProviderReadings(ttls TTLSet) *ProviderReadings {
+  c := &ProviderReadings{ttls: ttls, entries: make(map[string]providerEntry)}
+  go c.evict()
+  return c
+}
+
+// TTL is how long p's readings are kept.
+func (c *ProviderReadings) TTL(p providers.Provider) time.Duration {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  return c.ttls.TTL(p)
+}
+
+// SetTTLs replaces the overrides of the providers' cadences. Readings
+// already kept expire as they were stored.
+func (c *ProviderReadings) SetTTLs(ttls TTLSet) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.ttls = ttls
+}
+
+// Readings is the cached reading of loc of each provider in ps that has a
+// fresh one, and fetch's for the rest, which are cached in turn.
+// Failures aren't cached, so a failed provider is asked again next time.
+func (c *ProviderReadings) Readings(ps providers.Multi, loc geo.Location, fetch func(providers.Multi) []providers.Reading) []providers.Reading {
+  now := time.Now()
+  rs := make([]providers.Reading, len(ps))
+  hit := make([]bool, len(ps))
+  var ask providers.Multi
+
+  c.mu.Lock()
+  for i, p := range ps {
+    e, ok := c.entries[p.Name()+"/"+loc.Key()]
+    if ok && now.Sub(e.stored) < e.ttl {
+      rs[i], hit[i] = e.reading, true
+      rs[i].Took, rs[i].Cached = 0, true
+      providerCacheRequests.Inc(p.Name(), "hit")
+      continue
+    }
+
+    if c.ttls.TTL(p) > 0 {
+      providerCacheRequests.Inc(p.Name(), "miss")
+    }
+
+    ask = append(ask, p)
+  }
+  c.mu.Unlock()
+
+  if len(ask) == 0 {
+    return rs
+  }
+
+  fresh := fetch(ask)
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  for i, p := range ps {
+    if hit[i] {
+      continue
+    }
+
+    r := fresh[0]
+    fresh = fresh[1:]
+    rs[i] = r
+    if ttl := c.ttls.TTL(p); ttl > 0 && r.Error == "" && !r.Reused() {
+      c.entries[p.Name()+"/"+loc.Key()] = providerEntry{reading: r, stored: time.Now(), ttl: ttl}
+    }
+  }
+
+  return rs
+}
+
+func (c *ProviderReadings) evict() {
+  for range time.Tick(time.Minute) {
+    c.mu.Lock()
+    for key, e := range c.entries {
+      if time.Since(e.stored) >= e.ttl {
+        delete(c.entries, key)
+      }
+    }
+    c.mu.Unlock()
+  }
+}
@@ -0,0 +1,91 @@
+package cache
+
+import (
+  "encoding/json"
+  "errors"
+  "io/fs"
+  "os"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+)
+
+// Snapshot writes every entry not due for eviction to path, as a JSON
+// array. The file is replaced whole, so one cut short by a crash leaves
+// the previous snapshot.
+func (c *Readings) Snapshot(path string) (int, error) {
+  keep := c.TTL() + c.StaleFor()
+  es := c.Match(func(e Entry) bool { return time.Since(e.Stored) <= keep })
+  if es == nil {
+    es = []Entry{}
+  }
+
+  raw, err := json.Marshal(es)
+  if err != nil {
+    return 0, err
+  }
+
+  tmp := path + ".tmp"
+  if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+    return 0, err
+  }
+
+  return len(es), os.Rename(tmp, path)
+}
+
+// Restore loads the snapshot at path, keeping each entry's age: fresh ones
+// answer as before the restart, expired ones are there to serve stale.
+// Entries due for eviction by now are left out, as are those the backend
+// already has a newer one of. A missing snapshot, or a disabled cache,
+// restores none.
+func (c *Readings) Restore(path string) (int, error) {
+  ttl, staleFor := c.TTL(), c.StaleFor()
+  if ttl <= 0 {
+    return 0, nil
+  }
+
+  raw, err := os.ReadFile(path)
+  if errors.Is(err, fs.ErrNotExist) {
+    return 0, nil
+  }
+
+  if err != nil {
+    return 0, err
+  }
+
+  var es []Entry
+  if err := json.Unmarshal(raw, &es); err != nil {
+    return 0, err
+  }
+
+  n := 0
+  for _, e := range es {
+    if time.Since(e.Stored) > ttl+staleFor {
+      continue
+    }
+
+    if have, ok := c.load(e.Loc.Key()); ok && !have.Stored.Before(e.Stored) {
+      continue
+    }
+
+    c.Put(e)
+    n++
+  }
+
+  return n, nil
+}
+
+// Expiry is when the entry for loc stops being fresh, without counting as
+// a lookup.
+func (c *Readings) Expiry(loc geo.Location) (time.Time, bool) {
+  if c.TTL() <= 0 {
+    return time.Time{}, false
+  }
+
+  e, ok := c.load(loc.Key())
+  if !ok {
+    return time.Time{}, false
+  }
+
+  return c.Expires(e), true
+}
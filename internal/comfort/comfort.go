@@ -0,0 +1,76 @@
+// Package comfort derives how the weather feels from the temperature, the
+// relative humidity and the wind: dew point, heat index, wind chill and
+// the feels-like temperature that picks between them; and whether roads
+// may be icy. Temperatures are in kelvin, humidity in percent and wind
+// speed in m/s; NaN is a value nobody reported.
+package comfort
+
+import "math"
+
+const (
+  heatIndexFrom = 299.817   // 80 °F
+  windChillTo   = 283.15    // 10 °C
+  windChillFrom = 4.8 / 3.6 // 4.8 km/h
+)
+
+// DewPoint is the Magnus formula with Sonntag's constants, within 0.35 K
+// from -45 to 60 °C.
+func DewPoint(kelvin, humidity float64) float64 {
+  const a, b = 17.62, 243.12
+  t := kelvin - 273.15
+  g := math.Log(math.Max(humidity, 0.1)/100) + a*t/(b+t)
+  return b*g/(a-g) + 273.15
+}
+
+// HasHeatIndex is whether it's warm enough for the heat index to mean
+// anything, with a known humidity.
+func HasHeatIndex(kelvin, humidity float64) bool {
+  return kelvin >= heatIndexFrom && !math.IsNaN(humidity)
+}
+
+// HeatIndex is the US National Weather Service's: Rothfusz's regression
+// with its adjustments for very dry and very humid air, or Steadman's
+// simpler formula where that comes out under 80 °F.
+func HeatIndex(kelvin, humidity float64) float64 {
+  t, rh := (kelvin-273.15)*9/5+32, humidity
+  hi := 0.5 * (t + 61 + (t-68)*1.2 + rh*0.094)
+  if (hi+t)/2 >= 80 {
+    hi = -42.379 + 2.04901523*t + 10.14333127*rh - 0.22475541*t*rh - 0.00683783*t*t - 0.05481717*rh*rh +
+      0.00122874*t*t*rh + 0.00085282*t*rh*rh - 0.00000199*t*t*rh*rh
+
+    switch {
+    case rh < 13 && t >= 80 && t <= 112:
+      hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(t-95))/17)
+    case rh > 85 && t >= 80 && t <= 87:
+      hi += (rh - 85) / 10 * (87 - t) / 5
+    }
+  }
+
+  return (hi-32)*5/9 + 273.15
+}
+
+// HasWindChill is whether it's cold and windy enough for the wind chill
+// to mean anything.
+func HasWindChill(kelvin, wind float64) bool {
+  return kelvin <= windChillTo && wind > windChillFrom
+}
+
+// WindChill is the North American index of 2001, as Environment Canada
+// and the NWS use it.
+func WindChill(kelvin, wind float64) float64 {
+  t, v := kelvin-273.15, math.Pow(wind*3.6, 0.16)
+  return 13.12 + 0.6215*t - 11.37*v + 0.3965*t*v + 273.15
+}
+
+// FeelsLike is the wind chill where it applies, the heat index where that
+// does, and the temperature in between.
+func FeelsLike(kelvin, humidity, wind float64) float64 {
+  switch {
+  case HasWindChill(kelvin, wind):
+    return WindChill(kelvin, wind)
+  case HasHeatIndex(kelvin, humidity):
+    return HeatIndex(kelvin, humidity)
+  }
+
+  return kelvin
+}
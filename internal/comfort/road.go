@@ -0,0 +1,26 @@
+package comfort
+
+// A road can be colder than the air over it, above all on bridges and
+// after a clear night, so it may glaze over a little above freezing.
+const (
+  freezing      = 273.15
+  icyRoadsBelow = 275.15 // 2 °C
+)
+
+// IcyRoads is why roads may be icy, "" when nothing points to it: rain or
+// snow falling at or below freezing, roads wet near freezing, or snow
+// lying below it that traffic packs into ice. Precipitation is mm over
+// the step it is of and snow depth m, NaN when unknown.
+func IcyRoads(kelvin, precipitation, snowDepth float64) string {
+  wet := precipitation > 0
+  switch {
+  case wet && kelvin <= freezing:
+    return "precipitation at or below freezing"
+  case wet && kelvin <= icyRoadsBelow:
+    return "wet roads near freezing"
+  case snowDepth > 0 && kelvin <= freezing:
+    return "snow lying below freezing"
+  }
+
+  return ""
+}
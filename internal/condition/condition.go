@@ -0,0 +1,246 @@
+// Package condition is the one vocabulary for the weather's state, into
+// which every provider's own codes are mapped.
+package condition
+
+import (
+  "fmt"
+  "strings"
+)
+
+// Code is a normalized weather condition.
+type Code int
+
+const (
+  Unknown Code = iota
+  Clear
+  PartlyCloudy
+  Cloudy
+  Fog
+  Drizzle
+  Rain
+  Sleet
+  Snow
+  Thunderstorm
+)
+
+var names = [...]string{
+  Unknown:      "unknown",
+  Clear:        "clear",
+  PartlyCloudy: "partly-cloudy",
+  Cloudy:       "cloudy",
+  Fog:          "fog",
+  Drizzle:      "drizzle",
+  Rain:         "rain",
+  Sleet:        "sleet",
+  Snow:         "snow",
+  Thunderstorm: "thunderstorm",
+}
+
+func (c Code) String() string {
+  if c < 0 || int(c) >= len(names) {
+    return names[Unknown]
+  }
+
+  return names[c]
+}
+
+// Names lists every code's name, Unknown first.
+func Names() []string { return append([]string(nil), names[:]...) }
+
+// Parse is the code named name, as String gives it.
+func Parse(name string) (Code, error) {
+  for c, n := range names {
+    if n == name {
+      return Code(c), nil
+    }
+  }
+
+  return Unknown, fmt.Errorf("unknown condition %q", name)
+}
+
+func (c Code) MarshalText() ([]byte, error) { return []byte(c.String()), nil }
+
+func (c *Code) UnmarshalText(b []byte) (err error) {
+  *c, err = Parse(string(b))
+  return err
+}
+
+// Icon identifies c's glyph: clear and partly cloudy skies look different
+// at night, the rest don't.
+func (c Code) Icon(night bool) string {
+  if night && (c == Clear || c == PartlyCloudy) {
+    return c.String() + "-night"
+  }
+
+  return c.String()
+}
+
+// Consensus is the code most of cs agree on, on a tie the one that got
+// there first. Unknown only wins when nothing else was reported.
+func Consensus(cs []Code) Code {
+  votes := make(map[Code]int)
+  best := Unknown
+  for _, c := range cs {
+    if c == Unknown {
+      continue
+    }
+
+    votes[c]++
+    if votes[c] > votes[best] {
+      best = c
+    }
+  }
+
+  return best
+}
+
+// FromOpenWeather maps OpenWeather's condition ids.
+func FromOpenWeather(id int) Code {
+  switch {
+  case id >= 200 && id < 300:
+    return Thunderstorm
+  case id >= 300 && id < 400:
+    return Drizzle
+  case id == 511, id >= 611 && id <= 616:
+    return Sleet
+  case id >= 500 && id < 600:
+    return Rain
+  case id >= 600 && id < 700:
+    return Snow
+  case id >= 700 && id < 800:
+    return Fog
+  case id == 800:
+    return Clear
+  case id == 801, id == 802:
+    return PartlyCloudy
+  case id == 803, id == 804:
+    return Cloudy
+  }
+
+  return Unknown
+}
+
+// FromWMO maps WMO 4677 present weather, as Open-Meteo reduces it.
+func FromWMO(code int) Code {
+  switch code {
+  case 0:
+    return Clear
+  case 1, 2:
+    return PartlyCloudy
+  case 3:
+    return Cloudy
+  case 45, 48:
+    return Fog
+  case 51, 53, 55:
+    return Drizzle
+  case 56, 57, 66, 67:
+    return Sleet
+  case 61, 63, 65, 80, 81, 82:
+    return Rain
+  case 71, 73, 75, 77, 85, 86:
+    return Snow
+  case 95, 96, 99:
+    return Thunderstorm
+  }
+
+  return Unknown
+}
+
+// FromMetNo maps MET Norway's symbol codes, such as lightrainshowers_day.
+func FromMetNo(symbol string) Code {
+  name, _, _ := strings.Cut(symbol, "_")
+  switch {
+  case strings.Contains(name, "thunder"):
+    return Thunderstorm
+  case strings.Contains(name, "sleet"):
+    return Sleet
+  case strings.Contains(name, "snow"):
+    return Snow
+  case strings.Contains(name, "rain"):
+    return Rain
+  case name == "fog":
+    return Fog
+  case name == "cloudy":
+    return Cloudy
+  case name == "partlycloudy", name == "fair":
+    return PartlyCloudy
+  case name == "clearsky":
+    return Clear
+  }
+
+  return Unknown
+}
+
+// FromVisualCrossing maps the Timeline API's icon names.
+func FromVisualCrossing(icon string) Code {
+  switch {
+  case strings.HasPrefix(icon, "thunder"):
+    return Thunderstorm
+  case strings.HasPrefix(icon, "snow"):
+    return Snow
+  case icon == "sleet":
+    return Sleet
+  case icon == "rain", strings.HasPrefix(icon, "showers"):
+    return Rain
+  case icon == "fog":
+    return Fog
+  case icon == "cloudy":
+    return Cloudy
+  case strings.HasPrefix(icon, "partly-cloudy"):
+    return PartlyCloudy
+  case strings.HasPrefix(icon, "clear"):
+    return Clear
+  }
+
+  return Unknown
+}
+
+// FromWunderground maps Weather Underground's icon names, such as
+// chancetstorms or nt_mostlycloudy.
+func FromWunderground(icon string) Code {
+  icon = strings.TrimPrefix(strings.TrimPrefix(icon, "nt_"), "chance")
+  switch icon {
+  case "tstorms":
+    return Thunderstorm
+  case "sleet", "freezingrain":
+    return Sleet
+  case "snow", "flurries":
+    return Snow
+  case "rain":
+    return Rain
+  case "fog", "hazy":
+    return Fog
+  case "cloudy", "mostlycloudy":
+    return Cloudy
+  case "partlycloudy", "partlysunny", "mostlysunny":
+    return PartlyCloudy
+  case "clear", "sunny":
+    return Clear
+  }
+
+  return Unknown
+}
+
+// FromMeteostat maps Meteostat's weather condition codes, 1 to 27.
+func FromMeteostat(coco int) Code {
+  switch coco {
+  case 1:
+    return Clear
+  case 2:
+    return PartlyCloudy
+  case 3, 4:
+    return Cloudy
+  case 5, 6:
+    return Fog
+  case 7, 8, 9, 17, 18:
+    return Rain
+  case 10, 11, 12, 13, 19, 20, 24:
+    return Sleet
+  case 14, 15, 16, 21, 22:
+    return Snow
+  case 23, 25, 26, 27:
+    return Thunderstorm
+  }
+
+  return Unknown
+}
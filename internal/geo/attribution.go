@@ -0,0 +1,33 @@
+package geo
+
+import (
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+func (g OWMGeocoder) Attribution() upstream.Attribution {
+  return upstream.Attribution{Source: "openweathermap-geocoding", Text: "Geocoding by OpenWeather", URL: "https://openweathermap.org/"}
+}
+
+func (g NominatimGeocoder) Attribution() upstream.Attribution {
+  return upstream.Attribution{Source: "nominatim", Text: "© OpenStreetMap contributors", URL: "https://www.openstreetmap.org/copyright", License: "ODbL 1.0"}
+}
+
+func (g *CachedGeocoder) Attribution() upstream.Attribution {
+  if a, ok := g.Geocoder.(upstream.Attributed); ok {
+    return a.Attribution()
+  }
+
+  return upstream.Attribution{}
+}
+
+// Attribution credits the geocoder for places it resolved; bare coordinate
+// queries never reach it and carry no country.
+func Attribution(g Geocoder, loc Location) (upstream.Attribution, bool) {
+  a, ok := g.(upstream.Attributed)
+  if !ok || loc.Country == "" {
+    return upstream.Attribution{}, false
+  }
+
+  c := a.Attribution()
+  return c, c.Source != ""
+}
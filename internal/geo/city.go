@@ -0,0 +1,205 @@
+package geo
+
+import (
+  "errors"
+  "fmt"
+  "math"
+  "strings"
+  "unicode"
+  "unicode/utf8"
+)
+
+// ErrBadCity is a city query that can't be a place name.
+var ErrBadCity = errors.New("bad city")
+
+// Longer than any real place name, even Bangkok's ceremonial one.
+const maxCityRunes = 200
+
+// CityQuery is a free-text city request split into its parts:
+// "Paris, FR" becomes {Name: "Paris", Country: "FR"}.
+type CityQuery struct {
+  Name    string
+  Country string // ISO 3166-1 alpha-2, upper case; empty when not given
+  Lang    string // language names are wanted in, such as de; empty is the geocoder's default
+}
+
+// ParseCity splits a trailing two-letter country code off raw.
+func ParseCity(raw string) CityQuery {
+  raw = strings.Join(strings.Fields(raw), " ")
+
+  if i := strings.LastIndex(raw, ","); i >= 0 {
+    cc := strings.TrimSpace(raw[i+1:])
+    if len(cc) == 2 && isASCIILetters(cc) {
+      return CityQuery{Name: strings.TrimSpace(raw[:i]), Country: strings.ToUpper(cc)}
+    }
+  }
+
+  return CityQuery{Name: raw}
+}
+
+// Validate rejects queries that are obviously not a place name before
+// they reach a geocoder: invalid UTF-8, control characters, no letters at
+// all, overly long ones, or symbols no place name uses. Letters of any
+// script, digits and the punctuation of names such as "St. John's",
+// "Sant'Antioco" and "Trinidad & Tobago" are fine.
+func (q CityQuery) Validate() error {
+  name := q.Name
+  switch {
+  case !utf8.ValidString(name):
+    return fmt.Errorf("%w: not valid UTF-8", ErrBadCity)
+  case name == "":
+    return fmt.Errorf("%w: empty name", ErrBadCity)
+  case utf8.RuneCountInString(name) > maxCityRunes:
+    return fmt.Errorf("%w: longer than %d characters", ErrBadCity, maxCityRunes)
+  }
+
+  letters := 0
+  for _, r := range name {
+    switch {
+    case unicode.IsLetter(r):
+      letters++
+    case unicode.IsMark(r), unicode.IsDigit(r), r == ' ', strings.ContainsRune("-'’.,()/&", r):
+    default:
+      return fmt.Errorf("%w: %q is not allowed in a city name", ErrBadCity, r)
+    }
+  }
+
+  if letters == 0 {
+    return fmt.Errorf("%w: %q has no letters", ErrBadCity, name)
+  }
+
+  return nil
+}
+
+func (q CityQuery) String() string {
+  if q.Country == "" {
+    return q.Name
+  }
+
+  return q.Name + "," + q.Country
+}
+
+// Key is the normalized form used for caching and comparisons:
+// "São  Paulo,br" and "sao paulo, BR" share a key.
+func (q CityQuery) Key() string {
+  k := NormalizeName(q.Name)
+  if q.Country != "" {
+    k += "," + strings.ToLower(q.Country)
+  }
+
+  if q.Lang != "" {
+    k += ";" + strings.ToLower(q.Lang)
+  }
+
+  return k
+}
+
+func isASCIILetters(s string) bool {
+  for _, r := range s {
+    if r > unicode.MaxASCII || !unicode.IsLetter(r) {
+      return false
+    }
+  }
+
+  return true
+}
+
+// NormalizeName lower-cases, folds diacritics and collapses whitespace.
+func NormalizeName(s string) string {
+  var b strings.Builder
+  for _, r := range strings.ToLower(s) {
+    if f, ok := foldTable[r]; ok {
+      b.WriteString(f)
+      continue
+    }
+
+    switch {
+    case unicode.Is(unicode.Mn, r): // stray combining marks
+    case r == '-' || r == '\'' || unicode.IsSpace(r):
+      b.WriteRune(' ')
+    default:
+      b.WriteRune(r)
+    }
+  }
+
+  return strings.Join(strings.Fields(b.String()), " ")
+}
+
+var foldTable = func() map[rune]string {
+  pairs := []string{
+    "àáâãäåāăą", "a", "æ", "ae", "çćĉċč", "c", "ďđ", "d", "èéêëēĕėęě", "e",
+    "ĝğġģ", "g", "ĥħ", "h", "ìíîïĩīĭįı", "i", "ĵ", "j", "ķ", "k", "ĺļľŀł", "l",
+    "ñńņňŉ", "n", "òóôõöøōŏő", "o", "œ", "oe", "ŕŗř", "r", "śŝşšș", "s", "ß", "ss",
+    "ţťŧț", "t", "ùúûüũūŭůűų", "u", "ŵ", "w", "ýÿŷ", "y", "źżž", "z", "þ", "th",
+  }
+
+  t := make(map[rune]string)
+  for i := 0; i < len(pairs); i += 2 {
+    for _, r := range pairs[i] {
+      t[r] = pairs[i+1]
+    }
+  }
+
+  return t
+}()
+
+// AmbiguousError carries the candidates when a query matches several
+// distinct places equally well.
+type AmbiguousError struct {
+  Query      string
+  Candidates []Location
+}
+
+func (e *AmbiguousError) Error() string {
+  return fmt.Sprintf("%q matches %d locations", e.Query, len(e.Candidates))
+}
+
+// Candidates closer than this are considered the same place returned twice
+// (e.g. a city and its administrative boundary).
+const samePlaceKm = 50
+
+// StrongMatches returns the results that plausibly are what the user meant:
+// an exact normalized name match, at least three quarters as relevant as
+// the best result, and not a near-duplicate of one already picked.
+func StrongMatches(q CityQuery, locs []Location) []Location {
+  name := NormalizeName(q.Name)
+
+  var top float64
+  for _, l := range locs {
+    top = math.Max(top, l.Score)
+  }
+
+  var picked []Location
+  for _, l := range locs {
+    if NormalizeName(l.Name) != name || l.Score < top*0.75 {
+      continue
+    }
+
+    dup := false
+    for _, p := range picked {
+      if DistanceKm(p, l) < samePlaceKm {
+        dup = true
+        break
+      }
+    }
+
+    if !dup {
+      picked = append(picked, l)
+    }
+  }
+
+  return picked
+}
+
+// DistanceKm is the great-circle distance between two locations.
+func DistanceKm(a, b Location) float64 {
+  const earthRadiusKm = 6371
+  rad := math.Pi / 180
+
+  dLat := (b.Lat - a.Lat) * rad
+  dLon := (b.Lon - a.Lon) * rad
+  h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+    math.Cos(a.Lat*rad)*math.Cos(b.Lat*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+  return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
@@ -0,0 +1,315 @@
+// Package geo resolves city names to coordinates and holds the locations
+// providers are queried for.
+package geo
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "log"
+  "math"
+  "net/http"
+  "net/url"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// Errors for queries that match nothing and for invalid coordinates.
+var (
+  ErrLocationNotFound = errors.New("location not found")
+  ErrBadCoordinates   = errors.New("bad coordinates")
+)
+
+// Location is a place resolved to coordinates. Providers only ever see
+// locations, so coordinate-only upstreams work for free-text queries too.
+type Location struct {
+  Name       string  `json:"name"`
+  Region     string  `json:"region,omitempty"`
+  Country    string  `json:"country,omitempty"`
+  Lat        float64 `json:"lat"`
+  Lon        float64 `json:"lon"`
+  TimeZone   string  `json:"timezone,omitempty"`   // IANA, such as Europe/Oslo
+  Population int     `json:"population,omitempty"` // where the geocoder knows it
+  Score      float64 `json:"-"`                    // geocoder relevance, higher is better
+}
+
+// LatString and LonString format coordinates for upstream queries.
+func (l Location) LatString() string { return strconv.FormatFloat(l.Lat, 'f', 4, 64) }
+func (l Location) LonString() string { return strconv.FormatFloat(l.Lon, 'f', 4, 64) }
+
+// Key rounds coordinates to ~1 km, so near-identical queries share cache
+// entries, history and other per-place state.
+func (l Location) Key() string {
+  return fmt.Sprintf("%.2f,%.2f", l.Lat, l.Lon)
+}
+
+// Coordinates builds a location for a direct lat/lon query. NaN compares
+// false with every bound, so it is rejected on its own; infinities are out
+// of range.
+func Coordinates(lat, lon string) (Location, error) {
+  la, err := strconv.ParseFloat(lat, 64)
+  if err != nil || math.IsNaN(la) || la < -90 || la > 90 {
+    return Location{}, fmt.Errorf("%w: latitude %q", ErrBadCoordinates, lat)
+  }
+
+  lo, err := strconv.ParseFloat(lon, 64)
+  if err != nil || math.IsNaN(lo) || lo < -180 || lo > 180 {
+    return Location{}, fmt.Errorf("%w: longitude %q", ErrBadCoordinates, lon)
+  }
+
+  l := Location{Lat: la, Lon: lo}
+  l.Name = l.LatString() + "," + l.LonString()
+  return l, nil
+}
+
+// Geocoder finds the places matching a city query.
+type Geocoder interface {
+  Geocode(ctx context.Context, q CityQuery) ([]Location, error) // best match first
+}
+
+var owmGeocodingEndpoint = upstream.Endpoint{Base: "http://api.openweathermap.org"}
+
+// OWMGeocoder is OpenWeather's geocoding API, with the same key as its
+// weather API.
+type OWMGeocoder struct {
+  APIKey string
+}
+
+func (g OWMGeocoder) Geocode(ctx context.Context, query CityQuery) ([]Location, error) {
+  return g.Search(ctx, query, owmGeocodeLimit)
+}
+
+// The most results OWM's geocoding API returns.
+const owmGeocodeLimit = 5
+
+// Search is Geocode with up to limit results, named in query.Lang where
+// OWM knows the name in it.
+func (g OWMGeocoder) Search(ctx context.Context, query CityQuery, limit int) ([]Location, error) {
+  begin := time.Now()
+
+  var d []struct {
+    Name       string            `json:"name"`
+    LocalNames map[string]string `json:"local_names"`
+    State      string            `json:"state"`
+    Country    string            `json:"country"`
+    Lat        float64           `json:"lat"`
+    Lon        float64           `json:"lon"`
+  }
+
+  q := url.Values{"q": {query.String()}, "limit": {strconv.Itoa(min(limit, owmGeocodeLimit))}, "appid": {g.APIKey}}
+  if err := owmGeocodingEndpoint.GetJSON(ctx, "/geo/1.0/direct", q, &d); err != nil {
+    return nil, err
+  }
+
+  // OWM has no relevance score, only rank order, so just its first hit
+  // counts as a strong match.
+  locs := make([]Location, 0, len(d))
+  for i, r := range d {
+    name := r.Name
+    if local := r.LocalNames[strings.ToLower(query.Lang)]; local != "" {
+      name = local
+    }
+
+    locs = append(locs, Location{Name: name, Region: r.State, Country: r.Country, Lat: r.Lat, Lon: r.Lon, Score: 1 / float64(1+i)})
+  }
+
+  log.Printf("owmGeocoder: %s: %d results, took: %s", query, len(locs), time.Since(begin).String())
+  return locs, nil
+}
+
+// Nominatim's usage policy rejects anonymous agents, which the shared
+// User-Agent covers; names are requested in English so they stay stable,
+// unless a search asks for another language.
+var nominatimEndpoint = upstream.Endpoint{
+  Base:   "https://nominatim.openstreetmap.org",
+  Header: http.Header{"Accept-Language": {"en"}},
+}
+
+// NominatimGeocoder is OpenStreetMap's keyless geocoder.
+type NominatimGeocoder struct{}
+
+func (g NominatimGeocoder) Geocode(ctx context.Context, query CityQuery) ([]Location, error) {
+  return g.Search(ctx, query, 10)
+}
+
+// Search is Geocode with up to limit results, named in query.Lang.
+func (g NominatimGeocoder) Search(ctx context.Context, query CityQuery, limit int) ([]Location, error) {
+  begin := time.Now()
+
+  var d []struct {
+    Name       string  `json:"name"`
+    Lat        string  `json:"lat"`
+    Lon        string  `json:"lon"`
+    Importance float64 `json:"importance"`
+    Address    struct {
+      State       string `json:"state"`
+      CountryCode string `json:"country_code"`
+    } `json:"address"`
+    ExtraTags struct {
+      Population string `json:"population"`
+    } `json:"extratags"`
+  }
+
+  q := url.Values{"q": {query.Name}, "format": {"jsonv2"}, "limit": {strconv.Itoa(limit)}, "addressdetails": {"1"}, "extratags": {"1"}, "featureType": {"city"}}
+  if query.Country != "" {
+    q.Set("countrycodes", strings.ToLower(query.Country))
+  }
+
+  e := nominatimEndpoint
+  if query.Lang != "" {
+    e.Header = http.Header{"Accept-Language": {query.Lang}}
+  }
+
+  if err := e.GetJSON(ctx, "/search", q, &d); err != nil {
+    return nil, err
+  }
+
+  locs := make([]Location, 0, len(d))
+  for _, r := range d {
+    l, err := Coordinates(r.Lat, r.Lon)
+    if err != nil {
+      continue
+    }
+
+    l.Name = r.Name
+    l.Region = r.Address.State
+    l.Country = strings.ToUpper(r.Address.CountryCode)
+    l.Population, _ = strconv.Atoi(r.ExtraTags.Population) // OSM tags are free text; a bad one is none
+    l.Score = r.Importance
+    locs = append(locs, l)
+  }
+
+  log.Printf("nominatimGeocoder: %s: %d results, took: %s", query, len(locs), time.Since(begin).String())
+  return locs, nil
+}
+
+type geocodeEntry struct {
+  locs    []Location
+  expires time.Time
+}
+
+// CachedGeocoder memoizes successful lookups; city coordinates hardly move.
+// With MissTTL it also remembers queries nothing matched, so a typo asked
+// for again doesn't reach the geocoder.
+type CachedGeocoder struct {
+  Geocoder
+  ttl     time.Duration
+  MissTTL time.Duration
+
+  mu      sync.Mutex
+  entries map[string]geocodeEntry
+}
+
+// Past this many entries, storing one first drops the expired.
+const sweepEntries = 10000
+
+// NewCachedGeocoder keeps g's answers for ttl.
+func NewCachedGeocoder(g Geocoder, ttl time.Duration) *CachedGeocoder {
+  return &CachedGeocoder{Geocoder: g, ttl: ttl, entries: make(map[string]geocodeEntry)}
+}
+
+func (g *CachedGeocoder) Geocode(ctx context.Context, query CityQuery) ([]Location, error) {
+  return g.remember(query.Key(), func() ([]Location, error) { return g.Geocoder.Geocode(ctx, query) })
+}
+
+// Search is the wrapped geocoder's Search, cached apart from its Geocode
+// since it answers the same query with more and looser matches.
+func (g *CachedGeocoder) Search(ctx context.Context, query CityQuery, limit int) ([]Location, error) {
+  s, ok := g.Geocoder.(Searcher)
+  if !ok {
+    return g.Geocode(ctx, query)
+  }
+
+  key := "search:" + query.Key() + ":" + strconv.Itoa(limit)
+  return g.remember(key, func() ([]Location, error) { return s.Search(ctx, query, limit) })
+}
+
+// remember answers from the entry at key while it lasts, from lookup
+// otherwise, storing what it answers.
+func (g *CachedGeocoder) remember(key string, lookup func() ([]Location, error)) ([]Location, error) {
+  g.mu.Lock()
+  e, ok := g.entries[key]
+  g.mu.Unlock()
+
+  if ok && time.Now().Before(e.expires) {
+    return e.locs, nil
+  }
+
+  locs, err := lookup()
+  if err != nil {
+    return nil, err
+  }
+
+  ttl := g.ttl
+  if len(locs) == 0 {
+    ttl = g.MissTTL
+  }
+
+  if ttl > 0 {
+    now := time.Now()
+    g.mu.Lock()
+    if len(g.entries) >= sweepEntries {
+      // Misses are as many as clients can type; don't keep dead ones.
+      for k, e := range g.entries {
+        if !now.Before(e.expires) {
+          delete(g.entries, k)
+        }
+      }
+    }
+
+    g.entries[key] = geocodeEntry{locs: locs, expires: now.Add(ttl)}
+    g.mu.Unlock()
+  }
+
+  return locs, nil
+}
+
+// Resolve returns the best match for a city query, or an *AmbiguousError
+// when several distinct places match it equally well.
+func Resolve(ctx context.Context, g Geocoder, query CityQuery) (Location, error) {
+  if err := query.Validate(); err != nil {
+    return Location{}, err
+  }
+
+  locs, err := g.Geocode(ctx, query)
+  if err != nil {
+    return Location{}, err
+  }
+
+  if query.Country != "" {
+    filtered := locs[:0:0]
+    for _, l := range locs {
+      if strings.EqualFold(l.Country, query.Country) {
+        filtered = append(filtered, l)
+      }
+    }
+
+    locs = filtered
+  }
+
+  if len(locs) == 0 {
+    return Location{}, ErrLocationNotFound
+  }
+
+  if m := StrongMatches(query, locs); len(m) > 1 {
+    return Location{}, &AmbiguousError{Query: query.String(), Candidates: m}
+  }
+
+  return locs[0], nil
+}
+
+// New is the geocoder called name: nominatim or owm.
+func New(name, owmAPIKey string) (Geocoder, error) {
+  switch name {
+  case "nominatim":
+    return NominatimGeocoder{}, nil
+  case "owm":
+    return OWMGeocoder{APIKey: owmAPIKey}, nil
+  default:
+    return nil, fmt.Errorf("unknown geocoder %q", name)
+  }
+}
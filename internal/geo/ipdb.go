@@ -0,0 +1,304 @@
+package geo
+
+import (
+  "bytes"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "math"
+  "net/netip"
+  "os"
+  "strings"
+)
+
+// ErrAddressNotLocated is an address the database has no location for:
+// private and reserved ranges, or ranges it only knows the country of.
+var ErrAddressNotLocated = errors.New("address not located")
+
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// IPDatabase is a MaxMind DB file such as GeoLite2-City, read whole into
+// memory: a binary search tree over the address bits whose leaves point
+// into a section of typed, deduplicated records.
+// https://maxmind.github.io/MaxMind-DB/
+type IPDatabase struct {
+  Type string // database_type from the metadata, e.g. GeoLite2-City
+
+  tree       []byte
+  data       []byte
+  nodes      uint
+  recordSize uint
+  ipv4Start  uint
+  ipVersion  uint
+}
+
+// OpenIPDatabase reads the database at path. Only city databases will do:
+// a country database has no coordinates to ask the providers for.
+func OpenIPDatabase(path string) (*IPDatabase, error) {
+  buf, err := os.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+
+  at := bytes.LastIndex(buf, mmdbMetadataMarker)
+  if at < 0 {
+    return nil, fmt.Errorf("%s: not a MaxMind DB file", path)
+  }
+
+  meta, ok := mmdbDecoder{buf: buf[at+len(mmdbMetadataMarker):]}.value(0)
+  m, _ := meta.(map[string]interface{})
+  if !ok || m == nil {
+    return nil, fmt.Errorf("%s: unreadable metadata", path)
+  }
+
+  db := &IPDatabase{}
+  db.Type, _ = m["database_type"].(string)
+  nodes, _ := m["node_count"].(uint64)
+  size, _ := m["record_size"].(uint64)
+  version, _ := m["ip_version"].(uint64)
+  db.nodes, db.recordSize, db.ipVersion = uint(nodes), uint(size), uint(version)
+
+  if size != 24 && size != 28 && size != 32 {
+    return nil, fmt.Errorf("%s: unsupported record size %d", path, size)
+  }
+
+  if !strings.Contains(db.Type, "City") {
+    return nil, fmt.Errorf("%s: %s has no coordinates, want a city database such as GeoLite2-City", path, db.Type)
+  }
+
+  treeSize := db.nodes * db.recordSize / 4
+  if treeSize+16 > uint(at) {
+    return nil, fmt.Errorf("%s: truncated", path)
+  }
+
+  db.tree, db.data = buf[:treeSize], buf[treeSize+16:at]
+
+  // IPv4 addresses live at ::a.b.c.d in an IPv6 tree.
+  if db.ipVersion == 6 {
+    for i := 0; i < 96 && db.ipv4Start < db.nodes; i++ {
+      db.ipv4Start = db.record(db.ipv4Start, 0)
+    }
+  }
+
+  return db, nil
+}
+
+// record is the left (0) or right (1) record of node.
+func (db *IPDatabase) record(node, bit uint) uint {
+  switch b := db.tree[node*db.recordSize/4:]; db.recordSize {
+  case 24:
+    b = b[bit*3:]
+    return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+  case 28:
+    if bit == 0 {
+      return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+    }
+
+    return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+  default:
+    return uint(binary.BigEndian.Uint32(b[bit*4:]))
+  }
+}
+
+// Locate is the city and coordinates of ip.
+func (db *IPDatabase) Locate(ip netip.Addr) (Location, error) {
+  ip = ip.Unmap()
+
+  node := uint(0)
+  if ip.Is4() {
+    node = db.ipv4Start
+  } else if db.ipVersion == 4 {
+    return Location{}, ErrAddressNotLocated
+  }
+
+  bits := ip.AsSlice()
+  for i := 0; i < len(bits)*8 && node < db.nodes; i++ {
+    node = db.record(node, uint(bits[i/8]>>(7-i%8)&1))
+  }
+
+  if node <= db.nodes {
+    return Location{}, ErrAddressNotLocated
+  }
+
+  v, ok := mmdbDecoder{buf: db.data}.value(node - db.nodes - 16)
+  rec, _ := v.(map[string]interface{})
+  if !ok || rec == nil {
+    return Location{}, fmt.Errorf("%s: bad record for %s", db.Type, ip)
+  }
+
+  location, _ := rec["location"].(map[string]interface{})
+  lat, okLat := location["latitude"].(float64)
+  lon, okLon := location["longitude"].(float64)
+  if !okLat || !okLon {
+    return Location{}, ErrAddressNotLocated
+  }
+
+  loc := Location{Name: englishName(rec["city"]), Country: strings.ToUpper(lookupString(rec["country"], "iso_code")), Lat: lat, Lon: lon}
+  loc.TimeZone, _ = location["time_zone"].(string)
+  if subs, _ := rec["subdivisions"].([]interface{}); len(subs) > 0 {
+    loc.Region = englishName(subs[0])
+  }
+
+  if loc.Name == "" {
+    loc.Name = fmt.Sprintf("%.4f,%.4f", lat, lon)
+  }
+
+  return loc, nil
+}
+
+func lookupString(v interface{}, key string) string {
+  m, _ := v.(map[string]interface{})
+  s, _ := m[key].(string)
+  return s
+}
+
+func englishName(v interface{}) string {
+  m, _ := v.(map[string]interface{})
+  return lookupString(m["names"], "en")
+}
+
+// mmdbDecoder reads the data section format; pointers are offsets into buf.
+type mmdbDecoder struct {
+  buf []byte
+}
+
+// value decodes the value at offset.
+func (d mmdbDecoder) value(offset uint) (interface{}, bool) {
+  v, _, ok := d.decode(offset)
+  return v, ok
+}
+
+// decode returns the value at offset and the offset after it.
+func (d mmdbDecoder) decode(offset uint) (interface{}, uint, bool) {
+  if offset >= uint(len(d.buf)) {
+    return nil, 0, false
+  }
+
+  ctrl := d.buf[offset]
+  offset++
+
+  kind := uint(ctrl >> 5)
+  if kind == 1 {
+    // A pointer's size bits are the pointer itself.
+    n := uint(ctrl>>3&3) + 1
+    if offset+n > uint(len(d.buf)) {
+      return nil, 0, false
+    }
+
+    p := uint(0)
+    if n < 4 {
+      p = uint(ctrl & 7)
+    }
+
+    for _, b := range d.buf[offset : offset+n] {
+      p = p<<8 | uint(b)
+    }
+
+    p += [...]uint{0, 2048, 526336, 0}[n-1]
+    v, _, ok := d.decode(p)
+    return v, offset + n, ok
+  }
+
+  if kind == 0 {
+    if offset >= uint(len(d.buf)) {
+      return nil, 0, false
+    }
+
+    kind = 7 + uint(d.buf[offset])
+    offset++
+  }
+
+  size := uint(ctrl & 0x1f)
+  if size >= 29 {
+    n := size - 28
+    if offset+n > uint(len(d.buf)) {
+      return nil, 0, false
+    }
+
+    extra := uint(0)
+    for _, b := range d.buf[offset : offset+n] {
+      extra = extra<<8 | uint(b)
+    }
+
+    size = [...]uint{29, 285, 65821}[n-1] + extra
+    offset += n
+  }
+
+  switch kind {
+  case 7: // map
+    m := make(map[string]interface{}, size)
+    for i := uint(0); i < size; i++ {
+      k, next, ok := d.decode(offset)
+      key, isString := k.(string)
+      if !ok || !isString {
+        return nil, 0, false
+      }
+
+      var v interface{}
+      if v, offset, ok = d.decode(next); !ok {
+        return nil, 0, false
+      }
+
+      m[key] = v
+    }
+
+    return m, offset, true
+  case 11: // array
+    a := make([]interface{}, 0, size)
+    for i := uint(0); i < size; i++ {
+      var v interface{}
+      var ok bool
+      if v, offset, ok = d.decode(offset); !ok {
+        return nil, 0, false
+      }
+
+      a = append(a, v)
+    }
+
+    return a, offset, true
+  case 14: // boolean, in the size bits
+    return size != 0, offset, true
+  }
+
+  if offset+size > uint(len(d.buf)) {
+    return nil, 0, false
+  }
+
+  b := d.buf[offset : offset+size]
+  offset += size
+
+  switch kind {
+  case 2: // UTF-8 string
+    return string(b), offset, true
+  case 3: // double
+    if size != 8 {
+      return nil, 0, false
+    }
+
+    return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, true
+  case 15: // float
+    if size != 4 {
+      return nil, 0, false
+    }
+
+    return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, true
+  case 5, 6, 9: // unsigned integers of up to 2, 4 and 8 bytes
+    u := uint64(0)
+    for _, c := range b {
+      u = u<<8 | uint64(c)
+    }
+
+    return u, offset, true
+  case 8: // int32
+    u := uint32(0)
+    for _, c := range b {
+      u = u<<8 | uint32(c)
+    }
+
+    return int64(int32(u)), offset, true
+  case 4, 10: // bytes, and uint128 kept as its bytes
+    return b, offset, true
+  }
+
+  return nil, 0, false
+}
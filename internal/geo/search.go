@@ -0,0 +1,151 @@
+package geo
+
+import (
+  "context"
+  "math"
+  "sort"
+  "strings"
+)
+
+// Searcher is implemented by geocoders that match partial and misspelt
+// names themselves, which Geocode is too strict for.
+type Searcher interface {
+  Search(ctx context.Context, q CityQuery, limit int) ([]Location, error)
+}
+
+// Search is the places a user typing q may be after, for autocomplete:
+// g's own Search if it has one, its Geocode otherwise, best first as Rank
+// puts them and at most limit.
+func Search(ctx context.Context, g Geocoder, q CityQuery, limit int) ([]Location, error) {
+  if err := q.Validate(); err != nil {
+    return nil, err
+  }
+
+  var locs []Location
+  var err error
+  if s, ok := g.(Searcher); ok {
+    locs, err = s.Search(ctx, q, limit)
+  } else {
+    locs, err = g.Geocode(ctx, q)
+  }
+
+  if err != nil {
+    return nil, err
+  }
+
+  if q.Country != "" {
+    filtered := locs[:0:0]
+    for _, l := range locs {
+      if strings.EqualFold(l.Country, q.Country) {
+        filtered = append(filtered, l)
+      }
+    }
+
+    locs = filtered
+  }
+
+  locs = Rank(q, locs)
+  if len(locs) > limit {
+    locs = locs[:limit]
+  }
+
+  return locs, nil
+}
+
+// Rank orders locs for q: how closely the name matches what was typed
+// counts most, then the geocoder's own relevance, then how many people
+// live there, so "spring" offers Springfield, Illinois before a hamlet
+// of the same name. Near-duplicates of a better match are dropped.
+func Rank(q CityQuery, locs []Location) []Location {
+  var top float64
+  for _, l := range locs {
+    top = math.Max(top, l.Score)
+  }
+
+  type ranked struct {
+    Location
+    rank float64
+  }
+
+  rs := make([]ranked, 0, len(locs))
+  for _, l := range locs {
+    rank := 2 * Similarity(q.Name, l.Name)
+    if top > 0 {
+      rank += l.Score / top
+    }
+
+    if l.Population > 0 {
+      rank += math.Log10(float64(l.Population)) / 7
+    }
+
+    rs = append(rs, ranked{l, rank})
+  }
+
+  sort.SliceStable(rs, func(i, j int) bool { return rs[i].rank > rs[j].rank })
+
+  picked := make([]Location, 0, len(rs))
+  for _, r := range rs {
+    dup := false
+    for _, p := range picked {
+      if NormalizeName(p.Name) == NormalizeName(r.Name) && DistanceKm(p, r.Location) < samePlaceKm {
+        dup = true
+        break
+      }
+    }
+
+    if !dup {
+      picked = append(picked, r.Location)
+    }
+  }
+
+  return picked
+}
+
+// Similarity is how well name matches what was typed, from 0 to 1: 1 for
+// the same name, near it for a name typed so far, less for every letter
+// wrong, missing or extra. Case and diacritics don't count.
+func Similarity(typed, name string) float64 {
+  a, b := []rune(NormalizeName(typed)), []rune(NormalizeName(name))
+  switch {
+  case len(a) == 0 || len(b) == 0:
+    return 0
+  case string(a) == string(b):
+    return 1
+  case len(a) < len(b) && string(b[:len(a)]) == string(a):
+    return 0.9 + 0.05*float64(len(a))/float64(len(b))
+  }
+
+  // A typo in the part typed so far weighs against the prefix, not the
+  // whole name; a misspelt whole name against the name.
+  whole := 1 - float64(editDistance(a, b))/float64(max(len(a), len(b)))
+  prefix := whole
+  if len(a) < len(b) {
+    prefix = 1 - float64(editDistance(a, b[:len(a)]))/float64(len(a))
+  }
+
+  return math.Max(0, 0.85*math.Max(whole, prefix))
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b []rune) int {
+  prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+  for j := range prev {
+    prev[j] = j
+  }
+
+  for i := 1; i <= len(a); i++ {
+    cur[0] = i
+    for j := 1; j <= len(b); j++ {
+      cost := 1
+      if a[i-1] == b[j-1] {
+        cost = 0
+      }
+
+      cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+    }
+
+    prev, cur = cur, prev
+  }
+
+  return prev[len(b)]
+}
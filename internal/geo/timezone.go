@@ -0,0 +1,92 @@
+package geo
+
+import (
+  "context"
+  "errors"
+  "net/url"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+var timeZoneEndpoint = upstream.Endpoint{Base: "https://api.open-meteo.com"}
+
+const (
+  maxTimeZones     = 10000
+  timeZoneTimeout  = 2 * time.Second
+  timeZoneRetryGap = 5 * time.Minute // after a failed lookup
+)
+
+type timeZoneEntry struct {
+  name  string
+  retry time.Time // of a failed lookup
+}
+
+// TimeZones names the IANA time zone of places. Open-Meteo names the zone
+// of any coordinates its forecast API is asked about with timezone=auto;
+// zones don't move, so answers are kept for good, by Key.
+type TimeZones struct {
+  BaseURL string // instead of https://api.open-meteo.com
+
+  mu    sync.Mutex
+  zones map[string]timeZoneEntry
+}
+
+// Locate is loc with its TimeZone, unless the geocoder already said or the
+// zone can't be found in time; a nil TimeZones leaves loc be.
+func (z *TimeZones) Locate(ctx context.Context, loc Location) Location {
+  if z == nil || loc.TimeZone != "" {
+    return loc
+  }
+
+  key := loc.Key()
+
+  z.mu.Lock()
+  e, ok := z.zones[key]
+  z.mu.Unlock()
+
+  if ok && (e.name != "" || time.Now().Before(e.retry)) {
+    loc.TimeZone = e.name
+    return loc
+  }
+
+  ctx, cancel := context.WithTimeout(ctx, timeZoneTimeout)
+  defer cancel()
+
+  name, err := z.find(ctx, loc)
+  e = timeZoneEntry{name: name}
+  if err != nil {
+    e.retry = time.Now().Add(timeZoneRetryGap)
+  }
+
+  z.mu.Lock()
+  if z.zones == nil {
+    z.zones = make(map[string]timeZoneEntry)
+  }
+
+  if len(z.zones) < maxTimeZones {
+    z.zones[key] = e
+  }
+  z.mu.Unlock()
+
+  loc.TimeZone = name
+  return loc
+}
+
+func (z *TimeZones) find(ctx context.Context, loc Location) (string, error) {
+  var d struct {
+    TimeZone string `json:"timezone"`
+  }
+
+  q := url.Values{"latitude": {loc.LatString()}, "longitude": {loc.LonString()}, "timezone": {"auto"}, "forecast_days": {"1"}}
+  if err := timeZoneEndpoint.At(z.BaseURL).GetJSON(ctx, "/v1/forecast", q, &d); err != nil {
+    return "", err
+  }
+
+  if d.TimeZone == "" {
+    return "", errors.New("open-meteo named no time zone")
+  }
+
+  return d.TimeZone, nil
+}
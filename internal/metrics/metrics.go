@@ -0,0 +1,119 @@
+// Package metrics keeps labelled counters and gauges and serves them in the
+// Prometheus text format.
+package metrics
+
+import (
+  "fmt"
+  "io"
+  "net/http"
+  "sort"
+  "strings"
+  "sync"
+)
+
+// Metric is a labelled counter or gauge exposed in the Prometheus text
+// format on /metrics.
+type Metric struct {
+  name   string
+  help   string
+  kind   string // counter or gauge
+  labels []string
+
+  mu     sync.Mutex
+  values map[string]float64 // joined label values -> value
+}
+
+var (
+  registryMu sync.Mutex
+  registry   []*Metric
+)
+
+func newMetric(kind, name, help string, labels ...string) *Metric {
+  m := &Metric{name: name, help: help, kind: kind, labels: labels, values: make(map[string]float64)}
+
+  registryMu.Lock()
+  registry = append(registry, m)
+  registryMu.Unlock()
+
+  return m
+}
+
+func NewCounter(name, help string, labels ...string) *Metric {
+  return newMetric("counter", name, help, labels...)
+}
+
+func NewGauge(name, help string, labels ...string) *Metric {
+  return newMetric("gauge", name, help, labels...)
+}
+
+const labelSep = "\xff"
+
+func (m *Metric) Add(v float64, labelValues ...string) {
+  m.mu.Lock()
+  m.values[strings.Join(labelValues, labelSep)] += v
+  m.mu.Unlock()
+}
+
+func (m *Metric) Inc(labelValues ...string) { m.Add(1, labelValues...) }
+
+func (m *Metric) Set(v float64, labelValues ...string) {
+  m.mu.Lock()
+  m.values[strings.Join(labelValues, labelSep)] = v
+  m.mu.Unlock()
+}
+
+// Delete drops a series, for gauges of something that has stopped
+// reporting, rather than leaving its last value up.
+func (m *Metric) Delete(labelValues ...string) {
+  m.mu.Lock()
+  delete(m.values, strings.Join(labelValues, labelSep))
+  m.mu.Unlock()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (m *Metric) write(w io.Writer) {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+
+  keys := make([]string, 0, len(m.values))
+  for k := range m.values {
+    keys = append(keys, k)
+  }
+
+  sort.Strings(keys)
+
+  for _, k := range keys {
+    fmt.Fprint(w, m.name)
+    if len(m.labels) > 0 {
+      vals := strings.Split(k, labelSep)
+      pairs := make([]string, len(m.labels))
+      for i, l := range m.labels {
+        v := ""
+        if i < len(vals) {
+          v = vals[i]
+        }
+
+        pairs[i] = l + `="` + labelEscaper.Replace(v) + `"`
+      }
+
+      fmt.Fprint(w, "{"+strings.Join(pairs, ",")+"}")
+    }
+
+    fmt.Fprintf(w, " %g\n", m.values[k])
+  }
+}
+
+// Handler serves every registered metric.
+func Handler(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+  registryMu.Lock()
+  defer registryMu.Unlock()
+
+  for _, m := range registry {
+    m.write(w)
+  }
+}
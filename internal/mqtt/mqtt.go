@@ -0,0 +1,347 @@
+// Package mqtt is a small MQTT 3.1.1 client that only publishes, at QoS 0
+// or 1, over one connection it dials on first use and redials after any
+// error. http://docs.oasis-open.org/mqtt/mqtt/v3.1.1/mqtt-v3.1.1.html
+package mqtt
+
+import (
+  "bufio"
+  "context"
+  "crypto/tls"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "io"
+  "net"
+  "net/url"
+  "sync"
+  "time"
+)
+
+const (
+  packetConnect    = 1
+  packetConnack    = 2
+  packetPublish    = 3
+  packetPuback     = 4
+  packetPingreq    = 12
+  packetPingresp   = 13
+  packetDisconnect = 14
+)
+
+// ParseBroker checks a broker URL: tcp:// or mqtt:// for plain TCP, on
+// port 1883 by default, and ssl://, tls:// or mqtts:// for TLS, on 8883.
+func ParseBroker(broker string) (addr string, secure bool, err error) {
+  u, err := url.Parse(broker)
+  if err != nil || u.Host == "" {
+    return "", false, fmt.Errorf("want a broker URL such as tcp://localhost:1883, got %q", broker)
+  }
+
+  port := "1883"
+  switch u.Scheme {
+  case "tcp", "mqtt":
+  case "ssl", "tls", "mqtts":
+    secure, port = true, "8883"
+  default:
+    return "", false, fmt.Errorf("broker %q: want scheme tcp, mqtt, ssl, tls or mqtts", broker)
+  }
+
+  if u.Port() != "" {
+    port = u.Port()
+  }
+
+  return net.JoinHostPort(u.Hostname(), port), secure, nil
+}
+
+// Client publishes to one broker. Publishes are serialized; at QoS 1 each
+// waits for the broker's acknowledgement.
+type Client struct {
+  Broker   string // see ParseBroker
+  ClientID string
+  Username string
+  Password string
+  // KeepAlive is promised to the broker, which drops connections silent
+  // for longer; the client pings when idle for it. Default 60s.
+  KeepAlive time.Duration
+
+  mu     sync.Mutex
+  conn   net.Conn
+  r      *bufio.Reader
+  nextID uint16
+  last   time.Time // of the last packet sent
+  pinger sync.Once
+}
+
+// Publish sends payload to topic, dialing the broker if there is no
+// connection yet.
+func (c *Client) Publish(ctx context.Context, topic string, payload []byte, qos byte, retain bool) error {
+  if qos > 1 {
+    return fmt.Errorf("qos %d: only 0 and 1 are supported", qos)
+  }
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  if err := c.connect(ctx); err != nil {
+    return err
+  }
+
+  flags := qos << 1
+  if retain {
+    flags |= 1
+  }
+
+  body := appendString(nil, topic)
+  id := c.packetID()
+  if qos > 0 {
+    body = binary.BigEndian.AppendUint16(body, id)
+  }
+
+  body = append(body, payload...)
+  if err := c.exchange(ctx, packetPublish<<4|flags, body); err != nil {
+    return err
+  }
+
+  if qos == 0 {
+    return nil
+  }
+
+  for {
+    kind, body, err := c.read()
+    if err != nil {
+      c.drop()
+      return fmt.Errorf("mqtt: waiting for puback: %w", err)
+    }
+
+    if kind == packetPuback && len(body) == 2 && binary.BigEndian.Uint16(body) == id {
+      return nil
+    }
+  }
+}
+
+// Close disconnects cleanly.
+func (c *Client) Close() error {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  if c.conn == nil {
+    return nil
+  }
+
+  c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+  c.conn.Write([]byte{packetDisconnect << 4, 0})
+  return c.drop()
+}
+
+func (c *Client) keepAlive() time.Duration {
+  if c.KeepAlive <= 0 {
+    return time.Minute
+  }
+
+  return c.KeepAlive
+}
+
+func (c *Client) packetID() uint16 {
+  c.nextID++
+  if c.nextID == 0 {
+    c.nextID = 1
+  }
+
+  return c.nextID
+}
+
+// connect dials and sends CONNECT unless connected; the caller holds mu.
+func (c *Client) connect(ctx context.Context) error {
+  if c.conn != nil {
+    return nil
+  }
+
+  addr, secure, err := ParseBroker(c.Broker)
+  if err != nil {
+    return err
+  }
+
+  ctx, cancel := withTimeout(ctx)
+  defer cancel()
+
+  var conn net.Conn
+  if secure {
+    host, _, _ := net.SplitHostPort(addr)
+    conn, err = (&tls.Dialer{Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", addr)
+  } else {
+    conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+  }
+
+  if err != nil {
+    return fmt.Errorf("mqtt: %w", err)
+  }
+
+  c.conn, c.r = conn, bufio.NewReader(conn)
+
+  var flags byte = 0x02 // clean session: nothing is subscribed to resume
+  if c.Username != "" {
+    flags |= 0x80
+  }
+
+  if c.Password != "" {
+    flags |= 0x40
+  }
+
+  body := appendString(nil, "MQTT")
+  body = append(body, 4, flags) // protocol level 4 is 3.1.1
+  body = binary.BigEndian.AppendUint16(body, uint16(c.keepAlive()/time.Second))
+  body = appendString(body, c.ClientID)
+  if c.Username != "" {
+    body = appendString(body, c.Username)
+  }
+
+  if c.Password != "" {
+    body = appendString(body, c.Password)
+  }
+
+  if err := c.exchange(ctx, packetConnect<<4, body); err != nil {
+    return err
+  }
+
+  kind, ack, err := c.read()
+  switch {
+  case err != nil:
+    c.drop()
+    return fmt.Errorf("mqtt: waiting for connack: %w", err)
+  case kind != packetConnack || len(ack) != 2:
+    c.drop()
+    return fmt.Errorf("mqtt: want connack, got packet type %d", kind)
+  case ack[1] != 0:
+    c.drop()
+    return fmt.Errorf("mqtt: broker refused the connection: %s", refusal(ack[1]))
+  }
+
+  c.pinger.Do(func() { go c.ping() })
+  return nil
+}
+
+func refusal(code byte) string {
+  switch code {
+  case 1:
+    return "unacceptable protocol version"
+  case 2:
+    return "client id rejected"
+  case 3:
+    return "server unavailable"
+  case 4:
+    return "bad username or password"
+  case 5:
+    return "not authorized"
+  }
+
+  return fmt.Sprintf("return code %d", code)
+}
+
+// ping keeps an idle connection open.
+func (c *Client) ping() {
+  for range time.Tick(c.keepAlive() / 2) {
+    c.mu.Lock()
+    if c.conn != nil && time.Since(c.last) >= c.keepAlive()/2 {
+      ctx, cancel := withTimeout(context.Background())
+      if err := c.exchange(ctx, packetPingreq<<4, nil); err == nil {
+        if kind, _, err := c.read(); err != nil || kind != packetPingresp {
+          c.drop()
+        }
+      }
+
+      cancel()
+    }
+    c.mu.Unlock()
+  }
+}
+
+// exchange writes one packet; on failure the connection is dropped, to be
+// redialed next time.
+func (c *Client) exchange(ctx context.Context, header byte, body []byte) error {
+  deadline, _ := ctx.Deadline()
+  if deadline.IsZero() {
+    deadline = time.Now().Add(defaultTimeout)
+  }
+
+  c.conn.SetDeadline(deadline)
+
+  packet := appendLength([]byte{header}, len(body))
+  if _, err := c.conn.Write(append(packet, body...)); err != nil {
+    c.drop()
+    return fmt.Errorf("mqtt: %w", err)
+  }
+
+  c.last = time.Now()
+  return nil
+}
+
+// read returns the next packet's type and body.
+func (c *Client) read() (byte, []byte, error) {
+  header, err := c.r.ReadByte()
+  if err != nil {
+    return 0, nil, err
+  }
+
+  n, mult := 0, 1
+  for i := 0; ; i++ {
+    b, err := c.r.ReadByte()
+    if err != nil {
+      return 0, nil, err
+    }
+
+    if i == 4 {
+      return 0, nil, errors.New("malformed remaining length")
+    }
+
+    n += int(b&0x7f) * mult
+    mult *= 128
+    if b&0x80 == 0 {
+      break
+    }
+  }
+
+  body := make([]byte, n)
+  if _, err := io.ReadFull(c.r, body); err != nil {
+    return 0, nil, err
+  }
+
+  return header >> 4, body, nil
+}
+
+func (c *Client) drop() error {
+  if c.conn == nil {
+    return nil
+  }
+
+  err := c.conn.Close()
+  c.conn, c.r = nil, nil
+  return err
+}
+
+const defaultTimeout = 10 * time.Second
+
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+  if _, ok := ctx.Deadline(); ok {
+    return ctx, func() {}
+  }
+
+  return context.WithTimeout(ctx, defaultTimeout)
+}
+
+func appendString(b []byte, s string) []byte {
+  b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+  return append(b, s...)
+}
+
+// appendLength appends the variable-length remaining length of a packet.
+func appendLength(b []byte, n int) []byte {
+  for {
+    d := byte(n % 128)
+    if n /= 128; n > 0 {
+      d |= 0x80
+    }
+
+    b = append(b, d)
+    if n == 0 {
+      return b
+    }
+  }
+}
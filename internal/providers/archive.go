@@ -0,0 +1,157 @@
+package providers
+
+import (
+  "context"
+  "fmt"
+  "log"
+  "net/url"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/condition"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// Historian is implemented by providers with an archive of past weather:
+// the hourly temperatures they recorded on day, a UTC calendar day.
+type Historian interface {
+  Provider
+  History(ctx context.Context, loc geo.Location, day time.Time) ([]ForecastPoint, error)
+}
+
+var (
+  openMeteoArchiveEndpoint = upstream.Endpoint{Base: "https://archive-api.open-meteo.com", Provider: "open-meteo"}
+  visualCrossingEndpoint   = upstream.Endpoint{Base: "https://weather.visualcrossing.com", Provider: "visualcrossing"}
+)
+
+// History is Open-Meteo's reanalysis archive, from 1940 up to about five
+// days ago.
+func (w OpenMeteo) History(ctx context.Context, loc geo.Location, day time.Time) ([]ForecastPoint, error) {
+  var d struct {
+    Hourly struct {
+      Time    []int64    `json:"time"`
+      Celsius []*float64 `json:"temperature_2m"`
+    } `json:"hourly"`
+  }
+
+  date := day.UTC().Format(time.DateOnly)
+  q := url.Values{
+    "hourly":     {"temperature_2m"},
+    "start_date": {date},
+    "end_date":   {date},
+    "timeformat": {"unixtime"},
+    "latitude":   {loc.LatString()},
+    "longitude":  {loc.LonString()},
+  }
+
+  if err := w.archiveEndpoint().getJSON(ctx, "/v1/archive", q, &d); err != nil {
+    return nil, err
+  }
+
+  var ps []ForecastPoint
+  for i, t := range d.Hourly.Time {
+    // Days not yet in the archive come back as nulls.
+    if i < len(d.Hourly.Celsius) && d.Hourly.Celsius[i] != nil {
+      ps = append(ps, ForecastPoint{Valid: time.Unix(t, 0).UTC(), Kelvin: *d.Hourly.Celsius[i] + 273.15})
+    }
+  }
+
+  if len(ps) == 0 {
+    return nil, fmt.Errorf("no archived data for %s yet", date)
+  }
+
+  return ps, nil
+}
+
+// VisualCrossing is visualcrossing.com's Timeline API: current conditions
+// and decades of history behind one key.
+type VisualCrossing struct {
+  APIKey  string
+  BaseURL string // instead of https://weather.visualcrossing.com
+}
+
+func (w VisualCrossing) Name() string { return "visualcrossing" }
+
+func (w VisualCrossing) endpoint() core {
+  return core{visualCrossingEndpoint.At(w.BaseURL), w.APIKey}
+}
+
+func (w VisualCrossing) WithAPIKey(key string) Provider { w.APIKey = key; return w }
+
+// Only paid Visual Crossing plans may back a commercial service.
+func (w VisualCrossing) Terms() Terms { return Terms{} }
+
+func (w VisualCrossing) Attribution() upstream.Attribution {
+  return upstream.Attribution{Source: w.Name(), Text: "Weather data provided by Visual Crossing", URL: "https://www.visualcrossing.com/"}
+}
+
+type visualCrossingHour struct {
+  Epoch   int64    `json:"datetimeEpoch"`
+  Celsius *float64 `json:"temp"`
+  Icon    string   `json:"icon"`
+}
+
+func (w VisualCrossing) timeline(ctx context.Context, loc geo.Location, span, include string, v interface{}) error {
+  path := "/VisualCrossingWebServices/rest/services/timeline/" + loc.LatString() + "," + loc.LonString() + "/" + span
+  q := url.Values{"key": {w.APIKey}, "unitGroup": {"metric"}, "include": {include}, "elements": {"datetimeEpoch,temp,icon"}}
+  return w.endpoint().getJSON(ctx, path, q, v)
+}
+
+func (w VisualCrossing) Temperature(ctx context.Context, loc geo.Location) (float64, error) {
+  o, err := w.Observe(ctx, loc)
+  return o.Kelvin, err
+}
+
+func (w VisualCrossing) Observe(ctx context.Context, loc geo.Location) (Observation, error) {
+  begin := time.Now()
+
+  var d struct {
+    Current *visualCrossingHour `json:"currentConditions"`
+  }
+
+  if err := w.timeline(ctx, loc, "today", "current", &d); err != nil {
+    return Observation{}, err
+  }
+
+  if d.Current == nil || d.Current.Celsius == nil {
+    return Observation{}, errNoTemperature
+  }
+
+  o := Observation{Kelvin: *d.Current.Celsius + 273.15, Condition: condition.FromVisualCrossing(d.Current.Icon), Time: unixTime(d.Current.Epoch)}
+  log.Printf("visualCrossing: %s: %.2f, took: %s", loc.Name, o.Kelvin, time.Since(begin).String())
+  return o, nil
+}
+
+// History asks for the local days around day, as the API's days are the
+// place's, and keeps the hours of the UTC day.
+func (w VisualCrossing) History(ctx context.Context, loc geo.Location, day time.Time) ([]ForecastPoint, error) {
+  var d struct {
+    Days []struct {
+      Hours []visualCrossingHour `json:"hours"`
+    } `json:"days"`
+  }
+
+  y, m, dd := day.UTC().Date()
+  from := time.Date(y, m, dd, 0, 0, 0, 0, time.UTC)
+  to := from.AddDate(0, 0, 1)
+  span := from.AddDate(0, 0, -1).Format(time.DateOnly) + "/" + to.Format(time.DateOnly)
+  if err := w.timeline(ctx, loc, span, "hours", &d); err != nil {
+    return nil, err
+  }
+
+  var ps []ForecastPoint
+  for _, local := range d.Days {
+    for _, h := range local.Hours {
+      t := time.Unix(h.Epoch, 0).UTC()
+      if h.Celsius != nil && !t.Before(from) && t.Before(to) {
+        ps = append(ps, ForecastPoint{Valid: t, Kelvin: *h.Celsius + 273.15})
+      }
+    }
+  }
+
+  if len(ps) == 0 {
+    return nil, fmt.Errorf("no history for %s", from.Format(time.DateOnly))
+  }
+
+  return ps, nil
+}
@@ -0,0 +1,34 @@
+package providers
+
+import (
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+func (w OpenWeatherMap) Attribution() upstream.Attribution {
+  return upstream.Attribution{Source: w.Name(), Text: "Weather data provided by OpenWeather", URL: "https://openweathermap.org/"}
+}
+
+func (w WeatherUnderground) Attribution() upstream.Attribution {
+  return upstream.Attribution{Source: w.Name(), Text: "Data provided by Weather Underground", URL: "https://www.wunderground.com/"}
+}
+
+func (w OpenMeteo) Attribution() upstream.Attribution {
+  return upstream.Attribution{Source: w.Name(), Text: "Weather data by Open-Meteo.com", URL: "https://open-meteo.com/", License: "CC BY 4.0"}
+}
+
+func (w MetNo) Attribution() upstream.Attribution {
+  return upstream.Attribution{Source: w.Name(), Text: "Data from The Norwegian Meteorological Institute (MET Norway)", URL: "https://api.met.no/", License: "CC BY 4.0"}
+}
+
+// Attributions lists the credits of the providers behind a reading.
+// Plugins only have one if they announced it.
+func Attributions(providers []Provider) []upstream.Attribution {
+  var as []upstream.Attribution
+  for _, p := range providers {
+    if a, ok := p.(upstream.Attributed); ok && a.Attribution().Text != "" {
+      as = append(as, a.Attribution())
+    }
+  }
+
+  return as
+}
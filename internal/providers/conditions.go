@@ -0,0 +1,283 @@
+package providers
+
+import (
+  "context"
+  "net/url"
+  "strings"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/condition"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+)
+
+// Condition is the weather a provider reports now, with its own
+// description in Lang and what it measures of the air, nil where it
+// doesn't say.
+type Condition struct {
+  Code condition.Code
+  Text string
+  Lang string // empty when the provider has no text
+
+  Kelvin        *float64
+  Humidity      *float64 // relative, %
+  Wind          *float64 // speed, m/s
+  WindDirection *float64 // degrees clockwise from north it blows from
+  Gust          *float64 // m/s
+
+  // What falls and lies, for those that say: over the provider's current
+  // step, from 15 minutes to an hour.
+  Precipitation *float64 // mm, snow melted
+  Snowfall      *float64 // cm
+  SnowDepth     *float64 // m on the ground
+  FreezingLevel *float64 // m above sea level
+}
+
+// kmh is v km/h in m/s.
+func kmh(v *float64) *float64 {
+  if v == nil {
+    return nil
+  }
+
+  ms := *v / 3.6
+  return &ms
+}
+
+// celsius is c in kelvin.
+func celsius(c *float64) *float64 {
+  if c == nil {
+    return nil
+  }
+
+  k := *c + 273.15
+  return &k
+}
+
+// Observation is a provider's current reading.
+type Observation struct {
+  Kelvin    float64
+  Condition condition.Code
+  Time      time.Time // observed, or the model step it is of; zero when the provider doesn't say
+}
+
+// Observer is implemented by providers whose current reading comes with
+// the condition, so Readings gets both from one request.
+type Observer interface {
+  Provider
+  Observe(ctx context.Context, loc geo.Location) (Observation, error)
+}
+
+// Describer is implemented by providers that describe current conditions.
+// lang is the client's language tag, such as ru or pt-BR; providers that
+// can't answer in it describe in English or not at all.
+type Describer interface {
+  Provider
+  Conditions(ctx context.Context, loc geo.Location, lang string) (Condition, error)
+}
+
+// OpenWeather names a few languages its own way.
+var owmLanguages = map[string]string{
+  "af": "af", "ar": "ar", "az": "az", "bg": "bg", "ca": "ca", "cs": "cz", "da": "da", "de": "de", "el": "el",
+  "en": "en", "es": "es", "eu": "eu", "fa": "fa", "fi": "fi", "fr": "fr", "gl": "gl", "he": "he", "hi": "hi",
+  "hr": "hr", "hu": "hu", "id": "id", "it": "it", "ja": "ja", "ko": "kr", "lt": "lt", "lv": "la", "mk": "mk",
+  "nb": "no", "nl": "nl", "pl": "pl", "pt": "pt", "pt-br": "pt_br", "ro": "ro", "ru": "ru", "sk": "sk",
+  "sl": "sl", "sq": "al", "sr": "sr", "sv": "sv", "th": "th", "tr": "tr", "uk": "uk", "vi": "vi",
+  "zh-cn": "zh_cn", "zh-tw": "zh_tw", "zu": "zu",
+}
+
+var visualCrossingLanguages = []string{
+  "ar", "bg", "cs", "da", "de", "el", "en", "es", "fa", "fi", "fr", "he", "hu", "it", "ja", "ko", "nl", "pl",
+  "pt", "ru", "sk", "sr", "sv", "tr", "uk", "vi", "zh",
+}
+
+// supported finds a language of the provider's for tag: pt-BR is pt-br if
+// the provider has it, pt otherwise. Norwegian is nb whichever way it's tagged.
+func supported(tag string, has func(string) bool) string {
+  tag = strings.ToLower(tag)
+  base, _, _ := strings.Cut(tag, "-")
+  if base == "no" || base == "nn" {
+    base, tag = "nb", "nb"
+  }
+
+  for _, l := range []string{tag, base} {
+    if has(l) {
+      return l
+    }
+  }
+
+  return "en"
+}
+
+func (w OpenWeatherMap) Conditions(ctx context.Context, loc geo.Location, lang string) (Condition, error) {
+  var d struct {
+    Weather []struct {
+      ID          int    `json:"id"`
+      Description string `json:"description"`
+    } `json:"weather"`
+    Main struct {
+      Kelvin   *float64 `json:"temp"`
+      Humidity *float64 `json:"humidity"`
+    } `json:"main"`
+    Wind struct {
+      Speed     *float64 `json:"speed"`
+      Direction *float64 `json:"deg"`
+      Gust      *float64 `json:"gust"`
+    } `json:"wind"`
+  }
+
+  l := supported(lang, func(l string) bool { _, ok := owmLanguages[l]; return ok })
+  q := url.Values{"APPID": {w.APIKey}, "lat": {loc.LatString()}, "lon": {loc.LonString()}, "lang": {owmLanguages[l]}}
+  if err := w.endpoint().getJSON(ctx, "/data/2.5/weather", q, &d); err != nil {
+    return Condition{}, err
+  }
+
+  if len(d.Weather) == 0 {
+    return Condition{}, errNoConditions
+  }
+
+  c := d.Weather[0]
+  return Condition{
+    Code: condition.FromOpenWeather(c.ID), Text: c.Description, Lang: l, Kelvin: d.Main.Kelvin, Humidity: d.Main.Humidity,
+    Wind: d.Wind.Speed, WindDirection: d.Wind.Direction, Gust: d.Wind.Gust,
+  }, nil
+}
+
+// Conditions is Open-Meteo's WMO weather code, with the air at 2 m, the
+// wind at 10 m and the last 15 minutes' precipitation and snow; it has no
+// text of its own.
+func (w OpenMeteo) Conditions(ctx context.Context, loc geo.Location, lang string) (Condition, error) {
+  var d struct {
+    Current struct {
+      Code          *int     `json:"weather_code"`
+      Celsius       *float64 `json:"temperature_2m"`
+      Humidity      *float64 `json:"relative_humidity_2m"`
+      Wind          *float64 `json:"wind_speed_10m"`
+      Direction     *float64 `json:"wind_direction_10m"`
+      Gust          *float64 `json:"wind_gusts_10m"`
+      Precipitation *float64 `json:"precipitation"`
+      Snowfall      *float64 `json:"snowfall"`
+      SnowDepth     *float64 `json:"snow_depth"`
+      FreezingLevel *float64 `json:"freezing_level_height"`
+    } `json:"current"`
+  }
+
+  q := url.Values{
+    "current":         {"weather_code,temperature_2m,relative_humidity_2m,wind_speed_10m,wind_direction_10m,wind_gusts_10m,precipitation,snowfall,snow_depth,freezing_level_height"},
+    "wind_speed_unit": {"ms"},
+    "latitude":        {loc.LatString()},
+    "longitude":       {loc.LonString()},
+  }
+  if err := w.endpoint().getJSON(ctx, "/v1/forecast", q, &d); err != nil {
+    return Condition{}, err
+  }
+
+  if d.Current.Code == nil {
+    return Condition{}, errNoConditions
+  }
+
+  c := d.Current
+  return Condition{
+    Code: condition.FromWMO(*c.Code), Kelvin: celsius(c.Celsius), Humidity: c.Humidity, Wind: c.Wind, WindDirection: c.Direction, Gust: c.Gust,
+    Precipitation: c.Precipitation, Snowfall: c.Snowfall, SnowDepth: c.SnowDepth, FreezingLevel: c.FreezingLevel,
+  }, nil
+}
+
+// Conditions is the symbol of MET Norway's next hour, with its
+// precipitation; it has no text of its own either, and the compact
+// forecast has no gusts, snow depth or freezing level.
+func (w MetNo) Conditions(ctx context.Context, loc geo.Location, lang string) (Condition, error) {
+  var d struct {
+    Properties struct {
+      Timeseries []struct {
+        Data struct {
+          Instant struct {
+            Details struct {
+              Celsius   *float64 `json:"air_temperature"`
+              Humidity  *float64 `json:"relative_humidity"`
+              Wind      *float64 `json:"wind_speed"`
+              Direction *float64 `json:"wind_from_direction"`
+            } `json:"details"`
+          } `json:"instant"`
+          Next metNoHour `json:"next_1_hours"`
+        } `json:"data"`
+      } `json:"timeseries"`
+    } `json:"properties"`
+  }
+
+  q := url.Values{"lat": {loc.LatString()}, "lon": {loc.LonString()}}
+  if err := w.endpoint().getJSON(ctx, "/weatherdata/locationforecast/2.0/compact", q, &d); err != nil {
+    return Condition{}, err
+  }
+
+  if len(d.Properties.Timeseries) == 0 || d.Properties.Timeseries[0].Data.Next.Summary.Symbol == "" {
+    return Condition{}, errNoConditions
+  }
+
+  now := d.Properties.Timeseries[0].Data
+  air := now.Instant.Details
+  code := condition.FromMetNo(now.Next.Summary.Symbol)
+  return Condition{
+    Code: code, Kelvin: celsius(air.Celsius), Humidity: air.Humidity, Wind: air.Wind, WindDirection: air.Direction,
+    Precipitation: now.Next.Details.Precipitation, Snowfall: now.Next.snowfall(code),
+  }, nil
+}
+
+// metNoHour is a step's next_1_hours.
+type metNoHour struct {
+  Summary struct {
+    Symbol string `json:"symbol_code"`
+  } `json:"summary"`
+  Details struct {
+    Precipitation *float64 `json:"precipitation_amount"` // mm
+  } `json:"details"`
+}
+
+// snowfall is the hour's precipitation as snow, at the usual ratio of a
+// centimetre of snow to a millimetre of water, when the symbol is snow;
+// MET Norway doesn't give it otherwise.
+func (h metNoHour) snowfall(code condition.Code) *float64 {
+  if code != condition.Snow {
+    return nil
+  }
+
+  return h.Details.Precipitation
+}
+
+func (w VisualCrossing) Conditions(ctx context.Context, loc geo.Location, lang string) (Condition, error) {
+  var d struct {
+    Current *struct {
+      Conditions string   `json:"conditions"`
+      Icon       string   `json:"icon"`
+      Celsius    *float64 `json:"temp"`
+      Humidity   *float64 `json:"humidity"`
+      Wind       *float64 `json:"windspeed"` // km/h
+      Direction  *float64 `json:"winddir"`
+      Gust       *float64 `json:"windgust"` // km/h
+    } `json:"currentConditions"`
+  }
+
+  l := supported(lang, func(l string) bool {
+    for _, v := range visualCrossingLanguages {
+      if v == l {
+        return true
+      }
+    }
+
+    return false
+  })
+
+  path := "/VisualCrossingWebServices/rest/services/timeline/" + loc.LatString() + "," + loc.LonString() + "/today"
+  q := url.Values{"key": {w.APIKey}, "unitGroup": {"metric"}, "include": {"current"}, "elements": {"conditions,icon,temp,humidity,windspeed,winddir,windgust"}, "lang": {l}}
+  if err := w.endpoint().getJSON(ctx, path, q, &d); err != nil {
+    return Condition{}, err
+  }
+
+  if d.Current == nil || d.Current.Icon == "" {
+    return Condition{}, errNoConditions
+  }
+
+  c := d.Current
+  return Condition{
+    Code: condition.FromVisualCrossing(c.Icon), Text: c.Conditions, Lang: l, Kelvin: celsius(c.Celsius), Humidity: c.Humidity,
+    Wind: kmh(c.Wind), WindDirection: c.Direction, Gust: kmh(c.Gust),
+  }, nil
+}
@@ -0,0 +1,95 @@
+package providers
+
+import (
+  "context"
+  "errors"
+  "log"
+  "net/url"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+var (
+  providerCalls       = metrics.NewCounter("provider_calls_total", "Upstream calls made by providers, by provider and outcome.", "provider", "outcome")
+  providerCallSeconds = metrics.NewCounter("provider_call_seconds_total", "Time spent in upstream calls made by providers, by provider.", "provider")
+)
+
+// core is the request flow every HTTP provider shares. Calls go through
+// its endpoint, which brings the shared client, the -provider.url base,
+// the headers, the concurrency cap and the trace; they are timed and
+// counted under the provider, and their errors come back typed, with the
+// API key redacted. What is left to a provider is its API's answer:
+//
+//	func (w Acme) endpoint() core { return core{acmeEndpoint.At(w.BaseURL), w.APIKey} }
+//
+//	func (w Acme) Observe(ctx context.Context, loc geo.Location) (Observation, error) {
+//	  var d struct {
+//	    Celsius *float64 `json:"temp_c"`
+//	  }
+//
+//	  q := url.Values{"key": {w.APIKey}, "lat": {loc.LatString()}, "lon": {loc.LonString()}}
+//	  return observeJSON(ctx, w.endpoint(), loc, "/v1/now", q, &d, func() (Observation, error) {
+//	    k, err := kelvin(d.Celsius)
+//	    return Observation{Kelvin: k}, err
+//	  })
+//	}
+type core struct {
+  upstream.Endpoint
+  key string // the API key, wherever the API takes it
+}
+
+// getJSON is a GET of path decoded into v.
+func (c core) getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+  begin := time.Now()
+  err := c.GetJSON(ctx, path, query, v)
+  if err != nil {
+    err = classify(upstream.Redact(err, c.key))
+  }
+
+  providerCalls.Inc(c.Provider, callOutcome(err))
+  providerCallSeconds.Add(time.Since(begin).Seconds(), c.Provider)
+  return err
+}
+
+// observeJSON is Observe for an API whose current reading is one GET of
+// path, decoded into d for read to make the observation of.
+func observeJSON(ctx context.Context, c core, loc geo.Location, path string, query url.Values, d interface{}, read func() (Observation, error)) (Observation, error) {
+  begin := time.Now()
+  if err := c.getJSON(ctx, path, query, d); err != nil {
+    return Observation{}, err
+  }
+
+  o, err := read()
+  if err != nil {
+    return Observation{}, err
+  }
+
+  log.Printf("%s: %s: %.2f, took: %s", c.Provider, loc.Name, o.Kelvin, time.Since(begin).String())
+  return o, nil
+}
+
+// kelvin is a temperature in °C that the answer may lack.
+func kelvin(celsius *float64) (float64, error) {
+  if celsius == nil {
+    return 0, errNoTemperature
+  }
+
+  return *celsius + 273.15, nil
+}
+
+// callOutcome is the provider_calls_total outcome of a call that ended in err.
+func callOutcome(err error) string {
+  switch {
+  case err == nil:
+    return "ok"
+  case errors.Is(err, ErrCityNotFound):
+    return "not_found"
+  case errors.Is(err, upstream.ErrBusy):
+    return "busy"
+  default:
+    return string(Failure(err))
+  }
+}
@@ -0,0 +1,71 @@
+package providers
+
+import (
+  "errors"
+  "fmt"
+  "net/http"
+  "strings"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// Why a provider couldn't answer, whatever its API calls it. Provider
+// errors wrap these, with the upstream's message, when they apply.
+var (
+  ErrUnauthorized = errors.New("unauthorized") // missing, invalid or blocked API key
+  ErrCityNotFound = errors.New("city not found")
+  ErrRateLimited  = errors.New("rate limited")
+)
+
+// ErrCancelled is a provider a fail-fast fan-out stopped waiting for.
+var ErrCancelled = errors.New("fan-out cancelled")
+
+// errNoTemperature is a successful answer without the value, which would
+// otherwise decode to 0 K.
+var errNoTemperature = fmt.Errorf("%w: no temperature", upstream.ErrMalformed)
+
+var errNoConditions = fmt.Errorf("%w: no conditions", upstream.ErrMalformed)
+
+// classify maps the HTTP status of a failed upstream call to the typed
+// errors; other errors are returned as they are.
+func classify(err error) error {
+  var se *upstream.StatusError
+  if !errors.As(err, &se) {
+    return err
+  }
+
+  switch se.Status {
+  case http.StatusUnauthorized, http.StatusForbidden:
+    return explained(ErrUnauthorized, se.Message)
+  case http.StatusNotFound:
+    return explained(ErrCityNotFound, se.Message)
+  case http.StatusTooManyRequests:
+    return explained(ErrRateLimited, se.Message)
+  default:
+    return err
+  }
+}
+
+// Failure is the kind of upstream failure behind a provider error, "" for
+// an unknown place or a provider that wasn't asked.
+func Failure(err error) upstream.Failure {
+  switch {
+  case err == nil, errors.Is(err, ErrCityNotFound), errors.Is(err, ErrCancelled), errors.Is(err, upstream.ErrBusy):
+    return ""
+  case errors.Is(err, ErrUnauthorized):
+    return upstream.FailureAuth
+  case errors.Is(err, ErrRateLimited):
+    return upstream.FailureRateLimited
+  default:
+    return upstream.Classify(err)
+  }
+}
+
+// explained wraps kind with the upstream's message, unless it says no more.
+func explained(kind error, msg string) error {
+  if msg == "" || strings.EqualFold(msg, kind.Error()) {
+    return kind
+  }
+
+  return fmt.Errorf("%w: %s", kind, msg)
+}
@@ -0,0 +1,121 @@
+package providers
+
+import (
+  "context"
+  "net/url"
+  "strconv"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/condition"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+)
+
+// ForecastPoint is a provider's predicted temperature for one hour, and
+// what falls and lies then, for those that say: in the units of
+// Condition's.
+type ForecastPoint struct {
+  Valid  time.Time `json:"valid"`
+  Kelvin float64   `json:"temp"`
+
+  Precipitation *float64 `json:"precipitation,omitempty"`
+  Snowfall      *float64 `json:"snowfall,omitempty"`
+  SnowDepth     *float64 `json:"snow_depth,omitempty"`
+  FreezingLevel *float64 `json:"freezing_level,omitempty"`
+}
+
+// Forecaster is implemented by providers that also publish hourly
+// forecasts, for the next hours hours.
+type Forecaster interface {
+  Provider
+  Forecast(ctx context.Context, loc geo.Location, hours int) ([]ForecastPoint, error)
+}
+
+func (w OpenMeteo) Forecast(ctx context.Context, loc geo.Location, hours int) ([]ForecastPoint, error) {
+  var d struct {
+    Hourly struct {
+      Time          []int64    `json:"time"`
+      Celsius       []float64  `json:"temperature_2m"`
+      Precipitation []*float64 `json:"precipitation"`
+      Snowfall      []*float64 `json:"snowfall"`
+      SnowDepth     []*float64 `json:"snow_depth"`
+      FreezingLevel []*float64 `json:"freezing_level_height"`
+    } `json:"hourly"`
+  }
+
+  q := url.Values{
+    "hourly":         {"temperature_2m,precipitation,snowfall,snow_depth,freezing_level_height"},
+    "forecast_hours": {strconv.Itoa(hours)},
+    "timeformat":     {"unixtime"},
+    "latitude":       {loc.LatString()},
+    "longitude":      {loc.LonString()},
+  }
+
+  if err := w.endpoint().getJSON(ctx, "/v1/forecast", q, &d); err != nil {
+    return nil, err
+  }
+
+  at := func(vs []*float64, i int) *float64 {
+    if i < len(vs) {
+      return vs[i]
+    }
+
+    return nil
+  }
+
+  h := d.Hourly
+  var ps []ForecastPoint
+  for i, t := range h.Time {
+    if i < len(h.Celsius) {
+      ps = append(ps, ForecastPoint{
+        Valid: time.Unix(t, 0).UTC(), Kelvin: h.Celsius[i] + 273.15,
+        Precipitation: at(h.Precipitation, i), Snowfall: at(h.Snowfall, i), SnowDepth: at(h.SnowDepth, i), FreezingLevel: at(h.FreezingLevel, i),
+      })
+    }
+  }
+
+  return ps, nil
+}
+
+func (w MetNo) Forecast(ctx context.Context, loc geo.Location, hours int) ([]ForecastPoint, error) {
+  var d struct {
+    Properties struct {
+      Timeseries []struct {
+        Time time.Time `json:"time"`
+        Data struct {
+          Instant struct {
+            Details struct {
+              Celsius *float64 `json:"air_temperature"`
+            } `json:"details"`
+          } `json:"instant"`
+          Next metNoHour `json:"next_1_hours"`
+        } `json:"data"`
+      } `json:"timeseries"`
+    } `json:"properties"`
+  }
+
+  q := url.Values{"lat": {loc.LatString()}, "lon": {loc.LonString()}}
+  if err := w.endpoint().getJSON(ctx, "/weatherdata/locationforecast/2.0/compact", q, &d); err != nil {
+    return nil, err
+  }
+
+  until := time.Now().Add(time.Duration(hours) * time.Hour)
+
+  var ps []ForecastPoint
+  for _, t := range d.Properties.Timeseries {
+    if t.Time.After(until) {
+      break
+    }
+
+    if t.Data.Instant.Details.Celsius == nil {
+      continue
+    }
+
+    next := t.Data.Next
+    ps = append(ps, ForecastPoint{
+      Valid: t.Time.UTC(), Kelvin: *t.Data.Instant.Details.Celsius + 273.15,
+      Precipitation: next.Details.Precipitation, Snowfall: next.snowfall(condition.FromMetNo(next.Summary.Symbol)),
+    })
+  }
+
+  return ps, nil
+}
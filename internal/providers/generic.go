@@ -0,0 +1,127 @@
+package providers
+
+import (
+  "context"
+  "fmt"
+  "log"
+  "net/http"
+  "net/url"
+  "strings"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// GenericConfig describes an HTTP JSON weather source in the config file,
+// for adding internal or niche providers without code:
+//
+//	{"name": "corp", "url": "https://wx.corp/obs?lat={lat}&lon={lon}", "path": "$.current.temp", "unit": "celsius"}
+type GenericConfig struct {
+  Name    string            `json:"name"`
+  URL     string            `json:"url"`
+  Path    string            `json:"path"`
+  Unit    string            `json:"unit"` // kelvin, celsius or fahrenheit
+  Headers map[string]string `json:"headers,omitempty"`
+  Sunset  string            `json:"sunset,omitempty"` // YYYY-MM-DD the API is announced to stop answering
+  Notice  string            `json:"sunset_notice,omitempty"`
+}
+
+// Generic is a compiled GenericConfig.
+type Generic struct {
+  id    string
+  ep    core
+  url   string // with {lat} and {lon} placeholders
+  value *jsonPath
+  unit  string
+
+  lifecycle Lifecycle
+}
+
+// Compile checks the config, returning every problem found.
+func (g *GenericConfig) Compile() (*Generic, []string) {
+  var errs []string
+  if g.Name == "" {
+    errs = append(errs, "name: is required")
+  }
+
+  if !strings.Contains(g.URL, "{lat}") || !strings.Contains(g.URL, "{lon}") {
+    errs = append(errs, "url: needs {lat} and {lon} placeholders")
+  }
+
+  u, err := url.Parse(strings.NewReplacer("{lat}", "0", "{lon}", "0").Replace(g.URL))
+  if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+    errs = append(errs, fmt.Sprintf("url: want an http(s) URL, got %q", g.URL))
+  }
+
+  value, err := compilePath(g.Path)
+  if err != nil {
+    errs = append(errs, "path: "+err.Error())
+  }
+
+  switch g.Unit {
+  case "kelvin", "celsius", "fahrenheit":
+  default:
+    errs = append(errs, fmt.Sprintf("unit: want kelvin, celsius or fahrenheit, got %q", g.Unit))
+  }
+
+  lifecycle := Lifecycle{Notice: g.Notice}
+  if g.Sunset != "" {
+    if lifecycle.Sunset, err = time.Parse(time.DateOnly, g.Sunset); err != nil {
+      errs = append(errs, fmt.Sprintf("sunset: want a YYYY-MM-DD date, got %q", g.Sunset))
+    }
+  } else if g.Notice != "" {
+    errs = append(errs, "sunset_notice: needs a sunset date")
+  }
+
+  if len(errs) > 0 {
+    return nil, errs
+  }
+
+  header := http.Header{}
+  for k, v := range g.Headers {
+    header.Set(k, v)
+  }
+
+  return &Generic{
+    id:    g.Name,
+    ep:    core{Endpoint: upstream.Endpoint{Base: u.Scheme + "://" + u.Host, Header: header, Provider: g.Name}},
+    url:   g.URL,
+    value: value,
+    unit:  g.Unit,
+
+    lifecycle: lifecycle,
+  }, nil
+}
+
+func (w *Generic) Name() string { return w.id }
+
+func (w *Generic) Temperature(ctx context.Context, loc geo.Location) (float64, error) {
+  begin := time.Now()
+
+  u, err := url.Parse(strings.NewReplacer("{lat}", loc.LatString(), "{lon}", loc.LonString()).Replace(w.url))
+  if err != nil {
+    return 0, err
+  }
+
+  var doc interface{}
+  if err := w.ep.getJSON(ctx, u.EscapedPath(), u.Query(), &doc); err != nil {
+    return 0, err
+  }
+
+  v, err := w.value.number(doc)
+  if err != nil {
+    return 0, fmt.Errorf("%s: %w: %w", w.id, upstream.ErrMalformed, err)
+  }
+
+  kelvin := v
+  switch w.unit {
+  case "celsius":
+    kelvin = v + 273.15
+  case "fahrenheit":
+    kelvin = (v-32)*5/9 + 273.15
+  }
+
+  log.Printf("%s: %s: %.2f, took: %s", w.id, loc.Name, kelvin, time.Since(begin).String())
+  return kelvin, nil
+}
@@ -0,0 +1,114 @@
+package providers
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+)
+
+// jsonPath is a compiled path into a decoded JSON document, in the common
+// subset of JSONPath that locates one value: $.current.temp, $.list[0].main,
+// $['odd key'].
+type jsonPath struct {
+  src   string
+  steps []pathStep
+}
+
+type pathStep struct {
+  key   string
+  index int // when key is ""
+  col   int
+}
+
+// pathError locates a compile error in the path, 1-based.
+type pathError struct {
+  col int
+  msg string
+}
+
+func (e *pathError) Error() string { return fmt.Sprintf("col %d: %s", e.col, e.msg) }
+
+func compilePath(src string) (*jsonPath, error) {
+  if !strings.HasPrefix(src, "$") {
+    return nil, &pathError{1, "path must start with $"}
+  }
+
+  p := &jsonPath{src: src}
+  for i := 1; i < len(src); {
+    switch src[i] {
+    case '.':
+      j := i + 1
+      for j < len(src) && src[j] != '.' && src[j] != '[' {
+        j++
+      }
+
+      if j == i+1 {
+        return nil, &pathError{i + 1, "empty key after ."}
+      }
+
+      p.steps = append(p.steps, pathStep{key: src[i+1 : j], col: i + 2})
+      i = j
+    case '[':
+      end := strings.IndexByte(src[i:], ']')
+      if end < 0 {
+        return nil, &pathError{i + 1, "missing ]"}
+      }
+
+      inner := src[i+1 : i+end]
+      switch {
+      case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+        p.steps = append(p.steps, pathStep{key: inner[1 : len(inner)-1], col: i + 2})
+      default:
+        n, err := strconv.Atoi(inner)
+        if err != nil || n < 0 {
+          return nil, &pathError{i + 2, fmt.Sprintf("want an index or a quoted key in [], got %q", inner)}
+        }
+
+        p.steps = append(p.steps, pathStep{index: n, col: i + 2})
+      }
+
+      i += end + 1
+    default:
+      return nil, &pathError{i + 1, fmt.Sprintf("unexpected %q, want . or [", src[i])}
+    }
+  }
+
+  if len(p.steps) == 0 {
+    return nil, &pathError{1, "path selects the whole document, not a value"}
+  }
+
+  return p, nil
+}
+
+// number follows the path through doc and returns the number found there.
+func (p *jsonPath) number(doc interface{}) (float64, error) {
+  v := doc
+  for _, s := range p.steps {
+    switch node := v.(type) {
+    case map[string]interface{}:
+      if s.key == "" {
+        return 0, fmt.Errorf("%s: col %d: want an object key, document has an object", p.src, s.col)
+      }
+
+      var ok bool
+      if v, ok = node[s.key]; !ok {
+        return 0, fmt.Errorf("%s: col %d: no key %q", p.src, s.col, s.key)
+      }
+    case []interface{}:
+      if s.key != "" || s.index >= len(node) {
+        return 0, fmt.Errorf("%s: col %d: array has %d elements", p.src, s.col, len(node))
+      }
+
+      v = node[s.index]
+    default:
+      return 0, fmt.Errorf("%s: col %d: can't descend into %T", p.src, s.col, v)
+    }
+  }
+
+  f, ok := v.(float64)
+  if !ok {
+    return 0, fmt.Errorf("%s: want a number, found %T", p.src, v)
+  }
+
+  return f, nil
+}
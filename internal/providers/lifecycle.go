@@ -0,0 +1,67 @@
+package providers
+
+import (
+  "fmt"
+  "time"
+)
+
+// Lifecycle is a provider API's announced end of life: when it goes away
+// and what the provider says to move to.
+type Lifecycle struct {
+  Sunset time.Time // the API stops answering; zero when none is planned
+  Notice string    // the provider's advice, e.g. the replacement API
+  URL    string    // the announcement
+}
+
+// Sunsetting is implemented by providers whose API has a planned end of
+// life.
+type Sunsetting interface {
+  Lifecycle() Lifecycle
+}
+
+// Weather Underground shut its legacy API down for free keys at the end
+// of 2018; only PWS owners get keys for the successor.
+func (w WeatherUnderground) Lifecycle() Lifecycle {
+  return Lifecycle{
+    Sunset: time.Date(2018, time.December, 31, 0, 0, 0, 0, time.UTC),
+    Notice: "The Weather Company's PWS API replaces it for station owners",
+    URL:    "https://www.wunderground.com/member/api-keys",
+  }
+}
+
+func (w *Generic) Lifecycle() Lifecycle { return w.lifecycle }
+
+// SunsetOf returns p's planned end of life, if it has one.
+func SunsetOf(p Provider) (Lifecycle, bool) {
+  s, ok := p.(Sunsetting)
+  if !ok || s.Lifecycle().Sunset.IsZero() {
+    return Lifecycle{}, false
+  }
+
+  return s.Lifecycle(), true
+}
+
+// Warning says how close l is to its sunset at now, or "" while it is
+// further away than within.
+func (l Lifecycle) Warning(name string, now time.Time, within time.Duration) string {
+  left := l.Sunset.Sub(now)
+  var msg string
+  switch {
+  case left <= 0:
+    msg = fmt.Sprintf("%s: API was retired on %s", name, l.Sunset.Format(time.DateOnly))
+  case left <= within:
+    msg = fmt.Sprintf("%s: API sunsets on %s, in %d days", name, l.Sunset.Format(time.DateOnly), int(left.Hours()/24))
+  default:
+    return ""
+  }
+
+  if l.Notice != "" {
+    msg += "; " + l.Notice
+  }
+
+  if l.URL != "" {
+    msg += " (" + l.URL + ")"
+  }
+
+  return msg
+}
@@ -0,0 +1,73 @@
+package providers
+
+import (
+  "context"
+  "errors"
+  "net/url"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// Marine is the sea at a point now, nil where a provider doesn't say.
+// Heights are metres, periods seconds and directions degrees clockwise
+// from north the waves come from.
+type Marine struct {
+  WaveHeight     *float64 // significant height of wind waves and swell together
+  WavePeriod     *float64
+  WaveDirection  *float64
+  SwellHeight    *float64
+  SwellPeriod    *float64
+  SwellDirection *float64
+  SeaKelvin      *float64 // at the surface
+}
+
+// MarineReporter is implemented by providers with sea conditions.
+type MarineReporter interface {
+  Provider
+  Marine(ctx context.Context, loc geo.Location) (Marine, error)
+}
+
+// ErrNoMarine is a provider without sea conditions for the place, most
+// often because it is inland; it is left out rather than counted as
+// failing.
+var ErrNoMarine = errors.New("no marine data available")
+
+var openMeteoMarineEndpoint = upstream.Endpoint{Base: "https://marine-api.open-meteo.com", Provider: "open-meteo"}
+
+// Marine is Open-Meteo's wave models for the current hour, with nulls on
+// land and inland water.
+func (w OpenMeteo) Marine(ctx context.Context, loc geo.Location) (Marine, error) {
+  var d struct {
+    Current struct {
+      WaveHeight     *float64 `json:"wave_height"`
+      WavePeriod     *float64 `json:"wave_period"`
+      WaveDirection  *float64 `json:"wave_direction"`
+      SwellHeight    *float64 `json:"swell_wave_height"`
+      SwellPeriod    *float64 `json:"swell_wave_period"`
+      SwellDirection *float64 `json:"swell_wave_direction"`
+      Celsius        *float64 `json:"sea_surface_temperature"`
+    } `json:"current"`
+  }
+
+  q := url.Values{
+    "current":   {"wave_height,wave_period,wave_direction,swell_wave_height,swell_wave_period,swell_wave_direction,sea_surface_temperature"},
+    "latitude":  {loc.LatString()},
+    "longitude": {loc.LonString()},
+  }
+  if err := w.marineEndpoint().getJSON(ctx, "/v1/marine", q, &d); err != nil {
+    return Marine{}, err
+  }
+
+  c := d.Current
+  m := Marine{
+    WaveHeight: c.WaveHeight, WavePeriod: c.WavePeriod, WaveDirection: c.WaveDirection,
+    SwellHeight: c.SwellHeight, SwellPeriod: c.SwellPeriod, SwellDirection: c.SwellDirection,
+    SeaKelvin: celsius(c.Celsius),
+  }
+  if m == (Marine{}) {
+    return Marine{}, ErrNoMarine
+  }
+
+  return m, nil
+}
@@ -0,0 +1,169 @@
+package providers
+
+import (
+  "context"
+  "fmt"
+  "log"
+  "net/http"
+  "net/url"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/condition"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+var meteostatEndpoint = upstream.Endpoint{Base: "https://meteostat.p.rapidapi.com", Provider: "meteostat"}
+
+// Meteostat is meteostat.net's point data through RapidAPI: station
+// records interpolated to the place, going back decades, with model data
+// filling in the hours no station has reported yet.
+type Meteostat struct {
+  APIKey  string
+  BaseURL string // instead of https://meteostat.p.rapidapi.com
+}
+
+func (w Meteostat) Name() string { return "meteostat" }
+
+func (w Meteostat) endpoint() core { return core{meteostatEndpoint.At(w.BaseURL), w.APIKey} }
+
+func (w Meteostat) WithAPIKey(key string) Provider { w.APIKey = key; return w }
+
+// Stations report hourly.
+func (w Meteostat) Cadence() time.Duration { return time.Hour }
+
+// Meteostat's data is CC BY-NC 4.0.
+func (w Meteostat) Terms() Terms { return Terms{} }
+
+func (w Meteostat) Attribution() upstream.Attribution {
+  return upstream.Attribution{Source: w.Name(), Text: "Weather data by Meteostat", URL: "https://meteostat.net/", License: "CC BY-NC 4.0"}
+}
+
+type meteostatHour struct {
+  Time     string   `json:"time"` // UTC, as asked
+  Celsius  *float64 `json:"temp"`
+  Humidity *float64 `json:"rhum"`
+  Wind     *float64 `json:"wspd"` // km/h
+  WindDir  *float64 `json:"wdir"`
+  Gust     *float64 `json:"wpgt"` // km/h
+  Code     *int     `json:"coco"`
+}
+
+// hours is the hourly records of the UTC days from and to.
+func (w Meteostat) hours(ctx context.Context, loc geo.Location, from, to time.Time) ([]meteostatHour, error) {
+  var d struct {
+    Data []meteostatHour `json:"data"`
+  }
+
+  // RapidAPI takes the key in a header rather than the query.
+  e := w.endpoint()
+  e.Header = http.Header{"X-Rapidapi-Host": {"meteostat.p.rapidapi.com"}, "X-Rapidapi-Key": {w.APIKey}}
+
+  q := url.Values{
+    "lat":   {loc.LatString()},
+    "lon":   {loc.LonString()},
+    "start": {from.UTC().Format(time.DateOnly)},
+    "end":   {to.UTC().Format(time.DateOnly)},
+    "tz":    {"UTC"},
+  }
+
+  if err := e.getJSON(ctx, "/point/hourly", q, &d); err != nil {
+    return nil, err
+  }
+
+  return d.Data, nil
+}
+
+// valid is when the hour begins.
+func (h meteostatHour) valid() (time.Time, error) {
+  return time.Parse(time.DateTime, h.Time)
+}
+
+func (w Meteostat) Temperature(ctx context.Context, loc geo.Location) (float64, error) {
+  o, err := w.Observe(ctx, loc)
+  return o.Kelvin, err
+}
+
+func (w Meteostat) Observe(ctx context.Context, loc geo.Location) (Observation, error) {
+  begin := time.Now()
+
+  h, err := w.latest(ctx, loc)
+  if err != nil {
+    return Observation{}, err
+  }
+
+  o := Observation{Kelvin: *h.Celsius + 273.15}
+  if h.Code != nil {
+    o.Condition = condition.FromMeteostat(*h.Code)
+  }
+
+  if t, err := h.valid(); err == nil {
+    o.Time = t
+  }
+
+  log.Printf("meteostat: %s: %.2f, took: %s", loc.Name, o.Kelvin, time.Since(begin).String())
+  return o, nil
+}
+
+// latest is the last hour up to now with a temperature. Just after
+// midnight UTC that may still be yesterday's.
+func (w Meteostat) latest(ctx context.Context, loc geo.Location) (meteostatHour, error) {
+  now := time.Now().UTC()
+  hs, err := w.hours(ctx, loc, now.Add(-time.Hour), now)
+  if err != nil {
+    return meteostatHour{}, err
+  }
+
+  var last *meteostatHour
+  for i, h := range hs {
+    if t, err := h.valid(); err == nil && !t.After(now) && h.Celsius != nil {
+      last = &hs[i]
+    }
+  }
+
+  if last == nil {
+    return meteostatHour{}, errNoTemperature
+  }
+
+  return *last, nil
+}
+
+// Conditions is the latest hour's weather condition code; Meteostat has
+// no text of its own.
+func (w Meteostat) Conditions(ctx context.Context, loc geo.Location, lang string) (Condition, error) {
+  h, err := w.latest(ctx, loc)
+  if err != nil {
+    return Condition{}, err
+  }
+
+  if h.Code == nil {
+    return Condition{}, errNoConditions
+  }
+
+  return Condition{
+    Code: condition.FromMeteostat(*h.Code), Kelvin: celsius(h.Celsius), Humidity: h.Humidity,
+    Wind: kmh(h.Wind), WindDirection: h.WindDir, Gust: kmh(h.Gust),
+  }, nil
+}
+
+// History is the hours of day, from records that reach back to the
+// 1970s at most stations.
+func (w Meteostat) History(ctx context.Context, loc geo.Location, day time.Time) ([]ForecastPoint, error) {
+  hs, err := w.hours(ctx, loc, day, day)
+  if err != nil {
+    return nil, err
+  }
+
+  var ps []ForecastPoint
+  for _, h := range hs {
+    if t, err := h.valid(); err == nil && h.Celsius != nil {
+      ps = append(ps, ForecastPoint{Valid: t, Kelvin: *h.Celsius + 273.15})
+    }
+  }
+
+  if len(ps) == 0 {
+    return nil, fmt.Errorf("no history for %s", day.UTC().Format(time.DateOnly))
+  }
+
+  return ps, nil
+}
@@ -0,0 +1,104 @@
+package providers
+
+import (
+  "context"
+  "errors"
+  "net/http"
+  "net/url"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// NowcastPoint is a provider's precipitation outlook from Valid until its
+// next point.
+type NowcastPoint struct {
+  Valid       time.Time `json:"valid"`
+  Rate        float64   `json:"rate"`                  // mm/h
+  Probability *float64  `json:"probability,omitempty"` // 0 to 1, when the provider gives one
+}
+
+// Nowcaster is implemented by providers with short-range precipitation
+// nowcasts for the next hour.
+type Nowcaster interface {
+  Provider
+  Nowcast(ctx context.Context, loc geo.Location) ([]NowcastPoint, error)
+}
+
+// ErrNoNowcast is a nowcaster that has none for the place or on this
+// plan; it is left out rather than counted as failing.
+var ErrNoNowcast = errors.New("no nowcast available")
+
+// Nowcast is One Call 3.0's minutely forecast, a subscription of its own
+// on OpenWeather, so it is only asked with -openweather.onecall.
+func (w OpenWeatherMap) Nowcast(ctx context.Context, loc geo.Location) ([]NowcastPoint, error) {
+  if !w.OneCall {
+    return nil, ErrNoNowcast
+  }
+
+  var d struct {
+    Minutely []struct {
+      Time int64   `json:"dt"`
+      Rate float64 `json:"precipitation"`
+    } `json:"minutely"`
+  }
+
+  q := url.Values{"appid": {w.APIKey}, "lat": {loc.LatString()}, "lon": {loc.LonString()}, "exclude": {"current,hourly,daily,alerts"}}
+  if err := w.endpoint().getJSON(ctx, "/data/3.0/onecall", q, &d); err != nil {
+    return nil, err
+  }
+
+  if len(d.Minutely) == 0 {
+    return nil, ErrNoNowcast
+  }
+
+  ps := make([]NowcastPoint, len(d.Minutely))
+  for i, m := range d.Minutely {
+    ps[i] = NowcastPoint{Valid: time.Unix(m.Time, 0).UTC(), Rate: m.Rate}
+  }
+
+  return ps, nil
+}
+
+// Nowcast is MET Norway's radar nowcast in 5-minute steps. It covers the
+// Nordic countries; elsewhere it answers 422.
+func (w MetNo) Nowcast(ctx context.Context, loc geo.Location) ([]NowcastPoint, error) {
+  var d struct {
+    Properties struct {
+      Timeseries []struct {
+        Time time.Time `json:"time"`
+        Data struct {
+          Instant struct {
+            Details struct {
+              Rate *float64 `json:"precipitation_rate"`
+            } `json:"details"`
+          } `json:"instant"`
+        } `json:"data"`
+      } `json:"timeseries"`
+    } `json:"properties"`
+  }
+
+  q := url.Values{"lat": {loc.LatString()}, "lon": {loc.LonString()}}
+  if err := w.endpoint().getJSON(ctx, "/weatherdata/nowcast/2.0/complete", q, &d); err != nil {
+    var se *upstream.StatusError
+    if errors.As(err, &se) && se.Status == http.StatusUnprocessableEntity {
+      return nil, ErrNoNowcast
+    }
+
+    return nil, err
+  }
+
+  var ps []NowcastPoint
+  for _, t := range d.Properties.Timeseries {
+    if r := t.Data.Instant.Details.Rate; r != nil {
+      ps = append(ps, NowcastPoint{Valid: t.Time.UTC(), Rate: *r})
+    }
+  }
+
+  if len(ps) == 0 {
+    return nil, ErrNoNowcast
+  }
+
+  return ps, nil
+}
@@ -0,0 +1,364 @@
+package providers
+
+import (
+  "bufio"
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "log"
+  "os"
+  "os/exec"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// PluginConfig describes a provider that runs as a separate program, for
+// proprietary sources that can't be described as a generic HTTP provider
+// or shouldn't be compiled in:
+//
+//	{"name": "corp", "command": ["/opt/wx/corp-provider", "-region=eu"], "env": {"CORP_TOKEN": "..."}}
+//
+// The program speaks JSON lines over stdio. Its first line on stdout is a
+// handshake, {"protocol": 1} with an optional "attribution" object; then
+// it answers each request line on stdin,
+//
+//	{"id": 7, "method": "temperature", "location": {"name": "Oslo", "country": "NO", "lat": 59.91, "lon": 10.75}}
+//
+// with {"id": 7, "kelvin": 283.4} or {"id": 7, "error": "..."}, in any
+// order, so it may serve several requests at once. It exits when stdin is
+// closed; stderr goes to the server log. A plugin that dies is restarted on
+// the next request.
+type PluginConfig struct {
+  Name    string            `json:"name"`
+  Command []string          `json:"command"`
+  Env     map[string]string `json:"env,omitempty"`
+}
+
+// PluginProtocol is the protocol version plugins must announce.
+const PluginProtocol = 1
+
+const (
+  pluginHandshakeTimeout = 10 * time.Second
+  pluginRestartDelay     = 5 * time.Second
+)
+
+var (
+  errPluginExited  = errors.New("plugin exited")
+  errPluginStopped = errors.New("plugin stopped")
+)
+
+// Plugin is a compiled PluginConfig; it starts the program on Start or on
+// the first request.
+type Plugin struct {
+  id   string
+  argv []string
+  env  []string
+
+  mu      sync.Mutex
+  proc    *pluginProc
+  credit  *upstream.Attribution
+  failed  error     // last start error, returned until retryAt
+  retryAt time.Time // earliest next start after a failure
+  stopped bool      // by Stop, until the next Start
+}
+
+// pluginProc is one run of the program.
+type pluginProc struct {
+  cmd  *exec.Cmd
+  in   io.WriteCloser
+  wmu  sync.Mutex // serializes request lines
+  done chan struct{}
+
+  mu      sync.Mutex
+  next    uint64
+  pending map[uint64]chan pluginResponse
+}
+
+type pluginHandshake struct {
+  Protocol    int                   `json:"protocol"`
+  Attribution *upstream.Attribution `json:"attribution,omitempty"`
+}
+
+type pluginRequest struct {
+  ID       uint64       `json:"id"`
+  Method   string       `json:"method"`
+  Location geo.Location `json:"location"`
+}
+
+type pluginResponse struct {
+  ID     uint64   `json:"id"`
+  Kelvin *float64 `json:"kelvin"`
+  Error  string   `json:"error"`
+}
+
+// Compile checks the config, returning every problem found. The program is
+// looked up but not started.
+func (c *PluginConfig) Compile() (*Plugin, []string) {
+  var errs []string
+  if c.Name == "" {
+    errs = append(errs, "name: is required")
+  }
+
+  if len(c.Command) == 0 {
+    errs = append(errs, "command: is required")
+  } else if _, err := exec.LookPath(c.Command[0]); err != nil {
+    errs = append(errs, "command: "+err.Error())
+  }
+
+  if len(errs) > 0 {
+    return nil, errs
+  }
+
+  env := os.Environ()
+  for k, v := range c.Env {
+    env = append(env, k+"="+v)
+  }
+
+  return &Plugin{id: c.Name, argv: c.Command, env: env}, nil
+}
+
+func (p *Plugin) Name() string { return p.id }
+
+// Attribution is what the plugin announced in its handshake, if anything.
+func (p *Plugin) Attribution() upstream.Attribution {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+
+  if p.credit == nil {
+    return upstream.Attribution{}
+  }
+
+  a := *p.credit
+  a.Source = p.id
+  return a
+}
+
+// Start runs the program and waits for its handshake, so a broken plugin
+// is found at startup rather than on the first request.
+func (p *Plugin) Start() error {
+  p.mu.Lock()
+  p.stopped = false
+  p.mu.Unlock()
+
+  if _, err := p.running(); err != nil {
+    return fmt.Errorf("plugin %s: %w", p.id, err)
+  }
+
+  return nil
+}
+
+// Stop kills the program, if it runs, and waits for it to exit; requests
+// fail until the next Start.
+func (p *Plugin) Stop() {
+  p.mu.Lock()
+  proc := p.proc
+  p.proc, p.stopped = nil, true
+  p.mu.Unlock()
+
+  if proc != nil {
+    proc.cmd.Process.Kill()
+    <-proc.done
+  }
+}
+
+// running is the live process, started if there is none.
+func (p *Plugin) running() (*pluginProc, error) {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+
+  if p.stopped {
+    return nil, errPluginStopped
+  }
+
+  if p.proc != nil {
+    return p.proc, nil
+  }
+
+  if time.Now().Before(p.retryAt) {
+    return nil, p.failed
+  }
+
+  proc, hs, err := p.start()
+  if err != nil {
+    p.failed = err
+    p.retryAt = time.Now().Add(pluginRestartDelay)
+    return nil, p.failed
+  }
+
+  p.proc, p.credit = proc, hs.Attribution
+  go func() {
+    <-proc.done
+    p.mu.Lock()
+    if p.proc == proc {
+      p.proc = nil
+    }
+    p.mu.Unlock()
+  }()
+
+  return proc, nil
+}
+
+func (p *Plugin) start() (*pluginProc, pluginHandshake, error) {
+  var hs pluginHandshake
+
+  cmd := exec.Command(p.argv[0], p.argv[1:]...)
+  cmd.Env = p.env
+
+  in, err := cmd.StdinPipe()
+  if err != nil {
+    return nil, hs, err
+  }
+
+  out, err := cmd.StdoutPipe()
+  if err != nil {
+    return nil, hs, err
+  }
+
+  stderr, err := cmd.StderrPipe()
+  if err != nil {
+    return nil, hs, err
+  }
+
+  if err := cmd.Start(); err != nil {
+    return nil, hs, err
+  }
+
+  go func() {
+    s := bufio.NewScanner(stderr)
+    for s.Scan() {
+      log.Printf("plugin %s: %s", p.id, s.Text())
+    }
+  }()
+
+  lines := bufio.NewScanner(out)
+  lines.Buffer(make([]byte, 64<<10), 1<<20)
+
+  type handshake struct {
+    hs  pluginHandshake
+    err error
+  }
+
+  first := make(chan handshake, 1)
+  go func() {
+    if !lines.Scan() {
+      err := lines.Err()
+      if err == nil {
+        err = io.ErrUnexpectedEOF
+      }
+
+      first <- handshake{err: fmt.Errorf("no handshake: %w", err)}
+      return
+    }
+
+    var h handshake
+    if err := json.Unmarshal(lines.Bytes(), &h.hs); err != nil {
+      h.err = fmt.Errorf("bad handshake: %w", err)
+    }
+
+    first <- h
+  }()
+
+  select {
+  case h := <-first:
+    hs, err = h.hs, h.err
+  case <-time.After(pluginHandshakeTimeout):
+    err = fmt.Errorf("no handshake within %s", pluginHandshakeTimeout)
+  }
+
+  if err == nil && hs.Protocol != PluginProtocol {
+    err = fmt.Errorf("speaks protocol %d, want %d", hs.Protocol, PluginProtocol)
+  }
+
+  if err != nil {
+    cmd.Process.Kill()
+    cmd.Wait()
+    return nil, hs, err
+  }
+
+  proc := &pluginProc{cmd: cmd, in: in, done: make(chan struct{}), pending: make(map[uint64]chan pluginResponse)}
+  go proc.read(p.id, lines)
+
+  return proc, hs, nil
+}
+
+// read dispatches responses to their requests until the program exits.
+func (pp *pluginProc) read(id string, lines *bufio.Scanner) {
+  for lines.Scan() {
+    var resp pluginResponse
+    if err := json.Unmarshal(lines.Bytes(), &resp); err != nil {
+      log.Printf("plugin %s: bad response line: %s", id, err)
+      continue
+    }
+
+    pp.mu.Lock()
+    ch, ok := pp.pending[resp.ID]
+    delete(pp.pending, resp.ID)
+    pp.mu.Unlock()
+
+    if ok {
+      ch <- resp
+    }
+  }
+
+  pp.in.Close()
+  err := pp.cmd.Wait()
+  log.Printf("plugin %s: exited: %v", id, err)
+  close(pp.done)
+}
+
+func (p *Plugin) Temperature(ctx context.Context, loc geo.Location) (float64, error) {
+  begin := time.Now()
+
+  proc, err := p.running()
+  if err != nil {
+    return 0, err
+  }
+
+  ch := make(chan pluginResponse, 1)
+  proc.mu.Lock()
+  proc.next++
+  id := proc.next
+  proc.pending[id] = ch
+  proc.mu.Unlock()
+
+  defer func() {
+    proc.mu.Lock()
+    delete(proc.pending, id)
+    proc.mu.Unlock()
+  }()
+
+  line, err := json.Marshal(pluginRequest{ID: id, Method: "temperature", Location: loc})
+  if err != nil {
+    return 0, err
+  }
+
+  proc.wmu.Lock()
+  _, err = proc.in.Write(append(line, '\n'))
+  proc.wmu.Unlock()
+  if err != nil {
+    return 0, err
+  }
+
+  select {
+  case resp := <-ch:
+    if resp.Error != "" {
+      return 0, errors.New(resp.Error)
+    }
+
+    if resp.Kelvin == nil {
+      return 0, errors.New("plugin response has neither kelvin nor error")
+    }
+
+    log.Printf("%s: %s: %.2f, took: %s", p.id, loc.Name, *resp.Kelvin, time.Since(begin).String())
+    return *resp.Kelvin, nil
+  case <-proc.done:
+    return 0, errPluginExited
+  case <-ctx.Done():
+    return 0, ctx.Err()
+  }
+}
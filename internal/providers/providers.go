@@ -0,0 +1,425 @@
+// Package providers are the weather APIs readings come from, with their
+// terms of use, attribution and forecasts.
+package providers
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net/url"
+  "strconv"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/condition"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// Provider is one source of temperatures.
+type Provider interface {
+  Name() string
+  Temperature(ctx context.Context, loc geo.Location) (float64, error) // in Kelvin, naturally
+}
+
+// Keyed is implemented by providers that authenticate with an API key, so
+// it can be rotated without a restart.
+type Keyed interface {
+  WithAPIKey(key string) Provider
+}
+
+// Cadenced is implemented by providers that publish new readings at a
+// known interval; asking again sooner gets the same value.
+type Cadenced interface {
+  Cadence() time.Duration
+}
+
+var (
+  owmEndpoint          = upstream.Endpoint{Base: "http://api.openweathermap.org", Provider: "openweathermap"}
+  wundergroundEndpoint = upstream.Endpoint{Base: "http://api.wunderground.com", Provider: "wunderground"}
+  openMeteoEndpoint    = upstream.Endpoint{Base: "https://api.open-meteo.com", Provider: "open-meteo"}
+  metNoEndpoint        = upstream.Endpoint{Base: "https://api.met.no", Provider: "met.no"}
+)
+
+// OpenWeatherMap is openweathermap.org's current weather API.
+type OpenWeatherMap struct {
+  APIKey  string
+  OneCall bool   // subscribed to One Call 3.0, for nowcasts
+  BaseURL string // instead of http://api.openweathermap.org
+}
+
+func (w OpenWeatherMap) Name() string { return "openweathermap" }
+
+func (w OpenWeatherMap) endpoint() core { return core{owmEndpoint.At(w.BaseURL), w.APIKey} }
+
+func (w OpenWeatherMap) WithAPIKey(key string) Provider { w.APIKey = key; return w }
+
+// Current weather is updated about every 10 minutes.
+func (w OpenWeatherMap) Cadence() time.Duration { return 10 * time.Minute }
+
+func (w OpenWeatherMap) Temperature(ctx context.Context, loc geo.Location) (float64, error) {
+  o, err := w.Observe(ctx, loc)
+  return o.Kelvin, err
+}
+
+func (w OpenWeatherMap) Observe(ctx context.Context, loc geo.Location) (Observation, error) {
+  var d struct {
+    Main struct {
+      Kelvin *float64 `json:"temp"`
+    } `json:"main"`
+    Weather []struct {
+      ID int `json:"id"`
+    } `json:"weather"`
+    Epoch int64 `json:"dt"`
+  }
+
+  q := url.Values{"APPID": {w.APIKey}, "lat": {loc.LatString()}, "lon": {loc.LonString()}}
+  return observeJSON(ctx, w.endpoint(), loc, "/data/2.5/weather", q, &d, func() (Observation, error) {
+    if d.Main.Kelvin == nil {
+      return Observation{}, errNoTemperature
+    }
+
+    o := Observation{Kelvin: *d.Main.Kelvin, Time: unixTime(d.Epoch)}
+    if len(d.Weather) > 0 {
+      o.Condition = condition.FromOpenWeather(d.Weather[0].ID)
+    }
+
+    return o, nil
+  })
+}
+
+// WeatherUnderground is wunderground.com's conditions API.
+type WeatherUnderground struct {
+  APIKey  string
+  BaseURL string // instead of http://api.wunderground.com
+}
+
+func (w WeatherUnderground) Name() string { return "wunderground" }
+
+func (w WeatherUnderground) endpoint() core {
+  return core{wundergroundEndpoint.At(w.BaseURL), w.APIKey}
+}
+
+func (w WeatherUnderground) WithAPIKey(key string) Provider { w.APIKey = key; return w }
+
+func (w WeatherUnderground) Temperature(ctx context.Context, loc geo.Location) (float64, error) {
+  o, err := w.Observe(ctx, loc)
+  return o.Kelvin, err
+}
+
+func (w WeatherUnderground) Observe(ctx context.Context, loc geo.Location) (Observation, error) {
+  // Errors come back as 200 with a typed error object.
+  var d struct {
+    Response struct {
+      Error *struct {
+        Type        string `json:"type"`
+        Description string `json:"description"`
+      } `json:"error"`
+    } `json:"response"`
+    Observation *struct {
+      Celsius *float64 `json:"temp_c"`
+      Icon    string   `json:"icon"`
+      Epoch   string   `json:"observation_epoch"`
+    } `json:"current_observation"`
+  }
+
+  path := "/api/" + url.PathEscape(w.APIKey) + "/conditions/q/" + loc.LatString() + "," + loc.LonString() + ".json"
+  return observeJSON(ctx, w.endpoint(), loc, path, nil, &d, func() (Observation, error) {
+    if e := d.Response.Error; e != nil {
+      switch e.Type {
+      case "keynotfound", "keydisabled", "invalidkey":
+        return Observation{}, explained(ErrUnauthorized, e.Description)
+      case "querynotfound":
+        return Observation{}, explained(ErrCityNotFound, e.Description)
+      default:
+        return Observation{}, fmt.Errorf("%s: %s", e.Type, e.Description)
+      }
+    }
+
+    if d.Observation == nil {
+      return Observation{}, errNoTemperature
+    }
+
+    k, err := kelvin(d.Observation.Celsius)
+    if err != nil {
+      return Observation{}, err
+    }
+
+    o := Observation{Kelvin: k, Condition: condition.FromWunderground(d.Observation.Icon)}
+    if epoch, err := strconv.ParseInt(d.Observation.Epoch, 10, 64); err == nil {
+      o.Time = unixTime(epoch)
+    }
+
+    return o, nil
+  })
+}
+
+// OpenMeteo is keyless but only understands coordinates.
+type OpenMeteo struct {
+  BaseURL       string // instead of https://api.open-meteo.com
+  ArchiveURL    string // instead of https://archive-api.open-meteo.com
+  AirQualityURL string // instead of https://air-quality-api.open-meteo.com
+  MarineURL     string // instead of https://marine-api.open-meteo.com
+}
+
+func (w OpenMeteo) Name() string { return "open-meteo" }
+
+func (w OpenMeteo) endpoint() core { return core{Endpoint: openMeteoEndpoint.At(w.BaseURL)} }
+
+func (w OpenMeteo) archiveEndpoint() core {
+  return core{Endpoint: openMeteoArchiveEndpoint.At(w.ArchiveURL)}
+}
+
+func (w OpenMeteo) airQualityEndpoint() core {
+  return core{Endpoint: openMeteoAirQualityEndpoint.At(w.AirQualityURL)}
+}
+
+func (w OpenMeteo) marineEndpoint() core {
+  return core{Endpoint: openMeteoMarineEndpoint.At(w.MarineURL)}
+}
+
+// Current conditions are 15-minutely.
+func (w OpenMeteo) Cadence() time.Duration { return 15 * time.Minute }
+
+func (w OpenMeteo) Temperature(ctx context.Context, loc geo.Location) (float64, error) {
+  o, err := w.Observe(ctx, loc)
+  return o.Kelvin, err
+}
+
+func (w OpenMeteo) Observe(ctx context.Context, loc geo.Location) (Observation, error) {
+  var d struct {
+    Current struct {
+      Time    string   `json:"time"` // UTC without a zone, as asked
+      Celsius *float64 `json:"temperature_2m"`
+      Code    *int     `json:"weather_code"`
+    } `json:"current"`
+  }
+
+  q := url.Values{"current": {"temperature_2m,weather_code"}, "latitude": {loc.LatString()}, "longitude": {loc.LonString()}}
+  return observeJSON(ctx, w.endpoint(), loc, "/v1/forecast", q, &d, func() (Observation, error) {
+    k, err := kelvin(d.Current.Celsius)
+    if err != nil {
+      return Observation{}, err
+    }
+
+    o := Observation{Kelvin: k}
+    if d.Current.Code != nil {
+      o.Condition = condition.FromWMO(*d.Current.Code)
+    }
+
+    if t, err := time.Parse("2006-01-02T15:04", d.Current.Time); err == nil {
+      o.Time = t
+    }
+
+    return o, nil
+  })
+}
+
+// MetNo is MET Norway's keyless forecast API; it has global coverage and
+// asks for an identifying User-Agent, which every upstream request carries.
+type MetNo struct {
+  BaseURL string // instead of https://api.met.no
+}
+
+func (w MetNo) Name() string { return "met.no" }
+
+func (w MetNo) endpoint() core { return core{Endpoint: metNoEndpoint.At(w.BaseURL)} }
+
+// The forecast is rerun hourly.
+func (w MetNo) Cadence() time.Duration { return time.Hour }
+
+func (w MetNo) Temperature(ctx context.Context, loc geo.Location) (float64, error) {
+  o, err := w.Observe(ctx, loc)
+  return o.Kelvin, err
+}
+
+func (w MetNo) Observe(ctx context.Context, loc geo.Location) (Observation, error) {
+  var d struct {
+    Properties struct {
+      Timeseries []struct {
+        Time time.Time `json:"time"`
+        Data struct {
+          Instant struct {
+            Details struct {
+              Celsius *float64 `json:"air_temperature"`
+            } `json:"details"`
+          } `json:"instant"`
+          Next struct {
+            Summary struct {
+              Symbol string `json:"symbol_code"`
+            } `json:"summary"`
+          } `json:"next_1_hours"`
+        } `json:"data"`
+      } `json:"timeseries"`
+    } `json:"properties"`
+  }
+
+  q := url.Values{"lat": {loc.LatString()}, "lon": {loc.LonString()}}
+  return observeJSON(ctx, w.endpoint(), loc, "/weatherdata/locationforecast/2.0/compact", q, &d, func() (Observation, error) {
+    if len(d.Properties.Timeseries) == 0 {
+      return Observation{}, fmt.Errorf("%w: met.no has no forecast for %s", upstream.ErrMalformed, loc.Name)
+    }
+
+    step := d.Properties.Timeseries[0]
+    now := step.Data
+    k, err := kelvin(now.Instant.Details.Celsius)
+    if err != nil {
+      return Observation{}, err
+    }
+
+    return Observation{Kelvin: k, Condition: condition.FromMetNo(now.Next.Summary.Symbol), Time: step.Time}, nil
+  })
+}
+
+func unixTime(epoch int64) time.Time {
+  if epoch <= 0 {
+    return time.Time{}
+  }
+
+  return time.Unix(epoch, 0).UTC()
+}
+
+// ErrNoProviders is the aggregate of nothing: every provider is disabled
+// or unavailable.
+var ErrNoProviders = errors.New("every provider is disabled")
+
+// Multi fans out to several providers.
+type Multi []Provider
+
+func (w Multi) Name() string { return "aggregate" }
+
+// Temperature is the plain average of the providers; the first to fail
+// fails it, and cancels the ones still working.
+func (w Multi) Temperature(ctx context.Context, loc geo.Location) (float64, error) {
+  rs := w.Gather(ctx, loc, FailFast)
+
+  var failed error
+  sum := 0.0
+  for _, r := range rs {
+    if r.Err != nil && (failed == nil || errors.Is(failed, ErrCancelled)) {
+      failed = r.Err
+    }
+
+    sum += r.Kelvin
+  }
+
+  if failed != nil {
+    return 0, failed
+  }
+
+  return sum / float64(len(w)), nil
+}
+
+// observe is p's current reading, with the condition when p has it.
+func observe(ctx context.Context, p Provider, loc geo.Location) (Observation, error) {
+  if o, ok := p.(Observer); ok {
+    return o.Observe(ctx, loc)
+  }
+
+  k, err := p.Temperature(ctx, loc)
+  return Observation{Kelvin: k}, err
+}
+
+// Reading is one provider's answer within a fan-out.
+type Reading struct {
+  Provider string        `json:"provider"`
+  Kelvin   float64       `json:"temp,omitempty"`
+  Took     time.Duration `json:"-"`
+  Error    string        `json:"error,omitempty"`
+  Err      error         `json:"-"`                  // Error as returned, for errors.Is
+  Withheld string        `json:"withheld,omitempty"` // why the value isn't shown
+  Excluded string        `json:"excluded,omitempty"` // why it was left out of the average
+  Carried  bool          `json:"carried,omitempty"`  // last reading reused by adaptive sampling
+  Cached   bool          `json:"cached,omitempty"`   // reused from the provider cache
+  Weight   float64       `json:"weight,omitempty"`   // effective weight in the average
+  Observed time.Time     `json:"-"`                  // see Observation.Time
+  Fetched  time.Time     `json:"-"`                  // when the provider answered; kept when reused
+
+  Condition condition.Code `json:"condition,omitempty"` // unknown when the provider doesn't say
+}
+
+// Reused is a reading that wasn't asked for this time.
+func (r Reading) Reused() bool { return r.Carried || r.Cached }
+
+func (r Reading) MarshalJSON() ([]byte, error) {
+  type plain Reading
+  var observed *time.Time
+  if !r.Observed.IsZero() {
+    observed = &r.Observed
+  }
+
+  return json.Marshal(struct {
+    plain
+    Took     string     `json:"took"`
+    Observed *time.Time `json:"observed,omitempty"`
+  }{plain(r), r.Took.String(), observed})
+}
+
+// Readings queries every provider and waits for all of them, successful or
+// not, so the caller can see each one's contribution.
+func (w Multi) Readings(ctx context.Context, loc geo.Location) []Reading {
+  return w.Gather(ctx, loc, CollectAll)
+}
+
+// ErrorPolicy is what a fan-out does once a provider has failed.
+type ErrorPolicy int
+
+const (
+  // CollectAll waits for every provider, so each one's outcome is known.
+  CollectAll ErrorPolicy = iota
+  // FailFast cancels the providers still working: any failure fails the
+  // aggregate, so there's no point waiting for them.
+  FailFast
+)
+
+var errorPolicies = [...]string{CollectAll: "collect-all", FailFast: "fail-fast"}
+
+func (p ErrorPolicy) String() string { return errorPolicies[p] }
+
+// Set parses a policy's name, as a flag.
+func (p *ErrorPolicy) Set(v string) error {
+  for i, name := range errorPolicies {
+    if name == v {
+      *p = ErrorPolicy(i)
+      return nil
+    }
+  }
+
+  return fmt.Errorf("want collect-all or fail-fast, got %q", v)
+}
+
+// Gather is Readings under policy. However it ends, every goroutine has
+// returned by the time it does; under FailFast the providers cut short
+// fail with ErrCancelled, naming the one that failed first.
+func (w Multi) Gather(ctx context.Context, loc geo.Location, policy ErrorPolicy) []Reading {
+  rs := make([]Reading, len(w))
+  ctx, cancel := context.WithCancelCause(ctx)
+  defer cancel(nil)
+
+  var wg sync.WaitGroup
+  for i, provider := range w {
+    wg.Add(1)
+    go func(i int, p Provider) {
+      defer wg.Done()
+
+      begin := time.Now()
+      o, err := observe(ctx, p, loc)
+      rs[i] = Reading{Provider: p.Name(), Kelvin: o.Kelvin, Condition: o.Condition, Observed: o.Time, Fetched: time.Now(), Took: time.Since(begin)}
+      if err == nil {
+        return
+      }
+
+      if cause := context.Cause(ctx); errors.Is(cause, ErrCancelled) {
+        err = cause
+      } else if policy == FailFast {
+        cancel(fmt.Errorf("%w: %s failed", ErrCancelled, p.Name()))
+      }
+
+      rs[i] = Reading{Provider: p.Name(), Took: rs[i].Took, Error: err.Error(), Err: err}
+    }(i, provider)
+  }
+
+  wg.Wait()
+  return rs
+}
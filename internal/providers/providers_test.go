@@ -0,0 +1,210 @@
+package providers
+
+import (
+  "context"
+  "io"
+  "log"
+  "math"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "testing"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// The places each provider's fixture has an answer for, one per way its
+// API answers.
+var (
+  oslo      = geo.Location{Name: "Oslo", Lat: 59.9139, Lon: 10.7522} // a reading
+  nowhere   = geo.Location{Name: "nowhere", Lat: -89.9, Lon: 0}      // no such place, or no data there
+  partial   = geo.Location{Name: "partial", Lat: 1, Lon: 1}          // answered without the temperature
+  throttled = geo.Location{Name: "throttled", Lat: 2, Lon: 2}        // over the rate limit
+  failing   = geo.Location{Name: "failing", Lat: 3, Lon: 3}          // a 5xx, often not JSON
+  revoked   = geo.Location{Name: "revoked", Lat: 4, Lon: 4}          // the key, or the client, refused
+)
+
+const testKey = "test-key"
+
+// replay answers upstream calls from testdata/name until the test ends;
+// the providers' per-call log lines are dropped with it. {hour} in the
+// fixture stands for the start of the current UTC hour, as Unix time, for
+// the APIs asked about it.
+func replay(tb testing.TB, name string, secrets ...string) {
+  tb.Helper()
+
+  raw, err := os.ReadFile(filepath.Join("testdata", name))
+  if err != nil {
+    tb.Fatal(err)
+  }
+
+  hour := strconv.FormatInt(time.Now().UTC().Truncate(time.Hour).Unix(), 10)
+  path := filepath.Join(tb.TempDir(), name)
+  if err := os.WriteFile(path, []byte(strings.ReplaceAll(string(raw), "{hour}", hour)), 0o644); err != nil {
+    tb.Fatal(err)
+  }
+
+  f, err := upstream.ReplayFixtures(path, secrets...)
+  if err != nil {
+    tb.Fatal(err)
+  }
+
+  prev, out := upstream.Client.Transport, log.Writer()
+  upstream.Client.Transport = f
+  log.SetOutput(io.Discard)
+  tb.Cleanup(func() {
+    upstream.Client.Transport = prev
+    log.SetOutput(out)
+  })
+}
+
+func BenchmarkGather(b *testing.B) {
+  replay(b, "oslo.json")
+  ps := Multi{OpenMeteo{}, MetNo{}}
+  ctx := context.Background()
+
+  for _, policy := range []ErrorPolicy{CollectAll, FailFast} {
+    b.Run(policy.String(), func(b *testing.B) {
+      b.ReportAllocs()
+      for i := 0; i < b.N; i++ {
+        for _, r := range ps.Gather(ctx, oslo, policy) {
+          if r.Err != nil {
+            b.Fatalf("%s: %s", r.Provider, r.Err)
+          }
+        }
+      }
+    })
+  }
+}
+
+// answer is what a provider should make of its API's answer for a place:
+// the reading, in K, or the provider_calls_total outcome it fails with.
+type answer struct {
+  loc     geo.Location
+  kelvin  float64
+  outcome string
+}
+
+func TestObserve(t *testing.T) {
+  corp, errs := (&GenericConfig{Name: "corp", URL: "https://wx.example.com/obs?lat={lat}&lon={lon}", Path: "$.obs.temp_f", Unit: "fahrenheit"}).Compile()
+  if errs != nil {
+    t.Fatal(errs)
+  }
+
+  tests := []struct {
+    provider Provider
+    answers  []answer
+  }{
+    {OpenWeatherMap{APIKey: testKey}, []answer{
+      {oslo, 281.55, "ok"}, // already in K
+      {nowhere, 0, "not_found"},
+      {partial, 0, "parse"},
+      {throttled, 0, "rate_limited"},
+      {failing, 0, "server_error"},
+      {revoked, 0, "auth"},
+    }},
+    {WeatherUnderground{APIKey: testKey}, []answer{
+      {oslo, 281.15, "ok"},      // 8 °C
+      {nowhere, 0, "not_found"}, // a 200 with a typed error
+      {partial, 0, "parse"},     // an observation without temp_c
+      {failing, 0, "server_error"},
+      {revoked, 0, "auth"},
+    }},
+    {OpenMeteo{}, []answer{
+      {oslo, 281.55, "ok"}, // 8.4 °C
+      {nowhere, 0, "rejected"},
+      {partial, 0, "parse"},
+      {throttled, 0, "rate_limited"},
+      {failing, 0, "server_error"},
+    }},
+    {MetNo{}, []answer{
+      {oslo, 281.25, "ok"},  // 8.1 °C
+      {nowhere, 0, "parse"}, // an empty timeseries
+      {partial, 0, "parse"}, // a step without air_temperature
+      {throttled, 0, "rate_limited"},
+      {failing, 0, "server_error"},
+      {revoked, 0, "auth"}, // a 403 for the User-Agent
+    }},
+    {VisualCrossing{APIKey: testKey}, []answer{
+      {oslo, 281.45, "ok"}, // 8.3 °C, unitGroup=metric
+      {nowhere, 0, "rejected"},
+      {partial, 0, "parse"}, // no currentConditions
+      {throttled, 0, "rate_limited"},
+      {failing, 0, "server_error"},
+      {revoked, 0, "auth"},
+    }},
+    {Tomorrow{APIKey: testKey}, []answer{
+      {oslo, 281.75, "ok"}, // 8.6 °C, units=metric
+      {nowhere, 0, "rejected"},
+      {partial, 0, "parse"},
+      {throttled, 0, "rate_limited"},
+      {failing, 0, "server_error"},
+      {revoked, 0, "auth"},
+    }},
+    {Stormglass{APIKey: testKey}, []answer{
+      {oslo, 281.35, "ok"},  // 8.2 °C, Stormglass's own pick over NOAA's 7.9
+      {nowhere, 0, "parse"}, // no hours
+      {partial, 0, "parse"}, // an hour without airTemperature
+      {throttled, 0, "rate_limited"},
+      {failing, 0, "server_error"},
+      {revoked, 0, "auth"},
+    }},
+    {corp, []answer{
+      {oslo, 281.65, "ok"},  // 47.3 °F
+      {partial, 0, "parse"}, // no $.obs.temp_f
+      {failing, 0, "server_error"},
+    }},
+  }
+
+  for _, tt := range tests {
+    t.Run(tt.provider.Name(), func(t *testing.T) {
+      replay(t, tt.provider.Name()+".json", testKey)
+      for _, a := range tt.answers {
+        o, err := observe(context.Background(), tt.provider, a.loc)
+        if got := callOutcome(err); got != a.outcome {
+          t.Errorf("%s: got %s (%v), want %s", a.loc.Name, got, err, a.outcome)
+          continue
+        }
+
+        if err == nil && math.Abs(o.Kelvin-a.kelvin) > 1e-9 {
+          t.Errorf("%s: got %.4f K, want %.4f K", a.loc.Name, o.Kelvin, a.kelvin)
+        }
+      }
+    })
+  }
+}
+
+func TestMeteostatHistory(t *testing.T) {
+  replay(t, "meteostat.json", testKey)
+  w, day := Meteostat{APIKey: testKey}, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+  ps, err := w.History(context.Background(), oslo, day)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  // The hour without a temperature is left out rather than read as 0 °C.
+  want := []ForecastPoint{{Valid: day, Kelvin: 280.35}, {Valid: day.Add(2 * time.Hour), Kelvin: 279.75}}
+  if len(ps) != len(want) {
+    t.Fatalf("got %d points, want %d: %+v", len(ps), len(want), ps)
+  }
+
+  for i, p := range ps {
+    if !p.Valid.Equal(want[i].Valid) || math.Abs(p.Kelvin-want[i].Kelvin) > 1e-9 {
+      t.Errorf("point %d: got %s %.4f K, want %s %.4f K", i, p.Valid, p.Kelvin, want[i].Valid, want[i].Kelvin)
+    }
+  }
+
+  for _, a := range []answer{{throttled, 0, "rate_limited"}, {revoked, 0, "auth"}} {
+    if _, err := w.History(context.Background(), a.loc, day); callOutcome(err) != a.outcome {
+      t.Errorf("%s: got %s (%v), want %s", a.loc.Name, callOutcome(err), err, a.outcome)
+    }
+  }
+
+  if _, err := w.History(context.Background(), partial, day); err == nil {
+    t.Errorf("partial: got no error for a day without temperatures")
+  }
+}
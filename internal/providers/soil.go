@@ -0,0 +1,190 @@
+package providers
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "net/http"
+  "net/url"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+)
+
+// Soil is the ground at a point now. Each provider measures at depths of
+// its own, in cm below the surface: a point such as "6" or a layer such as
+// "9-27".
+type Soil struct {
+  Temps              []SoilDepth // kelvin
+  Moisture           []SoilDepth // volumetric, m³ of water per m³ of soil
+  Evapotranspiration *float64    // FAO-56 reference, mm over the local day
+}
+
+// SoilDepth is a value at one depth.
+type SoilDepth struct {
+  Depth string
+  Value float64
+}
+
+// SoilReporter is implemented by providers with soil conditions.
+type SoilReporter interface {
+  Provider
+  Soil(ctx context.Context, loc geo.Location) (Soil, error)
+}
+
+// ErrNoSoil is a provider without soil data for the place, most often
+// because it is at sea; it is left out rather than counted as failing.
+var ErrNoSoil = errors.New("no soil data available")
+
+// soilParam is a provider's name for the value at a depth.
+type soilParam struct{ depth, name string }
+
+func soilNames(params ...[]soilParam) string {
+  var names []string
+  for _, ps := range params {
+    for _, p := range ps {
+      names = append(names, p.name)
+    }
+  }
+
+  return strings.Join(names, ",")
+}
+
+// soilDepths is the values in vs by params, shallowest first, leaving out
+// the depths without one.
+func soilDepths(vs map[string]*float64, params []soilParam, convert func(*float64) *float64) []SoilDepth {
+  var ds []SoilDepth
+  for _, p := range params {
+    if v := convert(vs[p.name]); v != nil {
+      ds = append(ds, SoilDepth{Depth: p.depth, Value: *v})
+    }
+  }
+
+  return ds
+}
+
+func volumetric(v *float64) *float64 { return v }
+
+// soilValues is the members of an hour that value decodes, leaving out
+// the rest such as its time.
+func soilValues(hour map[string]json.RawMessage, value func(json.RawMessage) (*float64, error)) map[string]*float64 {
+  vs := make(map[string]*float64, len(hour))
+  for name, raw := range hour {
+    if v, err := value(raw); err == nil {
+      vs[name] = v
+    }
+  }
+
+  return vs
+}
+
+var (
+  openMeteoSoilTemps = []soilParam{
+    {"0", "soil_temperature_0cm"}, {"6", "soil_temperature_6cm"},
+    {"18", "soil_temperature_18cm"}, {"54", "soil_temperature_54cm"},
+  }
+  openMeteoSoilMoisture = []soilParam{
+    {"0-1", "soil_moisture_0_to_1cm"}, {"1-3", "soil_moisture_1_to_3cm"}, {"3-9", "soil_moisture_3_to_9cm"},
+    {"9-27", "soil_moisture_9_to_27cm"}, {"27-81", "soil_moisture_27_to_81cm"},
+  }
+)
+
+// Soil is Open-Meteo's land model for the current hour, down to 81 cm,
+// with the reference evapotranspiration over the place's day. It has
+// nulls at sea.
+func (w OpenMeteo) Soil(ctx context.Context, loc geo.Location) (Soil, error) {
+  var d struct {
+    Current map[string]json.RawMessage `json:"current"`
+    Daily   struct {
+      Evapotranspiration []*float64 `json:"et0_fao_evapotranspiration"`
+    } `json:"daily"`
+  }
+
+  q := url.Values{
+    "current":       {soilNames(openMeteoSoilTemps, openMeteoSoilMoisture)},
+    "daily":         {"et0_fao_evapotranspiration"},
+    "forecast_days": {"1"},
+    "timezone":      {"auto"},
+    "latitude":      {loc.LatString()},
+    "longitude":     {loc.LonString()},
+  }
+  if err := w.endpoint().getJSON(ctx, "/v1/forecast", q, &d); err != nil {
+    return Soil{}, err
+  }
+
+  vs := soilValues(d.Current, func(raw json.RawMessage) (v *float64, err error) {
+    return v, json.Unmarshal(raw, &v)
+  })
+
+  s := Soil{
+    Temps:    soilDepths(vs, openMeteoSoilTemps, celsius),
+    Moisture: soilDepths(vs, openMeteoSoilMoisture, volumetric),
+  }
+  if len(s.Temps) == 0 && len(s.Moisture) == 0 {
+    return Soil{}, ErrNoSoil
+  }
+
+  if et := d.Daily.Evapotranspiration; len(et) > 0 {
+    s.Evapotranspiration = et[0]
+  }
+
+  return s, nil
+}
+
+// Stormglass names each layer but the top one by the depth it starts at.
+var (
+  stormglassSoilTemps = []soilParam{
+    {"0-10", "soilTemperature"}, {"10-40", "soilTemperature10cm"},
+    {"40-100", "soilTemperature40cm"}, {"100-200", "soilTemperature100cm"},
+  }
+  stormglassSoilMoisture = []soilParam{
+    {"0-10", "soilMoisture"}, {"10-40", "soilMoisture10cm"},
+    {"40-100", "soilMoisture40cm"}, {"100-200", "soilMoisture100cm"},
+  }
+)
+
+// Soil is Stormglass's bio data for the current hour, down to 2 m. It has
+// no evapotranspiration.
+func (w Stormglass) Soil(ctx context.Context, loc geo.Location) (Soil, error) {
+  var d struct {
+    Hours []map[string]json.RawMessage `json:"hours"`
+  }
+
+  e := w.endpoint()
+  e.Header = http.Header{"Authorization": {w.APIKey}}
+
+  hour := time.Now().UTC().Truncate(time.Hour)
+  q := url.Values{
+    "lat":    {loc.LatString()},
+    "lng":    {loc.LonString()},
+    "params": {soilNames(stormglassSoilTemps, stormglassSoilMoisture)},
+    "start":  {strconv.FormatInt(hour.Unix(), 10)},
+    "end":    {strconv.FormatInt(hour.Unix(), 10)},
+  }
+
+  if err := e.getJSON(ctx, "/v2/bio/point", q, &d); err != nil {
+    return Soil{}, err
+  }
+
+  if len(d.Hours) == 0 {
+    return Soil{}, ErrNoSoil
+  }
+
+  vs := soilValues(d.Hours[0], func(raw json.RawMessage) (*float64, error) {
+    var v stormglassValue
+    err := json.Unmarshal(raw, &v)
+    return v.best(), err
+  })
+
+  s := Soil{
+    Temps:    soilDepths(vs, stormglassSoilTemps, celsius),
+    Moisture: soilDepths(vs, stormglassSoilMoisture, volumetric),
+  }
+  if len(s.Temps) == 0 && len(s.Moisture) == 0 {
+    return Soil{}, ErrNoSoil
+  }
+
+  return s, nil
+}
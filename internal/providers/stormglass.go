@@ -0,0 +1,137 @@
+package providers
+
+import (
+  "context"
+  "log"
+  "net/http"
+  "net/url"
+  "strconv"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+var stormglassEndpoint = upstream.Endpoint{Base: "https://api.stormglass.io", Provider: "stormglass"}
+
+// Stormglass is stormglass.io's point weather, which blends several
+// models for the sea. Its free plan allows 10 calls a day.
+type Stormglass struct {
+  APIKey  string
+  BaseURL string // instead of https://api.stormglass.io
+}
+
+func (w Stormglass) Name() string { return "stormglass" }
+
+func (w Stormglass) endpoint() core { return core{stormglassEndpoint.At(w.BaseURL), w.APIKey} }
+
+func (w Stormglass) WithAPIKey(key string) Provider { w.APIKey = key; return w }
+
+// The models run hourly.
+func (w Stormglass) Cadence() time.Duration { return time.Hour }
+
+// The free plan is for non-commercial use.
+func (w Stormglass) Terms() Terms { return Terms{} }
+
+func (w Stormglass) Attribution() upstream.Attribution {
+  return upstream.Attribution{Source: w.Name(), Text: "Data provided by Stormglass", URL: "https://stormglass.io/"}
+}
+
+// stormglassValue is one parameter by the model that gave it; "sg" is
+// Stormglass's own pick among them.
+type stormglassValue map[string]float64
+
+func (v stormglassValue) best() *float64 {
+  for _, source := range []string{"sg", "noaa", "icon", "meteo"} {
+    if x, ok := v[source]; ok {
+      return &x
+    }
+  }
+
+  return nil
+}
+
+type stormglassHour struct {
+  Time           time.Time       `json:"time"`
+  AirCelsius     stormglassValue `json:"airTemperature"`
+  WaveHeight     stormglassValue `json:"waveHeight"`
+  WavePeriod     stormglassValue `json:"wavePeriod"`
+  WaveDirection  stormglassValue `json:"waveDirection"`
+  SwellHeight    stormglassValue `json:"swellHeight"`
+  SwellPeriod    stormglassValue `json:"swellPeriod"`
+  SwellDirection stormglassValue `json:"swellDirection"`
+  SeaCelsius     stormglassValue `json:"waterTemperature"`
+}
+
+// now is the current hour, every parameter asked at once: each call
+// counts against the daily allowance however many it asks for.
+func (w Stormglass) now(ctx context.Context, loc geo.Location) (stormglassHour, error) {
+  var d struct {
+    Hours []stormglassHour `json:"hours"`
+  }
+
+  // Stormglass takes the key in a header rather than the query.
+  e := w.endpoint()
+  e.Header = http.Header{"Authorization": {w.APIKey}}
+
+  hour := time.Now().UTC().Truncate(time.Hour)
+  q := url.Values{
+    "lat":    {loc.LatString()},
+    "lng":    {loc.LonString()},
+    "params": {"airTemperature,waveHeight,wavePeriod,waveDirection,swellHeight,swellPeriod,swellDirection,waterTemperature"},
+    "start":  {strconv.FormatInt(hour.Unix(), 10)},
+    "end":    {strconv.FormatInt(hour.Unix(), 10)},
+  }
+
+  if err := e.getJSON(ctx, "/v2/weather/point", q, &d); err != nil {
+    return stormglassHour{}, err
+  }
+
+  if len(d.Hours) == 0 {
+    return stormglassHour{}, errNoTemperature
+  }
+
+  return d.Hours[0], nil
+}
+
+func (w Stormglass) Temperature(ctx context.Context, loc geo.Location) (float64, error) {
+  o, err := w.Observe(ctx, loc)
+  return o.Kelvin, err
+}
+
+func (w Stormglass) Observe(ctx context.Context, loc geo.Location) (Observation, error) {
+  begin := time.Now()
+
+  h, err := w.now(ctx, loc)
+  if err != nil {
+    return Observation{}, err
+  }
+
+  c := h.AirCelsius.best()
+  if c == nil {
+    return Observation{}, errNoTemperature
+  }
+
+  o := Observation{Kelvin: *c + 273.15, Time: h.Time.UTC()}
+  log.Printf("stormglass: %s: %.2f, took: %s", loc.Name, o.Kelvin, time.Since(begin).String())
+  return o, nil
+}
+
+// Marine has no sea parameters on land.
+func (w Stormglass) Marine(ctx context.Context, loc geo.Location) (Marine, error) {
+  h, err := w.now(ctx, loc)
+  if err != nil {
+    return Marine{}, err
+  }
+
+  m := Marine{
+    WaveHeight: h.WaveHeight.best(), WavePeriod: h.WavePeriod.best(), WaveDirection: h.WaveDirection.best(),
+    SwellHeight: h.SwellHeight.best(), SwellPeriod: h.SwellPeriod.best(), SwellDirection: h.SwellDirection.best(),
+    SeaKelvin: celsius(h.SeaCelsius.best()),
+  }
+  if m == (Marine{}) {
+    return Marine{}, ErrNoMarine
+  }
+
+  return m, nil
+}
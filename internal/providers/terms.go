@@ -0,0 +1,125 @@
+package providers
+
+import (
+  "fmt"
+  "sort"
+  "strings"
+  "time"
+)
+
+// Terms is what a provider's terms of use allow on the plan this server
+// uses them with (the free tier unless noted). They are a summary for
+// guarding configuration, not legal advice; check the current terms.
+type Terms struct {
+  Commercial bool          // may back a commercial service
+  MaxCache   time.Duration // longest a reading may be cached; 0 is no limit
+}
+
+// Licensed is implemented by providers with usage constraints; the offline
+// model and anything else without it are unrestricted.
+type Licensed interface {
+  Terms() Terms
+}
+
+func (w OpenWeatherMap) Terms() Terms { return Terms{Commercial: true} }
+
+// Weather Underground's API is for personal use and its data may not be
+// kept beyond short-term caching.
+func (w WeatherUnderground) Terms() Terms { return Terms{MaxCache: time.Hour} }
+
+// The free Open-Meteo API is non-commercial; commercial use needs a plan.
+func (w OpenMeteo) Terms() Terms { return Terms{} }
+
+func (w MetNo) Terms() Terms { return Terms{Commercial: true} }
+
+// Usage is how the server is configured to use provider data.
+type Usage struct {
+  commercial bool
+  cacheTTL   time.Duration
+}
+
+// ParseUsage reads -use: non-commercial or commercial.
+func ParseUsage(mode string, cacheTTL time.Duration) (Usage, error) {
+  switch mode {
+  case "non-commercial":
+    return Usage{cacheTTL: cacheTTL}, nil
+  case "commercial":
+    return Usage{commercial: true, cacheTTL: cacheTTL}, nil
+  default:
+    return Usage{}, fmt.Errorf("unknown use %q, want non-commercial or commercial", mode)
+  }
+}
+
+// Allows reports why p's terms forbid u, if they do.
+func (u Usage) Allows(p Provider) error {
+  l, ok := p.(Licensed)
+  if !ok {
+    return nil
+  }
+
+  t := l.Terms()
+  if u.commercial && !t.Commercial {
+    return fmt.Errorf("%s: terms don't allow commercial use", p.Name())
+  }
+
+  if t.MaxCache > 0 && u.cacheTTL > t.MaxCache {
+    return fmt.Errorf("%s: terms allow caching for at most %s, -cache.ttl is %s", p.Name(), t.MaxCache, u.cacheTTL)
+  }
+
+  return nil
+}
+
+// AllowsCaching reports why p's terms forbid keeping its readings for ttl,
+// if they do.
+func (u Usage) AllowsCaching(p Provider, ttl time.Duration) error {
+  if l, ok := p.(Licensed); ok && l.Terms().MaxCache > 0 && ttl > l.Terms().MaxCache {
+    return fmt.Errorf("%s: terms allow caching for at most %s, -provider.ttl is %s", p.Name(), l.Terms().MaxCache, ttl)
+  }
+
+  return nil
+}
+
+// Enabled picks the providers named in the -providers list (all
+// when empty) and refuses to start with any whose terms the configured use
+// would violate, rather than quietly dropping it.
+func Enabled(providers Multi, names string, u Usage) (Multi, error) {
+  want := make(map[string]bool)
+  for _, n := range strings.Split(names, ",") {
+    if n = strings.TrimSpace(n); n != "" {
+      want[n] = true
+    }
+  }
+
+  all := len(want) == 0
+
+  var enabled Multi
+  var errs []string
+  for _, p := range providers {
+    if !all && !want[p.Name()] {
+      continue
+    }
+
+    delete(want, p.Name())
+    if err := u.Allows(p); err != nil {
+      errs = append(errs, err.Error())
+      continue
+    }
+
+    enabled = append(enabled, p)
+  }
+
+  for n := range want {
+    errs = append(errs, fmt.Sprintf("unknown provider %q", n))
+  }
+
+  if len(errs) > 0 {
+    sort.Strings(errs)
+    return nil, fmt.Errorf("%s (fix or leave them out of -providers)", strings.Join(errs, "; "))
+  }
+
+  if len(enabled) == 0 {
+    return nil, ErrNoProviders
+  }
+
+  return enabled, nil
+}
@@ -0,0 +1,120 @@
+package providers
+
+import (
+  "context"
+  "log"
+  "net/url"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+var tomorrowEndpoint = upstream.Endpoint{Base: "https://api.tomorrow.io", Provider: "tomorrow.io"}
+
+// Tomorrow is tomorrow.io's realtime weather API, for the temperature, the
+// UV index and, where the plan includes them, pollen indexes.
+type Tomorrow struct {
+  APIKey  string
+  BaseURL string // instead of https://api.tomorrow.io
+}
+
+func (w Tomorrow) Name() string { return "tomorrow.io" }
+
+func (w Tomorrow) endpoint() core { return core{tomorrowEndpoint.At(w.BaseURL), w.APIKey} }
+
+func (w Tomorrow) WithAPIKey(key string) Provider { w.APIKey = key; return w }
+
+// The free plan is for personal, non-commercial use.
+func (w Tomorrow) Terms() Terms { return Terms{} }
+
+func (w Tomorrow) Attribution() upstream.Attribution {
+  return upstream.Attribution{Source: w.Name(), Text: "Powered by Tomorrow.io", URL: "https://www.tomorrow.io/"}
+}
+
+type tomorrowValues struct {
+  Celsius *float64 `json:"temperature"`
+  UV      *float64 `json:"uvIndex"`
+  Tree    *int     `json:"treeIndex"` // 0 none to 5 very high
+  Grass   *int     `json:"grassIndex"`
+  Weed    *int     `json:"weedIndex"`
+}
+
+func (w Tomorrow) realtime(ctx context.Context, loc geo.Location) (tomorrowValues, time.Time, error) {
+  var d struct {
+    Data struct {
+      Time   time.Time      `json:"time"`
+      Values tomorrowValues `json:"values"`
+    } `json:"data"`
+  }
+
+  q := url.Values{"apikey": {w.APIKey}, "location": {loc.LatString() + "," + loc.LonString()}, "units": {"metric"}}
+  if err := w.endpoint().getJSON(ctx, "/v4/weather/realtime", q, &d); err != nil {
+    return tomorrowValues{}, time.Time{}, err
+  }
+
+  return d.Data.Values, d.Data.Time.UTC(), nil
+}
+
+func (w Tomorrow) Temperature(ctx context.Context, loc geo.Location) (float64, error) {
+  o, err := w.Observe(ctx, loc)
+  return o.Kelvin, err
+}
+
+func (w Tomorrow) Observe(ctx context.Context, loc geo.Location) (Observation, error) {
+  begin := time.Now()
+
+  v, at, err := w.realtime(ctx, loc)
+  if err != nil {
+    return Observation{}, err
+  }
+
+  if v.Celsius == nil {
+    return Observation{}, errNoTemperature
+  }
+
+  o := Observation{Kelvin: *v.Celsius + 273.15, Time: at}
+  log.Printf("tomorrow.io: %s: %.2f, took: %s", loc.Name, o.Kelvin, time.Since(begin).String())
+  return o, nil
+}
+
+func (w Tomorrow) UV(ctx context.Context, loc geo.Location) (float64, error) {
+  v, _, err := w.realtime(ctx, loc)
+  if err != nil {
+    return 0, err
+  }
+
+  if v.UV == nil {
+    return 0, ErrNoUV
+  }
+
+  return *v.UV, nil
+}
+
+// Tomorrow.io's indexes run from 0 to 5, its very low and low both being
+// the NAB's low.
+var tomorrowPollen = [...]int{PollenNone, PollenLow, PollenLow, PollenModerate, PollenHigh, PollenVeryHigh}
+
+// Pollen is the tree, grass and weed indexes, which only some plans have.
+func (w Tomorrow) Pollen(ctx context.Context, loc geo.Location) (Pollen, error) {
+  v, _, err := w.realtime(ctx, loc)
+  if err != nil {
+    return Pollen{}, err
+  }
+
+  level := func(index *int) *int {
+    if index == nil || *index < 0 || *index >= len(tomorrowPollen) {
+      return nil
+    }
+
+    l := tomorrowPollen[*index]
+    return &l
+  }
+
+  p := Pollen{Tree: level(v.Tree), Grass: level(v.Grass), Weed: level(v.Weed)}
+  if p.Tree == nil && p.Grass == nil && p.Weed == nil {
+    return Pollen{}, ErrNoPollen
+  }
+
+  return p, nil
+}
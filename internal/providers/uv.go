@@ -0,0 +1,63 @@
+package providers
+
+import (
+  "context"
+  "errors"
+  "net/url"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+)
+
+// UVReporter is implemented by providers that know the UV index now.
+type UVReporter interface {
+  Provider
+  UV(ctx context.Context, loc geo.Location) (float64, error)
+}
+
+// ErrNoUV is a provider without a UV index for the place or on this plan;
+// it is left out rather than counted as failing.
+var ErrNoUV = errors.New("no UV index available")
+
+// UV is One Call 3.0's, like nowcasts only asked with -openweather.onecall.
+func (w OpenWeatherMap) UV(ctx context.Context, loc geo.Location) (float64, error) {
+  if !w.OneCall {
+    return 0, ErrNoUV
+  }
+
+  var d struct {
+    Current struct {
+      UVI *float64 `json:"uvi"`
+    } `json:"current"`
+  }
+
+  q := url.Values{"appid": {w.APIKey}, "lat": {loc.LatString()}, "lon": {loc.LonString()}, "exclude": {"minutely,hourly,daily,alerts"}}
+  if err := w.endpoint().getJSON(ctx, "/data/3.0/onecall", q, &d); err != nil {
+    return 0, err
+  }
+
+  if d.Current.UVI == nil {
+    return 0, ErrNoUV
+  }
+
+  return *d.Current.UVI, nil
+}
+
+// UV is the forecast model's for the current quarter hour.
+func (w OpenMeteo) UV(ctx context.Context, loc geo.Location) (float64, error) {
+  var d struct {
+    Current struct {
+      UV *float64 `json:"uv_index"`
+    } `json:"current"`
+  }
+
+  q := url.Values{"current": {"uv_index"}, "latitude": {loc.LatString()}, "longitude": {loc.LonString()}}
+  if err := w.endpoint().getJSON(ctx, "/v1/forecast", q, &d); err != nil {
+    return 0, err
+  }
+
+  if d.Current.UV == nil {
+    return 0, ErrNoUV
+  }
+
+  return *d.Current.UV, nil
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+  "context"
+  "encoding/json"
+  "fmt"
+  "io"
+  "log"
+  "net/http"
+  "net/url"
+  "os"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+// accessLog writes a line per request, apart from the log of what the
+// server and its providers do: -access.log=- to stdout, or to a file that
+// is rotated once it grows past maxSize.
+type accessLog struct {
+  out     io.Writer
+  json    bool
+  proxies proxyList
+}
+
+// newAccessLog is nil, logging nothing, without a path.
+func newAccessLog(path, format string, maxSize int64, backups int, proxies proxyList) (*accessLog, error) {
+  if path == "" {
+    return nil, nil
+  }
+
+  if format != "json" && format != "combined" {
+    return nil, fmt.Errorf("access log format: want json or combined, got %q", format)
+  }
+
+  a := &accessLog{out: os.Stdout, json: format == "json", proxies: proxies}
+  if path != "-" {
+    f, err := openRotating(path, maxSize, backups)
+    if err != nil {
+      return nil, fmt.Errorf("access log: %w", err)
+    }
+
+    a.out = f
+  }
+
+  return a, nil
+}
+
+// accessRecord is what handlers deeper down know about the request: the
+// rate limiter fills it in through the context.
+type accessRecord struct {
+  client    string // API client name, for authenticated requests
+  ratelimit string // off, ok or limited
+}
+
+type accessCtxKey struct{}
+
+func noteRateLimit(r *http.Request, client, state string) {
+  if rec, ok := r.Context().Value(accessCtxKey{}).(*accessRecord); ok {
+    rec.client, rec.ratelimit = client, state
+  }
+}
+
+type accessWriter struct {
+  http.ResponseWriter
+  status int
+  bytes  int64
+}
+
+func (w *accessWriter) WriteHeader(status int) {
+  w.status = status
+  w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessWriter) Write(b []byte) (int, error) {
+  n, err := w.ResponseWriter.Write(b)
+  w.bytes += int64(n)
+  return n, err
+}
+
+// Flush keeps streams streaming through the log.
+func (w *accessWriter) Flush() {
+  if f, ok := w.ResponseWriter.(http.Flusher); ok {
+    f.Flush()
+  }
+}
+
+func (w *accessWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// accessEntry is a line of the JSON format.
+type accessEntry struct {
+  Time      time.Time `json:"time"`
+  Method    string    `json:"method"`
+  Path      string    `json:"path"`
+  Status    int       `json:"status"`
+  Bytes     int64     `json:"bytes"`
+  Duration  float64   `json:"duration_ms"`
+  ClientIP  string    `json:"client_ip"`
+  Client    string    `json:"client,omitempty"`
+  RateLimit string    `json:"ratelimit"`
+  Referer   string    `json:"referer,omitempty"`
+  UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// handle logs every request once it is answered; a nil accessLog leaves h
+// as it is.
+func (a *accessLog) handle(h http.Handler) http.Handler {
+  if a == nil {
+    return h
+  }
+
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    begin := time.Now()
+    rec := &accessRecord{ratelimit: "off"}
+    aw := &accessWriter{ResponseWriter: w, status: http.StatusOK}
+    h.ServeHTTP(aw, r.WithContext(context.WithValue(r.Context(), accessCtxKey{}, rec)))
+
+    ip := a.proxies.clientIP(r)
+    e := accessEntry{
+      Time: begin, Method: r.Method, Path: redactedURI(r.URL), Status: aw.status, Bytes: aw.bytes,
+      Duration: float64(time.Since(begin).Microseconds()) / 1000, ClientIP: ip, Client: rec.client, RateLimit: rec.ratelimit,
+      Referer: r.Referer(), UserAgent: r.UserAgent(),
+    }
+
+    if _, err := a.out.Write(a.format(e, r.Proto)); err != nil {
+      log.Printf("access log: %s", err)
+    }
+  })
+}
+
+func (a *accessLog) format(e accessEntry, proto string) []byte {
+  if a.json {
+    line, _ := json.Marshal(e)
+    return append(line, '\n')
+  }
+
+  user := e.Client
+  if user == "" {
+    user = "-"
+  }
+
+  // Apache's combined format, followed by the duration in milliseconds and
+  // the rate limit's state.
+  return fmt.Appendf(nil, "%s - %s [%s] %s %d %d %s %s %.3f %s\n",
+    e.ClientIP, user, e.Time.Format("02/Jan/2006:15:04:05 -0700"), strconv.Quote(e.Method+" "+e.Path+" "+proto),
+    e.Status, e.Bytes, quoteOrDash(e.Referer), quoteOrDash(e.UserAgent), e.Duration, e.RateLimit)
+}
+
+func quoteOrDash(s string) string {
+  if s == "" {
+    return `"-"`
+  }
+
+  return strconv.Quote(s)
+}
+
+// redactedURI is u's path and query with ?api_key= hidden, so keys don't
+// end up in log files.
+func redactedURI(u *url.URL) string {
+  if !strings.Contains(u.RawQuery, "api_key") {
+    return u.RequestURI()
+  }
+
+  q := u.Query()
+  if q.Has("api_key") {
+    q.Set("api_key", "redacted")
+  }
+
+  r := *u
+  r.RawQuery = q.Encode()
+  return r.RequestURI()
+}
+
+// rotatingFile is a log file moved to path.1 once it grows past maxSize,
+// path.1 to path.2 and so on, keeping backups of them; a maxSize of 0
+// never rotates.
+type rotatingFile struct {
+  path    string
+  maxSize int64
+  backups int
+
+  mu   sync.Mutex
+  f    *os.File
+  size int64
+}
+
+func openRotating(path string, maxSize int64, backups int) (*rotatingFile, error) {
+  r := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+  if err := r.open(); err != nil {
+    return nil, err
+  }
+
+  return r, nil
+}
+
+func (r *rotatingFile) open() error {
+  f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+  if err != nil {
+    return err
+  }
+
+  info, err := f.Stat()
+  if err != nil {
+    f.Close()
+    return err
+  }
+
+  r.f, r.size = f, info.Size()
+  return nil
+}
+
+func (r *rotatingFile) Write(b []byte) (int, error) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  if r.maxSize > 0 && r.size > 0 && r.size+int64(len(b)) > r.maxSize {
+    if err := r.rotate(); err != nil {
+      return 0, err
+    }
+  }
+
+  n, err := r.f.Write(b)
+  r.size += int64(n)
+  return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+  if err := r.f.Close(); err != nil {
+    return err
+  }
+
+  if r.backups == 0 {
+    os.Remove(r.path)
+  } else {
+    os.Remove(r.path + "." + strconv.Itoa(r.backups))
+    for i := r.backups - 1; i >= 1; i-- {
+      os.Rename(r.path+"."+strconv.Itoa(i), r.path+"."+strconv.Itoa(i+1))
+    }
+
+    if err := os.Rename(r.path, r.path+".1"); err != nil {
+      return err
+    }
+  }
+
+  return r.open()
+}
@@ -0,0 +1,597 @@
+package server
+
+import (
+  "bytes"
+  "context"
+  "crypto/ecdsa"
+  "crypto/elliptic"
+  "crypto/rand"
+  "crypto/sha256"
+  "crypto/tls"
+  "crypto/x509"
+  "crypto/x509/pkix"
+  "encoding/base64"
+  "encoding/json"
+  "encoding/pem"
+  "errors"
+  "fmt"
+  "io"
+  "log"
+  "math/big"
+  "net"
+  "net/http"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+const (
+  letsEncryptDirectory = "https://acme-v02.api.letsencrypt.org/directory"
+  acmeBucket           = "acme"
+  acmeRenewBefore      = 30 * 24 * time.Hour
+)
+
+var acmeHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// acmeManager gets a certificate for its domains from an ACME CA (RFC 8555,
+// Let's Encrypt by default) and renews it a month before it expires. It
+// answers http-01 challenges, so the CA must reach the domains on port 80.
+// The account key and certificate are kept in the store: without
+// -store.path every restart orders a new certificate and CA rate limits
+// soon apply.
+type acmeManager struct {
+  directory string
+  domains   []string
+  email     string
+  httpsPort string // where challenges redirects other requests, "" for 443
+  db        *store
+
+  mu     sync.RWMutex
+  cert   *tls.Certificate
+  tokens map[string]string // http-01 token -> key authorization
+
+  // ACME session, only used by the renewal goroutine.
+  key   *ecdsa.PrivateKey
+  kid   string // account URL
+  nonce string
+  dir   acmeDirectory
+}
+
+type acmeDirectory struct {
+  NewNonce   string `json:"newNonce"`
+  NewAccount string `json:"newAccount"`
+  NewOrder   string `json:"newOrder"`
+}
+
+// acmeAccount and acmeCert are what the store keeps, PEM encoded.
+type acmeAccount struct {
+  Key string `json:"key"`
+  URL string `json:"url"`
+}
+
+type acmeCert struct {
+  Chain string `json:"chain"`
+  Key   string `json:"key"`
+}
+
+type acmeProblem struct {
+  Type   string `json:"type"`
+  Detail string `json:"detail"`
+}
+
+func (p *acmeProblem) Error() string { return "acme: " + p.Detail + " (" + p.Type + ")" }
+
+type acmeOrder struct {
+  Status         string   `json:"status"`
+  Authorizations []string `json:"authorizations"`
+  Finalize       string   `json:"finalize"`
+  Certificate    string   `json:"certificate"`
+}
+
+type acmeAuthz struct {
+  Status     string `json:"status"`
+  Identifier struct {
+    Value string `json:"value"`
+  } `json:"identifier"`
+  Challenges []struct {
+    Type   string       `json:"type"`
+    URL    string       `json:"url"`
+    Token  string       `json:"token"`
+    Status string       `json:"status"`
+    Error  *acmeProblem `json:"error"`
+  } `json:"challenges"`
+}
+
+func newACMEManager(db *store, directory, email string, domains []string, addr string) (*acmeManager, error) {
+  m := &acmeManager{directory: directory, domains: domains, email: email, db: db, tokens: make(map[string]string)}
+  if _, port, err := net.SplitHostPort(addr); err == nil && port != "443" {
+    m.httpsPort = port
+  }
+
+  var acc acmeAccount
+  if _, err := db.get(acmeBucket, "account", &acc); err != nil {
+    return nil, err
+  }
+
+  if acc.Key != "" {
+    key, err := parseECKey(acc.Key)
+    if err != nil {
+      return nil, fmt.Errorf("acme: stored account key: %w", err)
+    }
+
+    m.key, m.kid = key, acc.URL
+  } else {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+      return nil, err
+    }
+
+    m.key = key
+  }
+
+  var stored acmeCert
+  if _, err := db.get(acmeBucket, m.certKey(), &stored); err != nil {
+    return nil, err
+  }
+
+  if stored.Chain != "" {
+    cert, err := tls.X509KeyPair([]byte(stored.Chain), []byte(stored.Key))
+    if err != nil {
+      return nil, fmt.Errorf("acme: stored certificate: %w", err)
+    }
+
+    m.cert = &cert
+  }
+
+  return m, nil
+}
+
+// certKey stores one certificate per set of domains, so changing
+// -acme.domain orders a new one.
+func (m *acmeManager) certKey() string {
+  return "cert:" + strings.Join(m.domains, ",")
+}
+
+func (m *acmeManager) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+  m.mu.RLock()
+  defer m.mu.RUnlock()
+
+  if m.cert == nil {
+    return nil, errors.New("acme: no certificate yet")
+  }
+
+  return m.cert, nil
+}
+
+// challenges answers http-01 challenges and redirects everything else to
+// HTTPS; it is served on -acme.http.
+func (m *acmeManager) challenges(w http.ResponseWriter, r *http.Request) {
+  if token, ok := strings.CutPrefix(r.URL.Path, "/.well-known/acme-challenge/"); ok {
+    m.mu.RLock()
+    auth, found := m.tokens[token]
+    m.mu.RUnlock()
+
+    if !found {
+      httpError(w, "no challenge "+token, http.StatusNotFound)
+      return
+    }
+
+    w.Header().Set("Content-Type", "text/plain")
+    w.Write([]byte(auth))
+    return
+  }
+
+  host := r.Host
+  if h, _, err := net.SplitHostPort(host); err == nil {
+    host = h
+  }
+
+  if m.httpsPort != "" {
+    host = net.JoinHostPort(host, m.httpsPort)
+  }
+
+  http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// run orders a certificate when there is none or it expires soon, and
+// checks again twice a day; failures are retried after an hour.
+func (m *acmeManager) run() {
+  for {
+    wait := 12 * time.Hour
+    if m.due() {
+      ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+      err := m.obtain(ctx)
+      cancel()
+
+      if err != nil {
+        log.Printf("acme: %s: %s", strings.Join(m.domains, ","), err)
+        wait = time.Hour
+      }
+    }
+
+    time.Sleep(wait)
+  }
+}
+
+func (m *acmeManager) due() bool {
+  m.mu.RLock()
+  defer m.mu.RUnlock()
+
+  return m.cert == nil || time.Until(m.cert.Leaf.NotAfter) < acmeRenewBefore
+}
+
+// obtain runs one order: account, authorizations, finalize, download.
+func (m *acmeManager) obtain(ctx context.Context) error {
+  begin := time.Now()
+  if err := m.getJSON(ctx, m.directory, &m.dir); err != nil {
+    return fmt.Errorf("directory: %w", err)
+  }
+
+  if err := m.register(ctx); err != nil {
+    return fmt.Errorf("account: %w", err)
+  }
+
+  ids := make([]map[string]string, 0, len(m.domains))
+  for _, d := range m.domains {
+    ids = append(ids, map[string]string{"type": "dns", "value": d})
+  }
+
+  var order acmeOrder
+  header, err := m.post(ctx, m.dir.NewOrder, map[string]interface{}{"identifiers": ids}, &order)
+  if err != nil {
+    return fmt.Errorf("new order: %w", err)
+  }
+
+  orderURL := header.Get("Location")
+  for _, u := range order.Authorizations {
+    if err := m.authorize(ctx, u); err != nil {
+      return err
+    }
+  }
+
+  key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  if err != nil {
+    return err
+  }
+
+  csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+    Subject:  pkix.Name{CommonName: m.domains[0]},
+    DNSNames: m.domains,
+  }, key)
+  if err != nil {
+    return err
+  }
+
+  if _, err := m.post(ctx, order.Finalize, map[string]string{"csr": b64(csr)}, &order); err != nil {
+    return fmt.Errorf("finalize: %w", err)
+  }
+
+  for order.Status != "valid" {
+    if order.Status == "invalid" {
+      return errors.New("order is invalid")
+    }
+
+    if err := m.poll(ctx, orderURL, &order); err != nil {
+      return fmt.Errorf("order: %w", err)
+    }
+  }
+
+  var chain []byte
+  if _, err := m.post(ctx, order.Certificate, nil, &chain); err != nil {
+    return fmt.Errorf("certificate: %w", err)
+  }
+
+  der, err := x509.MarshalECPrivateKey(key)
+  if err != nil {
+    return err
+  }
+
+  stored := acmeCert{Chain: string(chain), Key: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))}
+  cert, err := tls.X509KeyPair([]byte(stored.Chain), []byte(stored.Key))
+  if err != nil {
+    return fmt.Errorf("certificate: %w", err)
+  }
+
+  if err := m.db.put(acmeBucket, m.certKey(), stored); err != nil {
+    return err
+  }
+
+  m.mu.Lock()
+  m.cert = &cert
+  m.mu.Unlock()
+
+  log.Printf("acme: %s: certificate valid until %s, took: %s", strings.Join(m.domains, ","), cert.Leaf.NotAfter.Format(time.RFC3339), time.Since(begin).String())
+  return nil
+}
+
+// register creates the account on first use; the stored URL is reused
+// afterwards.
+func (m *acmeManager) register(ctx context.Context) error {
+  if m.kid != "" {
+    return nil
+  }
+
+  req := map[string]interface{}{"termsOfServiceAgreed": true}
+  if m.email != "" {
+    req["contact"] = []string{"mailto:" + m.email}
+  }
+
+  header, err := m.post(ctx, m.dir.NewAccount, req, nil)
+  if err != nil {
+    return err
+  }
+
+  kid := header.Get("Location")
+  if kid == "" {
+    return errors.New("no account URL in response")
+  }
+
+  der, err := x509.MarshalECPrivateKey(m.key)
+  if err != nil {
+    return err
+  }
+
+  m.kid = kid
+  return m.db.put(acmeBucket, "account", acmeAccount{Key: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})), URL: kid})
+}
+
+// authorize proves control of one domain with its http-01 challenge.
+func (m *acmeManager) authorize(ctx context.Context, u string) error {
+  var authz acmeAuthz
+  if _, err := m.post(ctx, u, nil, &authz); err != nil {
+    return fmt.Errorf("authorization: %w", err)
+  }
+
+  if authz.Status == "valid" {
+    return nil
+  }
+
+  domain := authz.Identifier.Value
+  for i, c := range authz.Challenges {
+    if c.Type != "http-01" {
+      continue
+    }
+
+    thumb, err := m.thumbprint()
+    if err != nil {
+      return err
+    }
+
+    m.mu.Lock()
+    m.tokens[c.Token] = c.Token + "." + thumb
+    m.mu.Unlock()
+
+    defer func() {
+      m.mu.Lock()
+      delete(m.tokens, c.Token)
+      m.mu.Unlock()
+    }()
+
+    if _, err := m.post(ctx, c.URL, struct{}{}, nil); err != nil {
+      return fmt.Errorf("%s: challenge: %w", domain, err)
+    }
+
+    for authz.Status != "valid" {
+      if authz.Status == "invalid" {
+        if p := authz.Challenges[i].Error; p != nil {
+          return fmt.Errorf("%s: %w", domain, p)
+        }
+
+        return fmt.Errorf("%s: authorization is invalid", domain)
+      }
+
+      if err := m.poll(ctx, u, &authz); err != nil {
+        return fmt.Errorf("%s: %w", domain, err)
+      }
+    }
+
+    return nil
+  }
+
+  return fmt.Errorf("%s: the CA offers no http-01 challenge", domain)
+}
+
+// poll gives the CA a moment, then re-reads u into v.
+func (m *acmeManager) poll(ctx context.Context, u string, v interface{}) error {
+  select {
+  case <-ctx.Done():
+    return ctx.Err()
+  case <-time.After(2 * time.Second):
+  }
+
+  _, err := m.post(ctx, u, nil, v)
+  return err
+}
+
+func (m *acmeManager) getJSON(ctx context.Context, u string, v interface{}) error {
+  req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+  if err != nil {
+    return err
+  }
+
+  req.Header.Set("User-Agent", upstream.UserAgent())
+  resp, err := acmeHTTPClient.Do(req)
+  if err != nil {
+    return err
+  }
+
+  defer resp.Body.Close()
+  if resp.StatusCode != http.StatusOK {
+    return fmt.Errorf("%s: %s", u, resp.Status)
+  }
+
+  return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// post sends a JWS-signed request; a nil payload is a POST-as-GET. The
+// answer is decoded into v, or stored raw when v is a *[]byte. A rejected
+// nonce is retried once with a fresh one.
+func (m *acmeManager) post(ctx context.Context, u string, payload, v interface{}) (http.Header, error) {
+  for attempt := 0; ; attempt++ {
+    resp, err := m.send(ctx, u, payload)
+    if err != nil {
+      return nil, err
+    }
+
+    body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+    resp.Body.Close()
+    if err != nil {
+      return nil, err
+    }
+
+    if resp.StatusCode >= 400 {
+      p := &acmeProblem{}
+      json.Unmarshal(body, p)
+      if p.Type == "urn:ietf:params:acme:error:badNonce" && attempt == 0 {
+        continue
+      }
+
+      if p.Detail == "" {
+        p.Detail = resp.Status
+      }
+
+      return nil, p
+    }
+
+    switch v := v.(type) {
+    case nil:
+    case *[]byte:
+      *v = body
+    default:
+      if err := json.Unmarshal(body, v); err != nil {
+        return nil, err
+      }
+    }
+
+    return resp.Header, nil
+  }
+}
+
+func (m *acmeManager) send(ctx context.Context, u string, payload interface{}) (*http.Response, error) {
+  if m.nonce == "" {
+    if err := m.newNonce(ctx); err != nil {
+      return nil, err
+    }
+  }
+
+  protected := map[string]interface{}{"alg": "ES256", "nonce": m.nonce, "url": u}
+  if m.kid != "" {
+    protected["kid"] = m.kid
+  } else {
+    protected["jwk"] = m.jwk()
+  }
+
+  m.nonce = ""
+  body, err := m.sign(protected, payload)
+  if err != nil {
+    return nil, err
+  }
+
+  req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+  if err != nil {
+    return nil, err
+  }
+
+  req.Header.Set("User-Agent", upstream.UserAgent())
+  req.Header.Set("Content-Type", "application/jose+json")
+  resp, err := acmeHTTPClient.Do(req)
+  if err != nil {
+    return nil, err
+  }
+
+  m.nonce = resp.Header.Get("Replay-Nonce")
+  return resp, nil
+}
+
+func (m *acmeManager) newNonce(ctx context.Context) error {
+  req, err := http.NewRequestWithContext(ctx, "HEAD", m.dir.NewNonce, nil)
+  if err != nil {
+    return err
+  }
+
+  req.Header.Set("User-Agent", upstream.UserAgent())
+  resp, err := acmeHTTPClient.Do(req)
+  if err != nil {
+    return err
+  }
+
+  resp.Body.Close()
+  if m.nonce = resp.Header.Get("Replay-Nonce"); m.nonce == "" {
+    return errors.New("no nonce from " + m.dir.NewNonce)
+  }
+
+  return nil
+}
+
+// sign builds the flattened JSON JWS of payload with the account key.
+func (m *acmeManager) sign(protected map[string]interface{}, payload interface{}) ([]byte, error) {
+  head, err := json.Marshal(protected)
+  if err != nil {
+    return nil, err
+  }
+
+  var body string
+  if payload != nil {
+    raw, err := json.Marshal(payload)
+    if err != nil {
+      return nil, err
+    }
+
+    body = b64(raw)
+  }
+
+  signed := b64(head) + "." + body
+  digest := sha256.Sum256([]byte(signed))
+  r, s, err := ecdsa.Sign(rand.Reader, m.key, digest[:])
+  if err != nil {
+    return nil, err
+  }
+
+  // ES256 signatures are r and s as fixed-size big-endian integers.
+  sig := make([]byte, 64)
+  r.FillBytes(sig[:32])
+  s.FillBytes(sig[32:])
+
+  return json.Marshal(map[string]string{"protected": b64(head), "payload": body, "signature": b64(sig)})
+}
+
+func (m *acmeManager) jwk() map[string]string {
+  return map[string]string{
+    "crv": "P-256",
+    "kty": "EC",
+    "x":   b64(padded(m.key.X)),
+    "y":   b64(padded(m.key.Y)),
+  }
+}
+
+// thumbprint is the RFC 7638 hash of the account key, part of every key
+// authorization.
+func (m *acmeManager) thumbprint() (string, error) {
+  // encoding/json sorts map keys, as the thumbprint requires.
+  raw, err := json.Marshal(m.jwk())
+  if err != nil {
+    return "", err
+  }
+
+  sum := sha256.Sum256(raw)
+  return b64(sum[:]), nil
+}
+
+func padded(n *big.Int) []byte {
+  return n.FillBytes(make([]byte, 32))
+}
+
+func b64(b []byte) string {
+  return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseECKey(s string) (*ecdsa.PrivateKey, error) {
+  block, _ := pem.Decode([]byte(s))
+  if block == nil {
+    return nil, errors.New("not PEM")
+  }
+
+  return x509.ParseECPrivateKey(block.Bytes)
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+  "crypto/subtle"
+  "encoding/json"
+  "fmt"
+  "log"
+  "net/http"
+  "strconv"
+  "strings"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+// adminOnly guards operator endpoints with the -admin.token bearer token.
+// Without a configured token the admin API is not served at all.
+func adminOnly(token string) func(http.HandlerFunc) http.HandlerFunc {
+  return func(h http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+      if token == "" {
+        httpError(w, fmt.Sprintf("no %s %s here", r.Method, r.URL.Path), http.StatusNotFound) // as if it didn't exist
+        return
+      }
+
+      got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+      if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+        w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+        httpError(w, "admin token required", http.StatusUnauthorized)
+        return
+      }
+
+      h(w, r)
+    }
+  }
+}
+
+// override changes a setting at runtime without a restart. Its ID is the
+// setting key, so there is at most one override per setting.
+type override struct {
+  resourceMeta
+  Key   string `json:"key"`
+  Value string `json:"value"`
+}
+
+func (o *override) validate() error {
+  name, _ := strings.CutPrefix(o.Key, "provider.")
+  if n, ok := strings.CutSuffix(name, ".api_key"); ok && n != "" {
+    switch {
+    case strings.TrimSpace(o.Value) == "":
+      return fmt.Errorf("%s can't be empty", o.Key)
+    case strings.HasPrefix(o.Value, redactedKey):
+      return fmt.Errorf("%s is the redacted key as listed; send the key itself", o.Key)
+    }
+
+    return nil
+  }
+
+  if name, ok := strings.CutSuffix(name, ".enabled"); !ok || name == "" {
+    return fmt.Errorf("unknown setting %q, want provider.<name>.enabled or provider.<name>.api_key", o.Key)
+  }
+
+  if o.Value != "true" && o.Value != "false" {
+    return fmt.Errorf("%s must be true or false", o.Key)
+  }
+
+  return nil
+}
+
+// redactedKey stands for a rotated API key in responses, followed by its
+// last 4 characters when it is long enough for them not to give it away.
+const redactedKey = "****"
+
+func (o *override) redacted() resource {
+  if !strings.HasSuffix(o.Key, ".api_key") {
+    return o
+  }
+
+  r := *o
+  r.Value = redactedKey
+  if len(o.Value) >= 12 {
+    r.Value += o.Value[len(o.Value)-4:]
+  }
+
+  return &r
+}
+
+func newOverrides(db *store, guard func(http.HandlerFunc) http.HandlerFunc) *collection {
+  return &collection{
+    db:      db,
+    name:    "overrides",
+    base:    "/v1/admin/overrides",
+    newItem: func() resource { return &override{} },
+    idOf:    func(r resource) string { return r.(*override).Key },
+    guard:   guard,
+  }
+}
+
+// setting returns the live override for key, if any.
+func (s *server) setting(key string) (string, bool) {
+  item, err := s.overrides.get(key)
+  if err != nil || item.meta().DeletedAt != nil {
+    return "", false
+  }
+
+  return item.(*override).Value, true
+}
+
+// activeProviders are the providers answers are made of, and
+// shadowProviders those only compared with them. Both drop providers
+// switched off by an override, and give the rest their rotated API key if
+// they have one.
+func (s *server) activeProviders() providers.Multi {
+  live, _ := s.providers.split()
+  return s.overridden(live)
+}
+
+func (s *server) shadowProviders() providers.Multi {
+  _, shadow := s.providers.split()
+  return s.overridden(shadow)
+}
+
+func (s *server) overridden(ps providers.Multi) providers.Multi {
+  active := make(providers.Multi, 0, len(ps))
+  for _, p := range ps {
+    if v, ok := s.setting("provider." + p.Name() + ".enabled"); ok && v == "false" {
+      continue
+    }
+
+    if k, ok := p.(providers.Keyed); ok {
+      if key, rotated := s.setting("provider." + p.Name() + ".api_key"); rotated {
+        p = k.WithAPIKey(key)
+      }
+    }
+
+    active = append(active, p)
+  }
+
+  return active
+}
+
+// adminProviders answers GET /v1/admin/providers with every configured
+// provider, whether it is enabled, the state of its key, its health over
+// the -health.window and its circuit, and for a shadow how its readings
+// compare with the answers.
+func (s *server) adminProviders(w http.ResponseWriter, r *http.Request) {
+  ps := s.providers.get()
+  list := make([]map[string]interface{}, 0, len(ps))
+  for _, p := range ps {
+    list = append(list, s.adminProvider(p))
+  }
+
+  writeJSON(w, http.StatusOK, map[string]interface{}{"providers": list})
+}
+
+func (s *server) adminProvider(p providers.Provider) map[string]interface{} {
+  v, overridden := s.setting("provider." + p.Name() + ".enabled")
+  item := map[string]interface{}{
+    "name":    p.Name(),
+    "enabled": !overridden || v != "false",
+    "health":  s.health.report(p.Name()),
+  }
+
+  if s.providers.isShadow(p.Name()) {
+    item["shadow"] = s.shadows.report(p.Name())
+  }
+
+  if _, ok := p.(providers.Keyed); ok {
+    key := map[string]interface{}{"source": "flag"}
+    if o, err := s.overrides.get("provider." + p.Name() + ".api_key"); err == nil && o.meta().DeletedAt == nil {
+      key["source"], key["rotated_at"] = "rotated", o.meta().Updated
+    }
+
+    item["api_key"] = key
+  }
+
+  return item
+}
+
+// adminProviderAction serves POST /v1/admin/providers/{name}/{action}:
+// enable, disable, reset (closes its circuit) and key, which rotates its
+// API key to the body's {"api_key": "..."} and resets the circuit, whose
+// failures were likely the old key being rejected.
+func (s *server) adminProviderAction(w http.ResponseWriter, r *http.Request) {
+  name := r.PathValue("name")
+  var p providers.Provider
+  for _, c := range s.providers.get() {
+    if c.Name() == name {
+      p = c
+    }
+  }
+
+  if p == nil {
+    httpError(w, "provider "+name+" not found", http.StatusNotFound)
+    return
+  }
+
+  var err error
+  switch action := r.PathValue("action"); action {
+  case "enable", "disable":
+    err = s.setOverride("provider."+name+".enabled", strconv.FormatBool(action == "enable"))
+  case "reset":
+    s.health.reset(name)
+  case "key":
+    if _, ok := p.(providers.Keyed); !ok {
+      httpError(w, "provider "+name+" has no API key", http.StatusUnprocessableEntity)
+      return
+    }
+
+    var req struct {
+      APIKey string `json:"api_key"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.APIKey) == "" {
+      httpError(w, `want {"api_key": "<new key>"}`, bodyStatus(err))
+      return
+    }
+
+    if err = s.setOverride("provider."+name+".api_key", strings.TrimSpace(req.APIKey)); err == nil {
+      s.health.reset(name)
+      log.Printf("providers: %s: API key rotated", name)
+    }
+  default:
+    httpError(w, fmt.Sprintf("unknown action %q, want enable, disable, reset or key", action), http.StatusNotFound)
+    return
+  }
+
+  if err != nil {
+    writeError(w, err, http.StatusInternalServerError)
+    return
+  }
+
+  writeJSON(w, http.StatusOK, s.adminProvider(p))
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+  "context"
+  "errors"
+  "net/http"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+var errNoSoil = errors.New("no enabled provider has soil data for this place")
+
+// agro answers GET /v1/agro/{location} (or ?lat=&lon=) with soil
+// temperature and moisture by depth and the day's reference
+// evapotranspiration, for irrigation and planting. Providers measure at
+// depths of their own; where two share one its value is their mean.
+func (s *server) agro(w http.ResponseWriter, r *http.Request) {
+  begin := time.Now()
+  ctx := upstream.WithTrace(r)
+
+  d, ok := s.requestDisplay(w, r)
+  if !ok {
+    return
+  }
+
+  loc, ok := s.placeFor(ctx, w, r, pointLocation)
+  if !ok {
+    return
+  }
+
+  replies := askEach(ctx, s, loc, providers.ErrNoSoil, func(p providers.Provider) (func(context.Context, geo.Location) (providers.Soil, error), bool) {
+    m, ok := p.(providers.SoilReporter)
+    if !ok {
+      return nil, false
+    }
+
+    return m.Soil, true
+  })
+
+  resp := &AgroResponse{SchemaVersion: responseVersion, City: loc.Name, Region: loc.Region, Country: loc.Country, Lat: loc.Lat, Lon: loc.Lon, TimeZone: loc.TimeZone, Units: d.units}
+  var soils []providers.Soil
+  for _, a := range replies {
+    src := AgroSource{Provider: a.p.Name(), Omitted: a.omitted, Error: a.err, Took: a.took}
+    if a.ok {
+      src.Depths = len(a.v.Temps) + len(a.v.Moisture)
+      src.Evapotranspiration = d.value(a.v.Evapotranspiration)
+      soils = append(soils, a.v)
+      resp.ProviderCount++
+      resp.Attribution = append(resp.Attribution, providers.Attributions(providers.Multi{a.p})...)
+    }
+
+    resp.Providers = append(resp.Providers, src)
+  }
+
+  if resp.ProviderCount == 0 {
+    httpError(w, errNoSoil.Error()+replyFailures(replies), repliesStatus(replies))
+    return
+  }
+
+  resp.SoilTemp = soilLevels(soils, func(s providers.Soil) []providers.SoilDepth { return s.Temps }, d.temp)
+  resp.SoilMoisture = soilLevels(soils, func(s providers.Soil) []providers.SoilDepth { return s.Moisture }, func(v *float64) *float64 {
+    percent := *v * 100
+    return d.value(&percent)
+  })
+
+  resp.Evapotranspiration = d.value(meanOf(soils, func(s providers.Soil) *float64 { return s.Evapotranspiration }))
+  resp.Took = time.Since(begin).String()
+  writeJSON(w, http.StatusOK, resp)
+}
+
+// soilLevels is the providers' values by depth, shallowest first, each
+// the mean of those measuring there, shown by show.
+func soilLevels(soils []providers.Soil, v func(providers.Soil) []providers.SoilDepth, show func(*float64) *float64) []SoilLevel {
+  type sum struct {
+    v float64
+    n int
+  }
+
+  byDepth := make(map[string]*sum)
+  for _, s := range soils {
+    for _, sd := range v(s) {
+      if byDepth[sd.Depth] == nil {
+        byDepth[sd.Depth] = &sum{}
+      }
+
+      byDepth[sd.Depth].v += sd.Value
+      byDepth[sd.Depth].n++
+    }
+  }
+
+  levels := make([]SoilLevel, 0, len(byDepth))
+  for depth, s := range byDepth {
+    mean := s.v / float64(s.n)
+    levels = append(levels, SoilLevel{Depth: depth, Value: show(&mean), ProviderCount: s.n})
+  }
+
+  sort.Slice(levels, func(i, j int) bool {
+    ti, bi := depthRange(levels[i].Depth)
+    tj, bj := depthRange(levels[j].Depth)
+    if ti != tj {
+      return ti < tj
+    }
+
+    return bi < bj
+  })
+
+  return levels
+}
+
+// depthRange is the top and bottom of "9-27", both 6 for "6".
+func depthRange(depth string) (top, bottom float64) {
+  t, b, ok := strings.Cut(depth, "-")
+  top, _ = strconv.ParseFloat(t, 64)
+  if !ok {
+    return top, top
+  }
+
+  bottom, _ = strconv.ParseFloat(b, 64)
+  return top, bottom
+}
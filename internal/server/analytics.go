@@ -0,0 +1,314 @@
+package server
+
+import (
+  "context"
+  "log"
+  "net/http"
+  "sort"
+  "strconv"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+)
+
+const (
+  analyticsBucket = "analytics"
+
+  // Fixed width, so keys sort chronologically.
+  analyticsHourFormat = "2006-01-02T15Z"
+
+  // analyticsCities caps the distinct places counted per hour; the rest
+  // only add to the hour's totals.
+  analyticsCities = 1000
+
+  anonymousClient = "anonymous"
+)
+
+// analyticsHour is one hour of answered lookups, as stored.
+type analyticsHour struct {
+  Hour      time.Time                 `json:"hour"`
+  Requests  int                       `json:"requests"`
+  CacheHits int                       `json:"cache_hits"`
+  Clients   map[string]int            `json:"clients"`
+  Cities    map[string]*analyticsCity `json:"cities"`
+}
+
+type analyticsCity struct {
+  geo.Location
+  Requests int            `json:"requests"`
+  Clients  map[string]int `json:"clients"` // by config client name
+}
+
+func newAnalyticsHour(hour time.Time) *analyticsHour {
+  return &analyticsHour{Hour: hour, Clients: make(map[string]int), Cities: make(map[string]*analyticsCity)}
+}
+
+// analytics counts lookups by place, client and whether the cache answered,
+// per hour. Unlike popularity's sketches the counts are exact and kept in
+// the "analytics" bucket of the store, so they survive restarts and cover
+// weeks: the current hour is written back every flush interval, and hours
+// older than retention are dropped.
+type analytics struct {
+  db        *store
+  retention time.Duration
+
+  mu    sync.Mutex
+  cur   *analyticsHour
+  dirty bool
+}
+
+func newAnalytics(db *store, retention, flush time.Duration) *analytics {
+  a := &analytics{db: db, retention: retention}
+  go a.run(flush)
+  return a
+}
+
+func (a *analytics) run(flush time.Duration) {
+  pruned := time.Now()
+  for range time.Tick(flush) {
+    a.mu.Lock()
+    a.save()
+    a.mu.Unlock()
+
+    if a.retention > 0 && time.Since(pruned) >= time.Hour {
+      a.prune()
+      pruned = time.Now()
+    }
+  }
+}
+
+// record counts one lookup for loc by ctx's client.
+// flush writes the current hour now, for shutdown.
+func (a *analytics) flush() {
+  a.mu.Lock()
+  defer a.mu.Unlock()
+  a.save()
+}
+
+func (a *analytics) record(ctx context.Context, loc geo.Location, cached bool) {
+  client := anonymousClient
+  if c, ok := clientFrom(ctx); ok {
+    client = c.Name
+  }
+
+  hour := time.Now().UTC().Truncate(time.Hour)
+
+  a.mu.Lock()
+  defer a.mu.Unlock()
+
+  if a.cur == nil || !a.cur.Hour.Equal(hour) {
+    a.save()
+    a.cur = a.load(hour)
+  }
+
+  h := a.cur
+  h.Requests++
+  h.Clients[client]++
+  if cached {
+    h.CacheHits++
+  }
+
+  c, ok := h.Cities[loc.Key()]
+  if !ok && len(h.Cities) < analyticsCities {
+    c = &analyticsCity{Location: loc, Clients: make(map[string]int)}
+    h.Cities[loc.Key()] = c
+  }
+
+  if c != nil {
+    c.Requests++
+    c.Clients[client]++
+  }
+
+  a.dirty = true
+}
+
+// load picks up an hour counted before a restart.
+func (a *analytics) load(hour time.Time) *analyticsHour {
+  h := newAnalyticsHour(hour)
+  if _, err := a.db.get(analyticsBucket, hour.Format(analyticsHourFormat), h); err != nil {
+    log.Printf("analytics: %s: %s", hour.Format(analyticsHourFormat), err)
+    return newAnalyticsHour(hour)
+  }
+
+  return h
+}
+
+// save writes the current hour if it changed; the caller holds mu.
+func (a *analytics) save() {
+  if a.cur == nil || !a.dirty {
+    return
+  }
+
+  if err := a.db.put(analyticsBucket, a.cur.Hour.Format(analyticsHourFormat), a.cur); err != nil {
+    log.Printf("analytics: %s", err)
+    return
+  }
+
+  a.dirty = false
+}
+
+// prune drops hours older than retention.
+func (a *analytics) prune() {
+  cutoff := time.Now().Add(-a.retention).UTC().Format(analyticsHourFormat)
+  for _, k := range a.db.keys(analyticsBucket, "") {
+    if k >= cutoff {
+      break
+    }
+
+    if err := a.db.delete(analyticsBucket, k); err != nil {
+      log.Printf("analytics: prune: %s", err)
+      return
+    }
+  }
+}
+
+// hours returns the hours from since on, oldest first, the current one as
+// counted so far.
+func (a *analytics) hours(since time.Time) []*analyticsHour {
+  a.mu.Lock()
+  a.save()
+  a.mu.Unlock()
+
+  from := since.UTC().Truncate(time.Hour).Format(analyticsHourFormat)
+
+  var hs []*analyticsHour
+  for _, k := range a.db.keys(analyticsBucket, "") {
+    if k < from {
+      continue
+    }
+
+    h := newAnalyticsHour(time.Time{})
+    if _, err := a.db.get(analyticsBucket, k, h); err != nil {
+      log.Printf("analytics: %s: %s", k, err)
+      continue
+    }
+
+    hs = append(hs, h)
+  }
+
+  return hs
+}
+
+type analyticsReport struct {
+  Window       string            `json:"window"`
+  Requests     int               `json:"requests"`
+  CacheHits    int               `json:"cache_hits"`
+  CacheHitRate float64           `json:"cache_hit_rate"`
+  Cities       []analyticsCity   `json:"top_cities"`
+  Clients      []clientRequests  `json:"clients"`
+  Volume       []analyticsVolume `json:"volume"` // oldest first
+}
+
+type clientRequests struct {
+  Client   string `json:"client"`
+  Requests int    `json:"requests"`
+}
+
+type analyticsVolume struct {
+  From         time.Time `json:"from"`
+  Requests     int       `json:"requests"`
+  CacheHits    int       `json:"cache_hits"`
+  CacheHitRate float64   `json:"cache_hit_rate"`
+}
+
+func hitRate(hits, requests int) float64 {
+  if requests == 0 {
+    return 0
+  }
+
+  return float64(hits) / float64(requests)
+}
+
+// report sums the hours of the trailing window: the n most requested
+// places, every client, and the volume per step.
+func (a *analytics) report(window, step time.Duration, n int) analyticsReport {
+  r := analyticsReport{Window: window.String(), Cities: []analyticsCity{}, Clients: []clientRequests{}, Volume: []analyticsVolume{}}
+  cities := make(map[string]*analyticsCity)
+  clients := make(map[string]int)
+
+  for _, h := range a.hours(time.Now().Add(-window)) {
+    r.Requests += h.Requests
+    r.CacheHits += h.CacheHits
+    for name, count := range h.Clients {
+      clients[name] += count
+    }
+
+    for key, c := range h.Cities {
+      sum, ok := cities[key]
+      if !ok {
+        sum = &analyticsCity{Location: c.Location, Clients: make(map[string]int)}
+        cities[key] = sum
+      }
+
+      sum.Requests += c.Requests
+      for name, count := range c.Clients {
+        sum.Clients[name] += count
+      }
+    }
+
+    from := h.Hour.Truncate(step)
+    if len(r.Volume) == 0 || !r.Volume[len(r.Volume)-1].From.Equal(from) {
+      r.Volume = append(r.Volume, analyticsVolume{From: from})
+    }
+
+    v := &r.Volume[len(r.Volume)-1]
+    v.Requests += h.Requests
+    v.CacheHits += h.CacheHits
+  }
+
+  r.CacheHitRate = hitRate(r.CacheHits, r.Requests)
+  for i := range r.Volume {
+    r.Volume[i].CacheHitRate = hitRate(r.Volume[i].CacheHits, r.Volume[i].Requests)
+  }
+
+  for _, c := range cities {
+    r.Cities = append(r.Cities, *c)
+  }
+
+  sort.Slice(r.Cities, func(i, j int) bool { return r.Cities[i].Requests > r.Cities[j].Requests })
+  if len(r.Cities) > n {
+    r.Cities = r.Cities[:n]
+  }
+
+  for name, count := range clients {
+    r.Clients = append(r.Clients, clientRequests{Client: name, Requests: count})
+  }
+
+  sort.Slice(r.Clients, func(i, j int) bool { return r.Clients[i].Requests > r.Clients[j].Requests })
+  return r
+}
+
+// adminAnalytics serves GET /v1/admin/analytics?window=168h&step=24h&n=10.
+func (s *server) adminAnalytics(w http.ResponseWriter, r *http.Request) {
+  q := r.URL.Query()
+
+  window := 24 * time.Hour
+  if v := q.Get("window"); v != "" {
+    var err error
+    if window, err = time.ParseDuration(v); err != nil || window <= 0 || (s.analytics.retention > 0 && window > s.analytics.retention) {
+      httpError(w, "window must be a duration up to the retention, "+s.analytics.retention.String(), http.StatusBadRequest)
+      return
+    }
+  }
+
+  step := time.Hour
+  if v := q.Get("step"); v != "" {
+    var err error
+    if step, err = time.ParseDuration(v); err != nil || step < time.Hour || step%time.Hour != 0 {
+      httpError(w, "step must be a whole number of hours, e.g. 1h or 24h", http.StatusBadRequest)
+      return
+    }
+  }
+
+  n := 10
+  if v := q.Get("n"); v != "" {
+    var err error
+    if n, err = strconv.Atoi(v); err != nil || n < 1 || n > analyticsCities {
+      httpError(w, "n must be 1 to "+strconv.Itoa(analyticsCities), http.StatusBadRequest)
+      return
+    }
+  }
+
+  writeJSON(w, http.StatusOK, s.analytics.report(window, step, n))
+}
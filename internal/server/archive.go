@@ -0,0 +1,255 @@
+package server
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "math"
+  "net/http"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/aggregate"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+var errNoHistorians = errors.New("no enabled provider has a weather archive")
+
+// The archives that go furthest back start here.
+var archiveStart = time.Date(1940, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// archived is one place's past day as the provider archives told it.
+type archived struct {
+  readings []historyReading // hourly, exact values
+  sources  []archiveSource
+  credit   []upstream.Attribution
+}
+
+type archiveSource struct {
+  Provider string `json:"provider"`
+  Hours    int    `json:"hours"`
+  Error    string `json:"error,omitempty"`
+  Took     string `json:"took"`
+}
+
+// archiveCache keeps backfilled days for ttl; past days hardly change, but
+// archives do correct their latest ones. The oldest day is dropped once
+// there are max of them.
+type archiveCache struct {
+  ttl time.Duration
+  max int
+
+  mu   sync.Mutex
+  days map[string]archiveEntry
+}
+
+type archiveEntry struct {
+  day    *archived
+  stored time.Time
+}
+
+// At most this many backfilled days are cached.
+const archiveMaxDays = 1000
+
+func newArchiveCache(ttl time.Duration, max int) *archiveCache {
+  return &archiveCache{ttl: ttl, max: max, days: make(map[string]archiveEntry)}
+}
+
+func (c *archiveCache) get(key string) (*archived, bool) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  e, ok := c.days[key]
+  if !ok || time.Since(e.stored) > c.ttl {
+    return nil, false
+  }
+
+  return e.day, true
+}
+
+func (c *archiveCache) put(key string, day *archived) {
+  if c.ttl <= 0 {
+    return
+  }
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  if _, ok := c.days[key]; !ok && len(c.days) >= c.max {
+    oldest := ""
+    for k, e := range c.days {
+      if oldest == "" || e.stored.Before(c.days[oldest].stored) {
+        oldest = k
+      }
+    }
+
+    delete(c.days, oldest)
+  }
+
+  c.days[key] = archiveEntry{day: day, stored: time.Now()}
+}
+
+// backfill answers GET /v1/history/{city}?date=2023-07-14 from the archives
+// of the providers that keep one: the hourly aggregate of that UTC day,
+// with every provider's value, and the day's minimum, maximum and mean.
+func (s *server) backfill(w http.ResponseWriter, r *http.Request, loc geo.Location, date, format string) {
+  day, err := time.Parse(time.DateOnly, date)
+  if err != nil {
+    httpError(w, fmt.Sprintf("date wants YYYY-MM-DD, got %q", date), http.StatusBadRequest)
+    return
+  }
+
+  if !day.Before(time.Now().UTC().Truncate(24 * time.Hour)) {
+    httpError(w, "date must be before today; /v1/weather has the current reading", http.StatusBadRequest)
+    return
+  }
+
+  if day.Before(archiveStart) {
+    httpError(w, "archives start on "+archiveStart.Format(time.DateOnly), http.StatusBadRequest)
+    return
+  }
+
+  key := loc.Key() + "/" + date
+  a, cached := s.archive.get(key)
+  if !cached {
+    if a, err = s.archived(upstream.WithTrace(r), loc, day); err != nil {
+      writeError(w, err, http.StatusBadGateway)
+      return
+    }
+
+    complete := true
+    for _, src := range a.sources {
+      complete = complete && src.Error == ""
+    }
+
+    // Retried on the next request rather than cached without a provider.
+    if complete {
+      s.archive.put(key, a)
+    }
+  }
+
+  rs := s.policies.history(a.readings, time.Now())
+  resp := map[string]interface{}{
+    "city":      loc.Name,
+    "lat":       loc.Lat,
+    "lon":       loc.Lon,
+    "date":      date,
+    "summary":   summarizeDay(rs),
+    "providers": a.sources,
+  }
+
+  if cached {
+    resp["cached"] = true
+  }
+
+  if len(a.credit) > 0 {
+    resp["attribution"] = a.credit
+  }
+
+  writeHistory(w, format, loc, rs, resp)
+}
+
+// archived asks every historian among the available providers for day at
+// loc and averages them hour by hour, with the usual weights.
+func (s *server) archived(ctx context.Context, loc geo.Location, day time.Time) (*archived, error) {
+  active := s.activeFor(ctx, loc)
+
+  var hs providers.Multi
+  for _, p := range active {
+    if _, ok := p.(providers.Historian); ok {
+      hs = append(hs, p)
+    }
+  }
+
+  if len(hs) == 0 {
+    return nil, errNoHistorians
+  }
+
+  points := make([][]providers.ForecastPoint, len(hs))
+  outcomes := make([]providers.Reading, len(hs))
+
+  var wg sync.WaitGroup
+  for i, p := range hs {
+    s.quotasFor(ctx).spend(p.Name())
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+
+      begin := time.Now()
+      ps, err := p.(providers.Historian).History(ctx, loc, day)
+      outcomes[i] = providers.Reading{Provider: p.Name(), Took: time.Since(begin)}
+      if err != nil {
+        outcomes[i].Error, outcomes[i].Err = err.Error(), err
+      }
+
+      points[i] = ps
+    }()
+  }
+
+  wg.Wait()
+  s.healthFor(ctx).record(outcomes)
+
+  a := &archived{}
+  hours := make(map[time.Time][]providers.Reading)
+  var failures []string
+  for i, o := range outcomes {
+    a.sources = append(a.sources, archiveSource{Provider: o.Provider, Hours: len(points[i]), Error: o.Error, Took: o.Took.String()})
+    if o.Error != "" {
+      failures = append(failures, o.Provider+": "+o.Error)
+      continue
+    }
+
+    a.credit = append(a.credit, providers.Attributions(hs[i:i+1])...)
+    for _, pt := range points[i] {
+      h := pt.Valid.Truncate(time.Hour)
+      hours[h] = append(hours[h], providers.Reading{Provider: o.Provider, Kelvin: pt.Kelvin})
+    }
+  }
+
+  if len(hours) == 0 {
+    return nil, errors.New(strings.Join(failures, "; "))
+  }
+
+  for h, rs := range hours {
+    s.weights.AssignIn(s.learner.region(loc), rs)
+    kelvin, err := aggregate.Average(rs)
+    if err != nil {
+      continue
+    }
+
+    reading := historyReading{Time: h, Kelvin: kelvin, Providers: make(map[string]float64, len(rs))}
+    for _, r := range rs {
+      reading.Providers[r.Provider] = r.Kelvin
+    }
+
+    a.readings = append(a.readings, reading)
+  }
+
+  sort.Slice(a.readings, func(i, j int) bool { return a.readings[i].Time.Before(a.readings[j].Time) })
+  return a, nil
+}
+
+type daySummary struct {
+  Min  float64 `json:"min"`
+  Max  float64 `json:"max"`
+  Mean float64 `json:"mean"`
+}
+
+func summarizeDay(rs []historyReading) daySummary {
+  if len(rs) == 0 {
+    return daySummary{}
+  }
+
+  sum := daySummary{Min: math.Inf(1), Max: math.Inf(-1)}
+  for _, r := range rs {
+    sum.Min, sum.Max = math.Min(sum.Min, r.Kelvin), math.Max(sum.Max, r.Kelvin)
+    sum.Mean += r.Kelvin
+  }
+
+  sum.Mean /= float64(len(rs))
+  return sum
+}
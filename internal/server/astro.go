@@ -0,0 +1,79 @@
+package server
+
+import (
+  "errors"
+  "fmt"
+  "math"
+  "net/http"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/astro"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// astroDays is how far from today ?date= may be.
+const astroDays = 366
+
+// astronomy answers GET /v1/astro/{city} (or ?lat=&lon=) with the sun's
+// and the moon's day on ?date= (default today, UTC). It is computed from
+// the coordinates, so it costs no provider calls and works offline.
+func (s *server) astronomy(w http.ResponseWriter, r *http.Request) {
+  date := time.Now().UTC()
+  if d := r.URL.Query().Get("date"); d != "" {
+    var err error
+    if date, err = time.Parse(time.DateOnly, d); err != nil {
+      httpError(w, fmt.Sprintf("date wants YYYY-MM-DD, got %q", d), http.StatusBadRequest)
+      return
+    }
+
+    if math.Abs(time.Since(date).Hours()) > astroDays*24 {
+      httpError(w, fmt.Sprintf("date must be within %d days of today", astroDays), http.StatusBadRequest)
+      return
+    }
+  }
+
+  loc, err := requestLocation(upstream.WithTrace(r), r, s.geo)
+  var amb *geo.AmbiguousError
+  if errors.As(err, &amb) {
+    writeCandidates(w, amb)
+    return
+  }
+
+  if err != nil {
+    writeError(w, err, locationStatus(err))
+    return
+  }
+
+  loc = s.zones.Locate(upstream.WithTrace(r), loc)
+  sun := astro.SunOn(date, loc.Lat, loc.Lon)
+  moon := astro.MoonAt(sun.Noon)
+  resp := &AstroResponse{
+    SchemaVersion: responseVersion,
+    City:          loc.Name,
+    Region:        loc.Region,
+    Country:       loc.Country,
+    Lat:           loc.Lat,
+    Lon:           loc.Lon,
+    TimeZone:      loc.TimeZone,
+    Date:          date.Format(time.DateOnly),
+    SolarNoon:     sun.Noon,
+    DayLength:     sun.Day.String(),
+    Polar:         sun.Polar,
+    Moon: MoonInfo{
+      Phase:        moon.Phase,
+      Age:          math.Round(moon.Age*10) / 10,
+      Illumination: math.Round(moon.Illumination*100) / 100,
+    },
+  }
+
+  if sun.Polar == "" {
+    resp.Sunrise, resp.Sunset = &sun.Rise, &sun.Set
+  }
+
+  if a, ok := geo.Attribution(s.geo, loc); ok {
+    resp.Attribution = append(resp.Attribution, a)
+  }
+
+  writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+  "context"
+  "crypto/sha256"
+  "net/http"
+  "strings"
+  "sync"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+)
+
+var authFailures = metrics.NewCounter("http_auth_failures_total", "Requests rejected for a missing or unknown API key.")
+
+type clientCtxKey struct{}
+
+// clientFrom returns the authenticated client of a request, if any.
+func clientFrom(ctx context.Context) (clientConfig, bool) {
+  c, ok := ctx.Value(clientCtxKey{}).(clientConfig)
+  return c, ok
+}
+
+// clientAuth requires an API key from the config on every API request, in
+// the X-API-Key header or the api_key query parameter. Keys are looked up
+// by hash so comparison time doesn't depend on how much of a guess matches.
+type clientAuth struct {
+  enabled bool
+
+  mu      sync.RWMutex
+  clients map[[sha256.Size]byte]clientConfig
+}
+
+func newClientAuth(enabled bool, clients []clientConfig) *clientAuth {
+  a := &clientAuth{enabled: enabled}
+  a.set(clients)
+  return a
+}
+
+// set replaces the clients, for a reloaded config file.
+func (a *clientAuth) set(clients []clientConfig) {
+  byKey := make(map[[sha256.Size]byte]clientConfig, len(clients))
+  for _, c := range clients {
+    byKey[sha256.Sum256([]byte(c.Key))] = c
+  }
+
+  a.mu.Lock()
+  defer a.mu.Unlock()
+  a.clients = byKey
+}
+
+// exempt paths have their own protection or none is wanted: the admin API
+// has its token, metrics and status are for monitoring, icons are loaded
+// by browsers that have no key to send, and the spec and the signing key
+// are read before one is issued.
+func authExempt(path string) bool {
+  return path == "/" || path == "/metrics" || path == "/status" || path == "/openapi.json" || path == "/v1/signing-key" || strings.HasPrefix(path, "/v1/admin/") || strings.HasPrefix(path, "/icons/")
+}
+
+func (a *clientAuth) authenticate(h http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    // Known keys identify the client even where none is required, so its
+    // rate limit and preferences apply.
+    c, ok := a.identify(r)
+    if ok {
+      r = r.WithContext(context.WithValue(r.Context(), clientCtxKey{}, c))
+    }
+
+    if ok || !a.enabled || authExempt(r.URL.Path) {
+      h.ServeHTTP(w, r)
+      return
+    }
+
+    authFailures.Inc()
+    w.Header().Set("WWW-Authenticate", `APIKey realm="weather", header="X-API-Key"`)
+    httpError(w, "API key required in X-API-Key or ?api_key=", http.StatusUnauthorized)
+  })
+}
+
+// named finds a client by its name, for work done on its behalf outside a
+// request.
+func (a *clientAuth) named(name string) (clientConfig, bool) {
+  a.mu.RLock()
+  defer a.mu.RUnlock()
+
+  for _, c := range a.clients {
+    if c.Name == name {
+      return c, true
+    }
+  }
+
+  return clientConfig{}, false
+}
+
+func (a *clientAuth) identify(r *http.Request) (clientConfig, bool) {
+  key := r.Header.Get("X-API-Key")
+  if key == "" {
+    key = r.URL.Query().Get("api_key")
+  }
+
+  if key == "" {
+    return clientConfig{}, false
+  }
+
+  a.mu.RLock()
+  defer a.mu.RUnlock()
+
+  c, ok := a.clients[sha256.Sum256([]byte(key))]
+  return c, ok
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+  "archive/tar"
+  "bytes"
+  "compress/gzip"
+  "encoding/json"
+  "errors"
+  "flag"
+  "fmt"
+  "io"
+  "os"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// backupManifest describes an archive written by `weather-go backup`.
+type backupManifest struct {
+  Format  int            `json:"format"`
+  Version string         `json:"version"`
+  Created time.Time      `json:"created"`
+  Buckets map[string]int `json:"buckets"` // keys per bucket
+}
+
+const backupFormat = 1
+
+// backup snapshots the store at storePath into a tar.gz archive holding a
+// manifest and the compacted store log. It only reads the store, so it can
+// run next to a live server.
+func backup(storePath string, args []string) error {
+  if storePath == "" || len(args) != 1 {
+    return errors.New("usage: weather-go -store.path=<store> backup <archive.tar.gz>")
+  }
+
+  s, err := loadStore(storePath)
+  if err != nil {
+    return err
+  }
+
+  var data bytes.Buffer
+  if err := s.snapshot(&data); err != nil {
+    return err
+  }
+
+  m := backupManifest{Format: backupFormat, Version: upstream.Version, Created: time.Now().UTC(), Buckets: map[string]int{}}
+  for _, b := range s.bucketNames() {
+    m.Buckets[b] = len(s.keys(b, ""))
+  }
+
+  manifest, err := json.MarshalIndent(m, "", "  ")
+  if err != nil {
+    return err
+  }
+
+  f, err := os.OpenFile(args[0], os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+  if err != nil {
+    return err
+  }
+
+  zw := gzip.NewWriter(f)
+  tw := tar.NewWriter(zw)
+
+  for _, entry := range []struct {
+    name string
+    body []byte
+  }{
+    {"manifest.json", manifest},
+    {"store.jsonl", data.Bytes()},
+  } {
+    hdr := &tar.Header{Name: entry.name, Mode: 0o600, Size: int64(len(entry.body)), ModTime: m.Created}
+    if err := tw.WriteHeader(hdr); err != nil {
+      return err
+    }
+
+    if _, err := tw.Write(entry.body); err != nil {
+      return err
+    }
+  }
+
+  for _, c := range []io.Closer{tw, zw, f} {
+    if err := c.Close(); err != nil {
+      return err
+    }
+  }
+
+  fmt.Printf("backup %s: %v\n", args[0], m.Buckets)
+  return nil
+}
+
+// restore replaces the store at storePath with the contents of an archive.
+// The server must be stopped; an existing store is only overwritten with
+// -force.
+func restore(storePath string, args []string) error {
+  fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+  force := fs.Bool("force", false, "overwrite an existing store")
+  if err := fs.Parse(args); err != nil {
+    return err
+  }
+
+  if storePath == "" || fs.NArg() != 1 {
+    return errors.New("usage: weather-go -store.path=<store> restore [-force] <archive.tar.gz>")
+  }
+
+  if _, err := os.Stat(storePath); err == nil && !*force {
+    return fmt.Errorf("%s exists, pass -force to overwrite it", storePath)
+  }
+
+  m, data, err := readBackup(fs.Arg(0))
+  if err != nil {
+    return err
+  }
+
+  // Replay before touching the live path so a bad archive changes nothing.
+  check := &store{path: fs.Arg(0), buckets: make(map[string]map[string]json.RawMessage)}
+  if err := check.replay(bytes.NewReader(data)); err != nil {
+    return err
+  }
+
+  for b, n := range m.Buckets {
+    if got := len(check.keys(b, "")); got != n {
+      return fmt.Errorf("archive is inconsistent: bucket %s has %d keys, manifest says %d", b, got, n)
+    }
+  }
+
+  tmp := storePath + ".restore"
+  if err := os.WriteFile(tmp, data, 0o600); err != nil {
+    return err
+  }
+
+  if err := os.Rename(tmp, storePath); err != nil {
+    return err
+  }
+
+  fmt.Printf("restored %s from %s (created %s by %s): %v\n", storePath, fs.Arg(0), m.Created.Format(time.RFC3339), m.Version, m.Buckets)
+  return nil
+}
+
+func readBackup(path string) (backupManifest, []byte, error) {
+  var m backupManifest
+
+  f, err := os.Open(path)
+  if err != nil {
+    return m, nil, err
+  }
+
+  defer f.Close()
+
+  zr, err := gzip.NewReader(f)
+  if err != nil {
+    return m, nil, err
+  }
+
+  var manifest, data []byte
+  tr := tar.NewReader(zr)
+  for {
+    hdr, err := tr.Next()
+    if err == io.EOF {
+      break
+    }
+
+    if err != nil {
+      return m, nil, err
+    }
+
+    body, err := io.ReadAll(tr)
+    if err != nil {
+      return m, nil, err
+    }
+
+    switch hdr.Name {
+    case "manifest.json":
+      manifest = body
+    case "store.jsonl":
+      data = body
+    }
+  }
+
+  if manifest == nil || data == nil {
+    return m, nil, fmt.Errorf("%s is not a weather-go backup", path)
+  }
+
+  if err := json.Unmarshal(manifest, &m); err != nil {
+    return m, nil, err
+  }
+
+  if m.Format != backupFormat {
+    return m, nil, fmt.Errorf("unsupported backup format %d", m.Format)
+  }
+
+  return m, data, nil
+}
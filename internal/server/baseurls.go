@@ -0,0 +1,82 @@
+package server
+
+import (
+  "fmt"
+  "net/url"
+  "sort"
+  "strings"
+)
+
+// rebasable are the built-in upstreams, by the provider they belong to;
+// Open-Meteo's history comes from a second one, open-meteo.archive, its
+// pollen from open-meteo.air-quality and its waves from open-meteo.marine.
+var rebasable = []string{"openweathermap", "wunderground", "open-meteo", "open-meteo.archive", "open-meteo.air-quality", "open-meteo.marine", "met.no", "visualcrossing", "meteostat", "tomorrow.io", "stormglass"}
+
+// baseURLSet points built-in providers at a staging mirror, a proxy or a
+// test server instead of their public APIs. It is the config file's
+// "base_urls" and doubles as the repeatable -provider.url flag, which wins:
+// -provider.url=open-meteo=http://127.0.0.1:8081.
+type baseURLSet map[string]string
+
+func (b baseURLSet) String() string {
+  s := make([]string, 0, len(b))
+  for name, u := range b {
+    s = append(s, name+"="+u)
+  }
+
+  sort.Strings(s)
+  return strings.Join(s, ",")
+}
+
+func (b baseURLSet) Set(v string) error {
+  name, u, ok := strings.Cut(v, "=")
+  if !ok || name == "" {
+    return fmt.Errorf("want provider=<base URL>, got %q", v)
+  }
+
+  if err := checkBaseURL(name, u); err != nil {
+    return err
+  }
+
+  b[name] = u
+  return nil
+}
+
+func checkBaseURL(name, base string) error {
+  known := false
+  for _, n := range rebasable {
+    known = known || n == name
+  }
+
+  if !known {
+    return fmt.Errorf("%s has no base URL to change, want one of %s", name, strings.Join(rebasable, ", "))
+  }
+
+  u, err := url.Parse(base)
+  if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" {
+    return fmt.Errorf("%s: want an http(s) URL without a query, such as http://127.0.0.1:8081, got %q", name, base)
+  }
+
+  return nil
+}
+
+func (b baseURLSet) compile() []string {
+  var errs []string
+  for name, u := range b {
+    if err := checkBaseURL(name, u); err != nil {
+      errs = append(errs, err.Error())
+    }
+  }
+
+  sort.Strings(errs)
+  return errs
+}
+
+// merge adds the config file's base URLs the flag doesn't set.
+func (b baseURLSet) merge(file baseURLSet) {
+  for name, u := range file {
+    if _, ok := b[name]; !ok {
+      b[name] = u
+    }
+  }
+}
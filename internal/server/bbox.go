@@ -0,0 +1,183 @@
+package server
+
+import (
+  "fmt"
+  "math"
+  "net/http"
+  "strconv"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/cache"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// box is a validated bounding-box query: a grid of points every step
+// degrees, starting in its south-west corner.
+type box struct {
+  minLat, minLon, maxLat, maxLon float64
+  step                           float64
+  rows, cols                     int
+}
+
+func parseBox(r *http.Request, maxCells int) (box, error) {
+  q := r.URL.Query()
+
+  sw, err := geo.Coordinates(q.Get("min_lat"), q.Get("min_lon"))
+  if err != nil {
+    return box{}, err
+  }
+
+  ne, err := geo.Coordinates(q.Get("max_lat"), q.Get("max_lon"))
+  if err != nil {
+    return box{}, err
+  }
+
+  if sw.Lat > ne.Lat || sw.Lon > ne.Lon {
+    return box{}, fmt.Errorf("%w: min_lat/min_lon must not exceed max_lat/max_lon", geo.ErrBadCoordinates)
+  }
+
+  b := box{minLat: sw.Lat, minLon: sw.Lon, maxLat: ne.Lat, maxLon: ne.Lon, step: 0.5}
+  if g := q.Get("grid"); g != "" {
+    if b.step, err = strconv.ParseFloat(g, 64); err != nil || math.IsNaN(b.step) || math.IsInf(b.step, 0) || b.step < 0.01 {
+      return box{}, fmt.Errorf("grid %q must be at least 0.01 degrees", g)
+    }
+  }
+
+  // The epsilon keeps the far edge on the grid despite float division.
+  b.rows = int(math.Floor((b.maxLat-b.minLat)/b.step+1e-9)) + 1
+  b.cols = int(math.Floor((b.maxLon-b.minLon)/b.step+1e-9)) + 1
+  if b.rows*b.cols > maxCells {
+    return box{}, fmt.Errorf("box has %d grid points, over the limit of %d; use a coarser grid", b.rows*b.cols, maxCells)
+  }
+
+  return b, nil
+}
+
+// point is the grid point in row i and column j, rounded so keys and
+// output don't carry float noise.
+func (b box) point(i, j int) geo.Location {
+  l := geo.Location{
+    Lat: math.Round((b.minLat+float64(i)*b.step)*1e4) / 1e4,
+    Lon: math.Round((b.minLon+float64(j)*b.step)*1e4) / 1e4,
+  }
+
+  l.Name = l.LatString() + "," + l.LonString()
+  return l
+}
+
+// cell is the grid point nearest to loc, if loc is within half a step of it.
+func (b box) cell(loc geo.Location) (int, bool) {
+  i := int(math.Round((loc.Lat - b.minLat) / b.step))
+  j := int(math.Round((loc.Lon - b.minLon) / b.step))
+  if i < 0 || i >= b.rows || j < 0 || j >= b.cols {
+    return 0, false
+  }
+
+  return i*b.cols + j, true
+}
+
+// weatherBox serves GET /weather/bbox: a reading for every grid point of
+// the box, for heat-map overlays. Points are answered by the freshest
+// cached reading of any place in their cell; only up to -bbox.upstream of
+// the rest are fetched per request, row by row from the south-west, so
+// repeated requests fill the grid in without a burst of upstream calls.
+func (s *server) weatherBox(w http.ResponseWriter, r *http.Request) {
+  begin := time.Now()
+  ctx := upstream.WithTrace(r)
+
+  format, ok := responseFormat(w, r, "geojson")
+  if !ok {
+    return
+  }
+
+  d, ok := s.requestDisplay(w, r)
+  if !ok {
+    return
+  }
+
+  b, err := parseBox(r, s.bboxMaxCells)
+  if err != nil {
+    writeError(w, err, http.StatusBadRequest)
+    return
+  }
+
+  half := b.step / 2
+  near := make([]*cache.Entry, b.rows*b.cols)
+  for _, e := range s.cache.Within(b.minLat-half, b.minLon-half, b.maxLat+half, b.maxLon+half) {
+    if c, ok := b.cell(e.Loc); ok && (near[c] == nil || e.Stored.After(near[c].Stored)) {
+      near[c] = &e
+    }
+  }
+
+  active := s.activeProviders()
+  cells := make([]*TemperatureResponse, len(near))
+  sem := make(chan struct{}, s.batchConcurrency)
+  budget := s.bboxUpstream
+  var counts struct{ cached, fetched, missing int }
+
+  var wg sync.WaitGroup
+  for c := range cells {
+    loc := b.point(c/b.cols, c%b.cols)
+    cell := newTemperatureResponse()
+    cell.Lat, cell.Lon = &loc.Lat, &loc.Lon
+    cells[c] = cell
+
+    switch {
+    case near[c] != nil:
+      counts.cached++
+      cell.Temp, cell.Units = kelvinPtr(s.policies.aggregate(near[c].Kelvin, active)), "kelvin"
+      cell.Cached, cell.Age = true, age(*near[c])
+      cell.Condition = conditionAt(near[c].Condition, near[c].Loc, time.Now())
+      if near[c].Loc.Key() != loc.Key() {
+        cell.From = near[c].Loc.Name
+      }
+    case budget > 0:
+      budget--
+      counts.fetched++
+
+      wg.Add(1)
+      sem <- struct{}{}
+      go func() {
+        defer func() { <-sem; wg.Done() }()
+
+        res := s.fetch(ctx, loc, summary, false)
+        cell.Temp, cell.Units, cell.Error, cell.Stale, cell.Age = res.Temp, res.Units, res.Error, res.Stale, res.Age
+        cell.Degraded, cell.DataAge = res.Degraded, res.DataAge
+      }()
+    default:
+      counts.missing++
+      cell.Error = "not cached yet, over the upstream budget of this request"
+    }
+  }
+
+  wg.Wait()
+  showAll(cells, d)
+
+  members := map[string]interface{}{
+    "box":     map[string]float64{"min_lat": b.minLat, "min_lon": b.minLon, "max_lat": b.maxLat, "max_lon": b.maxLon},
+    "grid":    b.step,
+    "rows":    b.rows,
+    "cols":    b.cols,
+    "cached":  counts.cached,
+    "fetched": counts.fetched,
+    "missing": counts.missing,
+    "took":    time.Since(begin).String(),
+  }
+
+  if counts.cached+counts.fetched > 0 {
+    if credit := providers.Attributions(active); len(credit) > 0 {
+      members["attribution"] = credit
+    }
+  }
+
+  if format == "geojson" {
+    writeGeoJSON(w, http.StatusOK, featureCollection(cells, members))
+    return
+  }
+
+  members["cells"] = cells
+  writeJSON(w, http.StatusOK, members)
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+  "bufio"
+  "context"
+  "errors"
+  "flag"
+  "fmt"
+  "io"
+  "net/http"
+  "net/url"
+  "os"
+  "sort"
+  "strings"
+  "sync"
+  "testing"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/aggregate"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+// Cities a load test asks for without -cities.
+var benchCities = []string{"london,gb", "paris,fr", "berlin,de", "oslo,no", "madrid,es", "rome,it", "new york,us", "tokyo,jp"}
+
+// benchCommand implements `weather-go bench`: a load test of a running
+// server, or with -aggregate the aggregation path's Go benchmarks, for
+// checking what a change to pooling or caching does.
+func benchCommand(args []string) error {
+  fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+  base := fs.String("url", "http://127.0.0.1:8080", "server to load")
+  rps := fs.Int("rps", 100, "requests per second to send, whether or not earlier ones have been answered")
+  duration := fs.Duration("duration", 30*time.Second, "how long to send for")
+  citiesPath := fs.String("cities", "", "file of cities to ask for in turn, one per line, # for comments; a few capitals by default")
+  key := fs.String("key", "", "client API key sent as X-API-Key")
+  inFlight := fs.Int("concurrency", 512, "most requests waiting for an answer at once; sends beyond it are counted as dropped")
+  agg := fs.Bool("aggregate", false, "run the aggregation benchmarks in-process instead, no server needed")
+  if err := fs.Parse(args); err != nil {
+    return err
+  }
+
+  if *agg {
+    benchAggregation()
+    return nil
+  }
+
+  if *rps <= 0 || *duration <= 0 || *inFlight <= 0 {
+    return errors.New("usage: weather-go bench [-url <server>] [-rps <n>] [-duration <d>] [-cities <file>] [-concurrency <n>] | -aggregate")
+  }
+
+  cities := benchCities
+  if *citiesPath != "" {
+    var err error
+    if cities, err = readCities(*citiesPath); err != nil {
+      return err
+    }
+  }
+
+  fmt.Printf("loading %s with %d requests/s for %s over %d cities\n", *base, *rps, *duration, len(cities))
+  load := &loadTest{base: strings.TrimSuffix(*base, "/"), key: *key, slots: make(chan struct{}, *inFlight), statuses: make(map[string]int)}
+  load.run(cities, *rps, *duration)
+  load.report(os.Stdout)
+  return nil
+}
+
+func readCities(path string) ([]string, error) {
+  f, err := os.Open(path)
+  if err != nil {
+    return nil, err
+  }
+
+  defer f.Close()
+
+  var cities []string
+  sc := bufio.NewScanner(f)
+  for sc.Scan() {
+    if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+      cities = append(cities, line)
+    }
+  }
+
+  if err := sc.Err(); err != nil {
+    return nil, err
+  }
+
+  if len(cities) == 0 {
+    return nil, fmt.Errorf("%s: no cities", path)
+  }
+
+  return cities, nil
+}
+
+// loadTest sends at a fixed rate however slow the answers, so a server
+// falling behind shows in the latencies rather than in a lower rate.
+type loadTest struct {
+  base  string
+  key   string
+  slots chan struct{}
+
+  mu        sync.Mutex
+  latencies []time.Duration
+  statuses  map[string]int // by status code, or "error"
+  dropped   int
+  took      time.Duration
+}
+
+func (l *loadTest) run(cities []string, rps int, duration time.Duration) {
+  client := &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{MaxIdleConnsPerHost: cap(l.slots)}}
+  tick := time.NewTicker(time.Second / time.Duration(rps))
+  defer tick.Stop()
+
+  var wg sync.WaitGroup
+  begin := time.Now()
+  for i := 0; time.Since(begin) < duration; i++ {
+    <-tick.C
+    select {
+    case l.slots <- struct{}{}:
+    default:
+      l.mu.Lock()
+      l.dropped++
+      l.mu.Unlock()
+      continue
+    }
+
+    wg.Add(1)
+    go func(city string) {
+      defer wg.Done()
+      defer func() { <-l.slots }()
+      l.send(client, city)
+    }(cities[i%len(cities)])
+  }
+
+  wg.Wait()
+  l.took = time.Since(begin)
+}
+
+func (l *loadTest) send(client *http.Client, city string) {
+  req, err := http.NewRequest(http.MethodGet, l.base+"/v1/weather/"+url.PathEscape(city), nil)
+  if err != nil {
+    l.record("error", 0)
+    return
+  }
+
+  if l.key != "" {
+    req.Header.Set("X-API-Key", l.key)
+  }
+
+  begin := time.Now()
+  resp, err := client.Do(req)
+  if err != nil {
+    l.record("error", 0)
+    return
+  }
+
+  io.Copy(io.Discard, resp.Body)
+  resp.Body.Close()
+  l.record(fmt.Sprint(resp.StatusCode), time.Since(begin))
+}
+
+func (l *loadTest) record(status string, took time.Duration) {
+  l.mu.Lock()
+  defer l.mu.Unlock()
+
+  l.statuses[status]++
+  if status != "error" {
+    l.latencies = append(l.latencies, took)
+  }
+}
+
+func (l *loadTest) report(w io.Writer) {
+  l.mu.Lock()
+  defer l.mu.Unlock()
+
+  sent := l.dropped
+  codes := make([]string, 0, len(l.statuses))
+  for code, n := range l.statuses {
+    codes = append(codes, code)
+    sent += n
+  }
+
+  sort.Strings(codes)
+  fmt.Fprintf(w, "sent %d, %d dropped at the concurrency limit; %.1f answered/s over %s\n", sent, l.dropped, float64(sent-l.dropped)/l.took.Seconds(), l.took.Round(time.Millisecond))
+  for _, code := range codes {
+    fmt.Fprintf(w, "  %-5s %d\n", code, l.statuses[code])
+  }
+
+  if len(l.latencies) == 0 {
+    return
+  }
+
+  sort.Slice(l.latencies, func(i, j int) bool { return l.latencies[i] < l.latencies[j] })
+  at := func(p float64) time.Duration { return l.latencies[int(p*float64(len(l.latencies)-1))] }
+  fmt.Fprintf(w, "latency p50 %s, p90 %s, p99 %s, max %s\n", at(0.5), at(0.9), at(0.99), l.latencies[len(l.latencies)-1])
+}
+
+// benchProvider answers at once with a fixed reading.
+type benchProvider struct {
+  name   string
+  kelvin float64
+}
+
+func (p benchProvider) Name() string { return p.name }
+
+func (p benchProvider) Temperature(ctx context.Context, loc geo.Location) (float64, error) {
+  return p.kelvin, nil
+}
+
+// benchAggregation runs the aggregation path's benchmarks: each step on
+// six providers' readings, and the whole of it from fan-out to average.
+func benchAggregation() {
+  var ps providers.Multi
+  for i, k := range []float64{283.1, 283.4, 282.9, 283.2, 289.5, 283.0} {
+    ps = append(ps, benchProvider{name: fmt.Sprintf("bench-%d", i), kelvin: k})
+  }
+
+  loc := geo.Location{Name: "bench", Lat: 59.91, Lon: 10.75}
+  readings := func() []providers.Reading {
+    rs := make([]providers.Reading, len(ps))
+    for i, p := range ps {
+      k, _ := p.Temperature(context.Background(), loc)
+      rs[i] = providers.Reading{Provider: p.Name(), Kelvin: k, Weight: 1, Observed: time.Now()}
+    }
+
+    return rs
+  }
+
+  outliers := aggregate.Outliers{Kelvin: 3, Sigma: 3}
+  weights := aggregate.NewWeights(nil, true)
+  staleness := aggregate.Staleness{MaxAge: time.Hour}
+  sampler := aggregate.NewSampler(1, 0)
+
+  benches := []struct {
+    name string
+    fn   func(b *testing.B)
+  }{
+    {"average", func(b *testing.B) {
+      rs := readings()
+      for i := 0; i < b.N; i++ {
+        aggregate.Average(rs)
+      }
+    }},
+    {"outliers", func(b *testing.B) {
+      for i := 0; i < b.N; i++ {
+        outliers.Exclude(readings())
+      }
+    }},
+    {"weights", func(b *testing.B) {
+      for i := 0; i < b.N; i++ {
+        rs := readings()
+        weights.Assign(rs)
+        weights.Learn(rs)
+      }
+    }},
+    {"fan-out", func(b *testing.B) {
+      for i := 0; i < b.N; i++ {
+        sampler.Readings(context.Background(), ps, loc, providers.CollectAll)
+      }
+    }},
+    {"pipeline", func(b *testing.B) {
+      for i := 0; i < b.N; i++ {
+        rs := sampler.Readings(context.Background(), ps, loc, providers.CollectAll)
+        staleness.Exclude(rs, time.Now())
+        outliers.Exclude(rs)
+        weights.Assign(rs)
+        aggregate.Average(rs)
+      }
+    }},
+  }
+
+  for _, bench := range benches {
+    r := testing.Benchmark(bench.fn)
+    fmt.Printf("%-10s %s\t%s\n", bench.name, r.String(), r.MemString())
+  }
+}
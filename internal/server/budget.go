@@ -0,0 +1,71 @@
+package server
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+)
+
+var budgetsExhausted = metrics.NewCounter("request_budget_exhausted_total", "Lookups that ran out of their latency budget, by the phase that was running.", "phase")
+
+// requestBudget is how long a temperature lookup may take in all, so it
+// answers within the SLO rather than as slowly as the slowest upstream.
+// Geocoding may use up to its share, the fan-out whatever is left. A
+// fan-out that overruns keeps going in its flight and fills the cache for
+// the next request; this one gets the stale reading, or 504.
+type requestBudget struct {
+  total   time.Duration // 0 is unlimited
+  geocode float64       // of total, counted from the start of the request
+}
+
+var errBudgetExhausted = errors.New("request budget exhausted")
+
+type budgetKey struct{}
+
+// start bounds ctx by the whole budget.
+func (b requestBudget) start(ctx context.Context) (context.Context, context.CancelFunc) {
+  if b.total <= 0 {
+    return ctx, func() {}
+  }
+
+  ctx = context.WithValue(ctx, budgetKey{}, time.Now())
+  return context.WithTimeout(ctx, b.total)
+}
+
+// geocoding bounds ctx by geocoding's share, if start has set a budget.
+func (b requestBudget) geocoding(ctx context.Context) (context.Context, context.CancelFunc) {
+  began, ok := ctx.Value(budgetKey{}).(time.Time)
+  if !ok {
+    return ctx, func() {}
+  }
+
+  return context.WithDeadline(ctx, began.Add(time.Duration(b.geocode*float64(b.total))))
+}
+
+// overran reports whether ctx ran out of budget during phase, counting it.
+func overran(ctx context.Context, phase string) bool {
+  if _, ok := ctx.Value(budgetKey{}).(time.Time); !ok || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+    return false
+  }
+
+  budgetsExhausted.Inc(phase)
+  return true
+}
+
+// geocodeWithin resolves a location within geocoding's share of ctx's
+// budget.
+func (s *server) geocodeWithin(ctx context.Context, resolve func(context.Context) (geo.Location, error)) (geo.Location, error) {
+  gctx, cancel := s.budget.geocoding(ctx)
+  defer cancel()
+
+  loc, err := resolve(gctx)
+  if err != nil && overran(gctx, "geocode") {
+    return loc, fmt.Errorf("%w: geocoding took over %s", errBudgetExhausted, time.Duration(s.budget.geocode*float64(s.budget.total)))
+  }
+
+  return loc, err
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "strings"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/cache"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+)
+
+// bulkRequest is an admin operation over everything matching a filter.
+// With dry_run nothing changes; the response lists what would be affected.
+type bulkRequest struct {
+  Op     string `json:"op"` // purge-cache, disable-providers, enable-providers
+  DryRun bool   `json:"dry_run"`
+  Filter struct {
+    Country   string   `json:"country"`   // purge-cache: ISO country code
+    City      string   `json:"city"`      // purge-cache: normalized name
+    Providers []string `json:"providers"` // *-providers: only these
+    Except    []string `json:"except"`    // *-providers: all but these
+  } `json:"filter"`
+}
+
+// bulk serves POST /v1/admin/bulk; ?dry_run=true also forces a preview.
+func (s *server) bulk(w http.ResponseWriter, r *http.Request) {
+  var req bulkRequest
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    httpError(w, "bad bulk request: "+err.Error(), bodyStatus(err))
+    return
+  }
+
+  if r.URL.Query().Get("dry_run") == "true" {
+    req.DryRun = true
+  }
+
+  var affected []string
+  var err error
+  switch req.Op {
+  case "purge-cache":
+    affected = s.bulkPurgeCache(req)
+  case "disable-providers", "enable-providers":
+    affected, err = s.bulkProviders(req, req.Op == "enable-providers")
+  default:
+    httpError(w, fmt.Sprintf("unknown op %q, want purge-cache, disable-providers or enable-providers", req.Op), http.StatusBadRequest)
+    return
+  }
+
+  if err != nil {
+    writeError(w, err, http.StatusInternalServerError)
+    return
+  }
+
+  if affected == nil {
+    affected = []string{}
+  }
+
+  writeJSON(w, http.StatusOK, map[string]interface{}{
+    "op":       req.Op,
+    "dry_run":  req.DryRun,
+    "count":    len(affected),
+    "affected": affected,
+  })
+}
+
+func (s *server) bulkPurgeCache(req bulkRequest) []string {
+  city := geo.NormalizeName(req.Filter.City)
+  es := s.cache.Match(func(e cache.Entry) bool {
+    if req.Filter.Country != "" && !strings.EqualFold(e.Loc.Country, req.Filter.Country) {
+      return false
+    }
+
+    return city == "" || geo.NormalizeName(e.Loc.Name) == city
+  })
+
+  if !req.DryRun {
+    s.cache.Remove(es)
+  }
+
+  affected := make([]string, 0, len(es))
+  for _, e := range es {
+    affected = append(affected, e.Loc.Name+" ("+e.Key+")")
+  }
+
+  return affected
+}
+
+// bulkProviders flips the enabled override of every selected provider that
+// isn't already in the wanted state.
+func (s *server) bulkProviders(req bulkRequest, enable bool) ([]string, error) {
+  if len(req.Filter.Providers) > 0 && len(req.Filter.Except) > 0 {
+    return nil, fmt.Errorf("filter takes providers or except, not both")
+  }
+
+  in := func(name string, list []string) bool {
+    for _, n := range list {
+      if n == name {
+        return true
+      }
+    }
+
+    return false
+  }
+
+  want := fmt.Sprint(enable)
+
+  var affected []string
+  for _, p := range s.providers.get() {
+    name := p.Name()
+    if len(req.Filter.Providers) > 0 && !in(name, req.Filter.Providers) || in(name, req.Filter.Except) {
+      continue
+    }
+
+    key := "provider." + name + ".enabled"
+    current, ok := s.setting(key)
+    if current == want || !ok && enable {
+      continue
+    }
+
+    affected = append(affected, name)
+    if req.DryRun {
+      continue
+    }
+
+    if err := s.setOverride(key, want); err != nil {
+      return affected, err
+    }
+  }
+
+  return affected, nil
+}
+
+// setOverride creates or updates the override for key, reviving a deleted one.
+func (s *server) setOverride(key, value string) error {
+  item, err := s.overrides.get(key)
+  if err != nil {
+    return s.overrides.create(&override{Key: key, Value: value})
+  }
+
+  if item.meta().DeletedAt != nil {
+    if item, err = s.overrides.setDeleted(key, "", false); err != nil {
+      return err
+    }
+  }
+
+  _, err = s.overrides.update(key, item.meta().etag(), &override{Key: key, Value: value})
+  return err
+}
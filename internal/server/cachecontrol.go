@@ -0,0 +1,83 @@
+package server
+
+import (
+  "fmt"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/cache"
+)
+
+// cacheHeaders tells browsers and CDNs how long a lookup answer stays
+// fresh, from the cache entry behind it: Cache-Control's max-age is what
+// is left of the entry's -cache.ttl, Age how old the entry is, so a CDN
+// never keeps a reading longer than the service itself would.
+type cacheHeaders struct {
+  scope string          // public or private; empty is off
+  cache *cache.Readings // its TTL is how long fresh answers stay fresh, its stale time how long stale ones may stand in
+}
+
+// newCacheHeaders is -cache.control: public, private or off.
+func newCacheHeaders(scope string, c *cache.Readings) (cacheHeaders, error) {
+  switch scope {
+  case "public", "private":
+    return cacheHeaders{scope: scope, cache: c}, nil
+  case "off":
+    return cacheHeaders{}, nil
+  default:
+    return cacheHeaders{}, fmt.Errorf("cache control: want public, private or off, got %q", scope)
+  }
+}
+
+// set sets the headers for an answer to r made of resps, which lasts as
+// long as its stalest reading. Answers a failure went into are only kept
+// to revalidate against their ETag. A Cache-Control the handler set
+// already, "private" for answers that depend on who asks, narrows the
+// scope; answers to a client follow its preferences, so vary by its key.
+func (c cacheHeaders) set(w http.ResponseWriter, r *http.Request, resps ...*TemperatureResponse) {
+  if c.scope == "" {
+    return
+  }
+
+  now := time.Now()
+  fresh, age := c.cache.TTL(), time.Duration(0)
+  for _, resp := range resps {
+    switch {
+    case resp.Error != "" || resp.Stale || resp.Degraded:
+      fresh = 0
+    case resp.Cache != nil:
+      fresh = min(fresh, resp.Cache.ExpiresAt.Sub(now))
+      age = max(age, now.Sub(resp.Cache.StoredAt))
+    }
+  }
+
+  scope := c.scope
+  if strings.Contains(w.Header().Get("Cache-Control"), "private") {
+    scope = "private"
+  }
+
+  h := w.Header()
+  if _, ok := clientFrom(r.Context()); ok {
+    h.Add("Vary", "X-API-Key")
+  }
+
+  if age > 0 {
+    h.Set("Age", strconv.Itoa(int(age.Seconds())))
+  }
+
+  if fresh = fresh.Truncate(time.Second); fresh <= 0 {
+    h.Set("Cache-Control", scope+", no-cache")
+    h.Set("Expires", now.UTC().Format(http.TimeFormat))
+    return
+  }
+
+  directives := fmt.Sprintf("%s, max-age=%d", scope, int(fresh.Seconds()))
+  if staleIfError := c.cache.StaleFor(); staleIfError > 0 {
+    directives += fmt.Sprintf(", stale-if-error=%d", int(staleIfError.Seconds()))
+  }
+
+  h.Set("Cache-Control", directives)
+  h.Set("Expires", now.Add(fresh).UTC().Format(http.TimeFormat))
+}
@@ -0,0 +1,323 @@
+package server
+
+import (
+  "bytes"
+  "context"
+  "crypto/tls"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "mime"
+  "net"
+  "net/http"
+  "net/mail"
+  "net/smtp"
+  "net/url"
+  "strings"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// notificationsConfig is the config file's "notifications": the accounts
+// rules' email, telegram and mqtt channels send with.
+type notificationsConfig struct {
+  SMTP     *smtpConfig     `json:"smtp,omitempty"`
+  Telegram *telegramConfig `json:"telegram,omitempty"`
+  MQTT     *mqttConfig     `json:"mqtt,omitempty"`
+}
+
+// smtpConfig is a mail server that takes submissions on addr, upgraded with
+// STARTTLS when it offers it.
+type smtpConfig struct {
+  Addr     string `json:"addr"`
+  Username string `json:"username,omitempty"`
+  Password string `json:"password,omitempty"`
+  From     string `json:"from"`
+}
+
+// telegramConfig is a bot, as created with @BotFather.
+type telegramConfig struct {
+  Token string `json:"token"`
+}
+
+func (n notificationsConfig) compile() []string {
+  var errs []string
+  if s := n.SMTP; s != nil {
+    if _, _, err := net.SplitHostPort(s.Addr); err != nil {
+      errs = append(errs, fmt.Sprintf("smtp.addr: want host:port, got %q", s.Addr))
+    }
+
+    if _, err := mail.ParseAddress(s.From); err != nil {
+      errs = append(errs, fmt.Sprintf("smtp.from: %q is not an email address", s.From))
+    }
+
+    if (s.Username == "") != (s.Password == "") {
+      errs = append(errs, "smtp.username: username and password go together")
+    }
+  }
+
+  if t := n.Telegram; t != nil && !strings.Contains(t.Token, ":") {
+    errs = append(errs, "telegram.token: want the bot token from @BotFather, such as 123456:ABC-DEF")
+  }
+
+  if n.MQTT != nil {
+    errs = append(errs, n.MQTT.compile()...)
+  }
+
+  return errs
+}
+
+// channelConfig is one of a rule's "channels". Type picks the others that
+// apply: url for webhook, to for email, chat for telegram, topic for mqtt.
+type channelConfig struct {
+  Type  string   `json:"type"`
+  URL   string   `json:"url,omitempty"`
+  To    []string `json:"to,omitempty"`
+  Chat  string   `json:"chat,omitempty"`
+  Topic string   `json:"topic,omitempty"`
+}
+
+// notification is what a channel delivers when a rule fires.
+type notification struct {
+  Rule     string // or the report's name
+  City     string
+  Message  string
+  Reading  *TemperatureResponse
+  Forecast []*ForecastResponse // a scheduled report's, instead of Reading
+}
+
+// payload is n as webhooks and MQTT get it: {"rule", "city", "message",
+// "reading"}, or {"report", "city", "message", "forecast"} for a report.
+func (n notification) payload() []byte {
+  body := map[string]interface{}{"rule": n.Rule, "city": n.City, "message": n.Message, "reading": n.Reading}
+  if n.Forecast != nil {
+    body = map[string]interface{}{"report": n.Rule, "city": n.City, "message": n.Message, "forecast": n.Forecast}
+  }
+
+  b, _ := json.Marshal(body)
+  return b
+}
+
+// channel delivers rule notifications to one destination.
+type channel interface {
+  kind() string
+  send(ctx context.Context, n notification) error
+}
+
+// channelKinds builds each channel type from its config, checked against
+// the accounts in notifications.
+var channelKinds = map[string]func(cc channelConfig, n notificationsConfig) (channel, []string){
+  "webhook":  newWebhookChannel,
+  "email":    newEmailChannel,
+  "telegram": newTelegramChannel,
+  "mqtt":     newMQTTChannel,
+}
+
+func (cc channelConfig) compile(n notificationsConfig) (channel, []string) {
+  build, ok := channelKinds[cc.Type]
+  if !ok {
+    return nil, []string{fmt.Sprintf("type: want webhook, email, telegram or mqtt, got %q", cc.Type)}
+  }
+
+  return build(cc, n)
+}
+
+// webhookChannel POSTs the notification's payload to url.
+type webhookChannel struct {
+  url string
+}
+
+func newWebhookChannel(cc channelConfig, _ notificationsConfig) (channel, []string) {
+  if u, err := url.Parse(cc.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+    return nil, []string{fmt.Sprintf("url: want an http(s) URL, got %q", cc.URL)}
+  }
+
+  return webhookChannel{url: cc.URL}, nil
+}
+
+func (c webhookChannel) kind() string { return "webhook" }
+
+func (c webhookChannel) send(ctx context.Context, n notification) error {
+  req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(n.payload()))
+  if err != nil {
+    return err
+  }
+
+  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set("User-Agent", upstream.UserAgent())
+
+  resp, err := webhookClient.Do(req)
+  if err != nil {
+    return err
+  }
+
+  resp.Body.Close()
+  if resp.StatusCode/100 != 2 {
+    return fmt.Errorf("%s: %s", c.url, resp.Status)
+  }
+
+  return nil
+}
+
+// emailChannel mails the message through the configured SMTP server.
+type emailChannel struct {
+  server smtpConfig
+  to     []string
+}
+
+func newEmailChannel(cc channelConfig, n notificationsConfig) (channel, []string) {
+  var errs []string
+  if n.SMTP == nil {
+    errs = append(errs, "type: email needs notifications.smtp in the config")
+  }
+
+  if len(cc.To) == 0 {
+    errs = append(errs, "to: needs at least one address")
+  }
+
+  for i, to := range cc.To {
+    if _, err := mail.ParseAddress(to); err != nil {
+      errs = append(errs, fmt.Sprintf("to[%d]: %q is not an email address", i, to))
+    }
+  }
+
+  if len(errs) > 0 {
+    return nil, errs
+  }
+
+  return emailChannel{server: *n.SMTP, to: cc.To}, nil
+}
+
+func (c emailChannel) kind() string { return "email" }
+
+func (c emailChannel) send(ctx context.Context, n notification) error {
+  ctx, cancel := context.WithTimeout(ctx, webhookClient.Timeout)
+  defer cancel()
+
+  host, _, _ := net.SplitHostPort(c.server.Addr)
+  conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", c.server.Addr)
+  if err != nil {
+    return err
+  }
+
+  defer conn.Close()
+  if deadline, ok := ctx.Deadline(); ok {
+    conn.SetDeadline(deadline)
+  }
+
+  client, err := smtp.NewClient(conn, host)
+  if err != nil {
+    return err
+  }
+
+  defer client.Close()
+  if ok, _ := client.Extension("STARTTLS"); ok {
+    if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+      return err
+    }
+  }
+
+  if c.server.Username != "" {
+    // PlainAuth refuses to send the password unencrypted, except to localhost.
+    if err := client.Auth(smtp.PlainAuth("", c.server.Username, c.server.Password, host)); err != nil {
+      return err
+    }
+  }
+
+  from, _ := mail.ParseAddress(c.server.From)
+  if err := client.Mail(from.Address); err != nil {
+    return err
+  }
+
+  for _, to := range c.to {
+    addr, _ := mail.ParseAddress(to)
+    if err := client.Rcpt(addr.Address); err != nil {
+      return fmt.Errorf("%s: %w", to, err)
+    }
+  }
+
+  w, err := client.Data()
+  if err != nil {
+    return err
+  }
+
+  var msg strings.Builder
+  fmt.Fprintf(&msg, "From: %s\r\n", c.server.From)
+  fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(c.to, ", "))
+  fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Rule+": "+n.City))
+  fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+  msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+  msg.WriteString(strings.ReplaceAll(n.Message, "\n", "\r\n") + "\r\n")
+
+  if _, err := w.Write([]byte(msg.String())); err != nil {
+    return err
+  }
+
+  if err := w.Close(); err != nil {
+    return err
+  }
+
+  return client.Quit()
+}
+
+var telegramAPI = "https://api.telegram.org"
+
+// telegramChannel sends the message to a chat, group or channel the bot is
+// a member of: a numeric id, or @name for public ones.
+type telegramChannel struct {
+  token string
+  chat  string
+}
+
+func newTelegramChannel(cc channelConfig, n notificationsConfig) (channel, []string) {
+  var errs []string
+  if n.Telegram == nil {
+    errs = append(errs, "type: telegram needs notifications.telegram in the config")
+  }
+
+  if cc.Chat == "" {
+    errs = append(errs, "chat: is required, a chat id or @channel")
+  }
+
+  if len(errs) > 0 {
+    return nil, errs
+  }
+
+  return telegramChannel{token: n.Telegram.Token, chat: cc.Chat}, nil
+}
+
+func (c telegramChannel) kind() string { return "telegram" }
+
+func (c telegramChannel) send(ctx context.Context, n notification) error {
+  body, _ := json.Marshal(map[string]string{"chat_id": c.chat, "text": n.Message})
+  req, err := http.NewRequestWithContext(ctx, "POST", telegramAPI+"/bot"+c.token+"/sendMessage", bytes.NewReader(body))
+  if err != nil {
+    return upstream.Redact(err, c.token)
+  }
+
+  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set("User-Agent", upstream.UserAgent())
+
+  resp, err := webhookClient.Do(req)
+  if err != nil {
+    return upstream.Redact(err, c.token)
+  }
+
+  defer resp.Body.Close()
+
+  var d struct {
+    OK          bool   `json:"ok"`
+    Description string `json:"description"`
+  }
+
+  if err := json.NewDecoder(resp.Body).Decode(&d); err != nil || !d.OK {
+    if d.Description == "" {
+      d.Description = resp.Status
+    }
+
+    return errors.New(d.Description)
+  }
+
+  return nil
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+  "encoding/json"
+  "log"
+  "net/http"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// adminChaos serves GET and PUT /v1/admin/chaos: the faults -chaos injects
+// into provider calls, changed live with {"faults": "error=0.3"} so a
+// staging run can step through failure rates without restarts. Without
+// -chaos there is nothing to change.
+func (s *server) adminChaos(w http.ResponseWriter, r *http.Request) {
+  if s.chaos == nil {
+    httpError(w, "fault injection is off; start with -chaos to use it", http.StatusNotFound)
+    return
+  }
+
+  if r.Method == http.MethodPut {
+    var req struct {
+      Faults string `json:"faults"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+      httpError(w, `want {"faults": "latency=<duration>@<rate>,error=<rate>,malformed=<rate>"}`, bodyStatus(err))
+      return
+    }
+
+    f, err := upstream.ParseFaults(req.Faults)
+    if err != nil {
+      writeError(w, err, http.StatusBadRequest)
+      return
+    }
+
+    s.chaos.Set(f)
+    log.Printf("chaos: injecting %s", f)
+  }
+
+  writeJSON(w, http.StatusOK, map[string]interface{}{"faults": s.chaos.Faults().String()})
+}
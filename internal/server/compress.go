@@ -0,0 +1,125 @@
+package server
+
+import (
+  "compress/gzip"
+  "net/http"
+  "strings"
+  "sync"
+)
+
+// Bodies smaller than this aren't worth the gzip header and CPU.
+const gzipMin = 1024
+
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+// compress gzips responses for clients that accept it. Streams are left
+// alone: every event must reach the client when it is flushed.
+func compress(h http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Add("Vary", "Accept-Encoding")
+    if !acceptsGzip(r) || strings.HasPrefix(r.URL.Path, "/v1/stream") {
+      h.ServeHTTP(w, r)
+      return
+    }
+
+    gw := &gzipWriter{ResponseWriter: w, status: http.StatusOK}
+    defer gw.close()
+    h.ServeHTTP(gw, r)
+  })
+}
+
+func acceptsGzip(r *http.Request) bool {
+  for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+    name, q, _ := strings.Cut(strings.TrimSpace(enc), ";")
+    if strings.EqualFold(strings.TrimSpace(name), "gzip") && strings.ReplaceAll(q, " ", "") != "q=0" {
+      return true
+    }
+  }
+
+  return false
+}
+
+// gzipWriter holds the start of the body back until it knows whether it is
+// big enough to compress, then sends the headers and everything else
+// through the gzip stream or straight on.
+type gzipWriter struct {
+  http.ResponseWriter
+  status  int
+  buf     []byte
+  started bool
+  gz      *gzip.Writer
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+  if w.started {
+    return
+  }
+
+  w.status = status
+  if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified {
+    w.start(false)
+  }
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+  if !w.started {
+    w.buf = append(w.buf, b...)
+    if len(w.buf) >= gzipMin {
+      w.start(true)
+    }
+
+    return len(b), nil
+  }
+
+  if w.gz != nil {
+    return w.gz.Write(b)
+  }
+
+  return w.ResponseWriter.Write(b)
+}
+
+// start sends the headers, compressing when the body is big enough and
+// not already encoded.
+func (w *gzipWriter) start(big bool) {
+  w.started = true
+  h := w.Header()
+  if big && h.Get("Content-Encoding") == "" && w.status != http.StatusPartialContent {
+    h.Set("Content-Encoding", "gzip")
+    h.Del("Content-Length")
+    w.gz = gzipWriters.Get().(*gzip.Writer)
+    w.gz.Reset(w.ResponseWriter)
+  }
+
+  w.ResponseWriter.WriteHeader(w.status)
+  if len(w.buf) > 0 {
+    w.Write(w.buf)
+    w.buf = nil
+  }
+}
+
+func (w *gzipWriter) close() {
+  if !w.started {
+    w.start(false)
+  }
+
+  if w.gz != nil {
+    w.gz.Close()
+    gzipWriters.Put(w.gz)
+  }
+}
+
+// Flush sends what is written so far, compressed if it is big enough,
+// for exports that go out as they are read.
+func (w *gzipWriter) Flush() {
+  if !w.started {
+    w.start(len(w.buf) >= gzipMin)
+  }
+
+  if w.gz != nil {
+    w.gz.Flush()
+  }
+
+  http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *gzipWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
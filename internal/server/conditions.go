@@ -0,0 +1,290 @@
+package server
+
+import (
+  "context"
+  "errors"
+  "math"
+  "net/http"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/aggregate"
+  "github.com/im-kulikov/weather-go-external-api/internal/astro"
+  "github.com/im-kulikov/weather-go-external-api/internal/comfort"
+  "github.com/im-kulikov/weather-go-external-api/internal/condition"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+var errNoDescribers = errors.New("no enabled provider describes conditions")
+
+// conditions answers GET /v1/conditions/{city} (or ?lat=&lon=) with the
+// weather now in words, in the language of ?lang=, the client's preference
+// or Accept-Language. Providers that speak it are asked in it; otherwise
+// the condition the providers agree on is told from the icon titles.
+func (s *server) conditions(w http.ResponseWriter, r *http.Request) {
+  begin := time.Now()
+  ctx := upstream.WithTrace(r)
+
+  d, ok := s.requestDisplay(w, r)
+  if !ok {
+    return
+  }
+
+  if l := r.URL.Query().Get("lang"); l != "" && !langTag.MatchString(l) {
+    httpError(w, "lang wants a language tag such as en or pt-BR, got "+l, http.StatusBadRequest)
+    return
+  }
+
+  loc, err := requestLocation(ctx, r, s.geo)
+  var amb *geo.AmbiguousError
+  if errors.As(err, &amb) {
+    writeCandidates(w, amb)
+    return
+  }
+
+  if err != nil {
+    writeError(w, err, locationStatus(err))
+    return
+  }
+
+  lang := "en"
+  if asked := requestedLanguages(r); len(asked) > 0 {
+    lang = asked[0]
+  }
+
+  loc = s.zones.Locate(ctx, loc)
+  resp := &ConditionsResponse{SchemaVersion: responseVersion, City: loc.Name, Region: loc.Region, Country: loc.Country, Lat: loc.Lat, Lon: loc.Lon, TimeZone: loc.TimeZone}
+  credit, err := s.describe(ctx, loc, lang, resp)
+  if err != nil {
+    status := http.StatusBadGateway
+    if errors.Is(err, errNoDescribers) {
+      status = http.StatusNotFound
+    }
+
+    writeError(w, err, status)
+    return
+  }
+
+  var codes []condition.Code
+  for _, src := range resp.Providers {
+    if src.Error == "" {
+      codes = append(codes, src.Code)
+    }
+  }
+
+  resp.Code = condition.Consensus(codes)
+  icon := resp.Code.Icon(astro.Night(time.Now(), loc.Lat, loc.Lon))
+  resp.Icon = "/icons/" + icon + ".svg"
+  local := preferredLanguage(r, iconLanguages)
+  resp.Text, resp.Lang, resp.TextFrom = iconTitles[icon][local], local, "local"
+  for _, src := range resp.Providers {
+    if src.Code == resp.Code && src.Text != "" && baseLanguage(src.Lang) == baseLanguage(lang) {
+      resp.Text, resp.Lang, resp.TextFrom = src.Text, src.Lang, src.Provider
+      break
+    }
+  }
+
+  resp.Metrics = conditionMetrics(resp.Providers)
+  resp.show(d)
+  resp.Attribution = credit
+  resp.Took = time.Since(begin).String()
+  w.Header().Set("Content-Language", resp.Lang)
+  w.Header().Set("Vary", "Accept-Language, X-API-Key")
+  writeJSON(w, http.StatusOK, resp)
+}
+
+// describe asks every available describer for the conditions at loc,
+// noting each one's outcome in resp.
+func (s *server) describe(ctx context.Context, loc geo.Location, lang string, resp *ConditionsResponse) ([]upstream.Attribution, error) {
+  active := s.activeFor(ctx, loc)
+
+  var ds providers.Multi
+  for _, p := range active {
+    if _, ok := p.(providers.Describer); ok {
+      ds = append(ds, p)
+    }
+  }
+
+  if len(ds) == 0 {
+    return nil, errNoDescribers
+  }
+
+  outcomes := make([]providers.Reading, len(ds))
+  resp.Providers = make([]ConditionSource, len(ds))
+
+  var wg sync.WaitGroup
+  for i, p := range ds {
+    s.quotasFor(ctx).spend(p.Name())
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+
+      begin := time.Now()
+      c, err := p.(providers.Describer).Conditions(ctx, loc, lang)
+      outcomes[i] = providers.Reading{Provider: p.Name(), Took: time.Since(begin)}
+      resp.Providers[i] = ConditionSource{
+        Provider:  p.Name(),
+        Code:      c.Code,
+        Text:      c.Text,
+        Lang:      c.Lang,
+        Temp:      c.Kelvin,
+        Humidity:  c.Humidity,
+        WindSpeed: c.Wind,
+        WindDir:   c.WindDirection,
+        WindGust:  c.Gust,
+        Took:      outcomes[i].Took.String(),
+        RoadWeather: RoadWeather{
+          Precipitation: c.Precipitation, Snowfall: c.Snowfall, SnowDepth: c.SnowDepth, FreezingLevel: c.FreezingLevel,
+        },
+      }
+      if err != nil {
+        outcomes[i].Error, outcomes[i].Err = err.Error(), err
+        resp.Providers[i].Error = err.Error()
+      }
+    }()
+  }
+
+  wg.Wait()
+  s.healthFor(ctx).record(outcomes)
+
+  var credit []upstream.Attribution
+  var failures []string
+  for i, o := range outcomes {
+    if o.Error != "" {
+      failures = append(failures, o.Provider+": "+o.Error)
+      continue
+    }
+
+    credit = append(credit, providers.Attributions(ds[i:i+1])...)
+  }
+
+  if len(failures) == len(ds) {
+    return nil, errors.New(strings.Join(failures, "; "))
+  }
+
+  return credit, nil
+}
+
+// consensus is the condition the successful readings agree on.
+func consensus(rs []providers.Reading) condition.Code {
+  var codes []condition.Code
+  for _, r := range rs {
+    if r.Error == "" {
+      codes = append(codes, r.Condition)
+    }
+  }
+
+  return condition.Consensus(codes)
+}
+
+// conditionAt is c as shown at loc at t, with a night icon after dark; nil
+// when nobody knows the condition.
+func conditionAt(c condition.Code, loc geo.Location, t time.Time) *ConditionInfo {
+  if c == condition.Unknown {
+    return nil
+  }
+
+  return &ConditionInfo{Code: c, Icon: "/icons/" + c.Icon(astro.Night(t, loc.Lat, loc.Lon)) + ".svg"}
+}
+
+// meanOf is the mean of what v has of each source, nil when none has it.
+func meanOf[T any](srcs []T, v func(T) *float64) *float64 {
+  sum, n := 0.0, 0
+  for _, src := range srcs {
+    if x := v(src); x != nil {
+      sum, n = sum+*x, n+1
+    }
+  }
+
+  if n == 0 {
+    return nil
+  }
+
+  m := sum / float64(n)
+  return &m
+}
+
+// meanRoadWeather is the sources' mean of each.
+func meanRoadWeather(ws []RoadWeather) RoadWeather {
+  return RoadWeather{
+    Precipitation: meanOf(ws, func(w RoadWeather) *float64 { return w.Precipitation }),
+    Snowfall:      meanOf(ws, func(w RoadWeather) *float64 { return w.Snowfall }),
+    SnowDepth:     meanOf(ws, func(w RoadWeather) *float64 { return w.SnowDepth }),
+    FreezingLevel: meanOf(ws, func(w RoadWeather) *float64 { return w.FreezingLevel }),
+  }
+}
+
+// icyRoads is why roads may be icy at kelvin in w, "" if they needn't be.
+func icyRoads(kelvin float64, w RoadWeather) string {
+  orNaN := func(v *float64) float64 {
+    if v == nil {
+      return math.NaN()
+    }
+
+    return *v
+  }
+
+  return comfort.IcyRoads(kelvin, orNaN(w.Precipitation), orNaN(w.SnowDepth))
+}
+
+// conditionMetrics averages what the providers measured and derives how
+// it feels and what it does to roads; nil when none of them has the
+// temperature.
+func conditionMetrics(all []ConditionSource) *ConditionMetrics {
+  var srcs []ConditionSource
+  var road []RoadWeather
+  for _, src := range all {
+    if src.Error == "" {
+      srcs, road = append(srcs, src), append(road, src.RoadWeather)
+    }
+  }
+
+  var winds []providers.Condition
+  for _, src := range srcs {
+    winds = append(winds, providers.Condition{Wind: src.WindSpeed, WindDirection: src.WindDir, Gust: src.WindGust})
+  }
+
+  wind := aggregate.AverageWind(winds)
+  m := &ConditionMetrics{
+    Temp:        meanOf(srcs, func(src ConditionSource) *float64 { return src.Temp }),
+    Humidity:    meanOf(srcs, func(src ConditionSource) *float64 { return src.Humidity }),
+    WindSpeed:   wind.Speed,
+    WindDir:     wind.Direction,
+    WindGust:    wind.Gust,
+    RoadWeather: meanRoadWeather(road),
+  }
+
+  if m.Temp == nil {
+    return nil
+  }
+
+  k, rh, speed := *m.Temp, math.NaN(), math.NaN()
+  if m.Humidity != nil {
+    rh = *m.Humidity
+    dew := comfort.DewPoint(k, rh)
+    m.DewPoint = &dew
+  }
+
+  if m.WindSpeed != nil {
+    speed = *m.WindSpeed
+  }
+
+  if comfort.HasHeatIndex(k, rh) {
+    hi := comfort.HeatIndex(k, rh)
+    m.HeatIndex = &hi
+  }
+
+  if comfort.HasWindChill(k, speed) {
+    wc := comfort.WindChill(k, speed)
+    m.WindChill = &wc
+  }
+
+  feels := comfort.FeelsLike(k, rh, speed)
+  m.FeelsLike = &feels
+  m.IcyRoadReason = icyRoads(k, m.RoadWeather)
+  m.IcyRoadRisk = m.IcyRoadReason != ""
+  return m
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "os"
+  "strings"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+// config is the optional JSON file given with -config, for settings that
+// are lists or secrets rather than single values. Unknown fields are an
+// error so a typo doesn't silently fall back to defaults, and everything
+// in it is compiled on load, so a bad rule or path stops the server at
+// startup instead of failing when it is first used.
+type config struct {
+  Clients   []clientConfig            `json:"clients"`
+  Tenants   []tenantConfig            `json:"tenants"`
+  Providers []providers.GenericConfig `json:"providers"`
+  Plugins   []providers.PluginConfig  `json:"plugins"`
+  Groups    []groupConfig             `json:"groups"`
+  Rules     []ruleConfig              `json:"rules"`
+  Reports   []reportConfig            `json:"reports"`
+  Routing   []routeConfig             `json:"routing"`
+  BaseURLs  baseURLSet                `json:"base_urls"`
+  Storage   storageConfig             `json:"storage"`
+
+  Notifications notificationsConfig `json:"notifications"`
+
+  // Over their flags, and applied again on SIGHUP with the clients.
+  APIKeys   map[string]string `json:"api_keys"` // of the built-in providers, by provider
+  Enabled   []string          `json:"enabled_providers"`
+  Shadow    []string          `json:"shadow_providers"`
+  Cache     cacheConfig       `json:"cache"`
+  RateLimit *rateLimitConfig  `json:"ratelimit"`
+
+  generic []providers.Provider
+  plugins []*providers.Plugin
+  rules   []*rule
+  reports []*report
+  routes  []providerRoute
+}
+
+// clientConfig is an API client; rate and burst override the -ratelimit
+// defaults for its key, tenant names the tenants entry it belongs to.
+type clientConfig struct {
+  Name   string  `json:"name"`
+  Key    string  `json:"key"`
+  Rate   float64 `json:"rate,omitempty"`
+  Burst  int     `json:"burst,omitempty"`
+  Tenant string  `json:"tenant,omitempty"`
+}
+
+// configErrors lists every problem found, each prefixed with where it is.
+type configErrors []string
+
+func (e configErrors) Error() string { return strings.Join(e, "\n") }
+
+func loadConfig(path string) (*config, error) {
+  c := &config{}
+  if path == "" {
+    return c, nil
+  }
+
+  raw, err := os.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+
+  dec := json.NewDecoder(bytes.NewReader(raw))
+  dec.DisallowUnknownFields()
+  if err := dec.Decode(c); err != nil {
+    return nil, fmt.Errorf("%s:%s", path, jsonErrorAt(raw, err))
+  }
+
+  if errs := c.compile(); len(errs) > 0 {
+    for i := range errs {
+      errs[i] = path + ": " + errs[i]
+    }
+
+    return nil, errs
+  }
+
+  return c, nil
+}
+
+// jsonErrorAt prefixes a decoding error with its line:col in raw.
+func jsonErrorAt(raw []byte, err error) string {
+  var offset int64 = -1
+  var syntax *json.SyntaxError
+  var typ *json.UnmarshalTypeError
+  switch {
+  case errors.As(err, &syntax):
+    offset = syntax.Offset
+  case errors.As(err, &typ):
+    offset = typ.Offset
+  }
+
+  if offset < 0 {
+    return " " + err.Error()
+  }
+
+  before := raw[:min(int(offset), len(raw))]
+  line := bytes.Count(before, []byte("\n")) + 1
+  col := len(before) - bytes.LastIndexByte(before, '\n')
+  return fmt.Sprintf("%d:%d: %s", line, col, err)
+}
+
+func (c *config) compile() configErrors {
+  var errs configErrors
+  add := func(where string, es []string) {
+    for _, e := range es {
+      errs = append(errs, where+"."+e)
+    }
+  }
+
+  tenants := make(map[string]bool)
+  for i := range c.Tenants {
+    tc := &c.Tenants[i]
+    where := fmt.Sprintf("tenants[%d]", i)
+    es := tc.compile()
+    if tenants[tc.Name] {
+      es = append(es, fmt.Sprintf("name: duplicate %q", tc.Name))
+    }
+
+    tenants[tc.Name] = true
+    add(where, es)
+  }
+
+  names := make(map[string]bool)
+  keys := make(map[string]bool)
+  for i, cl := range c.Clients {
+    where := fmt.Sprintf("clients[%d]", i)
+    switch {
+    case cl.Name == "":
+      add(where, []string{"name: is required"})
+    case len(cl.Key) < 16:
+      add(where, []string{"key: must be at least 16 characters"})
+    case names[cl.Name]:
+      add(where, []string{fmt.Sprintf("name: duplicate %q", cl.Name)})
+    case keys[cl.Key]:
+      add(where, []string{"key: is already used by another client"})
+    case cl.Rate < 0 || cl.Burst < 0:
+      add(where, []string{"rate: rate and burst can't be negative"})
+    case cl.Tenant != "" && !tenants[cl.Tenant]:
+      add(where, []string{fmt.Sprintf("tenant: %q is not in tenants", cl.Tenant)})
+    }
+
+    names[cl.Name], keys[cl.Key] = true, true
+  }
+
+  seen := make(map[string]bool)
+  for i, g := range c.Providers {
+    where := fmt.Sprintf("providers[%d]", i)
+    p, es := g.Compile()
+    if seen[g.Name] {
+      es = append(es, fmt.Sprintf("name: duplicate %q", g.Name))
+    }
+
+    seen[g.Name] = true
+    if add(where, es); len(es) == 0 {
+      c.generic = append(c.generic, p)
+    }
+  }
+
+  // Plugins share the provider namespace.
+  for i, pc := range c.Plugins {
+    where := fmt.Sprintf("plugins[%d]", i)
+    p, es := pc.Compile()
+    if seen[pc.Name] {
+      es = append(es, fmt.Sprintf("name: duplicate %q", pc.Name))
+    }
+
+    seen[pc.Name] = true
+    if add(where, es); len(es) == 0 {
+      c.plugins = append(c.plugins, p)
+    }
+  }
+
+  seen = make(map[string]bool)
+  for i, gc := range c.Groups {
+    where := fmt.Sprintf("groups[%d]", i)
+    var es []string
+    if !groupName.MatchString(gc.Name) {
+      es = append(es, fmt.Sprintf("name: %q must be lowercase letters, digits, '.', '_' or '-'", gc.Name))
+    }
+
+    if len(gc.Cities) == 0 {
+      es = append(es, "cities: needs at least one city")
+    }
+
+    for j, city := range gc.Cities {
+      if err := geo.ParseCity(city).Validate(); err != nil {
+        es = append(es, fmt.Sprintf("cities[%d]: %s", j, err))
+      }
+    }
+
+    if seen[gc.Name] {
+      es = append(es, fmt.Sprintf("name: duplicate %q", gc.Name))
+    }
+
+    seen[gc.Name] = true
+    add(where, es)
+  }
+
+  add("api_keys", compileKeys(c.APIKeys))
+  add("cache", c.Cache.compile())
+  if c.RateLimit != nil {
+    add("ratelimit", c.RateLimit.compile())
+  }
+
+  add("notifications", c.Notifications.compile())
+  add("base_urls", c.BaseURLs.compile())
+  add("storage", c.Storage.compile())
+
+  seen = make(map[string]bool)
+  for i, rc := range c.Rules {
+    where := fmt.Sprintf("rules[%d]", i)
+    r, es := rc.compile(c.Notifications)
+    if seen[rc.Name] {
+      es = append(es, fmt.Sprintf("name: duplicate %q", rc.Name))
+    }
+
+    seen[rc.Name] = true
+    if add(where, es); len(es) == 0 {
+      c.rules = append(c.rules, r)
+    }
+  }
+
+  seen = make(map[string]bool)
+  for i, rc := range c.Reports {
+    where := fmt.Sprintf("reports[%d]", i)
+    r, es := rc.compile(c.Notifications)
+    if seen[rc.Name] {
+      es = append(es, fmt.Sprintf("name: duplicate %q", rc.Name))
+    }
+
+    seen[rc.Name] = true
+    if add(where, es); len(es) == 0 {
+      c.reports = append(c.reports, r)
+    }
+  }
+
+  routed := make(map[string]int)
+  for i, rc := range c.Routing {
+    where := fmt.Sprintf("routing[%d]", i)
+    r, es := rc.compile()
+    for _, cc := range rc.Countries {
+      cc = strings.ToUpper(strings.TrimSpace(cc))
+      if j, ok := routed[cc]; ok && j != i {
+        es = append(es, fmt.Sprintf("countries: %s is already routed by routing[%d]", cc, j))
+      }
+
+      routed[cc] = i
+    }
+
+    if add(where, es); len(es) == 0 {
+      c.routes = append(c.routes, r)
+    }
+  }
+
+  return errs
+}
+
+var errNoClients = errors.New("-auth needs clients with keys in the -config file")
+
+// configCommand implements `weather-go -config=<file> config validate`.
+func configCommand(path string, args []string) error {
+  if path == "" || len(args) != 1 || args[0] != "validate" {
+    return errors.New("usage: weather-go -config=<file> config validate")
+  }
+
+  c, err := loadConfig(path)
+  if err != nil {
+    return err
+  }
+
+  fmt.Printf("%s: ok, %d clients, %d tenants, %d providers, %d plugins, %d groups, %d rules, %d reports\n", path, len(c.Clients), len(c.Tenants), len(c.generic), len(c.plugins), len(c.Groups), len(c.rules), len(c.reports))
+  return nil
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+  "fmt"
+  "net/http"
+  "net/url"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// Request headers browser front-ends send: the API key, bodies, the
+// validators of conditional requests and optimistic updates, and the keys
+// of retried POSTs.
+const corsHeaders = "X-API-Key, Authorization, Content-Type, If-Match, If-None-Match, Accept-Language, Idempotency-Key"
+
+// Response headers scripts may read besides the CORS-safelisted ones.
+const corsExposed = "ETag, Location, Retry-After, WWW-Authenticate, X-Signature, X-Signature-Key, Idempotent-Replayed"
+
+// cors lets browser front-ends on the allowed origins call the API. With
+// no origins configured it adds nothing and browsers keep blocking
+// cross-origin calls, as before.
+type cors struct {
+  any     bool
+  origins map[string]bool
+  methods string
+  maxAge  string
+}
+
+// newCORS reads -cors.origins, a comma-separated list of origins such as
+// https://dash.example.com, or "*" for any.
+func newCORS(origins, methods string, maxAge time.Duration) (*cors, error) {
+  c := &cors{origins: make(map[string]bool), maxAge: strconv.Itoa(int(maxAge.Seconds()))}
+  for _, o := range strings.Split(origins, ",") {
+    switch o = strings.TrimSpace(o); {
+    case o == "":
+    case o == "*":
+      c.any = true
+    default:
+      u, err := url.Parse(o)
+      if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+        return nil, fmt.Errorf("-cors.origins: want origins such as https://dash.example.com, got %q", o)
+      }
+
+      c.origins[u.Scheme+"://"+strings.ToLower(u.Host)] = true
+    }
+  }
+
+  var ms []string
+  for _, m := range strings.Split(methods, ",") {
+    if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+      ms = append(ms, m)
+    }
+  }
+
+  c.methods = strings.Join(ms, ", ")
+  return c, nil
+}
+
+func (c *cors) allowed(origin string) bool {
+  return c.any || c.origins[strings.ToLower(origin)]
+}
+
+// handle answers preflights itself, before authentication (browsers send
+// them without the API key), and marks other responses to allowed
+// origins as readable by them.
+func (c *cors) handle(h http.Handler) http.Handler {
+  if !c.any && len(c.origins) == 0 {
+    return h
+  }
+
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    origin := r.Header.Get("Origin")
+    if !c.any {
+      w.Header().Add("Vary", "Origin")
+    }
+
+    preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+    if origin == "" || !c.allowed(origin) {
+      if preflight && origin != "" {
+        httpError(w, "origin "+origin+" is not allowed, see -cors.origins", http.StatusForbidden)
+        return
+      }
+
+      h.ServeHTTP(w, r)
+      return
+    }
+
+    allow := origin
+    if c.any {
+      allow = "*"
+    }
+
+    w.Header().Set("Access-Control-Allow-Origin", allow)
+    if !preflight {
+      w.Header().Set("Access-Control-Expose-Headers", corsExposed)
+      h.ServeHTTP(w, r)
+      return
+    }
+
+    w.Header().Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+    w.Header().Set("Access-Control-Allow-Methods", c.methods)
+    w.Header().Set("Access-Control-Allow-Headers", corsHeaders)
+    w.Header().Set("Access-Control-Max-Age", c.maxAge)
+    w.WriteHeader(http.StatusNoContent)
+  })
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+  "bytes"
+  "crypto/sha256"
+  _ "embed"
+  "encoding/hex"
+  "net/http"
+  "time"
+)
+
+// The dashboard is one page with its script and styles inline, built on
+// the public JSON API only, so it shows nothing a client couldn't ask for.
+//
+//go:embed data/dashboard/index.html
+var dashboardPage []byte
+
+var dashboardETag = func() string {
+  sum := sha256.Sum256(dashboardPage)
+  return `"` + hex.EncodeToString(sum[:8]) + `"`
+}()
+
+// dashboard serves the page at /.
+func dashboard(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  w.Header().Set("Cache-Control", "no-cache")
+  w.Header().Set("ETag", dashboardETag)
+  w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; img-src 'self'")
+  http.ServeContent(w, r, "index.html", time.Time{}, bytes.NewReader(dashboardPage))
+}
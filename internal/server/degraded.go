@@ -0,0 +1,51 @@
+package server
+
+import (
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+)
+
+var degradedAnswers = metrics.NewCounter("degraded_responses_total", "Lookups answered while every provider failed, from an expired cache entry or the history, by source.", "source")
+
+// How far from a time a stored reading may be to stand for it in the day
+// before's curve.
+const historyMatch = 30 * time.Minute
+
+// extrapolate is loc's temperature now from its history, for when every
+// provider is down and the cache has nothing left: the last reading within
+// -degraded.history, moved along the curve of the day before since then if
+// the history goes back that far.
+func (s *server) extrapolate(loc geo.Location) (answer, bool) {
+  if s.degradedAge <= 0 {
+    return answer{}, false
+  }
+
+  now := time.Now()
+  rs, err := s.history.series(loc, now.Add(-s.degradedAge))
+  if err != nil || len(rs) == 0 {
+    return answer{}, false
+  }
+
+  last := rs[len(rs)-1]
+  kelvin := last.Kelvin
+  then, ok1 := s.historyNear(loc, last.Time.Add(-24*time.Hour))
+  today, ok2 := s.historyNear(loc, now.Add(-24*time.Hour))
+  if ok1 && ok2 {
+    kelvin += today.Kelvin - then.Kelvin
+  }
+
+  return answer{kelvin: kelvin, at: last.Time, count: len(last.Providers)}, true
+}
+
+// historyNear is loc's stored reading nearest t, within historyMatch.
+func (s *server) historyNear(loc geo.Location, t time.Time) (historyReading, bool) {
+  var rs []historyReading
+  s.history.scan(loc, t.Add(-historyMatch), t.Add(historyMatch), func(r historyReading) error {
+    rs = append(rs, r)
+    return nil
+  })
+
+  return nearestReading(rs, t, historyMatch)
+}
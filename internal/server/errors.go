@@ -0,0 +1,204 @@
+package server
+
+import (
+  "errors"
+  "fmt"
+  "net/http"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/aggregate"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// ErrorResponse is the body of every error the API answers with itself:
+// {"error": {"code": "CITY_NOT_FOUND", "message": "...", "status": 404}}.
+type ErrorResponse struct {
+  Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail says what went wrong, the code for programs and the message
+// for people.
+type ErrorDetail struct {
+  Code      string          `json:"code" doc:"stable and machine-readable, such as CITY_NOT_FOUND or UPSTREAM_FAILED"`
+  Message   string          `json:"message"`
+  Status    int             `json:"status"`
+  Providers []ProviderError `json:"providers,omitempty" doc:"the providers that failed, when the aggregate couldn't be produced"`
+  Outage    *Outage         `json:"outage,omitempty" doc:"set while most providers fail the same way, when the trouble is likely this server's network or theirs rather than the place asked for"`
+}
+
+// Outage is many providers failing for the same reason at once.
+type Outage struct {
+  Reason    upstream.Failure `json:"reason" doc:"dns, connect, timeout, server_error, auth, rate_limited, rejected or parse"`
+  Providers []string         `json:"providers"`
+  Since     time.Time        `json:"since"`
+}
+
+// ProviderError is one provider's failure.
+type ProviderError struct {
+  Provider string `json:"provider"`
+  Code     string `json:"code"`
+  Message  string `json:"message"`
+}
+
+// errorCodes name the errors clients may want to tell apart, most specific
+// first; anything else is named by its status.
+var errorCodes = []struct {
+  err  error
+  code string
+}{
+  {errNoLocation, "LOCATION_REQUIRED"},
+  {geo.ErrBadCoordinates, "BAD_COORDINATES"},
+  {geo.ErrBadCity, "BAD_CITY"},
+  {geo.ErrLocationNotFound, "CITY_NOT_FOUND"},
+  {providers.ErrCityNotFound, "CITY_NOT_FOUND"},
+  {errBudgetExhausted, "BUDGET_EXHAUSTED"},
+  {errQuotaExhausted, "QUOTA_EXHAUSTED"},
+  {errCircuitsOpen, "PROVIDERS_UNAVAILABLE"},
+  {errNotRouted, "NOT_ROUTED"},
+  {providers.ErrNoProviders, "NO_PROVIDERS"},
+  {errNoDescribers, "NO_PROVIDERS"},
+  {aggregate.ErrAllExcluded, "READINGS_EXCLUDED"},
+  {providers.ErrUnauthorized, "UPSTREAM_UNAUTHORIZED"},
+  {providers.ErrRateLimited, "UPSTREAM_RATE_LIMITED"},
+  {upstream.ErrBusy, "UPSTREAM_BUSY"},
+  {providers.ErrCancelled, "CANCELLED"},
+}
+
+// failureCodes name upstream failures the errors above don't.
+var failureCodes = map[upstream.Failure]string{
+  upstream.FailureDNS:         "UPSTREAM_DNS",
+  upstream.FailureConnect:     "UPSTREAM_UNREACHABLE",
+  upstream.FailureTimeout:     "UPSTREAM_TIMEOUT",
+  upstream.FailureServer:      "UPSTREAM_SERVER_ERROR",
+  upstream.FailureAuth:        "UPSTREAM_UNAUTHORIZED",
+  upstream.FailureRateLimited: "UPSTREAM_RATE_LIMITED",
+  upstream.FailureRejected:    "UPSTREAM_REJECTED",
+  upstream.FailureParse:       "UPSTREAM_BAD_RESPONSE",
+}
+
+var statusCodes = map[int]string{
+  http.StatusBadRequest:            "BAD_REQUEST",
+  http.StatusUnauthorized:          "UNAUTHORIZED",
+  http.StatusForbidden:             "FORBIDDEN",
+  http.StatusNotFound:              "NOT_FOUND",
+  http.StatusMethodNotAllowed:      "METHOD_NOT_ALLOWED",
+  http.StatusConflict:              "CONFLICT",
+  http.StatusGone:                  "GONE",
+  http.StatusPreconditionFailed:    "PRECONDITION_FAILED",
+  http.StatusUnprocessableEntity:   "INVALID",
+  http.StatusPreconditionRequired:  "PRECONDITION_REQUIRED",
+  http.StatusRequestEntityTooLarge: "TOO_LARGE",
+  http.StatusRequestURITooLong:     "URI_TOO_LONG",
+  http.StatusUnsupportedMediaType:  "UNSUPPORTED_MEDIA_TYPE",
+  http.StatusNotAcceptable:         "NOT_ACCEPTABLE",
+  http.StatusTooManyRequests:       "RATE_LIMITED",
+  http.StatusInternalServerError:   "INTERNAL",
+  http.StatusNotImplemented:        "NOT_IMPLEMENTED",
+  http.StatusBadGateway:            "UPSTREAM_FAILED",
+  http.StatusServiceUnavailable:    "UNAVAILABLE",
+  http.StatusGatewayTimeout:        "TIMEOUT",
+}
+
+func statusCode(status int) string {
+  if code, ok := statusCodes[status]; ok {
+    return code
+  }
+
+  if status >= 500 {
+    return "INTERNAL"
+  }
+
+  return "BAD_REQUEST"
+}
+
+// errorCode is err's code, or status's for errors without their own.
+func errorCode(err error, status int) string {
+  var amb *geo.AmbiguousError
+  if errors.As(err, &amb) {
+    return "AMBIGUOUS_CITY"
+  }
+
+  for _, c := range errorCodes {
+    if errors.Is(err, c.err) {
+      return c.code
+    }
+  }
+
+  if code, ok := failureCodes[providers.Failure(err)]; ok {
+    return code
+  }
+
+  return statusCode(status)
+}
+
+// providerErrors are the failed readings of rs.
+func providerErrors(rs []providers.Reading) []ProviderError {
+  var out []ProviderError
+  for _, r := range rs {
+    if r.Error != "" {
+      out = append(out, ProviderError{Provider: r.Provider, Code: errorCode(r.Err, http.StatusBadGateway), Message: r.Error})
+    }
+  }
+
+  return out
+}
+
+func writeErrorDetail(w http.ResponseWriter, d ErrorDetail) {
+  w.Header().Del("Content-Length")
+  w.Header().Set("X-Content-Type-Options", "nosniff")
+  writeJSON(w, d.Status, ErrorResponse{Error: d})
+}
+
+// errorDetail is msg coded by status, for bodies that carry more than the
+// error, like the current version of a conflicting write.
+func errorDetail(msg string, status int) ErrorDetail {
+  return ErrorDetail{Code: statusCode(status), Message: msg, Status: status}
+}
+
+// httpError is http.Error with a JSON body, coded by status.
+func httpError(w http.ResponseWriter, msg string, status int) {
+  writeErrorDetail(w, errorDetail(msg, status))
+}
+
+// muxErrors answers what mux itself turns away, unknown paths with 404 and
+// wrong methods with 405 and its Allow header, in the error envelope rather
+// than as plain text.
+func muxErrors(mux *http.ServeMux) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if _, pattern := mux.Handler(r); pattern != "" {
+      mux.ServeHTTP(w, r)
+      return
+    }
+
+    rec := &statusRecorder{header: make(http.Header)}
+    mux.ServeHTTP(rec, r)
+    if allow := rec.header.Get("Allow"); allow != "" {
+      w.Header().Set("Allow", allow)
+    }
+
+    httpError(w, fmt.Sprintf("no %s %s here", r.Method, r.URL.Path), rec.status)
+  })
+}
+
+// statusRecorder keeps only the status and headers of a response.
+type statusRecorder struct {
+  header http.Header
+  status int
+}
+
+func (rec *statusRecorder) Header() http.Header         { return rec.header }
+func (rec *statusRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (rec *statusRecorder) WriteHeader(status int)      { rec.status = status }
+
+// writeError answers with err, coded by what it is.
+func writeError(w http.ResponseWriter, err error, status int) {
+  writeErrorDetail(w, ErrorDetail{Code: errorCode(err, status), Message: err.Error(), Status: status})
+}
+
+// writeFailedLookup answers with a lookup's failure and the providers
+// behind it.
+func writeFailedLookup(w http.ResponseWriter, resp *TemperatureResponse) {
+  writeErrorDetail(w, ErrorDetail{Code: resp.Code, Message: resp.Error, Status: resp.Status, Providers: resp.Failures, Outage: resp.Outage})
+}
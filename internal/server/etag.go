@@ -0,0 +1,40 @@
+package server
+
+import (
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "net/http"
+  "strings"
+)
+
+// readingETag is a weak validator of a lookup answer in format: the hash
+// of what the client gets, minus the fields that change on every request
+// without the reading changing, so a dashboard polling a cached reading
+// gets 304s until the reading itself changes.
+func readingETag(resp *TemperatureResponse, format string) string {
+  stable := *resp
+  stable.Took, stable.Cached, stable.Age, stable.Cache = "", false, "", nil
+  stable.LocalTime, stable.DataAge = nil, ""
+
+  b, err := json.Marshal(stable)
+  if err != nil {
+    return ""
+  }
+
+  sum := sha256.Sum256(append([]byte(format+"\n"), b...))
+  return `W/"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// notModified reports whether If-None-Match names etag; as for GET,
+// weak and strong tags compare the same.
+func notModified(r *http.Request, etag string) bool {
+  for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+    tag = strings.TrimSpace(tag)
+    if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+      return true
+    }
+  }
+
+  return false
+}
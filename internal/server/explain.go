@@ -0,0 +1,165 @@
+package server
+
+import (
+  "context"
+  "fmt"
+  "strings"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/aggregate"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+// explanation is the ?explain=true trace of one aggregate: who was asked,
+// what each said, what was left out and why, and the arithmetic from the
+// readings to the number in the response. Output policies apply to it as
+// to everything else, so a withheld value stays withheld here too.
+type explanation struct {
+  Skipped   map[string]string  `json:"skipped,omitempty"` // provider: why it wasn't asked
+  Providers []explainedReading `json:"providers"`
+  MaxAge    string             `json:"max_age,omitempty"` // of readings averaged, see -readings.max.age
+  Outliers  explainedOutliers  `json:"outliers"`
+  Weighting string             `json:"weighting"` // static or dynamic
+  Math      *explainedMath     `json:"math,omitempty"`
+  Rounding  float64            `json:"rounding,omitempty"` // kelvin step required by output policies
+  Smoothing *explainedSmooth   `json:"smoothing,omitempty"`
+  Result    *float64           `json:"result,omitempty"`
+  Error     string             `json:"error,omitempty"`
+}
+
+type explainedReading struct {
+  Provider  string   `json:"provider"`
+  Kelvin    *float64 `json:"temp,omitempty"`
+  Error     string   `json:"error,omitempty"`
+  Withheld  string   `json:"withheld,omitempty"`
+  Carried   bool     `json:"carried,omitempty"`
+  Cached    bool     `json:"cached,omitempty"`
+  Excluded  string   `json:"excluded,omitempty"`
+  Static    float64  `json:"static_weight"`
+  Deviation *float64 `json:"deviation,omitempty"` // recent distance from the consensus, K
+  Weight    float64  `json:"weight"`
+  Share     float64  `json:"share"` // of the total weight; 0 when left out
+}
+
+type explainedOutliers struct {
+  Decision string   `json:"decision,omitempty"` // see aggregate.Outliers.Exclude
+  Median   *float64 `json:"median,omitempty"`
+  Kelvin   float64  `json:"kelvin_limit,omitempty"`
+  Sigma    float64  `json:"sigma_limit,omitempty"`
+}
+
+type explainedMath struct {
+  Formula     string  `json:"formula"`
+  TotalWeight float64 `json:"total_weight"`
+  Mean        float64 `json:"mean"`
+}
+
+type explainedSmooth struct {
+  Alpha    float64 `json:"alpha"`
+  Raw      float64 `json:"raw"`
+  Smoothed float64 `json:"smoothed"`
+}
+
+// explain traces an upstream aggregate from its readings, as fetch left
+// them: outliers marked, weights assigned.
+func (s *server) explain(ctx context.Context, loc geo.Location, rs []providers.Reading, outliers string, exhausted []string, kelvin float64, err error, active providers.Multi) *explanation {
+  e := &explanation{Outliers: explainedOutliers{Decision: outliers, Kelvin: s.outliers.Kelvin, Sigma: s.outliers.Sigma}, Weighting: "static"}
+  if s.staleness.MaxAge > 0 {
+    e.MaxAge = s.staleness.MaxAge.String()
+  }
+  if s.weights.Dynamic() {
+    e.Weighting = "dynamic"
+  }
+
+  e.Skipped = make(map[string]string)
+  asked := make(map[string]bool, len(active))
+  for _, p := range active {
+    asked[p.Name()] = true
+  }
+
+  for _, name := range exhausted {
+    e.Skipped[name] = "quota exhausted"
+    asked[name] = true
+  }
+
+  route, routed := s.routeFor(loc)
+  t := s.tenantFor(ctx)
+  for _, p := range s.providers.get() {
+    switch {
+    case asked[p.Name()]:
+    case s.providers.isShadow(p.Name()):
+      e.Skipped[p.Name()] = "shadow, only compared with the answer"
+    case t != nil && t.enabled != nil && !t.enabled[p.Name()]:
+      e.Skipped[p.Name()] = "not enabled for tenant " + t.name
+    case routed && !route.allows(p.Name()):
+      e.Skipped[p.Name()] = "not routed to " + strings.ToUpper(loc.Country)
+    case s.healthFor(ctx).open(p.Name()):
+      e.Skipped[p.Name()] = "circuit open"
+    default:
+      e.Skipped[p.Name()] = "disabled by override"
+    }
+  }
+
+  shown := s.policies.readings(rs)
+
+  var total float64
+  var values []float64
+  withheld := false
+  for i, r := range rs {
+    if r.Error == "" {
+      values = append(values, r.Kelvin)
+      withheld = withheld || shown[i].Withheld != ""
+      if r.Excluded == "" {
+        total += r.Weight
+      }
+    }
+  }
+
+  // The median could be a withheld provider's own value.
+  if len(values) > 0 && outliers != "off" && !withheld {
+    med := roundTo(aggregate.Median(values), s.policies.step(active))
+    e.Outliers.Median = &med
+  }
+
+  var terms []string
+  for _, r := range shown {
+    static, dev := s.weights.BasisIn(s.learner.region(loc), r.Provider)
+    er := explainedReading{Provider: r.Provider, Error: r.Error, Withheld: r.Withheld, Carried: r.Carried, Cached: r.Cached, Excluded: r.Excluded, Static: static, Weight: r.Weight}
+    if r.Error == "" && r.Withheld == "" {
+      k := r.Kelvin
+      er.Kelvin = &k
+    }
+
+    if s.weights.Dynamic() {
+      er.Deviation = &dev
+    }
+
+    if r.Error == "" && r.Excluded == "" && total > 0 {
+      er.Share = r.Weight / total
+      value := "withheld"
+      if er.Kelvin != nil {
+        value = fmt.Sprintf("%.2f", *er.Kelvin)
+      }
+
+      terms = append(terms, fmt.Sprintf("%.3g×%s", r.Weight, value))
+    }
+
+    e.Providers = append(e.Providers, er)
+  }
+
+  if err != nil {
+    e.Error = err.Error()
+    return e
+  }
+
+  e.Rounding = s.policies.step(active)
+  mean := s.policies.aggregate(kelvin, active)
+  e.Math = &explainedMath{
+    Formula:     fmt.Sprintf("(%s) / %.3g", strings.Join(terms, " + "), total),
+    TotalWeight: total,
+    Mean:        mean,
+  }
+
+  e.Result = &mean
+  return e
+}
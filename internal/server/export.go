@@ -0,0 +1,158 @@
+package server
+
+import (
+  "encoding/csv"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "log"
+  "net/http"
+  "strconv"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// Readings written between flushes, so a client sees an export arrive as
+// it is read rather than all at the end.
+const exportFlushEvery = 500
+
+// export serves GET /v1/export/{city}?from=...&to=... (or ?lat=&lon=): the
+// stored readings in the span, oldest first, as CSV or with ?format=ndjson
+// as a JSON object per line. It is written as the store is read, chunked,
+// however long the span; from defaults to the oldest reading kept, to to
+// now.
+func (s *server) export(w http.ResponseWriter, r *http.Request) {
+  format := r.URL.Query().Get("format")
+  switch format {
+  case "":
+    format = "csv"
+  case "csv", "ndjson":
+  default:
+    httpError(w, fmt.Sprintf("unknown format %q, want csv or ndjson", format), http.StatusBadRequest)
+    return
+  }
+
+  from, err := exportTime(r.URL.Query().Get("from"), time.Time{})
+  if err != nil {
+    httpError(w, "from "+err.Error(), http.StatusBadRequest)
+    return
+  }
+
+  to, err := exportTime(r.URL.Query().Get("to"), time.Now())
+  if err != nil {
+    httpError(w, "to "+err.Error(), http.StatusBadRequest)
+    return
+  }
+
+  if !from.Before(to) {
+    httpError(w, "from must be before to", http.StatusBadRequest)
+    return
+  }
+
+  loc, err := requestLocation(upstream.WithTrace(r), r, s.geo)
+  var amb *geo.AmbiguousError
+  if errors.As(err, &amb) {
+    writeCandidates(w, amb)
+    return
+  }
+
+  if err != nil {
+    writeError(w, err, locationStatus(err))
+    return
+  }
+
+  // CSV columns must be known before the first line: the providers that
+  // may be shown. NDJSON lines carry whichever a reading has.
+  var names []string
+  for _, p := range s.providers.get() {
+    if !s.policies[p.Name()].aggregateOnly {
+      names = append(names, p.Name())
+    }
+  }
+
+  var write func(historyReading) error
+  cw := csv.NewWriter(w)
+  switch format {
+  case "csv":
+    w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+    header := []string{"city", "lat", "lon", "time", "temp"}
+    for _, name := range names {
+      header = append(header, "providers."+name)
+    }
+
+    cw.Write(header)
+    lat, lon := strconv.FormatFloat(loc.Lat, 'f', -1, 64), strconv.FormatFloat(loc.Lon, 'f', -1, 64)
+    write = func(hr historyReading) error {
+      line := []string{loc.Name, lat, lon, hr.Time.Format(time.RFC3339Nano), strconv.FormatFloat(hr.Kelvin, 'f', -1, 64)}
+      for _, name := range names {
+        v := ""
+        if k, ok := hr.Providers[name]; ok {
+          v = strconv.FormatFloat(k, 'f', -1, 64)
+        }
+
+        line = append(line, v)
+      }
+
+      return cw.Write(line)
+    }
+  case "ndjson":
+    w.Header().Set("Content-Type", "application/x-ndjson")
+    enc := json.NewEncoder(w)
+    write = func(hr historyReading) error {
+      return enc.Encode(historyRow{City: loc.Name, Lat: loc.Lat, Lon: loc.Lon, historyReading: hr})
+    }
+  }
+
+  w.WriteHeader(http.StatusOK)
+
+  rc := http.NewResponseController(w)
+  flush := func() {
+    cw.Flush()
+    rc.Flush()
+  }
+
+  n, now := 0, time.Now()
+  err = s.history.scan(loc, from, to, func(hr historyReading) error {
+    if err := r.Context().Err(); err != nil {
+      return err
+    }
+
+    if err := write(s.policies.history([]historyReading{hr}, now)[0]); err != nil {
+      return err
+    }
+
+    if n++; n%exportFlushEvery == 0 {
+      flush()
+    }
+
+    return nil
+  })
+
+  flush()
+
+  // The status is sent; all that is left is to end the body short.
+  if err != nil {
+    log.Printf("export: %s: stopped after %d readings: %s", loc.Name, n, err)
+  }
+}
+
+// exportTime is a from or to: RFC 3339, or a date for its midnight UTC;
+// empty is otherwise.
+func exportTime(v string, otherwise time.Time) (time.Time, error) {
+  if v == "" {
+    return otherwise, nil
+  }
+
+  if t, err := time.Parse(time.RFC3339, v); err == nil {
+    return t, nil
+  }
+
+  t, err := time.Parse(time.DateOnly, v)
+  if err != nil {
+    return time.Time{}, fmt.Errorf("wants RFC 3339 or YYYY-MM-DD, got %q", v)
+  }
+
+  return t, nil
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+  "context"
+  "log"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+)
+
+var (
+  exportedTemperature = metrics.NewGauge("weather_temperature_kelvin", "Temperature of an exported city by one provider.", "city", "provider")
+  exportedAverage     = metrics.NewGauge("weather_temperature_average_kelvin", "Aggregate temperature of an exported city, as /v1/weather answers it.", "city")
+  exportedUpdated     = metrics.NewGauge("weather_temperature_updated_timestamp_seconds", "When an exported city's providers were last asked.", "city")
+  exportRefreshes     = metrics.NewCounter("exporter_refreshes_total", "Refreshes of exported cities, by result.", "result")
+)
+
+// exporter polls the -exporter.city places and keeps their temperatures
+// on /metrics, one series per provider, so Prometheus can scrape the
+// weather itself. Cities are labelled as configured, not as geocoded, so
+// the series stay put if a geocoder renames a place. A provider that
+// fails or is withheld loses its series until it answers again, instead
+// of repeating its last value.
+type exporter struct {
+  srv      *server
+  cities   []string
+  interval time.Duration
+
+  mu    sync.Mutex
+  alive map[string]map[string]bool
+}
+
+func newExporter(srv *server, cities []string, interval time.Duration) *exporter {
+  return &exporter{srv: srv, cities: cities, interval: interval, alive: make(map[string]map[string]bool)}
+}
+
+// run subscribes to every city for good, sharing a poller with anyone
+// streaming it.
+func (e *exporter) run() {
+  for _, city := range e.cities {
+    go e.watch(city)
+  }
+}
+
+func (e *exporter) watch(city string) {
+  loc, ok := e.resolve(city)
+  for !ok {
+    time.Sleep(e.interval)
+    loc, ok = e.resolve(city)
+  }
+
+  updates, _ := e.srv.feeds.subscribe(loc, e.interval, readings)
+  for resp := range updates {
+    e.refresh(city, resp)
+  }
+}
+
+func (e *exporter) resolve(city string) (geo.Location, bool) {
+  ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+  defer cancel()
+
+  loc, err := geo.Resolve(ctx, e.srv.geo, geo.ParseCity(city))
+  if err != nil {
+    log.Printf("exporter: %s: %s", city, err)
+    exportRefreshes.Inc("error")
+    return geo.Location{}, false
+  }
+
+  return loc, true
+}
+
+// refresh shows what the providers said about city, asked bypassing the
+// caches so every provider is heard from once per interval.
+func (e *exporter) refresh(city string, resp *TemperatureResponse) {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+
+  alive := make(map[string]bool)
+  for _, p := range resp.Providers {
+    if p.Temp != nil && p.Error == "" {
+      exportedTemperature.Set(*p.Temp, city, p.Provider)
+      alive[p.Provider] = true
+    }
+  }
+
+  for name := range e.alive[city] {
+    if !alive[name] {
+      exportedTemperature.Delete(city, name)
+    }
+  }
+
+  e.alive[city] = alive
+  exportedUpdated.Set(float64(time.Now().Unix()), city)
+
+  if resp.Error != "" || resp.Temp == nil {
+    log.Printf("exporter: %s: %s", city, resp.Error)
+    exportedAverage.Delete(city)
+    exportRefreshes.Inc("error")
+    return
+  }
+
+  exportedAverage.Set(*resp.Temp, city)
+  exportRefreshes.Inc("ok")
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+  "unicode"
+)
+
+// ruleExpr is a compiled alert condition such as "temp_c < 0 and temp_c > -30".
+// The language is deliberately small: numbers, the reading's variables,
+// comparisons, and/or/not and parentheses, type-checked at compile time so a
+// rule can't fail when it is first evaluated.
+type ruleExpr struct {
+  src  string
+  root exprNode
+}
+
+// ruleVars are the variables a rule can refer to.
+var ruleVars = map[string]bool{"temp_k": true, "temp_c": true, "temp_f": true}
+
+type exprNode interface {
+  boolean() bool // type, fixed at compile time
+  eval(vars map[string]float64) float64
+}
+
+// exprError locates a compile error in the expression, 1-based.
+type exprError struct {
+  col int
+  msg string
+}
+
+func (e *exprError) Error() string { return fmt.Sprintf("col %d: %s", e.col, e.msg) }
+
+type token struct {
+  col  int
+  kind string // num, ident, op, (, ), eof
+  text string
+}
+
+func lexExpr(src string) ([]token, error) {
+  var ts []token
+  for i := 0; i < len(src); {
+    c := rune(src[i])
+    switch {
+    case unicode.IsSpace(c):
+      i++
+    case c == '(' || c == ')':
+      ts = append(ts, token{col: i + 1, kind: string(c), text: string(c)})
+      i++
+    case strings.ContainsRune("<>=!", c):
+      op := string(c)
+      if i+1 < len(src) && src[i+1] == '=' {
+        op += "="
+      }
+
+      if op == "=" || op == "!" {
+        return nil, &exprError{i + 1, fmt.Sprintf("unknown operator %q, want == or !=", op)}
+      }
+
+      ts = append(ts, token{col: i + 1, kind: "op", text: op})
+      i += len(op)
+    case c == '-' || c == '.' || unicode.IsDigit(c):
+      j := i + 1
+      for j < len(src) && (src[j] == '.' || unicode.IsDigit(rune(src[j]))) {
+        j++
+      }
+
+      if _, err := strconv.ParseFloat(src[i:j], 64); err != nil {
+        return nil, &exprError{i + 1, fmt.Sprintf("bad number %q", src[i:j])}
+      }
+
+      ts = append(ts, token{col: i + 1, kind: "num", text: src[i:j]})
+      i = j
+    case unicode.IsLetter(c) || c == '_':
+      j := i + 1
+      for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+        j++
+      }
+
+      ts = append(ts, token{col: i + 1, kind: "ident", text: src[i:j]})
+      i = j
+    default:
+      return nil, &exprError{i + 1, fmt.Sprintf("unexpected %q", c)}
+    }
+  }
+
+  return append(ts, token{col: len(src) + 1, kind: "eof"}), nil
+}
+
+type exprParser struct {
+  ts  []token
+  pos int
+}
+
+func (p *exprParser) peek() token { return p.ts[p.pos] }
+
+func (p *exprParser) next() token {
+  t := p.ts[p.pos]
+  if t.kind != "eof" {
+    p.pos++
+  }
+
+  return t
+}
+
+func compileExpr(src string) (*ruleExpr, error) {
+  ts, err := lexExpr(src)
+  if err != nil {
+    return nil, err
+  }
+
+  p := &exprParser{ts: ts}
+  root, err := p.or()
+  if err != nil {
+    return nil, err
+  }
+
+  if t := p.peek(); t.kind != "eof" {
+    return nil, &exprError{t.col, fmt.Sprintf("unexpected %q", t.text)}
+  }
+
+  if !root.boolean() {
+    return nil, &exprError{1, "condition must compare, e.g. temp_c < 0"}
+  }
+
+  return &ruleExpr{src: src, root: root}, nil
+}
+
+func (p *exprParser) or() (exprNode, error) {
+  return p.logical("or", p.and)
+}
+
+func (p *exprParser) and() (exprNode, error) {
+  return p.logical("and", p.not)
+}
+
+func (p *exprParser) logical(op string, operand func() (exprNode, error)) (exprNode, error) {
+  left, err := operand()
+  if err != nil {
+    return nil, err
+  }
+
+  for p.peek().kind == "ident" && p.peek().text == op {
+    t := p.next()
+    right, err := operand()
+    if err != nil {
+      return nil, err
+    }
+
+    if !left.boolean() || !right.boolean() {
+      return nil, &exprError{t.col, op + " needs conditions on both sides"}
+    }
+
+    left = logicNode{op: op, left: left, right: right}
+  }
+
+  return left, nil
+}
+
+func (p *exprParser) not() (exprNode, error) {
+  if t := p.peek(); t.kind == "ident" && t.text == "not" {
+    p.next()
+    n, err := p.not()
+    if err != nil {
+      return nil, err
+    }
+
+    if !n.boolean() {
+      return nil, &exprError{t.col, "not needs a condition"}
+    }
+
+    return notNode{n}, nil
+  }
+
+  return p.comparison()
+}
+
+func (p *exprParser) comparison() (exprNode, error) {
+  left, err := p.operand()
+  if err != nil {
+    return nil, err
+  }
+
+  if p.peek().kind != "op" {
+    return left, nil
+  }
+
+  t := p.next()
+  right, err := p.operand()
+  if err != nil {
+    return nil, err
+  }
+
+  if left.boolean() || right.boolean() {
+    return nil, &exprError{t.col, t.text + " compares numbers, not conditions"}
+  }
+
+  return cmpNode{op: t.text, left: left, right: right}, nil
+}
+
+func (p *exprParser) operand() (exprNode, error) {
+  t := p.next()
+  switch {
+  case t.kind == "num":
+    v, _ := strconv.ParseFloat(t.text, 64)
+    return numNode(v), nil
+  case t.kind == "ident" && ruleVars[t.text]:
+    return varNode(t.text), nil
+  case t.kind == "ident" && (t.text == "and" || t.text == "or" || t.text == "not"):
+    return nil, &exprError{t.col, "expected a value before " + t.text}
+  case t.kind == "ident":
+    return nil, &exprError{t.col, fmt.Sprintf("unknown variable %q, want temp_k, temp_c or temp_f", t.text)}
+  case t.kind == "(":
+    n, err := p.or()
+    if err != nil {
+      return nil, err
+    }
+
+    if c := p.next(); c.kind != ")" {
+      return nil, &exprError{c.col, "missing )"}
+    }
+
+    return n, nil
+  case t.kind == "eof":
+    return nil, &exprError{t.col, "unexpected end of condition"}
+  default:
+    return nil, &exprError{t.col, fmt.Sprintf("unexpected %q", t.text)}
+  }
+}
+
+type numNode float64
+
+func (n numNode) boolean() bool                   { return false }
+func (n numNode) eval(map[string]float64) float64 { return float64(n) }
+
+type varNode string
+
+func (n varNode) boolean() bool                        { return false }
+func (n varNode) eval(vars map[string]float64) float64 { return vars[string(n)] }
+
+func truth(b bool) float64 {
+  if b {
+    return 1
+  }
+
+  return 0
+}
+
+type cmpNode struct {
+  op          string
+  left, right exprNode
+}
+
+func (n cmpNode) boolean() bool { return true }
+
+func (n cmpNode) eval(vars map[string]float64) float64 {
+  l, r := n.left.eval(vars), n.right.eval(vars)
+  switch n.op {
+  case "<":
+    return truth(l < r)
+  case "<=":
+    return truth(l <= r)
+  case ">":
+    return truth(l > r)
+  case ">=":
+    return truth(l >= r)
+  case "==":
+    return truth(l == r)
+  default:
+    return truth(l != r)
+  }
+}
+
+type logicNode struct {
+  op          string
+  left, right exprNode
+}
+
+func (n logicNode) boolean() bool { return true }
+
+func (n logicNode) eval(vars map[string]float64) float64 {
+  l := n.left.eval(vars) != 0
+  if n.op == "and" {
+    return truth(l && n.right.eval(vars) != 0)
+  }
+
+  return truth(l || n.right.eval(vars) != 0)
+}
+
+type notNode struct{ n exprNode }
+
+func (n notNode) boolean() bool                        { return true }
+func (n notNode) eval(vars map[string]float64) float64 { return truth(n.n.eval(vars) == 0) }
+
+// holds evaluates the condition for a temperature in kelvin.
+func (e *ruleExpr) holds(kelvin float64) bool {
+  c := kelvin - 273.15
+  return e.root.eval(map[string]float64{"temp_k": kelvin, "temp_c": c, "temp_f": c*9/5 + 32}) != 0
+}
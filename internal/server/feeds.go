@@ -0,0 +1,181 @@
+package server
+
+import (
+  "context"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+)
+
+var feedPollers = metrics.NewGauge("stream_pollers", "Background pollers, one per streamed or exported place.")
+
+// feeds counts who is subscribed to each place, streams and the exporter
+// alike, and runs one poller per place with any subscriber, fanning each
+// reading out to all of them, so N consumers of a city cost one upstream
+// fetch per interval. A poller polls as often as its most eager subscriber
+// asks, and more often the more subscribers share it, down to
+// -stream.interval.min; it stops when its last subscriber leaves.
+type feeds struct {
+  srv      *server
+  interval time.Duration // streams'
+  floor    time.Duration
+
+  mu      sync.Mutex
+  pollers map[string]*poller
+}
+
+type poller struct {
+  loc    geo.Location
+  subs   map[*feedSub]struct{}
+  last   *TemperatureResponse
+  detail detailLevel   // of last
+  retime chan struct{} // subscribers came or went
+  cancel context.CancelFunc
+}
+
+// feedSub is one subscriber: how often and in how much detail it wants
+// readings.
+type feedSub struct {
+  ch     chan *TemperatureResponse
+  every  time.Duration
+  detail detailLevel
+}
+
+func newFeeds(srv *server, interval, floor time.Duration) *feeds {
+  return &feeds{srv: srv, interval: interval, floor: floor, pollers: make(map[string]*poller)}
+}
+
+// subscribe returns a channel of readings for loc, fetched bypassing the
+// caches at least every every. The latest reading, if the poller already
+// has one in enough detail, is delivered right away.
+func (h *feeds) subscribe(loc geo.Location, every time.Duration, detail detailLevel) (<-chan *TemperatureResponse, func()) {
+  sub := &feedSub{ch: make(chan *TemperatureResponse, 1), every: every, detail: detail}
+  key := loc.Key()
+
+  h.mu.Lock()
+  p, ok := h.pollers[key]
+  if !ok {
+    ctx, cancel := context.WithCancel(context.Background())
+    p = &poller{loc: loc, subs: make(map[*feedSub]struct{}), retime: make(chan struct{}, 1), cancel: cancel}
+    h.pollers[key] = p
+    go h.poll(ctx, p)
+  }
+
+  p.subs[sub] = struct{}{}
+  if p.last != nil && p.detail >= detail {
+    sub.deliver(p.last)
+  }
+
+  p.changed()
+  feedPollers.Set(float64(len(h.pollers)))
+  h.mu.Unlock()
+
+  return sub.ch, func() {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    delete(p.subs, sub)
+    if len(p.subs) == 0 {
+      p.cancel()
+      delete(h.pollers, key)
+    }
+
+    p.changed()
+    feedPollers.Set(float64(len(h.pollers)))
+  }
+}
+
+func (p *poller) changed() {
+  select {
+  case p.retime <- struct{}{}:
+  default:
+  }
+}
+
+// every is how long p waits between fetches: the shortest interval its
+// subscribers ask for, shared out among them, but not below the floor
+// unless a subscriber asks for less.
+func (h *feeds) every(p *poller) time.Duration {
+  var every time.Duration
+  for sub := range p.subs {
+    if every == 0 || sub.every < every {
+      every = sub.every
+    }
+  }
+
+  return max(every/time.Duration(max(len(p.subs), 1)), min(h.floor, every))
+}
+
+// wanted is the most detail any of p's subscribers wants.
+func (p *poller) wanted() detailLevel {
+  detail := summary
+  for sub := range p.subs {
+    detail = max(detail, sub.detail)
+  }
+
+  return detail
+}
+
+func (h *feeds) poll(ctx context.Context, p *poller) {
+  for {
+    h.mu.Lock()
+    detail := p.wanted()
+    h.mu.Unlock()
+
+    reading := h.srv.fetch(ctx, p.loc, detail, true)
+    if ctx.Err() != nil {
+      return
+    }
+
+    polled := time.Now()
+    now := polled.UTC()
+    reading.Time = &now
+
+    h.mu.Lock()
+    p.last, p.detail = reading, detail
+    for sub := range p.subs {
+      sub.deliver(reading)
+    }
+    h.mu.Unlock()
+
+    // Subscribers coming or going change the interval, counted from the
+    // last fetch.
+    for waiting := true; waiting; {
+      h.mu.Lock()
+      t := time.NewTimer(time.Until(polled.Add(h.every(p))))
+      h.mu.Unlock()
+
+      select {
+      case <-ctx.Done():
+        t.Stop()
+        return
+      case <-p.retime:
+        t.Stop()
+      case <-t.C:
+        waiting = false
+      }
+    }
+  }
+}
+
+// deliver hands sub its copy of r, without what it didn't ask for.
+func (sub *feedSub) deliver(r *TemperatureResponse) {
+  c := *r
+  if sub.detail < readings {
+    c.Providers = nil
+  }
+
+  if sub.detail < explained {
+    c.Explain = nil
+  }
+
+  // Slow subscribers skip to the newest reading instead of blocking the rest.
+  select {
+  case <-sub.ch:
+  default:
+  }
+
+  sub.ch <- &c
+}
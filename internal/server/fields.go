@@ -0,0 +1,145 @@
+package server
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "net/http"
+  "slices"
+  "sort"
+  "strings"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// fieldSource is the part of a lookup that produces a field.
+type fieldSource int
+
+const (
+  fromReadings   fieldSource = iota // the temperature fan-out
+  fromConditions                    // the describers, as for /v1/conditions
+)
+
+// responseFields are what ?fields= can ask /v1/weather for. The place,
+// attribution and errors are always there.
+var responseFields = map[string]fieldSource{
+  "temp":       fromReadings,
+  "condition":  fromReadings,
+  "humidity":   fromConditions,
+  "wind":       fromConditions,
+  "feels_like": fromConditions,
+}
+
+// fieldSet is the fields a client asked for; nil, without ?fields=, is
+// the usual response.
+type fieldSet map[string]bool
+
+func parseFields(v string) (fieldSet, error) {
+  if v == "" {
+    return nil, nil
+  }
+
+  f := fieldSet{}
+  for _, name := range strings.Split(v, ",") {
+    name = strings.TrimSpace(name)
+    if _, ok := responseFields[name]; !ok {
+      known := make([]string, 0, len(responseFields))
+      for n := range responseFields {
+        known = append(known, n)
+      }
+
+      sort.Strings(known)
+      return nil, fmt.Errorf("unknown field %q, want some of %s", name, strings.Join(known, ","))
+    }
+
+    f[name] = true
+  }
+
+  return f, nil
+}
+
+// needs reports whether any asked field comes from src, so lookups skip
+// the upstream calls nobody wants the answer of.
+func (f fieldSet) needs(src fieldSource) bool {
+  if f == nil {
+    return src == fromReadings
+  }
+
+  for name := range f {
+    if responseFields[name] == src {
+      return true
+    }
+  }
+
+  return false
+}
+
+// only clears what the client didn't ask for; run it before show.
+func (r *TemperatureResponse) only(f fieldSet) {
+  if f == nil {
+    return
+  }
+
+  if !f["temp"] {
+    r.Temp, r.RawTemp, r.TempRounded, r.ProviderCount = nil, nil, nil, 0
+    r.ObservedAt, r.DataAge = nil, ""
+  }
+
+  if !f["condition"] {
+    r.Condition = nil
+  }
+
+  if !f["humidity"] {
+    r.Humidity = nil
+  }
+
+  if !f["wind"] {
+    r.WindSpeed, r.WindDir, r.WindGust = nil, nil, nil
+  }
+
+  if !f["feels_like"] {
+    r.FeelsLike = nil
+  }
+
+  if r.Temp == nil && r.FeelsLike == nil {
+    r.Units = ""
+  }
+}
+
+// measure adds the describers' humidity, wind and feels-like temperature
+// to resp, failing it when none of them answers.
+func (s *server) measure(ctx context.Context, loc geo.Location, resp *TemperatureResponse) {
+  c := &ConditionsResponse{}
+  credit, err := s.describe(ctx, loc, "en", c)
+  if err != nil {
+    status := http.StatusBadGateway
+    if errors.Is(err, errNoDescribers) {
+      status = http.StatusNotFound
+    }
+
+    resp.fail(status, err)
+    return
+  }
+
+  if m := conditionMetrics(c.Providers); m != nil {
+    resp.Humidity, resp.WindSpeed, resp.FeelsLike = m.Humidity, m.WindSpeed, m.FeelsLike
+    resp.WindDir, resp.WindGust = m.WindDir, m.WindGust
+  }
+
+  resp.Units = "kelvin"
+  resp.Attribution = addCredit(resp.Attribution, credit...)
+}
+
+// addCredit appends the attributions credit doesn't list yet.
+func addCredit(credit []upstream.Attribution, more ...upstream.Attribution) []upstream.Attribution {
+  // Capped so the append never writes into a cached slice.
+  credit = credit[:len(credit):len(credit)]
+  for _, a := range more {
+    if !slices.Contains(credit, a) {
+      credit = append(credit, a)
+    }
+  }
+
+  return credit
+}
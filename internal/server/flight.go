@@ -0,0 +1,92 @@
+package server
+
+import (
+  "context"
+  "fmt"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+var fanOutsShared = metrics.NewCounter("upstream_fanouts_shared_total", "Lookups that joined a provider fan-out already in flight for the same place.")
+
+// flightTimeout bounds a fan-out, which outlives the request that started
+// it when others have joined or it refreshes the cache in the background.
+const flightTimeout = 30 * time.Second
+
+// flight is one provider fan-out; a is set once done is closed.
+type flight struct {
+  done chan struct{}
+  a    answer
+}
+
+// flights coalesces concurrent fan-outs for the same place, so 50 clients
+// asking about London on a cold cache cost one call per provider, not 50.
+type flights struct {
+  mu      sync.Mutex
+  running map[string]*flight
+}
+
+// join returns the flight for key, starting fn for it if there is none.
+func (fs *flights) join(key string, fn func() answer) *flight {
+  fs.mu.Lock()
+  defer fs.mu.Unlock()
+
+  if f, ok := fs.running[key]; ok {
+    fanOutsShared.Inc()
+    return f
+  }
+
+  if fs.running == nil {
+    fs.running = make(map[string]*flight)
+  }
+
+  f := &flight{done: make(chan struct{})}
+  fs.running[key] = f
+
+  go func() {
+    f.a = fn()
+
+    fs.mu.Lock()
+    delete(fs.running, key)
+    fs.mu.Unlock()
+
+    close(f.done)
+  }()
+
+  return f
+}
+
+// fly is the fan-out for loc, joined if one with the same options is in
+// flight for the same tenant. It runs detached from ctx, keeping its values
+// such as the trace and the client, so one client going away doesn't fail
+// the others.
+func (s *server) fly(ctx context.Context, loc geo.Location, active providers.Multi, exhausted []string, fresh, explain bool) *flight {
+  key := fmt.Sprintf("%s fresh=%t explain=%t", loc.Key(), fresh, explain)
+  if t := s.tenantFor(ctx); t != nil {
+    key += " tenant=" + t.name
+  }
+
+  return s.flights.join(key, func() answer {
+    ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), flightTimeout)
+    defer cancel()
+
+    return s.fanOut(ctx, loc, active, exhausted, fresh, explain)
+  })
+}
+
+// ask is the answer of the providers for loc, or ctx's error if it ends
+// first.
+func (s *server) ask(ctx context.Context, loc geo.Location, active providers.Multi, exhausted []string, fresh, explain bool) answer {
+  f := s.fly(ctx, loc, active, exhausted, fresh, explain)
+
+  select {
+  case <-f.done:
+    return f.a
+  case <-ctx.Done():
+    return answer{err: ctx.Err()}
+  }
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+  "bytes"
+  "encoding/csv"
+  "encoding/json"
+  "encoding/xml"
+  "fmt"
+  "io"
+  "net/http"
+  "strconv"
+  "strings"
+)
+
+// XML and CSV are made from the JSON an answer would be, so every field
+// has the same name in all three and no type needs tags of its own.
+
+// writeXML is v as XML under root: each JSON member becomes an element of
+// the same name, in order, and each array entry an <item>. Nulls are left
+// out.
+func writeXML(w http.ResponseWriter, status int, root string, v interface{}) {
+  b, err := json.Marshal(v)
+  if err != nil {
+    writeError(w, err, http.StatusInternalServerError)
+    return
+  }
+
+  var buf bytes.Buffer
+  buf.WriteString(xml.Header)
+  enc := xml.NewEncoder(&buf)
+  dec := json.NewDecoder(bytes.NewReader(b))
+  dec.UseNumber()
+  if err := jsonToXML(dec, enc, root); err != nil {
+    writeError(w, err, http.StatusInternalServerError)
+    return
+  }
+
+  enc.Flush()
+  w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+  w.WriteHeader(status)
+  w.Write(buf.Bytes())
+}
+
+// jsonToXML writes the next JSON value in dec as the element name.
+func jsonToXML(dec *json.Decoder, enc *xml.Encoder, name string) error {
+  t, err := dec.Token()
+  if err != nil {
+    return err
+  }
+
+  if t == nil {
+    return nil
+  }
+
+  start := xml.StartElement{Name: xml.Name{Local: name}}
+  if err := enc.EncodeToken(start); err != nil {
+    return err
+  }
+
+  switch t {
+  case json.Delim('{'):
+    for dec.More() {
+      k, err := dec.Token()
+      if err != nil {
+        return err
+      }
+
+      if err := jsonToXML(dec, enc, xmlName(k.(string))); err != nil {
+        return err
+      }
+    }
+
+    dec.Token() // }
+  case json.Delim('['):
+    for dec.More() {
+      if err := jsonToXML(dec, enc, "item"); err != nil {
+        return err
+      }
+    }
+
+    dec.Token() // ]
+  default:
+    if err := enc.EncodeToken(xml.CharData(scalar(t))); err != nil {
+      return err
+    }
+  }
+
+  return enc.EncodeToken(start.End())
+}
+
+// xmlName is a JSON member name made a valid element name: map keys such
+// as provider names may start with a digit or hold a space.
+func xmlName(k string) string {
+  b := []byte(k)
+  for i, c := range b {
+    if !(c == '_' || c == '-' || c == '.' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+      b[i] = '_'
+    }
+  }
+
+  if len(b) == 0 || '0' <= b[0] && b[0] <= '9' || b[0] == '-' || b[0] == '.' {
+    return "_" + string(b)
+  }
+
+  return string(b)
+}
+
+func scalar(t json.Token) string {
+  switch v := t.(type) {
+  case string:
+    return v
+  case json.Number:
+    return v.String()
+  case bool:
+    return strconv.FormatBool(v)
+  default:
+    return fmt.Sprint(v)
+  }
+}
+
+// writeCSV is rows as CSV with a header line, one line per row. Nested
+// members become dotted columns such as condition.code, array entries are
+// numbered (providers.0.kelvin) and arrays of plain values are joined with
+// ';'. Columns are every row's, in the order they first appear.
+func writeCSV[T any](w http.ResponseWriter, status int, rows []T) {
+  var columns []string
+  seen := make(map[string]bool)
+  flat := make([]map[string]string, len(rows))
+  for i, row := range rows {
+    b, err := json.Marshal(row)
+    if err != nil {
+      writeError(w, err, http.StatusInternalServerError)
+      return
+    }
+
+    dec := json.NewDecoder(bytes.NewReader(b))
+    dec.UseNumber()
+    flat[i] = make(map[string]string)
+    err = flatten(dec, "", func(column, v string) {
+      if !seen[column] {
+        seen[column] = true
+        columns = append(columns, column)
+      }
+
+      flat[i][column] = v
+    })
+
+    if err != nil {
+      writeError(w, err, http.StatusInternalServerError)
+      return
+    }
+  }
+
+  var buf bytes.Buffer
+  cw := csv.NewWriter(&buf)
+  cw.Write(columns)
+  for _, f := range flat {
+    line := make([]string, len(columns))
+    for i, c := range columns {
+      line[i] = f[c]
+    }
+
+    cw.Write(line)
+  }
+
+  cw.Flush()
+  w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+  w.WriteHeader(status)
+  w.Write(buf.Bytes())
+}
+
+// flatten calls set with each plain value of the next JSON value in dec,
+// under its dotted path from prefix.
+func flatten(dec *json.Decoder, prefix string, set func(column, v string)) error {
+  t, err := dec.Token()
+  if err != nil {
+    return err
+  }
+
+  join := func(k string) string {
+    if prefix == "" {
+      return k
+    }
+
+    return prefix + "." + k
+  }
+
+  switch t {
+  case nil:
+  case json.Delim('{'):
+    for dec.More() {
+      k, err := dec.Token()
+      if err != nil {
+        return err
+      }
+
+      if err := flatten(dec, join(k.(string)), set); err != nil {
+        return err
+      }
+    }
+
+    _, err = dec.Token()
+  case json.Delim('['):
+    var plain []string
+    for i := 0; dec.More(); i++ {
+      var raw json.RawMessage
+      if err := dec.Decode(&raw); err != nil {
+        return err
+      }
+
+      d := json.NewDecoder(bytes.NewReader(raw))
+      d.UseNumber()
+      if c := bytes.TrimSpace(raw); len(c) > 0 && (c[0] == '{' || c[0] == '[') {
+        if err := flatten(d, join(strconv.Itoa(i)), set); err != nil {
+          return err
+        }
+
+        continue
+      }
+
+      if v, err := d.Token(); err == nil && v != nil {
+        plain = append(plain, scalar(v))
+      }
+    }
+
+    if len(plain) > 0 {
+      set(prefix, strings.Join(plain, ";"))
+    }
+
+    _, err = dec.Token()
+  default:
+    set(prefix, scalar(t))
+  }
+
+  if err == io.EOF {
+    return nil
+  }
+
+  return err
+}
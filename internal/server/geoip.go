@@ -0,0 +1,89 @@
+package server
+
+import (
+  "fmt"
+  "net/http"
+  "net/netip"
+  "strings"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+)
+
+// proxyList is the repeatable -trusted.proxy flag: addresses or CIDR
+// ranges of the reverse proxies in front of the server.
+type proxyList []netip.Prefix
+
+func (l *proxyList) String() string {
+  s := make([]string, len(*l))
+  for i, p := range *l {
+    s[i] = p.String()
+  }
+
+  return strings.Join(s, ",")
+}
+
+func (l *proxyList) Set(v string) error {
+  p, err := netip.ParsePrefix(v)
+  if err != nil {
+    a, aerr := netip.ParseAddr(v)
+    if aerr != nil {
+      return fmt.Errorf("want an address or CIDR range, got %q", v)
+    }
+
+    p = netip.PrefixFrom(a, a.BitLen())
+  }
+
+  *l = append(*l, p.Masked())
+  return nil
+}
+
+func (l proxyList) trusts(a netip.Addr) bool {
+  for _, p := range l {
+    if p.Contains(a.Unmap()) {
+      return true
+    }
+  }
+
+  return false
+}
+
+// caller is the address of whoever sent r. Each proxy appends the address
+// it got the request from to X-Forwarded-For, so the list is read from
+// the right, skipping our own proxies: the first address that isn't one
+// is the client's. Anything left of it could have been made up by the
+// client, and the header isn't read at all unless a trusted proxy sent it.
+func (l proxyList) caller(r *http.Request) (netip.Addr, error) {
+  addr, err := netip.ParseAddrPort(r.RemoteAddr)
+  if err != nil {
+    return netip.Addr{}, fmt.Errorf("remote address %q: %w", r.RemoteAddr, err)
+  }
+
+  ip := addr.Addr().Unmap()
+  hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+  for i := len(hops) - 1; i >= 0 && l.trusts(ip); i-- {
+    hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+    if err != nil {
+      break
+    }
+
+    ip = hop.Unmap()
+  }
+
+  return ip, nil
+}
+
+// locateCaller is the approximate location of the address r came from, for
+// requests that name no place.
+func (s *server) locateCaller(r *http.Request) (geo.Location, error) {
+  ip, err := s.proxies.caller(r)
+  if err != nil {
+    return geo.Location{}, err
+  }
+
+  loc, err := s.ipdb.Locate(ip)
+  if err != nil {
+    return geo.Location{}, fmt.Errorf("%w: can't locate %s: %w", errNoLocation, ip, err)
+  }
+
+  return loc, nil
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "strconv"
+  "strings"
+)
+
+// Media types clients ask for the formats by in Accept.
+var formatTypes = map[string][]string{
+  "xml": {"application/xml", "text/xml"},
+  "csv": {"text/csv"},
+}
+
+// responseFormat is ?format=: json (the default) or one of also, the
+// other formats the endpoint has. Without ?format=, an Accept of one of
+// theirs picks it too, unless it names text/html: browsers list XML but
+// are better off with JSON. Unknown formats are answered with 400 and
+// false.
+func responseFormat(w http.ResponseWriter, r *http.Request, also ...string) (string, bool) {
+  f := r.URL.Query().Get("format")
+  if f == "" {
+    f = acceptedFormat(w, r, also)
+  }
+
+  if f == "" || f == "json" {
+    return "json", true
+  }
+
+  for _, a := range also {
+    if f == a {
+      return f, true
+    }
+  }
+
+  want := "json"
+  for i, a := range also {
+    if i == len(also)-1 {
+      want += " or " + a
+    } else {
+      want += ", " + a
+    }
+  }
+
+  httpError(w, fmt.Sprintf("unknown format %q, want %s", f, want), http.StatusBadRequest)
+  return "", false
+}
+
+// acceptedFormat is the format of also Accept prefers to JSON, if any.
+func acceptedFormat(w http.ResponseWriter, r *http.Request, also []string) string {
+  negotiable := false
+  for _, a := range also {
+    negotiable = negotiable || formatTypes[a] != nil
+  }
+
+  if !negotiable {
+    return ""
+  }
+
+  w.Header().Add("Vary", "Accept")
+  accept := r.Header.Get("Accept")
+  if strings.Contains(accept, "text/html") {
+    return ""
+  }
+
+  best, bestQ := "", 0.0
+  for _, part := range strings.Split(accept, ",") {
+    media, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+    q := 1.0
+    if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+      if f, err := strconv.ParseFloat(v, 64); err == nil {
+        q = f
+      }
+    }
+
+    f := ""
+    if media == "application/json" {
+      f = "json"
+    }
+
+    for _, a := range also {
+      for _, t := range formatTypes[a] {
+        if strings.EqualFold(media, t) {
+          f = a
+        }
+      }
+    }
+
+    if f != "" && q > bestQ {
+      best, bestQ = f, q
+    }
+  }
+
+  return best
+}
+
+// feature turns a lookup result into a GeoJSON Feature: its coordinates
+// become the Point geometry and everything else the properties. Results
+// without coordinates, such as unknown cities, get a null geometry so
+// they still show up in the collection.
+func feature(res interface{}) map[string]interface{} {
+  var props map[string]interface{}
+  b, _ := json.Marshal(res)
+  json.Unmarshal(b, &props)
+
+  var geometry interface{}
+  lat, okLat := props["lat"].(float64)
+  lon, okLon := props["lon"].(float64)
+  if okLat && okLon {
+    geometry = map[string]interface{}{"type": "Point", "coordinates": []float64{lon, lat}}
+    delete(props, "lat")
+    delete(props, "lon")
+  }
+
+  return map[string]interface{}{"type": "Feature", "geometry": geometry, "properties": props}
+}
+
+// featureCollection is batch results as a FeatureCollection; members such
+// as took or a group's summary are kept next to the features, which
+// GeoJSON allows.
+func featureCollection[T any](results []T, members map[string]interface{}) map[string]interface{} {
+  features := make([]map[string]interface{}, len(results))
+  for i, res := range results {
+    features[i] = feature(res)
+  }
+
+  fc := map[string]interface{}{"type": "FeatureCollection", "features": features}
+  for k, v := range members {
+    fc[k] = v
+  }
+
+  return fc
+}
+
+func writeGeoJSON(w http.ResponseWriter, status int, v interface{}) {
+  w.Header().Set("Content-Type", "application/geo+json")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(v)
+}
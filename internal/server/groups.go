@@ -0,0 +1,172 @@
+package server
+
+import (
+  "errors"
+  "fmt"
+  "log"
+  "net/http"
+  "regexp"
+  "slices"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// group is a named set of cities, such as "emea-offices", shared by every
+// client and queried as one. Unlike a watchlist it is addressed by name.
+type group struct {
+  resourceMeta
+  Name   string   `json:"name"`
+  Cities []string `json:"cities"`
+}
+
+// groupConfig is a group defined in the -config file.
+type groupConfig struct {
+  Name   string   `json:"name"`
+  Cities []string `json:"cities"`
+}
+
+var groupName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+func (g *group) validate() error {
+  if !groupName.MatchString(g.Name) {
+    return fmt.Errorf("group name %q must be lowercase letters, digits, '.', '_' or '-'", g.Name)
+  }
+
+  if len(g.Cities) == 0 {
+    return errors.New("group needs at least one city")
+  }
+
+  return validCities(g.Cities)
+}
+
+func newGroups(db *store) *collection {
+  return &collection{
+    db:      db,
+    name:    "groups",
+    newItem: func() resource { return &group{} },
+    idOf:    func(r resource) string { return r.(*group).Name },
+  }
+}
+
+// seedGroups writes the groups of the config file to the store, so they are
+// served like any other and the config stays the source of truth for them:
+// API changes to a configured group last until the next restart.
+func seedGroups(c *collection, gs []groupConfig) error {
+  for _, gc := range gs {
+    g := &group{Name: gc.Name, Cities: gc.Cities}
+
+    item, err := c.get(g.Name)
+    switch {
+    case errors.Is(err, errNotFound):
+      err = c.create(g)
+    case err != nil:
+    case item.meta().DeletedAt != nil:
+      if _, err = c.setDeleted(g.Name, "", false); err == nil {
+        _, err = c.update(g.Name, "", g)
+      }
+    case !slices.Equal(item.(*group).Cities, g.Cities):
+      _, err = c.update(g.Name, "", g)
+    }
+
+    if err != nil {
+      return fmt.Errorf("group %s: %w", g.Name, err)
+    }
+  }
+
+  if len(gs) > 0 {
+    log.Printf("groups: %d from config", len(gs))
+  }
+
+  return nil
+}
+
+// groupWeather serves GET /v1/groups/{id}/weather: every city of the group
+// through the batch machinery, and a summary across them.
+func (s *server) groupWeather(w http.ResponseWriter, r *http.Request) {
+  format, ok := responseFormat(w, r, "geojson")
+  if !ok {
+    return
+  }
+
+  d, ok := s.requestDisplay(w, r)
+  if !ok {
+    return
+  }
+
+  item, ok := s.groups.lookup(w, r)
+  if !ok {
+    return
+  }
+
+  if item.meta().DeletedAt != nil {
+    httpError(w, "group "+item.meta().ID+" is deleted", http.StatusGone)
+    return
+  }
+
+  begin := time.Now()
+  g := item.(*group)
+  if len(g.Cities) > s.batchMax {
+    httpError(w, fmt.Sprintf("group has %d cities, over the batch limit of %d", len(g.Cities), s.batchMax), http.StatusUnprocessableEntity)
+    return
+  }
+
+  results := s.lookupAll(upstream.WithTrace(r), g.Cities, detailOf(r))
+  showAll(results, d) // before summarize, so the summary is in them too
+  s.cacheHeaders.set(w, r, results...)
+  took := time.Since(begin).String()
+
+  if format == "geojson" {
+    writeGeoJSON(w, http.StatusOK, featureCollection(results, map[string]interface{}{"group": g.Name, "summary": summarize(results, d), "took": took}))
+    return
+  }
+
+  writeJSON(w, http.StatusOK, GroupResponse{SchemaVersion: responseVersion, Group: g.Name, Summary: summarize(results, d), Results: results, Took: took})
+}
+
+type cityTemp struct {
+  City   string  `json:"city"`
+  Kelvin float64 `json:"temp"`
+}
+
+type groupSummary struct {
+  Cities int       `json:"cities"`
+  Failed int       `json:"failed"`
+  Mean   *float64  `json:"mean,omitempty"` // of the cities, each counted once
+  Min    *cityTemp `json:"min,omitempty"`
+  Max    *cityTemp `json:"max,omitempty"`
+}
+
+// summarize aggregates batch results across cities. Failed cities are
+// counted, not guessed.
+func summarize(results []*TemperatureResponse, d display) groupSummary {
+  sum := groupSummary{Cities: len(results)}
+
+  var total float64
+  var n int
+  for _, res := range results {
+    if res.Temp == nil {
+      sum.Failed++
+      continue
+    }
+
+    k, city := *res.Temp, res.Query
+    if sum.Min == nil || k < sum.Min.Kelvin {
+      sum.Min = &cityTemp{City: city, Kelvin: k}
+    }
+
+    if sum.Max == nil || k > sum.Max.Kelvin {
+      sum.Max = &cityTemp{City: city, Kelvin: k}
+    }
+
+    total += k
+    n++
+  }
+
+  if n > 0 {
+    mean := d.round(total / float64(n))
+    sum.Mean = &mean
+  }
+
+  return sum
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+  "errors"
+  "log"
+  "math"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+var circuitOpened = metrics.NewCounter("provider_circuit_opened_total", "Times a provider's circuit opened after consecutive failures.", "provider")
+
+var errCircuitsOpen = errors.New("every provider is failing, their circuits are open")
+
+var upstreamOutages = metrics.NewCounter("upstream_outages_total", "Times most providers started failing for the same reason.", "reason")
+
+// An outage is at least this many providers, and at least half of those
+// called within the window, whose last call failed the same way.
+const outageMinProviders = 2
+
+// providerHealth keeps the outcome of every provider call over a rolling
+// window, and a circuit breaker per provider: after failures consecutive
+// errors the provider is left out for cooldown, then let through again;
+// its next answer closes the circuit or opens it for another cooldown.
+// An unknown place is the query's fault, not the provider's, and counts
+// as a success.
+type providerHealth struct {
+  window   time.Duration
+  failures int // 0 never opens a circuit
+  cooldown time.Duration
+
+  mu      sync.Mutex
+  by      map[string]*providerState
+  current *Outage // nil while there is none
+}
+
+type providerState struct {
+  calls       []call // within window, oldest first
+  consecutive int
+  lastError   string
+  lastErrorAt time.Time
+  failing     upstream.Failure // of the last call, "" if it succeeded
+  lastOK      time.Time
+  openedAt    time.Time // zero while closed
+}
+
+type call struct {
+  at   time.Time
+  ok   bool
+  took time.Duration
+}
+
+func newProviderHealth(window time.Duration, failures int, cooldown time.Duration) *providerHealth {
+  return &providerHealth{window: window, failures: failures, cooldown: cooldown, by: make(map[string]*providerState)}
+}
+
+func (h *providerHealth) state(name string) *providerState {
+  st, ok := h.by[name]
+  if !ok {
+    st = &providerState{}
+    h.by[name] = st
+  }
+
+  return st
+}
+
+// record notes the outcome of each reading that was an actual call; one
+// turned away at the provider's concurrency cap never reached it.
+func (h *providerHealth) record(rs []providers.Reading) {
+  now := time.Now()
+
+  h.mu.Lock()
+  defer h.mu.Unlock()
+
+  for _, r := range rs {
+    if r.Reused() || errors.Is(r.Err, providers.ErrCancelled) || errors.Is(r.Err, upstream.ErrBusy) {
+      continue
+    }
+
+    st := h.state(r.Provider)
+    ok := r.Error == "" || errors.Is(r.Err, providers.ErrCityNotFound)
+    st.calls = append(st.prune(now, h.window), call{at: now, ok: ok, took: r.Took})
+    if ok {
+      st.consecutive, st.lastOK, st.openedAt, st.failing = 0, now, time.Time{}, ""
+      continue
+    }
+
+    st.consecutive++
+    st.lastError, st.lastErrorAt, st.failing = r.Error, now, providers.Failure(r.Err)
+    if st.failing == "" {
+      st.failing = upstream.FailureOther // known only by its message, say from a plugin
+    }
+
+    if h.failures > 0 && st.consecutive >= h.failures && (st.openedAt.IsZero() || now.Sub(st.openedAt) >= h.cooldown) {
+      if st.openedAt.IsZero() {
+        circuitOpened.Inc(r.Provider)
+        log.Printf("providers: %s: circuit open after %d failures, last: %s", r.Provider, st.consecutive, r.Error)
+      }
+
+      st.openedAt = now
+    }
+  }
+
+  h.detectOutage(now)
+}
+
+// detectOutage notes when most providers start or stop failing for the
+// same reason: all of them failing to resolve names, say, is this server's
+// DNS rather than each provider's.
+func (h *providerHealth) detectOutage(now time.Time) {
+  called := 0
+  by := make(map[upstream.Failure][]string)
+  for name, st := range h.by {
+    if len(st.calls) == 0 || now.Sub(st.calls[len(st.calls)-1].at) > h.window {
+      continue
+    }
+
+    called++
+    if st.failing != "" && st.failing != upstream.FailureOther {
+      by[st.failing] = append(by[st.failing], name)
+    }
+  }
+
+  var worst upstream.Failure
+  for f, names := range by {
+    if len(names) >= outageMinProviders && 2*len(names) >= called && len(names) > len(by[worst]) {
+      worst = f
+    }
+  }
+
+  switch {
+  case worst == "" && h.current != nil:
+    log.Printf("providers: %s outage over after %s", h.current.Reason, now.Sub(h.current.Since).Round(time.Second))
+    h.current = nil
+  case worst == "":
+  case h.current == nil || h.current.Reason != worst:
+    sort.Strings(by[worst])
+    h.current = &Outage{Reason: worst, Providers: by[worst], Since: now.UTC()}
+    upstreamOutages.Inc(string(worst))
+    log.Printf("providers: %s outage: %d of %d providers failing that way: %s", worst, len(by[worst]), called, strings.Join(by[worst], ", "))
+  default:
+    sort.Strings(by[worst])
+    h.current.Providers = by[worst]
+  }
+}
+
+// outage is the one going on, if any; one whose calls have all left the
+// window is over.
+func (h *providerHealth) outage() *Outage {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+
+  h.detectOutage(time.Now())
+  if h.current == nil {
+    return nil
+  }
+
+  o := *h.current
+  return &o
+}
+
+// At most this many calls are kept per provider, however busy the window.
+const healthMaxCalls = 10000
+
+func (st *providerState) prune(now time.Time, window time.Duration) []call {
+  i := max(len(st.calls)-healthMaxCalls+1, 0)
+  for i < len(st.calls) && now.Sub(st.calls[i].at) > window {
+    i++
+  }
+
+  return st.calls[i:]
+}
+
+// circuit is closed, open, or half-open once the cooldown has passed and
+// the provider is being tried again.
+func (h *providerHealth) circuit(st *providerState, now time.Time) string {
+  switch {
+  case st.openedAt.IsZero():
+    return "closed"
+  case now.Sub(st.openedAt) < h.cooldown:
+    return "open"
+  default:
+    return "half-open"
+  }
+}
+
+// available drops the providers whose circuit is open.
+func (h *providerHealth) available(ps providers.Multi) providers.Multi {
+  now := time.Now()
+
+  h.mu.Lock()
+  defer h.mu.Unlock()
+
+  ok := make(providers.Multi, 0, len(ps))
+  for _, p := range ps {
+    if st, seen := h.by[p.Name()]; seen && h.circuit(st, now) == "open" {
+      continue
+    }
+
+    ok = append(ok, p)
+  }
+
+  return ok
+}
+
+func (h *providerHealth) open(name string) bool {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+
+  st, ok := h.by[name]
+  return ok && h.circuit(st, time.Now()) == "open"
+}
+
+// reset closes name's circuit, e.g. after rotating a rejected key.
+func (h *providerHealth) reset(name string) {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+
+  st := h.state(name)
+  st.consecutive, st.openedAt = 0, time.Time{}
+}
+
+// healthReport is a provider's health over the window.
+type healthReport struct {
+  Window              string        `json:"window"`
+  Calls               int           `json:"calls"`
+  Errors              int           `json:"errors"`
+  SuccessRate         *float64      `json:"success_rate,omitempty"`
+  ErrorRate           *float64      `json:"error_rate,omitempty"`
+  P50                 string        `json:"p50,omitempty"`
+  P95                 string        `json:"p95,omitempty"`
+  ConsecutiveFailures int           `json:"consecutive_failures"`
+  LastError           string        `json:"last_error,omitempty"`
+  LastErrorAt         *time.Time    `json:"last_error_at,omitempty"`
+  LastSuccessAt       *time.Time    `json:"last_success_at,omitempty"`
+  Circuit             circuitReport `json:"circuit"`
+}
+
+type circuitReport struct {
+  State    string     `json:"state"`
+  OpenedAt *time.Time `json:"opened_at,omitempty"`
+  RetryAt  *time.Time `json:"retry_at,omitempty"`
+}
+
+func (h *providerHealth) report(name string) healthReport {
+  now := time.Now()
+
+  h.mu.Lock()
+  defer h.mu.Unlock()
+
+  st := h.state(name)
+  st.calls = st.prune(now, h.window)
+
+  rep := healthReport{Window: h.window.String(), Calls: len(st.calls), ConsecutiveFailures: st.consecutive}
+  took := make([]time.Duration, 0, len(st.calls))
+  for _, c := range st.calls {
+    if !c.ok {
+      rep.Errors++
+    }
+
+    took = append(took, c.took)
+  }
+
+  if len(st.calls) > 0 {
+    failed := float64(rep.Errors) / float64(len(st.calls))
+    ok := 1 - failed
+    rep.ErrorRate, rep.SuccessRate = &failed, &ok
+
+    sort.Slice(took, func(i, j int) bool { return took[i] < took[j] })
+    rep.P50 = quantile(took, 0.5).String()
+    rep.P95 = quantile(took, 0.95).String()
+  }
+
+  if st.lastError != "" {
+    at := st.lastErrorAt
+    rep.LastError, rep.LastErrorAt = st.lastError, &at
+  }
+
+  if !st.lastOK.IsZero() {
+    at := st.lastOK
+    rep.LastSuccessAt = &at
+  }
+
+  rep.Circuit.State = h.circuit(st, now)
+  if !st.openedAt.IsZero() {
+    opened, retry := st.openedAt, st.openedAt.Add(h.cooldown)
+    rep.Circuit.OpenedAt, rep.Circuit.RetryAt = &opened, &retry
+  }
+
+  return rep
+}
+
+// quantile of sorted durations, nearest rank, rounded for display.
+func quantile(sorted []time.Duration, q float64) time.Duration {
+  i := int(math.Ceil(q*float64(len(sorted)))) - 1
+  return sorted[max(i, 0)].Round(time.Microsecond)
+}
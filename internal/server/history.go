@@ -0,0 +1,228 @@
+package server
+
+import (
+  "context"
+  "errors"
+  "log"
+  "net/http"
+  "strings"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// historyReading is one aggregate reading with every provider's value, kept
+// to spot providers drifting apart over time.
+type historyReading struct {
+  Time      time.Time          `json:"time"`
+  Kelvin    float64            `json:"temp"`
+  Providers map[string]float64 `json:"providers"`
+}
+
+// history persists aggregate readings in the "history" bucket of the store,
+// keyed by place and time so a key prefix scan returns a place's series in
+// order. It lives in the embedded store, or a directory or Redis with
+// -history.storage, rather than SQLite to keep the binary free of cgo and
+// third-party drivers.
+type history struct {
+  db        kv
+  retention time.Duration
+}
+
+const historyBucket = "history"
+
+// Fixed width, so keys sort chronologically.
+const historyTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+func newHistory(db kv, retention time.Duration) *history {
+  h := &history{db: db, retention: retention}
+  if retention > 0 {
+    go h.prune()
+  }
+
+  return h
+}
+
+// newHistoryReading is the stored form of an aggregate and its readings.
+func newHistoryReading(kelvin float64, rs []providers.Reading) historyReading {
+  reading := historyReading{Time: time.Now().UTC(), Kelvin: kelvin, Providers: make(map[string]float64, len(rs))}
+  for _, r := range rs {
+    if r.Error == "" && !r.Reused() {
+      reading.Providers[r.Provider] = r.Kelvin
+    }
+  }
+
+  return reading
+}
+
+func (h *history) send(ctx context.Context, loc geo.Location, r historyReading) {
+  if err := h.db.put(historyBucket, loc.Key()+"/"+r.Time.Format(historyTimeFormat), r); err != nil {
+    log.Printf("history: %s: %s", loc.Name, err)
+  }
+}
+
+// series returns the readings for loc since the given time, oldest first.
+func (h *history) series(loc geo.Location, since time.Time) ([]historyReading, error) {
+  rs := []historyReading{}
+  err := h.scan(loc, since, time.Time{}, func(r historyReading) error {
+    rs = append(rs, r)
+    return nil
+  })
+
+  if err != nil {
+    return nil, err
+  }
+
+  return rs, nil
+}
+
+// scan calls fn with loc's readings from from until to, oldest first, one
+// at a time, so spans of months needn't fit in memory; a zero to is open.
+// An error from fn stops it and is returned.
+func (h *history) scan(loc geo.Location, from, to time.Time, fn func(historyReading) error) error {
+  prefix := loc.Key() + "/"
+  lo := prefix + from.UTC().Format(historyTimeFormat)
+  hi := prefix + to.UTC().Format(historyTimeFormat)
+
+  for _, k := range h.db.keys(historyBucket, prefix) {
+    if k < lo || (!to.IsZero() && k >= hi) {
+      continue
+    }
+
+    var r historyReading
+    if _, err := h.db.get(historyBucket, k, &r); err != nil {
+      return err
+    }
+
+    if err := fn(r); err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+// scanAll is scan for every place since from, with the place's key.
+func (h *history) scanAll(from time.Time, fn func(place string, r historyReading) error) error {
+  cutoff := from.UTC().Format(historyTimeFormat)
+  for _, k := range h.db.keys(historyBucket, "") {
+    i := strings.LastIndexByte(k, '/')
+    if k[i+1:] < cutoff {
+      continue
+    }
+
+    var r historyReading
+    if _, err := h.db.get(historyBucket, k, &r); err != nil {
+      return err
+    }
+
+    if err := fn(k[:i], r); err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+// prune drops readings older than retention.
+func (h *history) prune() {
+  for range time.Tick(time.Hour) {
+    cutoff := time.Now().Add(-h.retention).UTC().Format(historyTimeFormat)
+
+    n := 0
+    for _, k := range h.db.keys(historyBucket, "") {
+      if ts := k[strings.LastIndexByte(k, '/')+1:]; ts < cutoff {
+        if err := h.db.delete(historyBucket, k); err != nil {
+          log.Printf("history: prune: %s", err)
+          break
+        }
+
+        n++
+      }
+    }
+
+    if n > 0 {
+      log.Printf("history: pruned %d readings", n)
+    }
+  }
+}
+
+// historyHandler serves GET /v1/history/{city}?since=24h (or ?lat=&lon=),
+// or with ?date= a past day from the provider archives.
+func (s *server) historyHandler(w http.ResponseWriter, r *http.Request) {
+  format, ok := responseFormat(w, r, "xml", "csv")
+  if !ok {
+    return
+  }
+
+  loc, err := requestLocation(upstream.WithTrace(r), r, s.geo)
+  var amb *geo.AmbiguousError
+  if errors.As(err, &amb) {
+    writeCandidates(w, amb)
+    return
+  }
+
+  if err != nil {
+    writeError(w, err, locationStatus(err))
+    return
+  }
+
+  if date := r.URL.Query().Get("date"); date != "" {
+    s.backfill(w, r, loc, date, format)
+    return
+  }
+
+  since := 24 * time.Hour
+  if v := r.URL.Query().Get("since"); v != "" {
+    if since, err = time.ParseDuration(v); err != nil || since <= 0 {
+      httpError(w, "since must be a positive duration like \"24h\"", http.StatusBadRequest)
+      return
+    }
+  }
+
+  rs, err := s.history.series(loc, time.Now().Add(-since))
+  if err != nil {
+    writeError(w, err, http.StatusInternalServerError)
+    return
+  }
+
+  writeHistory(w, format, loc, s.policies.history(rs, time.Now()), map[string]interface{}{
+    "city":  loc.Name,
+    "lat":   loc.Lat,
+    "lon":   loc.Lon,
+    "since": since.String(),
+  })
+}
+
+// historyRow is a reading as a CSV line, with its place so files of
+// several places can be put together.
+type historyRow struct {
+  City string  `json:"city"`
+  Lat  float64 `json:"lat"`
+  Lon  float64 `json:"lon"`
+  historyReading
+}
+
+// writeHistory answers with rs and the other members of resp in format:
+// a line per reading in CSV, which has no room for the rest.
+func writeHistory(w http.ResponseWriter, format string, loc geo.Location, rs []historyReading, resp map[string]interface{}) {
+  if format == "csv" {
+    rows := make([]historyRow, len(rs))
+    for i, r := range rs {
+      rows[i] = historyRow{City: loc.Name, Lat: loc.Lat, Lon: loc.Lon, historyReading: r}
+    }
+
+    writeCSV(w, http.StatusOK, rows)
+    return
+  }
+
+  resp["readings"] = rs
+  if format == "xml" {
+    writeXML(w, http.StatusOK, "history", resp)
+    return
+  }
+
+  writeJSON(w, http.StatusOK, resp)
+}
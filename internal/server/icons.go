@@ -0,0 +1,134 @@
+package server
+
+import (
+  "bytes"
+  "crypto/sha256"
+  "embed"
+  "encoding/hex"
+  "net/http"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// The icon set is one SVG per normalized condition code, the same glyphs
+// whichever provider reported the condition. Titles are added per language
+// so screen readers and tooltips match the client's locale.
+//
+//go:embed data/icons/*.svg
+var iconFiles embed.FS
+
+var iconTitles = map[string]map[string]string{
+  "clear":               {"en": "Clear", "de": "Klar", "fr": "Dégagé", "es": "Despejado", "ru": "Ясно", "nb": "Klarvær"},
+  "clear-night":         {"en": "Clear night", "de": "Klare Nacht", "fr": "Nuit dégagée", "es": "Noche despejada", "ru": "Ясная ночь", "nb": "Klar natt"},
+  "partly-cloudy":       {"en": "Partly cloudy", "de": "Teilweise bewölkt", "fr": "Partiellement nuageux", "es": "Parcialmente nublado", "ru": "Переменная облачность", "nb": "Delvis skyet"},
+  "partly-cloudy-night": {"en": "Partly cloudy night", "de": "Teilweise bewölkte Nacht", "fr": "Nuit partiellement nuageuse", "es": "Noche parcialmente nublada", "ru": "Переменная облачность ночью", "nb": "Delvis skyet natt"},
+  "cloudy":              {"en": "Cloudy", "de": "Bewölkt", "fr": "Nuageux", "es": "Nublado", "ru": "Облачно", "nb": "Skyet"},
+  "fog":                 {"en": "Fog", "de": "Nebel", "fr": "Brouillard", "es": "Niebla", "ru": "Туман", "nb": "Tåke"},
+  "drizzle":             {"en": "Drizzle", "de": "Nieselregen", "fr": "Bruine", "es": "Llovizna", "ru": "Морось", "nb": "Yr"},
+  "rain":                {"en": "Rain", "de": "Regen", "fr": "Pluie", "es": "Lluvia", "ru": "Дождь", "nb": "Regn"},
+  "thunderstorm":        {"en": "Thunderstorm", "de": "Gewitter", "fr": "Orage", "es": "Tormenta", "ru": "Гроза", "nb": "Tordenvær"},
+  "sleet":               {"en": "Sleet", "de": "Schneeregen", "fr": "Neige fondue", "es": "Aguanieve", "ru": "Мокрый снег", "nb": "Sludd"},
+  "snow":                {"en": "Snow", "de": "Schnee", "fr": "Neige", "es": "Nieve", "ru": "Снег", "nb": "Snø"},
+  "unknown":             {"en": "Unknown", "de": "Unbekannt", "fr": "Inconnu", "es": "Desconocido", "ru": "Нет данных", "nb": "Ukjent"},
+}
+
+var iconLanguages = []string{"en", "de", "fr", "es", "ru", "nb"}
+
+// icon serves /icons/{code}.svg, titled in the language of ?lang= or the
+// Accept-Language header, English otherwise.
+func icon(w http.ResponseWriter, r *http.Request) {
+  code, ok := strings.CutSuffix(r.PathValue("file"), ".svg")
+  titles, known := iconTitles[code]
+  if !ok || !known {
+    httpError(w, "no icon "+r.PathValue("file"), http.StatusNotFound)
+    return
+  }
+
+  svg, err := iconFiles.ReadFile("data/icons/" + code + ".svg")
+  if err != nil {
+    httpError(w, "no icon "+r.PathValue("file"), http.StatusNotFound)
+    return
+  }
+
+  lang := preferredLanguage(r, iconLanguages)
+  if i := bytes.IndexByte(svg, '>'); i >= 0 {
+    title := "\n  <title>" + titles[lang] + "</title>"
+    svg = append(svg[:i+1:i+1], append([]byte(title), svg[i+1:]...)...)
+  }
+
+  sum := sha256.Sum256(svg)
+  w.Header().Set("Content-Type", "image/svg+xml")
+  w.Header().Set("Content-Language", lang)
+  w.Header().Set("Vary", "Accept-Language, X-API-Key")
+  w.Header().Set("Cache-Control", "public, max-age=86400")
+  w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+  http.ServeContent(w, r, code+".svg", time.Time{}, bytes.NewReader(svg))
+}
+
+// preferredLanguage picks the first of available the client asked for,
+// falling back to available[0].
+func preferredLanguage(r *http.Request, available []string) string {
+  for _, tag := range requestedLanguages(r) {
+    for _, l := range available {
+      if baseLanguage(tag) == l {
+        return l
+      }
+    }
+  }
+
+  return available[0]
+}
+
+// requestedLanguages lists the languages the client asked for, best first:
+// ?lang=, its stored preference, then Accept-Language by quality.
+func requestedLanguages(r *http.Request) []string {
+  type choice struct {
+    tag string
+    q   float64
+  }
+
+  var asked []choice
+  if l := r.URL.Query().Get("lang"); l != "" {
+    asked = append(asked, choice{l, 3})
+  }
+
+  if l := prefsFrom(r.Context()).Lang; l != "" {
+    asked = append(asked, choice{l, 2})
+  }
+
+  for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+    tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+    q := 1.0
+    if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+      if f, err := strconv.ParseFloat(v, 64); err == nil {
+        q = f
+      }
+    }
+
+    if tag != "" && tag != "*" && q > 0 {
+      asked = append(asked, choice{tag, q})
+    }
+  }
+
+  sort.SliceStable(asked, func(i, j int) bool { return asked[i].q > asked[j].q })
+
+  tags := make([]string, len(asked))
+  for i, c := range asked {
+    tags[i] = c.tag
+  }
+
+  return tags
+}
+
+// baseLanguage is tag without its region, lower case.
+// Regional variants match their language: de-CH is de, and Norwegian is nb.
+func baseLanguage(tag string) string {
+  base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+  if base == "no" || base == "nn" {
+    return "nb"
+  }
+
+  return base
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+  "bytes"
+  "crypto/sha256"
+  "fmt"
+  "io"
+  "net/http"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+)
+
+var idempotentReplays = metrics.NewCounter("idempotent_replays_total", "POSTs answered with the answer to an earlier one with the same Idempotency-Key.")
+
+// Past this many remembered keys, taking one first clears the expired.
+const sweepIdempotency = 10000
+
+// The longest Idempotency-Key taken; a UUID is 36.
+const maxIdempotencyKey = 255
+
+// idempotency remembers the answer to each POST sent with an
+// Idempotency-Key for -idempotency.ttl, so a client retrying after a
+// timeout gets that answer again rather than a second rule or webhook, or
+// a second round of upstream calls against the providers' quotas. Keys are
+// the client's own: by API key, or by IP without one. 5xx answers aren't
+// kept, so a retry after one runs again.
+type idempotency struct {
+  ttl     time.Duration // 0 remembers none
+  proxies proxyList
+
+  mu   sync.Mutex
+  keys map[string]*idempotent // by client and key
+}
+
+// idempotent is a request sent with a key and, once done, its answer.
+type idempotent struct {
+  request [sha256.Size]byte // of the method, path, query and body
+  done    bool
+  until   time.Time
+  status  int
+  header  http.Header
+  body    []byte
+}
+
+func newIdempotency(ttl time.Duration, proxies proxyList) *idempotency {
+  return &idempotency{ttl: ttl, proxies: proxies, keys: make(map[string]*idempotent)}
+}
+
+// handle answers a POST whose key was seen with the same request before
+// with what it was answered then, marked Idempotent-Replayed. A key sent
+// with another request is refused with 422, one whose request is still
+// being answered with 409.
+func (i *idempotency) handle(h http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    key := r.Header.Get("Idempotency-Key")
+    if i.ttl <= 0 || r.Method != http.MethodPost || key == "" {
+      h.ServeHTTP(w, r)
+      return
+    }
+
+    if len(key) > maxIdempotencyKey {
+      httpError(w, fmt.Sprintf("Idempotency-Key is over %d characters", maxIdempotencyKey), http.StatusBadRequest)
+      return
+    }
+
+    body, err := io.ReadAll(r.Body)
+    if err != nil {
+      httpError(w, "reading the body: "+err.Error(), bodyStatus(err))
+      return
+    }
+
+    r.Body = io.NopCloser(bytes.NewReader(body))
+    request := sha256.Sum256(append([]byte(r.Method+" "+r.URL.RequestURI()+"\n"), body...))
+
+    client := "ip:" + i.proxies.clientIP(r)
+    if c, ok := clientFrom(r.Context()); ok {
+      client = "key:" + c.Name
+    }
+
+    scope := client + " " + key
+    e, prior := i.take(scope, request)
+    switch {
+    case prior && e.request != request:
+      httpError(w, "this Idempotency-Key was sent with another request", http.StatusUnprocessableEntity)
+    case prior && !e.done:
+      w.Header().Set("Retry-After", "1")
+      httpError(w, "a request with this Idempotency-Key is still being answered", http.StatusConflict)
+    case prior:
+      idempotentReplays.Inc()
+      for k, v := range e.header {
+        w.Header()[k] = v
+      }
+
+      w.Header().Set("Idempotent-Replayed", "true")
+      w.WriteHeader(e.status)
+      w.Write(e.body)
+    default:
+      rec := &recordingWriter{ResponseWriter: w}
+      defer func() { i.finish(scope, rec) }()
+
+      h.ServeHTTP(rec, r)
+    }
+  })
+}
+
+// take claims scope for request, or returns what it already holds while
+// that is remembered.
+func (i *idempotency) take(scope string, request [sha256.Size]byte) (idempotent, bool) {
+  now := time.Now()
+  i.mu.Lock()
+  defer i.mu.Unlock()
+
+  if e, ok := i.keys[scope]; ok && now.Before(e.until) {
+    return *e, true
+  }
+
+  if len(i.keys) >= sweepIdempotency {
+    for k, e := range i.keys {
+      if !now.Before(e.until) {
+        delete(i.keys, k)
+      }
+    }
+  }
+
+  i.keys[scope] = &idempotent{request: request, until: now.Add(i.ttl)}
+  return idempotent{}, false
+}
+
+// finish keeps the answer rec recorded for scope, or frees scope when
+// there is none to keep.
+func (i *idempotency) finish(scope string, rec *recordingWriter) {
+  i.mu.Lock()
+  defer i.mu.Unlock()
+
+  e := i.keys[scope]
+  if e == nil {
+    return
+  }
+
+  if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+    delete(i.keys, scope)
+    return
+  }
+
+  e.done, e.until = true, time.Now().Add(i.ttl)
+  e.status, e.header, e.body = rec.status, rec.Header().Clone(), rec.body.Bytes()
+}
+
+// recordingWriter keeps a copy of the answer it passes on.
+type recordingWriter struct {
+  http.ResponseWriter
+  status int
+  body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+  if w.status == 0 {
+    w.status = status
+  }
+
+  w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+  if w.status == 0 {
+    w.status = http.StatusOK
+  }
+
+  w.body.Write(b)
+  return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
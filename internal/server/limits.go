@@ -0,0 +1,186 @@
+package server
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "net/http"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// A request that runs out of time gets this long more to send what its
+// handler made of the deadline, usually a 504, before the connection is
+// cut.
+const requestWriteGrace = 5 * time.Second
+
+// Streams and exports run for as long as the client reads; only a route
+// limit set for them bounds them.
+var longRunning = []string{"/stream", "/export"}
+
+// limits bounds what a request may take of the server, so that it can
+// face the internet: how long its path and body may be and how long it
+// may run, by route where one is set. 0 is unlimited. How long the
+// headers may take to arrive is the http.Server's, see serve.
+type limits struct {
+  pathMax  int
+  bodyMax  int64 // bytes
+  timeout  time.Duration
+  bodies   routeSizes
+  timeouts routeTimeouts
+}
+
+// routeOf is the longest of prefixes path is under, "/v1" aside so a limit
+// holds for both the versioned and the unversioned route.
+func routeOf(path string, prefixes []string) (string, bool) {
+  path = unversioned(path)
+  best, found := "", false
+  for _, p := range prefixes {
+    if under(path, unversioned(p)) && (!found || len(unversioned(p)) > len(unversioned(best))) {
+      best, found = p, true
+    }
+  }
+
+  return best, found
+}
+
+func unversioned(path string) string {
+  if path == "/v1" || strings.HasPrefix(path, "/v1/") {
+    return path[len("/v1"):]
+  }
+
+  return path
+}
+
+// under is whether path is prefix or below it.
+func under(path, prefix string) bool {
+  prefix = strings.TrimSuffix(prefix, "/")
+  return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func (l limits) body(path string) int64 {
+  if route, ok := routeOf(path, keys(l.bodies)); ok {
+    return l.bodies[route]
+  }
+
+  return l.bodyMax
+}
+
+func (l limits) deadline(path string) time.Duration {
+  if route, ok := routeOf(path, keys(l.timeouts)); ok {
+    return l.timeouts[route]
+  }
+
+  if _, ok := routeOf(path, longRunning); ok {
+    return 0
+  }
+
+  return l.timeout
+}
+
+// handle refuses paths over -request.path.max with 414 and bodies declared
+// over the route's size with 413, and cuts those that are not declared
+// where they pass it. A request with a time limit has its context, and
+// every upstream call made under it, end then; its body must have arrived
+// by then too.
+func (l limits) handle(next http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if l.pathMax > 0 && len(r.URL.EscapedPath()) > l.pathMax {
+      httpError(w, fmt.Sprintf("path is over %d bytes", l.pathMax), http.StatusRequestURITooLong)
+      return
+    }
+
+    if max := l.body(r.URL.Path); max > 0 {
+      if r.ContentLength > max {
+        httpError(w, fmt.Sprintf("body is over %d bytes", max), http.StatusRequestEntityTooLarge)
+        return
+      }
+
+      r.Body = http.MaxBytesReader(w, r.Body, max)
+    }
+
+    if timeout := l.deadline(r.URL.Path); timeout > 0 {
+      end := time.Now().Add(timeout)
+      rc := http.NewResponseController(w)
+      rc.SetReadDeadline(end)
+      rc.SetWriteDeadline(end.Add(requestWriteGrace))
+
+      ctx, cancel := context.WithDeadline(r.Context(), end)
+      defer cancel()
+
+      r = r.WithContext(ctx)
+    }
+
+    next.ServeHTTP(w, r)
+  })
+}
+
+// bodyStatus is how to answer a body that didn't decode: 413 when it was
+// cut at the route's size, 400 otherwise.
+func bodyStatus(err error) int {
+  var tooLarge *http.MaxBytesError
+  if errors.As(err, &tooLarge) {
+    return http.StatusRequestEntityTooLarge
+  }
+
+  return http.StatusBadRequest
+}
+
+func keys[V any](m map[string]V) []string {
+  ks := make([]string, 0, len(m))
+  for k := range m {
+    ks = append(ks, k)
+  }
+
+  return ks
+}
+
+// routeSizes is -request.body.route: route=<KB>, repeatable.
+type routeSizes map[string]int64
+
+func (s routeSizes) String() string {
+  var out []string
+  for route, size := range s {
+    out = append(out, fmt.Sprintf("%s=%d", route, size/1024))
+  }
+
+  sort.Strings(out)
+  return strings.Join(out, ",")
+}
+
+func (s routeSizes) Set(v string) error {
+  route, raw, ok := strings.Cut(v, "=")
+  kb, err := strconv.ParseInt(raw, 10, 64)
+  if !ok || !strings.HasPrefix(route, "/") || err != nil || kb < 0 {
+    return fmt.Errorf("want /route=<KB>, got %q", v)
+  }
+
+  s[route] = kb * 1024
+  return nil
+}
+
+// routeTimeouts is -request.timeout.route: route=<duration>, repeatable.
+type routeTimeouts map[string]time.Duration
+
+func (t routeTimeouts) String() string {
+  var out []string
+  for route, d := range t {
+    out = append(out, route+"="+d.String())
+  }
+
+  sort.Strings(out)
+  return strings.Join(out, ",")
+}
+
+func (t routeTimeouts) Set(v string) error {
+  route, raw, ok := strings.Cut(v, "=")
+  d, err := time.ParseDuration(raw)
+  if !ok || !strings.HasPrefix(route, "/") || err != nil || d < 0 {
+    return fmt.Errorf("want /route=<duration>, got %q", v)
+  }
+
+  t[route] = d
+  return nil
+}
@@ -0,0 +1,558 @@
+// Package server is the weather-go HTTP service: flags, routes, background
+// workers and everything they keep in the store.
+package server
+
+import (
+  "crypto/tls"
+  "flag"
+  "fmt"
+  "log"
+  "math"
+  "net/http"
+  "strings"
+  "time"
+  _ "time/tzdata" // local times where the host has no zoneinfo
+
+  "github.com/im-kulikov/weather-go-external-api/internal/aggregate"
+  "github.com/im-kulikov/weather-go-external-api/internal/cache"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/storage"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// Main runs the weather-go server and its subcommands.
+func Main() {
+  wundergroundAPIKey := flag.String("wunderground.api.key", "0123456789abcdef", "wunderground.com API key")
+  openWeatherAPIKey := flag.String("openweather.api.key", "0123456789abcdef", "openweathermap.org API key")
+  openWeatherOneCall := flag.Bool("openweather.onecall", false, "the OpenWeather key is subscribed to One Call 3.0; enables its minutely nowcasts")
+  meteostatAPIKey := flag.String("meteostat.api.key", "", "RapidAPI key subscribed to Meteostat; enables the provider, for current readings and history backfill")
+  visualCrossingAPIKey := flag.String("visualcrossing.api.key", "", "visualcrossing.com API key; enables the provider, for current readings and history backfill")
+  tomorrowAPIKey := flag.String("tomorrow.api.key", "", "tomorrow.io API key; enables the provider, for current readings, the UV index and pollen")
+  stormglassAPIKey := flag.String("stormglass.api.key", "", "stormglass.io API key; enables the provider, for current readings, marine and soil conditions")
+  geocoderName := flag.String("geocoder", "nominatim", "city name geocoder: nominatim or owm")
+  geoipPath := flag.String("geoip.db", "", "MaxMind GeoLite2-City database; requests that name no place get the weather at the caller's approximate location")
+  geocoderTTL := flag.Duration("geocoder.cache.ttl", 24*time.Hour, "how long resolved city coordinates are cached")
+  timeZones := flag.Bool("geocoder.timezones", true, "name each place's IANA time zone in responses, asking Open-Meteo once per place")
+  offline := flag.Bool("offline", false, "air-gapped mode: serve climatology estimates and local station data only")
+  climatologyPath := flag.String("offline.climatology", "", "CSV of monthly normals to use instead of the bundled dataset")
+  batchConcurrency := flag.Int("batch.concurrency", 4, "cities looked up in parallel by one /weather/batch request")
+  batchMax := flag.Int("batch.max", 50, "maximum cities in one /weather/batch request")
+  bboxMaxCells := flag.Int("bbox.max.cells", 400, "maximum grid points in one /weather/bbox request")
+  precision := flag.Int("response.precision", 2, "decimal places of returned temperatures, 0 to 6; ?precision= overrides it per request")
+  routeHorizon := flag.Duration("route.horizon", 72*time.Hour, "how far ahead /route-weather accepts waypoint ETAs")
+  bboxUpstream := flag.Int("bbox.upstream", 20, "grid points of one /weather/bbox request fetched upstream when the cache has no reading near them")
+  storePath := flag.String("store.path", "", "file of the embedded store; empty keeps state in memory only")
+  storeRetention := flag.Duration("store.retention", 30*24*time.Hour, "how long deleted subscriptions, watchlists, groups and rules can be restored")
+  archiveTTL := flag.Duration("history.archive.ttl", 24*time.Hour, "how long days backfilled from provider archives for /v1/history?date= are cached; 0 disables it")
+  historyRetention := flag.Duration("history.retention", 7*24*time.Hour, "how long aggregate readings are kept for /v1/history; 0 keeps them forever")
+  historyStorage := flag.String("history.storage", "", "where the reading history and verification forecasts are kept: store (default, the embedded -store.path), memory, file:///<dir> or redis://...; overrides the config file's storage.history")
+  influxURL := flag.String("sink.influx.url", "", "InfluxDB write URL to export readings to, e.g. http://localhost:8086/api/v2/write?org=o&bucket=weather")
+  influxToken := flag.String("sink.influx.token", "", "InfluxDB API token")
+  sinkBatch := flag.Int("sink.batch", 500, "points per write to a time-series sink")
+  sinkFlush := flag.Duration("sink.flush", 10*time.Second, "longest a point waits before it is written to a sink")
+  outlierKelvin := flag.Float64("outliers.kelvin", 0, "leave providers more than this many kelvin from the median out of the average; 0 disables")
+  outlierSigma := flag.Float64("outliers.sigma", 0, "leave providers more than this many standard deviations from the others out of the average; 0 disables")
+  maxAge := flag.Duration("readings.max.age", 0, "leave provider readings observed longer ago than this, e.g. 2h, out of the average; 0 disables")
+  samplingFraction := flag.Float64("sampling.fraction", 1, "share of providers queried per background refresh, the rest reuse their last reading; 1 queries all")
+  samplingMaxAge := flag.Duration("sampling.max.age", 15*time.Minute, "oldest reading adaptive sampling may reuse")
+  dynamicWeights := flag.Bool("weights.dynamic", false, "weigh providers down by their recent disagreement with the consensus")
+  learnEvery := flag.Duration("weights.learn", 0, "how often to relearn each provider's disagreement with the consensus per region from the stored history, which then sets its weight there; 0 disables it")
+  learnWindow := flag.Duration("weights.learn.window", 7*24*time.Hour, "how much stored history weight learning looks back over")
+  learnCell := flag.Float64("weights.learn.cell", 10, "size of the regions weights are learned for, degrees of latitude and longitude")
+  verifyEvery := flag.Duration("verify.every", time.Hour, "how often observed places get a forecast issued for verification; 0 disables it")
+  verifyHours := flag.Int("verify.hours", 24, "forecast hours issued for verification")
+  storeMigrate := flag.Bool("store.migrate", true, "apply pending store migrations at startup")
+  cacheTTL := flag.Duration("cache.ttl", 5*time.Minute, "how long aggregate readings are served from cache; 0 disables it")
+  notFoundTTL := flag.Duration("cache.notfound.ttl", 10*time.Minute, "how long a city the geocoder or every provider says doesn't exist is answered 404 without asking again; 0 disables it")
+  cacheStale := flag.Duration("cache.stale", time.Hour, "how long expired readings are kept to serve while the SLO error budget is nearly spent or the providers fail")
+  cacheSnapshot := flag.String("cache.snapshot", "", "file the in-memory aggregate cache is saved to every -cache.snapshot.interval and on shutdown, and restored from at startup")
+  cacheSnapshotInterval := flag.Duration("cache.snapshot.interval", time.Minute, "how often -cache.snapshot is written")
+  cacheControl := flag.String("cache.control", "public", "Cache-Control of weather answers, their max-age what is left of the cache entry's -cache.ttl: public, private or off")
+  cacheStorage := flag.String("cache.storage", "", "where aggregate readings are cached: memory (default), file:///<dir> or redis://[:password@]host[:port][/db]; overrides the config file's storage.cache")
+  budget := flag.Duration("request.budget", 0, "how long a temperature lookup may take in all, e.g. 800ms; one that runs out gets the stale reading or 504, 0 is unlimited")
+  budgetGeocode := flag.Float64("request.budget.geocode", 0.25, "share of -request.budget that geocoding may use; the provider fan-out gets the rest")
+  headerTimeout := flag.Duration("request.header.timeout", 10*time.Second, "how long a client may take to send a request's headers; 0 is unlimited")
+  requestTimeout := flag.Duration("request.timeout", 0, "how long a request may run in all, its body and upstream calls included, e.g. 30s; streams and exports only stop at a -request.timeout.route of their own, 0 is unlimited")
+  requestTimeouts := routeTimeouts{}
+  flag.Var(requestTimeouts, "request.timeout.route", "-request.timeout of the requests under a path, /route=<duration>, e.g. /v1/weather/batch=1m; the longest matching route counts, with /v1 or without (repeatable)")
+  bodyMax := flag.Int64("request.body.max", 1024, "kilobytes a request body may have; 0 is unlimited")
+  requestBodies := routeSizes{}
+  flag.Var(requestBodies, "request.body.route", "-request.body.max of the requests under a path, /route=<KB>, e.g. /v1/weather/batch=4096 (repeatable)")
+  pathMax := flag.Int("request.path.max", 2048, "bytes a request path may have, escaped; 0 is unlimited")
+  idempotencyTTL := flag.Duration("idempotency.ttl", 24*time.Hour, "how long the answer to a POST with an Idempotency-Key is kept to answer its retries with; 0 ignores the header")
+  swrWait := flag.Duration("cache.swr.wait", 2*time.Second, "how long a request for an expired reading waits for the providers before getting the stale one while they catch up; 0 disables stale-while-revalidate")
+  degradedHistory := flag.Duration("degraded.history", 6*time.Hour, "while every provider fails and the cache has no reading left, answer from the last stored one this recent, extrapolated to now; 0 disables it")
+  streamInterval := flag.Duration("stream.interval", 30*time.Second, "how often streamed places are refreshed")
+  streamFloor := flag.Duration("stream.interval.min", 5*time.Second, "shortest interval a streamed or exported place is refreshed at as more subscribers share it")
+  smoothAlpha := flag.Float64("smooth.alpha", 0, "weight of the newest reading in the moving average of streamed values, (0, 1]; 0 disables smoothing")
+  prewarmTop := flag.Int("prewarm.top", 0, "keep the cache warm for this many of the most requested places")
+  prewarmWindow := flag.Duration("prewarm.window", time.Hour, "query window that ranks the places kept warm by -prewarm.top")
+  prewarmInterval := flag.Duration("prewarm.interval", 4*time.Minute, "how often pre-warmed places are refreshed; keep it below -cache.ttl")
+  prewarmWorkers := flag.Int("prewarm.workers", 4, "places refreshed in parallel by the pre-warmer")
+  enabled := flag.String("providers", "", "comma-separated providers to enable; empty enables all")
+  shadow := flag.String("providers.shadow", "", "comma-separated enabled providers to query only to compare with the answers, left out of them; see /v1/admin/providers")
+  sunsetWarn := flag.Duration("providers.sunset.warn", 90*24*time.Hour, "warn in the log, at startup and daily, about enabled providers whose API sunsets within this long")
+  healthWindow := flag.Duration("health.window", 5*time.Minute, "rolling window of the provider health in /v1/admin/providers")
+  circuitFailures := flag.Int("circuit.failures", 5, "consecutive failures that open a provider's circuit, leaving it out of the average; 0 disables circuit breaking")
+  circuitCooldown := flag.Duration("circuit.cooldown", 30*time.Second, "how long an open circuit leaves its provider out before trying it again")
+  use := flag.String("use", "non-commercial", "how the data is used, non-commercial or commercial; providers whose terms forbid it refuse to start")
+  analyticsRetention := flag.Duration("analytics.retention", 90*24*time.Hour, "how long hourly request analytics are kept for /v1/admin/analytics; 0 keeps them forever")
+  shutdownGrace := flag.Duration("shutdown.grace", 30*time.Second, "how long SIGINT or SIGTERM waits for requests in flight, streams included, before stopping")
+  analyticsFlush := flag.Duration("analytics.flush", 5*time.Minute, "how often the current hour's request analytics are written to the store")
+  statsKeep := flag.Duration("stats.keep", 48*time.Hour, "longest window of query statistics kept for /v1/stats/top-cities")
+  rateLimit := flag.Float64("ratelimit.rate", 0, "requests per second allowed per client IP; 0 disables rate limiting")
+  rateBurst := flag.Int("ratelimit.burst", 20, "requests a client may make in a burst above -ratelimit.rate")
+  configPath := flag.String("config", "", "JSON config file with API clients, provider keys, extra providers, alert rules and scheduled reports; SIGHUP reloads its keys, providers, cache TTLs and rate limits")
+  rulesInterval := flag.Duration("rules.interval", 5*time.Minute, "how often alert rules, from -config and /v1/rules, are evaluated")
+  authRequired := flag.Bool("auth", false, "require an API key from the -config clients on every API request")
+  sloAvailability := flag.Float64("slo.availability", 0.999, "availability target, the share of API requests that must not fail with 5xx")
+  sloLatency := flag.Duration("slo.latency", 500*time.Millisecond, "p95 latency target")
+  sloWindows := flag.String("slo.windows", "1h,5m,24h", "sliding windows the SLOs are reported over; the first one drives -slo.protect")
+  sloProtect := flag.Bool("slo.protect", false, "serve stale readings and shed batch requests while little error budget is left")
+  sloProtectBelow := flag.Float64("slo.protect.below", 0.1, "share of the error budget left that turns -slo.protect on")
+  adminToken := flag.String("admin.token", "", "bearer token for the /v1/admin API; empty disables it")
+  gzipResponses := flag.Bool("gzip", true, "gzip responses of 1KB or more for clients that accept it")
+  corsOrigins := flag.String("cors.origins", "", "comma-separated origins browser front-ends may call the API from, e.g. https://dash.example.com, or * for any; empty disables CORS")
+  corsMethods := flag.String("cors.methods", "GET,POST,PUT,DELETE", "methods allowed in cross-origin requests")
+  corsMaxAge := flag.Duration("cors.max.age", 10*time.Minute, "how long browsers may cache a preflight answer")
+  addr := flag.String("addr", ":8080", "address to listen on")
+  accessPath := flag.String("access.log", "", "file to write a line per request to, - for stdout; empty disables the access log")
+  accessFormat := flag.String("access.log.format", "combined", "access log format: combined (Apache's, with the duration and rate limit state appended) or json")
+  accessMaxSize := flag.Int("access.log.max.size", 100, "megabytes the access log file may grow to before it is rotated; 0 never rotates")
+  accessBackups := flag.Int("access.log.backups", 5, "rotated access log files kept, as <file>.1 to <file>.N")
+  tlsCert := flag.String("tls.cert", "", "certificate chain PEM file; with -tls.key the server speaks HTTPS and HTTP/2")
+  tlsKey := flag.String("tls.key", "", "private key PEM file of -tls.cert")
+  acmeDomain := flag.String("acme.domain", "", "comma-separated domains to get a certificate for from -acme.directory instead of -tls.cert")
+  acmeEmail := flag.String("acme.email", "", "contact address for the ACME account, for expiry notices")
+  acmeDirectory := flag.String("acme.directory", letsEncryptDirectory, "ACME directory URL")
+  acmeHTTP := flag.String("acme.http", ":80", "address answering ACME http-01 challenges and redirecting other requests to HTTPS")
+  upstreamClient := upstream.DefaultClientConfig
+  flag.DurationVar(&upstreamClient.Timeout, "upstream.timeout", upstreamClient.Timeout, "longest an upstream call may take, body included; 0 is no limit")
+  flag.DurationVar(&upstreamClient.DialTimeout, "upstream.dial.timeout", upstreamClient.DialTimeout, "longest connecting to an upstream may take, and its TLS handshake")
+  flag.DurationVar(&upstreamClient.HeaderTimeout, "upstream.header.timeout", upstreamClient.HeaderTimeout, "longest an upstream may take to send response headers")
+  flag.IntVar(&upstreamClient.IdleConns, "upstream.idle.conns", upstreamClient.IdleConns, "idle connections kept open per upstream host for reuse")
+  flag.DurationVar(&upstreamClient.IdleTimeout, "upstream.idle.timeout", upstreamClient.IdleTimeout, "how long an idle upstream connection is kept open")
+  flag.DurationVar(&upstreamClient.KeepAlive, "upstream.keepalive", upstreamClient.KeepAlive, "TCP keep-alive period of upstream connections; 0 disables keep-alives and connection reuse")
+  flag.StringVar(&upstreamClient.Proxy, "upstream.proxy", "", "proxy URL for upstream calls; empty uses HTTP_PROXY, HTTPS_PROXY and NO_PROXY")
+  pins := upstream.PinSet{}
+  var prewarmCities, exportCities cityList
+  flag.Var(&prewarmCities, "prewarm.city", "always keep the cache warm for this city, e.g. \"paris,fr\" (repeatable)")
+  flag.Var(&exportCities, "exporter.city", "export this city's temperature by every provider as weather_temperature_kelvin on /metrics (repeatable)")
+  exportInterval := flag.Duration("exporter.interval", time.Minute, "how often the -exporter.city temperatures are refreshed")
+  recordPath := flag.String("upstream.record", "", "write every upstream exchange to this fixtures file, with API keys redacted")
+  replayPath := flag.String("upstream.replay", "", "answer upstream calls from a fixtures file written by -upstream.record instead of the network")
+  signKey := flag.String("sign.key", "", "Ed25519 private key, PKCS #8 PEM, to sign answers with in X-Signature; weather answers then carry their provenance")
+  chaosSpec := flag.String("chaos", "", "inject faults into upstream calls, for resilience testing in staging: latency=<duration>@<rate>,error=<rate>,malformed=<rate>; also enables PUT /v1/admin/chaos")
+  policies := outputPolicies{}
+  flag.Var(policies, "output.policy", "limit how a provider's values are shown, provider=round:<kelvin>,delay:<duration>,aggregate-only (repeatable)")
+  staticWeights := aggregate.WeightSet{}
+  flag.Var(staticWeights, "provider.weight", "weight of a provider in the average, provider=<weight>, default 1 (repeatable)")
+  providerTTLs := cache.TTLSet{}
+  flag.Var(providerTTLs, "provider.ttl", "how long a provider's readings are reused below the aggregate cache, provider=<duration>; default its update cadence (openweathermap 10m, open-meteo 15m, met.no 1h), 0 asks every time (repeatable)")
+  var fanout providers.ErrorPolicy
+  flag.Var(&fanout, "fanout.policy", "when a provider fails: collect-all waits for the rest, so each one's outcome is known; fail-fast cancels them, since the aggregate has failed anyway")
+  baseURLs := baseURLSet{}
+  flag.Var(baseURLs, "provider.url", "base URL of a built-in provider's API, provider=<URL>, for a mirror, a proxy or a test server; open-meteo.archive is Open-Meteo's history (repeatable)")
+  budgets := quotaSet{}
+  flag.Var(budgets, "provider.quota", "call budget of a provider, provider=<calls>/<s|m|h|d>,...; exhausted providers are left out until the window resets (repeatable)")
+  caps := upstream.ConcurrencySet{}
+  flag.Var(caps, "provider.concurrency", "most calls in flight to a provider at once, provider=<calls>, for upstreams that throttle by concurrent connections (repeatable)")
+  capWait := flag.Duration("provider.concurrency.wait", time.Second, "how long a call over its provider's -provider.concurrency waits for a slot before failing busy; 0 fails at once")
+  var proxies proxyList
+  flag.Var(&proxies, "trusted.proxy", "address or CIDR range of a reverse proxy whose X-Forwarded-For is believed when locating, rate limiting and logging callers (repeatable)")
+  flag.Var(pins, "tls.pin", "pin an upstream host to an SPKI hash, host=sha256/<base64> (repeatable)")
+  flag.Parse()
+
+  switch flag.Arg(0) {
+  case "backup":
+    if err := backup(*storePath, flag.Args()[1:]); err != nil {
+      log.Fatal(err)
+    }
+
+    return
+  case "restore":
+    if err := restore(*storePath, flag.Args()[1:]); err != nil {
+      log.Fatal(err)
+    }
+
+    return
+  case "migrate":
+    if err := migrateCommand(*storePath, flag.Args()[1:]); err != nil {
+      log.Fatal(err)
+    }
+
+    return
+  case "config":
+    if err := configCommand(*configPath, flag.Args()[1:]); err != nil {
+      log.Fatal(err)
+    }
+
+    return
+  case "bench":
+    if err := benchCommand(flag.Args()[1:]); err != nil {
+      log.Fatal(err)
+    }
+
+    return
+  case "":
+  default:
+    log.Fatalf("unknown command %q, want backup, restore, migrate, config or bench", flag.Arg(0))
+  }
+
+  cfg, err := loadConfig(*configPath)
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  st := settings{
+    keys: map[string]string{
+      "openweathermap": *openWeatherAPIKey, "wunderground": *wundergroundAPIKey, "meteostat": *meteostatAPIKey,
+      "visualcrossing": *visualCrossingAPIKey, "tomorrow.io": *tomorrowAPIKey, "stormglass": *stormglassAPIKey,
+    },
+    enabled: *enabled, shadow: *shadow, cacheTTL: *cacheTTL, cacheStale: *cacheStale, providerTTLs: providerTTLs, rate: *rateLimit, burst: *rateBurst,
+  }
+
+  flagged := st
+  st = st.with(cfg)
+
+  transport, err := upstreamClient.Transport()
+  if err != nil {
+    log.Fatalf("-upstream.proxy: %s", err)
+  }
+
+  if len(pins) > 0 {
+    transport = upstream.PinnedTransport(transport, pins)
+    log.Printf("tls pins: %s", pins)
+  }
+
+  upstream.Client.Transport, upstream.Client.Timeout = transport, upstreamClient.Timeout
+
+  switch {
+  case *recordPath != "" && *replayPath != "":
+    log.Fatal("-upstream.record and -upstream.replay are exclusive")
+  case *recordPath != "":
+    upstream.Client.Transport = upstream.RecordFixtures(*recordPath, upstream.Client.Transport, st.keys["wunderground"], st.keys["openweathermap"])
+    log.Printf("recording upstream calls to %s", *recordPath)
+  case *replayPath != "":
+    f, err := upstream.ReplayFixtures(*replayPath, st.keys["wunderground"], st.keys["openweathermap"])
+    if err != nil {
+      log.Fatal(err)
+    }
+
+    upstream.Client.Transport = f
+    log.Printf("replaying upstream calls from %s", *replayPath)
+  }
+
+  var sign *signer
+  if *signKey != "" {
+    if sign, err = loadSigner(*signKey); err != nil {
+      log.Fatalf("-sign.key: %s", err)
+    }
+
+    log.Printf("signing answers with key %s", sign.id)
+  }
+
+  var chaos *upstream.Chaos
+  if *chaosSpec != "" {
+    f, err := upstream.ParseFaults(*chaosSpec)
+    if err != nil {
+      log.Fatal(err)
+    }
+
+    chaos = upstream.NewChaos(upstream.Client.Transport, f)
+    upstream.Client.Transport = chaos
+    log.Printf("chaos: injecting %s into upstream calls", f)
+  }
+
+  if *capWait < 0 {
+    log.Fatalf("-provider.concurrency.wait must not be negative, got %v", *capWait)
+  }
+
+  if len(caps) > 0 {
+    upstream.Concurrency = upstream.NewLimits(caps, *capWait)
+  }
+
+  log.Printf("wunderground apiKey: %s", st.keys["wunderground"])
+  log.Printf("openWeather apiKey: %s", st.keys["openweathermap"])
+
+  g, err := geo.New(*geocoderName, st.keys["openweathermap"])
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  memo := geo.NewCachedGeocoder(g, *geocoderTTL)
+  memo.MissTTL = *notFoundTTL
+  var geocoder geo.Geocoder = memo
+
+  db, err := openStore(*storePath)
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  if err := db.compact(); err != nil {
+    log.Fatal(err)
+  }
+
+  if *storeMigrate {
+    err = migrateTo(db, latestSchema(), log.Printf)
+  } else if v, _ := schemaVersion(db); v != latestSchema() {
+    err = fmt.Errorf("store schema is v%d, this binary needs v%d: run `weather-go migrate up`", v, latestSchema())
+  }
+
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  var getCert func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+  switch {
+  case *acmeDomain != "" && *tlsCert != "":
+    log.Fatal("-acme.domain and -tls.cert are mutually exclusive")
+  case (*tlsCert == "") != (*tlsKey == ""):
+    log.Fatal("-tls.cert and -tls.key go together")
+  case *tlsCert != "":
+    files, err := loadCertFiles(*tlsCert, *tlsKey)
+    if err != nil {
+      log.Fatal(err)
+    }
+
+    getCert = files.getCertificate
+  case *acmeDomain != "":
+    var domains []string
+    for _, d := range strings.Split(*acmeDomain, ",") {
+      if d = strings.TrimSpace(d); d != "" {
+        domains = append(domains, d)
+      }
+    }
+
+    m, err := newACMEManager(db, *acmeDirectory, *acmeEmail, domains, *addr)
+    if err != nil {
+      log.Fatal(err)
+    }
+
+    go func() { log.Fatal(http.ListenAndServe(*acmeHTTP, http.HandlerFunc(m.challenges))) }()
+    go m.run()
+    getCert = m.getCertificate
+  }
+
+  pws := newPWSStore(db)
+
+  baseURLs.merge(cfg.BaseURLs)
+  if *cacheStorage == "" {
+    *cacheStorage = cfg.Storage.Cache
+  }
+
+  if *historyStorage == "" {
+    *historyStorage = cfg.Storage.History
+  }
+
+  if *authRequired && len(st.clients) == 0 {
+    log.Fatal(errNoClients)
+  }
+
+  var cached storage.Backend
+  if *cacheStorage != "" {
+    if cached, err = storage.Open(*cacheStorage); err != nil {
+      log.Fatal("cache: ", err)
+    }
+  }
+
+  aggregates := cache.New(st.cacheTTL, st.cacheStale, cached)
+
+  historyDB, err := openHistoryStorage(*historyStorage, db)
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  crossOrigin, err := newCORS(*corsOrigins, *corsMethods, *corsMaxAge)
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  cacheHeaders, err := newCacheHeaders(*cacheControl, aggregates)
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  access, err := newAccessLog(*accessPath, *accessFormat, int64(*accessMaxSize)<<20, *accessBackups, proxies)
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  windows, err := parseWindows(*sloWindows)
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  if *batchConcurrency < 1 {
+    log.Fatalf("-batch.concurrency must be 1 or more, got %d", *batchConcurrency)
+  }
+
+  if *precision < 0 || *precision > maxPrecision {
+    log.Fatalf("-response.precision must be between 0 and %d, got %d", maxPrecision, *precision)
+  }
+
+  if *learnCell <= 0 || *learnCell > 90 || math.Mod(180, *learnCell) != 0 {
+    log.Fatalf("-weights.learn.cell must divide 180 into whole regions, such as 5, 10 or 30, got %g", *learnCell)
+  }
+
+  if *smoothAlpha < 0 || *smoothAlpha > 1 {
+    log.Fatalf("-smooth.alpha must be between 0 and 1, got %g", *smoothAlpha)
+  }
+
+  if *bodyMax < 0 || *pathMax < 0 {
+    log.Fatalf("-request.body.max and -request.path.max must be 0 or more, got %d and %d", *bodyMax, *pathMax)
+  }
+
+  if *budgetGeocode <= 0 || *budgetGeocode > 1 {
+    log.Fatalf("-request.budget.geocode must be over 0 and at most 1, got %g", *budgetGeocode)
+  }
+
+  if *cacheSnapshot != "" && *cacheSnapshotInterval <= 0 {
+    log.Fatalf("-cache.snapshot.interval must be over 0, got %s", *cacheSnapshotInterval)
+  }
+
+  var extra providers.Multi
+  extra = append(extra, cfg.generic...)
+  for _, p := range cfg.plugins {
+    extra = append(extra, p)
+  }
+
+  build := providerBuild{path: *configPath, oneCall: *openWeatherOneCall, baseURLs: baseURLs, use: *use, extra: extra, routing: cfg.Routing, tenants: cfg.Tenants}
+  mw, shadowNames, err := build.providers(st)
+  if err != nil && !*offline {
+    log.Fatal(err)
+  }
+
+  readings := cache.NewProviderReadings(st.providerTTLs)
+  if *offline {
+    climate, err := openClimatology(*climatologyPath)
+    if err != nil {
+      log.Fatal(err)
+    }
+
+    log.Printf("offline mode: %d climatology stations", len(climate))
+    geocoder = offlineGeocoder{stations: climate}
+    mw = providers.Multi{offlineModel{climate: climate, pws: pws}}
+  }
+
+  for _, p := range mw {
+    if pl, ok := p.(*providers.Plugin); ok {
+      if err := pl.Start(); err != nil {
+        log.Fatal(err)
+      }
+    }
+  }
+
+  var ipdb *geo.IPDatabase
+  if *geoipPath != "" {
+    if ipdb, err = geo.OpenIPDatabase(*geoipPath); err != nil {
+      log.Fatalf("geoip: %s", err)
+    }
+
+    log.Printf("geoip: %s", ipdb.Type)
+  }
+
+  var zones *geo.TimeZones
+  if *timeZones && !*offline {
+    zones = &geo.TimeZones{BaseURL: baseURLs["open-meteo"]}
+  }
+
+  srv := &server{
+    geo:              geocoder,
+    ipdb:             ipdb,
+    zones:            zones,
+    proxies:          proxies,
+    providers:        newProviderSet(mw, shadowNames),
+    offline:          *offline,
+    pws:              pws,
+    batchConcurrency: *batchConcurrency,
+    batchMax:         *batchMax,
+    bboxMaxCells:     *bboxMaxCells,
+    bboxUpstream:     *bboxUpstream,
+    routeHorizon:     *routeHorizon,
+    precision:        *precision,
+    subscriptions:    newSubscriptions(db),
+    watchlists:       newWatchlists(db),
+    groups:           newGroups(db),
+    overrides:        newOverrides(db, adminOnly(*adminToken)),
+    preferences:      newPreferences(db, adminOnly(*adminToken)),
+    rules:            newRules(db, cfg.Notifications, adminOnly(*adminToken)),
+    cache:            aggregates,
+    readings:         readings,
+    popular:          newPopularity(*statsKeep),
+    analytics:        newAnalytics(db, *analyticsRetention, *analyticsFlush),
+    history:          newHistory(historyDB, *historyRetention),
+    archive:          newArchiveCache(*archiveTTL, archiveMaxDays),
+    policies:         policies,
+    outliers:         aggregate.Outliers{Kelvin: *outlierKelvin, Sigma: *outlierSigma},
+    staleness:        aggregate.Staleness{MaxAge: *maxAge},
+    sampler:          aggregate.NewSampler(*samplingFraction, *samplingMaxAge),
+    fanout:           fanout,
+    weights:          aggregate.NewWeights(staticWeights, *dynamicWeights),
+    limiter:          newRateLimiter(st.rate, st.burst, proxies),
+    auth:             newClientAuth(*authRequired, st.clients),
+    slo:              newSLOTracker(*sloAvailability, *sloLatency, windows, *sloProtect, *sloProtectBelow),
+    smoother:         newSmoother(*smoothAlpha),
+    routing:          cfg.routes,
+    quotas:           newQuotas(budgets),
+    health:           newProviderHealth(*healthWindow, *circuitFailures, *circuitCooldown),
+    shadows:          newShadows(),
+    stopping:         make(chan struct{}),
+    tenants:          newTenants(cfg.Tenants, st.cacheTTL, st.cacheStale, st.providerTTLs, func() *providerHealth { return newProviderHealth(*healthWindow, *circuitFailures, *circuitCooldown) }),
+    adminGuard:       adminOnly(*adminToken),
+    swrWait:          *swrWait,
+    degradedAge:      *degradedHistory,
+    budget:           requestBudget{total: *budget, geocode: *budgetGeocode},
+    gzip:             *gzipResponses,
+    cors:             crossOrigin,
+    access:           access,
+    cacheHeaders:     cacheHeaders,
+    chaos:            chaos,
+    signer:           sign,
+    unknown:          newTombstones(*notFoundTTL),
+    idempotency:      newIdempotency(*idempotencyTTL, proxies),
+    limits:           limits{pathMax: *pathMax, bodyMax: *bodyMax * 1024, timeout: *requestTimeout, bodies: requestBodies, timeouts: requestTimeouts},
+  }
+
+  if err := seedGroups(srv.groups, cfg.Groups); err != nil {
+    log.Fatal(err)
+  }
+
+  if *cacheSnapshot != "" {
+    warmStart(srv.cache, *cacheSnapshot, *cacheSnapshotInterval)
+  }
+
+  srv.learner = newWeightLearner(srv, *learnEvery, *learnWindow, *learnCell)
+  srv.feeds = newFeeds(srv, *streamInterval, *streamFloor)
+  srv.sinks = []sink{srv.history, srv.smoother, newVerifier(srv, *verifyEvery, *verifyHours)}
+  if m := cfg.Notifications.MQTT; m != nil && m.Readings != "" {
+    srv.sinks = append(srv.sinks, newMQTTSink(m))
+  }
+
+  if *influxURL != "" {
+    srv.sinks = append(srv.sinks, newInfluxSink(*influxURL, *influxToken, *sinkBatch, *sinkFlush))
+  }
+
+  go (&prewarmer{srv: srv, cities: prewarmCities, top: *prewarmTop, window: *prewarmWindow, interval: *prewarmInterval, workers: *prewarmWorkers}).run()
+  go newExporter(srv, exportCities, *exportInterval).run()
+  go watchSunsets(mw, *sunsetWarn)
+  go janitor(*storeRetention, srv.subscriptions, srv.watchlists, srv.groups, srv.preferences, srv.rules)
+  go newDispatcher(srv, srv.subscriptions).run()
+  go newAlerter(srv, cfg.rules, srv.rules, *rulesInterval).run()
+  go newReporter(srv, db, cfg.reports).run()
+  go srv.learner.run()
+  go (&reloader{srv: srv, path: *configPath, flags: flagged, build: build, auth: *authRequired, started: cfg}).run()
+
+  scheme := "http"
+  if getCert != nil {
+    scheme = "https"
+  }
+
+  log.Printf("listening on %s (%s)", *addr, scheme)
+
+  hs := newHTTPServer(*addr, srv.handler(), *headerTimeout, getCert)
+  if err := (shutdown{srv: srv, hs: hs, grace: *shutdownGrace, snapshot: *cacheSnapshot, db: db}).run(); err != nil {
+    log.Fatal(err)
+  }
+}
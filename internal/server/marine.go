@@ -0,0 +1,125 @@
+package server
+
+import (
+  "context"
+  "errors"
+  "net/http"
+  "strings"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/aggregate"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+var errNoMarine = errors.New("no enabled provider has marine data for this place")
+
+// marine answers GET /v1/marine/{location} (or ?lat=&lon=) with waves,
+// swell and sea temperature now. Heights, periods and temperatures are the
+// providers' mean, directions their mean as vectors, each as long as its
+// provider's height.
+func (s *server) marine(w http.ResponseWriter, r *http.Request) {
+  begin := time.Now()
+  ctx := upstream.WithTrace(r)
+
+  d, ok := s.requestDisplay(w, r)
+  if !ok {
+    return
+  }
+
+  loc, ok := s.placeFor(ctx, w, r, pointLocation)
+  if !ok {
+    return
+  }
+
+  replies := askEach(ctx, s, loc, providers.ErrNoMarine, func(p providers.Provider) (func(context.Context, geo.Location) (providers.Marine, error), bool) {
+    m, ok := p.(providers.MarineReporter)
+    if !ok {
+      return nil, false
+    }
+
+    return m.Marine, true
+  })
+
+  resp := &MarineResponse{SchemaVersion: responseVersion, City: loc.Name, Region: loc.Region, Country: loc.Country, Lat: loc.Lat, Lon: loc.Lon, TimeZone: loc.TimeZone, Units: d.units}
+  var seas []providers.Marine
+  for _, a := range replies {
+    src := MarineSource{Provider: a.p.Name(), Omitted: a.omitted, Error: a.err, Took: a.took}
+    if a.ok {
+      src.WaveHeight, src.SwellHeight, src.SeaTemp = d.value(a.v.WaveHeight), d.value(a.v.SwellHeight), d.temp(a.v.SeaKelvin)
+      seas = append(seas, a.v)
+      resp.ProviderCount++
+      resp.Attribution = append(resp.Attribution, providers.Attributions(providers.Multi{a.p})...)
+    }
+
+    resp.Providers = append(resp.Providers, src)
+  }
+
+  if resp.ProviderCount == 0 {
+    httpError(w, errNoMarine.Error()+replyFailures(replies), repliesStatus(replies))
+    return
+  }
+
+  mean := func(v func(providers.Marine) *float64) *float64 { return d.value(meanMarine(seas, v)) }
+  resp.WaveHeight = mean(func(m providers.Marine) *float64 { return m.WaveHeight })
+  resp.WavePeriod = mean(func(m providers.Marine) *float64 { return m.WavePeriod })
+  resp.SwellHeight = mean(func(m providers.Marine) *float64 { return m.SwellHeight })
+  resp.SwellPeriod = mean(func(m providers.Marine) *float64 { return m.SwellPeriod })
+  resp.SeaTemp = d.temp(meanMarine(seas, func(m providers.Marine) *float64 { return m.SeaKelvin }))
+  resp.WaveDirection = d.value(marineDirection(seas, func(m providers.Marine) (*float64, *float64) { return m.WaveDirection, m.WaveHeight }))
+  resp.SwellDirection = d.value(marineDirection(seas, func(m providers.Marine) (*float64, *float64) { return m.SwellDirection, m.SwellHeight }))
+  resp.Took = time.Since(begin).String()
+  writeJSON(w, http.StatusOK, resp)
+}
+
+// pointLocation is requestLocation that also takes "lat,lon" for the
+// location, since surf spots, anchorages and fields are seldom a city's.
+func pointLocation(ctx context.Context, r *http.Request, g geo.Geocoder) (geo.Location, error) {
+  place := r.PathValue("location")
+  if lat, lon, ok := strings.Cut(place, ","); ok {
+    if loc, err := geo.Coordinates(strings.TrimSpace(lat), strings.TrimSpace(lon)); err == nil {
+      return loc, nil
+    }
+  }
+
+  r.SetPathValue("city", place)
+  return requestLocation(ctx, r, g)
+}
+
+func meanMarine(seas []providers.Marine, v func(providers.Marine) *float64) *float64 {
+  sum, n := 0.0, 0
+  for _, m := range seas {
+    if x := v(m); x != nil {
+      sum, n = sum+*x, n+1
+    }
+  }
+
+  if n == 0 {
+    return nil
+  }
+
+  mean := sum / float64(n)
+  return &mean
+}
+
+// marineDirection weighs each provider's direction by its height, by one
+// metre where it gives none.
+func marineDirection(seas []providers.Marine, v func(providers.Marine) (dir, height *float64)) *float64 {
+  var degrees, weights []float64
+  for _, m := range seas {
+    dir, height := v(m)
+    if dir == nil {
+      continue
+    }
+
+    weight := 1.0
+    if height != nil {
+      weight = *height
+    }
+
+    degrees, weights = append(degrees, *dir), append(weights, weight)
+  }
+
+  return aggregate.MeanDirection(degrees, weights)
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+  "errors"
+  "fmt"
+  "os"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// migration upgrades the store from version-1 to version. The store has no
+// transactions, so the version is only bumped after up succeeds and every
+// migration must be safe to re-run after a crash.
+type migration struct {
+  version int
+  name    string
+  up      func(*store) error
+}
+
+// migrations is the ordered schema history; append only, never renumber.
+var migrations = []migration{
+  {1, "baseline: pws readings keyed by station", func(*store) error { return nil }},
+  {2, "resources: versions start at 1 for optimistic locking", versionResources},
+}
+
+func versionResources(s *store) error {
+  for _, bucket := range []string{"subscriptions", "watchlists"} {
+    for _, k := range s.keys(bucket, "") {
+      var doc map[string]interface{}
+      if _, err := s.get(bucket, k, &doc); err != nil {
+        return err
+      }
+
+      if v, _ := doc["version"].(float64); v >= 1 {
+        continue
+      }
+
+      doc["version"] = 1
+      if err := s.put(bucket, k, doc); err != nil {
+        return err
+      }
+    }
+  }
+
+  return nil
+}
+
+const (
+  metaBucket       = "meta"
+  schemaVersionKey = "schema_version"
+)
+
+func schemaVersion(s *store) (int, error) {
+  var v int
+  _, err := s.get(metaBucket, schemaVersionKey, &v)
+  return v, err
+}
+
+func latestSchema() int {
+  return migrations[len(migrations)-1].version
+}
+
+// migrateTo applies pending migrations up to target under the store lock.
+func migrateTo(s *store, target int, logf func(string, ...interface{})) error {
+  unlock, err := lockStore(s.path, 30*time.Second)
+  if err != nil {
+    return err
+  }
+
+  defer unlock()
+
+  current, err := schemaVersion(s)
+  if err != nil {
+    return err
+  }
+
+  if current > latestSchema() {
+    return fmt.Errorf("store schema v%d is newer than this binary knows (v%d)", current, latestSchema())
+  }
+
+  if target < current {
+    return fmt.Errorf("store is at v%d, migrations only go forward", current)
+  }
+
+  for _, m := range migrations {
+    if m.version <= current || m.version > target {
+      continue
+    }
+
+    begin := time.Now()
+    if err := m.up(s); err != nil {
+      return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+    }
+
+    if err := s.put(metaBucket, schemaVersionKey, m.version); err != nil {
+      return err
+    }
+
+    logf("store: migrated to v%d (%s), took: %s", m.version, m.name, time.Since(begin).String())
+  }
+
+  return nil
+}
+
+// lockStore takes an exclusive lock file next to the store so that two
+// processes never migrate concurrently. A crashed holder leaves the file
+// behind; its pid is in the error to make cleaning up safe.
+func lockStore(path string, wait time.Duration) (func(), error) {
+  if path == "" {
+    return func() {}, nil
+  }
+
+  lock := path + ".lock"
+  deadline := time.Now().Add(wait)
+  for {
+    f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+    if err == nil {
+      fmt.Fprintf(f, "%d\n", os.Getpid())
+      f.Close()
+      return func() { os.Remove(lock) }, nil
+    }
+
+    if !errors.Is(err, os.ErrExist) {
+      return nil, err
+    }
+
+    if time.Now().After(deadline) {
+      holder, _ := os.ReadFile(lock)
+      return nil, fmt.Errorf("store is locked by pid %s (%s); remove the file if that process is gone", strings.TrimSpace(string(holder)), lock)
+    }
+
+    time.Sleep(200 * time.Millisecond)
+  }
+}
+
+// migrateCommand implements `weather-go migrate [status|up|to <version>]`.
+func migrateCommand(storePath string, args []string) error {
+  if storePath == "" {
+    return errors.New("usage: weather-go -store.path=<store> migrate [status|up|to <version>]")
+  }
+
+  s, err := openStore(storePath)
+  if err != nil {
+    return err
+  }
+
+  defer s.close()
+
+  current, err := schemaVersion(s)
+  if err != nil {
+    return err
+  }
+
+  printf := func(format string, a ...interface{}) { fmt.Printf(format+"\n", a...) }
+
+  switch {
+  case len(args) == 0 || args[0] == "status":
+    printf("store %s: schema v%d, binary knows v%d", storePath, current, latestSchema())
+    for _, m := range migrations {
+      state := "pending"
+      if m.version <= current {
+        state = "applied"
+      }
+
+      printf("  %3d %-8s %s", m.version, state, m.name)
+    }
+
+    return nil
+  case args[0] == "up":
+    return migrateTo(s, latestSchema(), printf)
+  case args[0] == "to" && len(args) == 2:
+    target, err := strconv.Atoi(args[1])
+    if err != nil || target > latestSchema() {
+      return fmt.Errorf("unknown schema version %q", args[1])
+    }
+
+    return migrateTo(s, target, printf)
+  default:
+    return fmt.Errorf("unknown migrate command %q", strings.Join(args, " "))
+  }
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+  "context"
+  "encoding/json"
+  "fmt"
+  "log"
+  "strings"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/mqtt"
+)
+
+const (
+  defaultReadingsTopic = "weather/{city}/temperature"
+  defaultAlertTopic    = "weather/{city}/alert"
+)
+
+// mqttConfig is the config file's "notifications.mqtt": a broker that
+// rules' mqtt channels publish alerts to and, with a readings topic, that
+// gets every aggregate reading, for Home Assistant and the like.
+type mqttConfig struct {
+  Broker   string `json:"broker"`
+  ClientID string `json:"client_id,omitempty"`
+  Username string `json:"username,omitempty"`
+  Password string `json:"password,omitempty"`
+  QoS      byte   `json:"qos,omitempty"`
+  Readings string `json:"readings,omitempty"` // topic, e.g. weather/{city}/temperature
+  Retain   bool   `json:"retain,omitempty"`   // of readings, so subscribers get the last one at once
+
+  client *mqtt.Client
+}
+
+func (m *mqttConfig) compile() []string {
+  var errs []string
+  if _, _, err := mqtt.ParseBroker(m.Broker); err != nil {
+    errs = append(errs, "mqtt.broker: "+err.Error())
+  }
+
+  if m.QoS > 1 {
+    errs = append(errs, fmt.Sprintf("mqtt.qos: want 0 or 1, got %d", m.QoS))
+  }
+
+  if m.Readings != "" {
+    if err := checkTopic(m.Readings); err != nil {
+      errs = append(errs, "mqtt.readings: "+err.Error())
+    }
+  }
+
+  if m.ClientID == "" {
+    m.ClientID = "weather-go"
+  }
+
+  m.client = &mqtt.Client{Broker: m.Broker, ClientID: m.ClientID, Username: m.Username, Password: m.Password}
+  return errs
+}
+
+// checkTopic rejects topics that can't be published to.
+func checkTopic(t string) error {
+  if t == "" || strings.ContainsAny(t, "+#\x00") {
+    return fmt.Errorf("want a topic without wildcards, such as %s, got %q", defaultReadingsTopic, t)
+  }
+
+  return nil
+}
+
+// topicLevel makes a name usable as one topic level: lower case, with
+// separators and wildcards replaced.
+var topicLevel = strings.NewReplacer("/", "-", "+", "-", "#", "-", " ", "-", "\x00", "")
+
+func expandTopic(t, city, rule string) string {
+  return strings.NewReplacer("{city}", topicLevel.Replace(strings.ToLower(city)), "{rule}", topicLevel.Replace(rule)).Replace(t)
+}
+
+// mqttChannel publishes the notification's payload to topic, by default
+// weather/{city}/alert.
+type mqttChannel struct {
+  broker *mqttConfig
+  topic  string
+}
+
+func newMQTTChannel(cc channelConfig, n notificationsConfig) (channel, []string) {
+  var errs []string
+  if n.MQTT == nil {
+    errs = append(errs, "type: mqtt needs notifications.mqtt in the config")
+  }
+
+  topic := cc.Topic
+  if topic == "" {
+    topic = defaultAlertTopic
+  }
+
+  if err := checkTopic(topic); err != nil {
+    errs = append(errs, "topic: "+err.Error())
+  }
+
+  if len(errs) > 0 {
+    return nil, errs
+  }
+
+  return mqttChannel{broker: n.MQTT, topic: topic}, nil
+}
+
+func (c mqttChannel) kind() string { return "mqtt" }
+
+func (c mqttChannel) send(ctx context.Context, n notification) error {
+  return c.broker.client.Publish(ctx, expandTopic(c.topic, n.City, n.Rule), n.payload(), c.broker.QoS, false)
+}
+
+// mqttReading is a reading as published: one JSON object in every unit, so
+// a Home Assistant sensor picks its own with value_template.
+type mqttReading struct {
+  City       string             `json:"city"`
+  Country    string             `json:"country,omitempty"`
+  Lat        float64            `json:"lat"`
+  Lon        float64            `json:"lon"`
+  Kelvin     float64            `json:"temp_k"`
+  Celsius    float64            `json:"temp_c"`
+  Fahrenheit float64            `json:"temp_f"`
+  Time       time.Time          `json:"time"`
+  Providers  map[string]float64 `json:"providers"` // kelvin
+}
+
+type mqttMessage struct {
+  topic   string
+  payload []byte
+}
+
+// mqttSink publishes every aggregate to the readings topic in the
+// background; when the broker can't keep up, readings are dropped rather
+// than slowing requests down.
+type mqttSink struct {
+  broker   *mqttConfig
+  messages chan mqttMessage
+  flushes  chan chan struct{}
+}
+
+func newMQTTSink(broker *mqttConfig) *mqttSink {
+  k := &mqttSink{broker: broker, messages: make(chan mqttMessage, 256), flushes: make(chan chan struct{})}
+  go k.run()
+  return k
+}
+
+func (k *mqttSink) send(ctx context.Context, loc geo.Location, r historyReading) {
+  c := r.Kelvin - 273.15
+  body, _ := json.Marshal(mqttReading{
+    City: loc.Name, Country: loc.Country, Lat: loc.Lat, Lon: loc.Lon,
+    Kelvin: r.Kelvin, Celsius: c, Fahrenheit: c*9/5 + 32, Time: r.Time, Providers: r.Providers,
+  })
+
+  select {
+  case k.messages <- mqttMessage{topic: expandTopic(k.broker.Readings, loc.Name, ""), payload: body}:
+  default:
+    sinkPoints.Inc("mqtt", "dropped")
+  }
+}
+
+// flush publishes every reading queued so far.
+func (k *mqttSink) flush() {
+  done := make(chan struct{})
+  k.flushes <- done
+  <-done
+}
+
+func (k *mqttSink) run() {
+  for {
+    select {
+    case m := <-k.messages:
+      k.publish(m)
+    case done := <-k.flushes:
+      for queued := true; queued; {
+        select {
+        case m := <-k.messages:
+          k.publish(m)
+        default:
+          queued = false
+        }
+      }
+
+      close(done)
+    }
+  }
+}
+
+func (k *mqttSink) publish(m mqttMessage) {
+  if err := k.broker.client.Publish(context.Background(), m.topic, m.payload, k.broker.QoS, k.broker.Retain); err != nil {
+    log.Printf("sink: %s", err)
+    sinkPoints.Inc("mqtt", "error")
+    return
+  }
+
+  sinkPoints.Inc("mqtt", "ok")
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "math"
+  "net/http"
+  "sort"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+const (
+  nowcastHorizon = time.Hour
+  rainThreshold  = 0.1             // mm/h; anything less is drizzle a radar can't tell from noise
+  nowcastStep    = 5 * time.Minute // assumed length of a provider's last point
+)
+
+var errNoNowcasters = errors.New("no enabled provider has a nowcast for this place")
+
+// nowcast answers GET /v1/nowcast/{city} (or ?lat=&lon=) with the chance
+// and rate of precipitation for each minute of the next hour. Providers
+// disagree less about whether it rains than about how much, and a nowcast
+// is for not getting caught out, so each minute takes the wettest provider:
+// the highest probability and rate, rain if any of them expects it.
+func (s *server) nowcast(w http.ResponseWriter, r *http.Request) {
+  begin := time.Now()
+  ctx := upstream.WithTrace(r)
+
+  loc, err := requestLocation(ctx, r, s.geo)
+  var amb *geo.AmbiguousError
+  if errors.As(err, &amb) {
+    writeCandidates(w, amb)
+    return
+  }
+
+  if err != nil {
+    writeError(w, err, locationStatus(err))
+    return
+  }
+
+  loc = s.zones.Locate(ctx, loc)
+  resp := &NowcastResponse{SchemaVersion: responseVersion, City: loc.Name, Region: loc.Region, Country: loc.Country, Lat: loc.Lat, Lon: loc.Lon, TimeZone: loc.TimeZone}
+  series, credit := s.nowcasts(ctx, loc, resp)
+  if len(series) == 0 {
+    status := http.StatusNotFound
+    for _, src := range resp.Providers {
+      if src.Error != "" {
+        status = http.StatusBadGateway
+      }
+    }
+
+    httpError(w, errNoNowcasters.Error()+failures(resp.Providers), status)
+    return
+  }
+
+  resp.Minutes = combineNowcasts(series, time.Now().UTC().Truncate(time.Minute))
+  resp.Summary = summarizeNowcast(resp.Minutes)
+  resp.Attribution = credit
+  resp.Took = time.Since(begin).String()
+  writeJSON(w, http.StatusOK, resp)
+}
+
+// nowcasts asks every available nowcaster, noting each one's outcome in
+// resp. Unlike forecasts, failures don't count against a provider's health:
+// nowcasts are a separate product, such as OpenWeather's One Call, whose
+// plan failing says nothing about current readings.
+func (s *server) nowcasts(ctx context.Context, loc geo.Location, resp *NowcastResponse) ([][]providers.NowcastPoint, []upstream.Attribution) {
+  active := s.activeFor(ctx, loc)
+
+  var ns providers.Multi
+  for _, p := range active {
+    if _, ok := p.(providers.Nowcaster); ok {
+      ns = append(ns, p)
+    }
+  }
+
+  series := make([][]providers.NowcastPoint, len(ns))
+  resp.Providers = make([]NowcastSource, len(ns))
+
+  var wg sync.WaitGroup
+  for i, p := range ns {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+
+      begin := time.Now()
+      ps, err := p.(providers.Nowcaster).Nowcast(ctx, loc)
+      src := NowcastSource{Provider: p.Name(), Points: len(ps)}
+      switch {
+      case errors.Is(err, providers.ErrNoNowcast):
+        src.Omitted = err.Error()
+      case err != nil:
+        src.Error, src.Took = err.Error(), time.Since(begin).String()
+      default:
+        src.Took = time.Since(begin).String()
+        series[i] = ps
+      }
+
+      resp.Providers[i] = src
+    }()
+  }
+
+  wg.Wait()
+
+  var ok [][]providers.NowcastPoint
+  var credit []upstream.Attribution
+  for i, p := range ns {
+    if resp.Providers[i].Omitted == "" {
+      s.quotasFor(ctx).spend(p.Name())
+    }
+
+    if series[i] != nil {
+      ok = append(ok, series[i])
+      credit = append(credit, providers.Attributions(ns[i:i+1])...)
+    }
+  }
+
+  return ok, credit
+}
+
+// combineNowcasts takes, minute by minute from now, the wettest outlook of
+// the providers covering that minute. A provider's point holds until its
+// next one.
+func combineNowcasts(series [][]providers.NowcastPoint, now time.Time) []NowcastMinute {
+  for _, ps := range series {
+    sort.Slice(ps, func(i, j int) bool { return ps[i].Valid.Before(ps[j].Valid) })
+  }
+
+  var minutes []NowcastMinute
+  for t := now; t.Before(now.Add(nowcastHorizon)); t = t.Add(time.Minute) {
+    m := NowcastMinute{Time: t}
+    covered := false
+    for _, ps := range series {
+      p, ok := pointAt(ps, t)
+      if !ok {
+        continue
+      }
+
+      covered = true
+      chance := 0.0
+      switch {
+      case p.Probability != nil:
+        chance = *p.Probability
+      case p.Rate >= rainThreshold:
+        chance = 1
+      }
+
+      m.Rate = math.Max(m.Rate, math.Round(p.Rate*100)/100)
+      m.Probability = math.Max(m.Probability, math.Round(chance*100)/100)
+      m.Rain = m.Rain || p.Rate >= rainThreshold
+    }
+
+    if covered {
+      minutes = append(minutes, m)
+    }
+  }
+
+  return minutes
+}
+
+func pointAt(ps []providers.NowcastPoint, t time.Time) (providers.NowcastPoint, bool) {
+  i := sort.Search(len(ps), func(i int) bool { return ps[i].Valid.After(t) }) - 1
+  if i < 0 {
+    return providers.NowcastPoint{}, false
+  }
+
+  if i == len(ps)-1 {
+    step := nowcastStep
+    if i > 0 {
+      step = ps[i].Valid.Sub(ps[i-1].Valid)
+    }
+
+    if !t.Before(ps[i].Valid.Add(step)) {
+      return providers.NowcastPoint{}, false
+    }
+  }
+
+  return ps[i], true
+}
+
+func summarizeNowcast(minutes []NowcastMinute) NowcastSummary {
+  var sum NowcastSummary
+  for _, m := range minutes {
+    sum.MaxRate = math.Max(sum.MaxRate, m.Rate)
+    sum.MaxProbability = math.Max(sum.MaxProbability, m.Probability)
+
+    switch {
+    case m.Rain && sum.StartsAt == nil:
+      t := m.Time
+      sum.RainExpected, sum.StartsAt = true, &t
+    case !m.Rain && sum.StartsAt != nil && sum.EndsAt == nil:
+      t := m.Time
+      sum.EndsAt = &t
+    }
+  }
+
+  return sum
+}
+
+func failures(srcs []NowcastSource) string {
+  msg := ""
+  for _, src := range srcs {
+    if src.Error != "" {
+      msg += fmt.Sprintf("; %s: %s", src.Provider, src.Error)
+    }
+  }
+
+  return msg
+}
@@ -0,0 +1,393 @@
+package server
+
+import (
+  "context"
+  _ "embed"
+  "encoding/csv"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "log"
+  "math"
+  "net/http"
+  "os"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+)
+
+var offlineEstimates = metrics.NewCounter("offline_estimates_total", "Offline temperatures, by whether nearby stations or the climatology gave them.", "source")
+
+//go:embed data/climatology.csv
+var bundledClimatology string
+
+// climateStation is a reference point with monthly mean temperatures.
+type climateStation struct {
+  geo.Location
+  normals [12]float64 // °C, January first
+}
+
+type climatology []climateStation
+
+// openClimatology loads the bundled dataset, or the file at path if given.
+func openClimatology(path string) (climatology, error) {
+  if path == "" {
+    return loadClimatology(strings.NewReader(bundledClimatology))
+  }
+
+  f, err := os.Open(path)
+  if err != nil {
+    return nil, err
+  }
+
+  defer f.Close()
+
+  return loadClimatology(f)
+}
+
+func loadClimatology(r io.Reader) (climatology, error) {
+  cr := csv.NewReader(r)
+  cr.Comment = '#'
+  cr.FieldsPerRecord = 16
+
+  var c climatology
+  for {
+    rec, err := cr.Read()
+    if err == io.EOF {
+      break
+    }
+
+    if err != nil {
+      return nil, err
+    }
+
+    l, err := geo.Coordinates(rec[2], rec[3])
+    if err != nil {
+      return nil, fmt.Errorf("climatology %s: %w", rec[0], err)
+    }
+
+    s := climateStation{Location: l}
+    s.Name, s.Country = rec[0], rec[1]
+    for m := 0; m < 12; m++ {
+      if s.normals[m], err = strconv.ParseFloat(rec[4+m], 64); err != nil {
+        return nil, fmt.Errorf("climatology %s month %d: %w", rec[0], m+1, err)
+      }
+    }
+
+    c = append(c, s)
+  }
+
+  return c, nil
+}
+
+// Stations further than this don't inform an estimate; beyond it the
+// latitude-only model takes over completely.
+const climateRadiusKm = 1000
+
+// estimate returns the expected air temperature (°C) at loc and time t:
+// monthly normals interpolated by day of year, inverse-distance weighted
+// across nearby stations, blended towards a zonal model with distance, plus
+// a simple diurnal cycle.
+func (c climatology) estimate(loc geo.Location, t time.Time) float64 {
+  type near struct {
+    km float64
+    c  float64
+  }
+
+  var ns []near
+  for _, s := range c {
+    if km := geo.DistanceKm(loc, s.Location); km < climateRadiusKm {
+      ns = append(ns, near{km: km, c: monthly(s.normals, t)})
+    }
+  }
+
+  sort.Slice(ns, func(i, j int) bool { return ns[i].km < ns[j].km })
+  if len(ns) > 4 {
+    ns = ns[:4]
+  }
+
+  mean := zonalMean(loc.Lat, t)
+  if len(ns) > 0 {
+    var sum, wsum float64
+    for _, n := range ns {
+      w := 1 / math.Max(n.km*n.km, 1)
+      sum += w * n.c
+      wsum += w
+    }
+
+    // Trust the stations fully when close, fade to the zonal model at the radius.
+    trust := 1 - ns[0].km/climateRadiusKm
+    mean = trust*(sum/wsum) + (1-trust)*mean
+  }
+
+  // Coldest around 05:00 local solar time, warmest around 15:00.
+  solarHour := float64(t.UTC().Hour()) + float64(t.UTC().Minute())/60 + loc.Lon/15
+  return mean + 4*math.Cos(2*math.Pi*(solarHour-15)/24)
+}
+
+// monthly interpolates between mid-month normals.
+func monthly(normals [12]float64, t time.Time) float64 {
+  pos := float64(t.YearDay()-15) / 365 * 12 // 0 at Jan 15
+  if pos < 0 {
+    pos += 12
+  }
+
+  i := int(pos) % 12
+  f := pos - math.Floor(pos)
+  return normals[i]*(1-f) + normals[(i+1)%12]*f
+}
+
+// zonalMean is a latitude-only approximation for places far from any
+// station: warm equator, cold poles, seasons growing with latitude.
+func zonalMean(lat float64, t time.Time) float64 {
+  annual := 27 - 0.0065*lat*lat
+  amplitude := math.Abs(lat) / 4.5
+  season := math.Cos(2 * math.Pi * float64(t.YearDay()-200) / 365)
+  if lat < 0 {
+    season = -season
+  }
+
+  return annual + amplitude*season
+}
+
+// offlineGeocoder resolves names against the climatology stations.
+type offlineGeocoder struct {
+  stations climatology
+}
+
+func (g offlineGeocoder) Geocode(ctx context.Context, q geo.CityQuery) ([]geo.Location, error) {
+  name := geo.NormalizeName(q.Name)
+
+  var locs []geo.Location
+  for _, s := range g.stations {
+    if geo.NormalizeName(s.Name) == name && (q.Country == "" || strings.EqualFold(q.Country, s.Country)) {
+      l := s.Location
+      l.Score = 1
+      locs = append(locs, l)
+    }
+  }
+
+  return locs, nil
+}
+
+// The least geo.Similarity a station's name needs to a search to be
+// offered: a letter or two wrong in a short name, a few in a long one.
+const offlineSearchMatch = 0.6
+
+// Search offers the stations whose names are like what was typed.
+func (g offlineGeocoder) Search(ctx context.Context, q geo.CityQuery, limit int) ([]geo.Location, error) {
+  var locs []geo.Location
+  for _, s := range g.stations {
+    if sim := geo.Similarity(q.Name, s.Name); sim >= offlineSearchMatch && (q.Country == "" || strings.EqualFold(q.Country, s.Country)) {
+      l := s.Location
+      l.Score = sim
+      locs = append(locs, l)
+    }
+  }
+
+  return locs, nil
+}
+
+// pwsReading is one observation pushed by a personal weather station.
+type pwsReading struct {
+  Station string    `json:"station"`
+  Lat     float64   `json:"lat"`
+  Lon     float64   `json:"lon"`
+  Celsius float64   `json:"temp_c"`
+  Time    time.Time `json:"time"`
+}
+
+// check rejects a reading that can't be a real observation: off the globe,
+// outside the temperatures ever recorded, or stamped in the future beyond
+// a station's clock skew or too old to be used.
+func (r pwsReading) check(now time.Time) error {
+  switch {
+  case r.Station == "":
+    return errors.New("station is required")
+  case r.Celsius < pwsMinCelsius || r.Celsius > pwsMaxCelsius || math.IsNaN(r.Celsius):
+    return fmt.Errorf("station %s: temp_c must be between %g and %g, got %g", r.Station, float64(pwsMinCelsius), float64(pwsMaxCelsius), r.Celsius)
+  case r.Time.Sub(now) > pwsMaxSkew:
+    return fmt.Errorf("station %s: time %s is in the future", r.Station, r.Time.Format(time.RFC3339))
+  case now.Sub(r.Time) > pwsMaxAge:
+    return fmt.Errorf("station %s: time %s is older than %s", r.Station, r.Time.Format(time.RFC3339), pwsMaxAge)
+  }
+
+  if _, err := geo.Coordinates(strconv.FormatFloat(r.Lat, 'f', -1, 64), strconv.FormatFloat(r.Lon, 'f', -1, 64)); err != nil {
+    return fmt.Errorf("station %s: %w", r.Station, err)
+  }
+
+  return nil
+}
+
+// pwsStore keeps the latest reading per station, persisted in the "pws"
+// bucket of the store so a restart doesn't lose local data. Stations that
+// stopped reporting are dropped once their reading is too old to be used.
+type pwsStore struct {
+  db *store
+
+  mu       sync.RWMutex
+  readings map[string]pwsReading
+}
+
+func newPWSStore(db *store) *pwsStore {
+  s := &pwsStore{db: db, readings: make(map[string]pwsReading)}
+  for _, k := range db.keys("pws", "") {
+    var r pwsReading
+    if _, err := db.get("pws", k, &r); err == nil {
+      s.readings[k] = r
+    }
+  }
+
+  s.mu.Lock()
+  s.prune(time.Now())
+  s.mu.Unlock()
+  return s
+}
+
+func (s *pwsStore) put(r pwsReading) error {
+  if err := s.db.put("pws", r.Station, r); err != nil {
+    return err
+  }
+
+  s.mu.Lock()
+  s.readings[r.Station] = r
+  s.prune(time.Now())
+  s.mu.Unlock()
+  return nil
+}
+
+// prune drops the stations whose reading is older than pwsMaxAge at now.
+// s.mu must be held.
+func (s *pwsStore) prune(now time.Time) {
+  for k, r := range s.readings {
+    if now.Sub(r.Time) <= pwsMaxAge {
+      continue
+    }
+
+    if err := s.db.delete("pws", k); err != nil {
+      log.Printf("pws: dropping station %s: %s", k, err)
+      continue
+    }
+
+    delete(s.readings, k)
+  }
+}
+
+// Only fresh readings from stations this close stand in for the model.
+const (
+  pwsRadiusKm = 25
+  pwsMaxAge   = time.Hour
+  pwsMaxSkew  = 5 * time.Minute // of a station's clock, for readings from the future
+  pwsMaxBody  = 1 << 20         // bytes per push
+
+  pwsMinCelsius = -90 // about the lowest and highest ever recorded
+  pwsMaxCelsius = 60
+)
+
+// stationAuth lets stations push with a client key, or the -admin.token.
+func (s *server) stationAuth(h http.HandlerFunc) http.HandlerFunc {
+  admin := s.adminGuard(h)
+  return func(w http.ResponseWriter, r *http.Request) {
+    if _, ok := clientFrom(r.Context()); ok {
+      h(w, r)
+      return
+    }
+
+    admin(w, r)
+  }
+}
+
+// near averages recent readings around loc.
+func (s *pwsStore) near(loc geo.Location, now time.Time) (float64, int) {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+
+  var sum float64
+  var n int
+  for _, r := range s.readings {
+    if now.Sub(r.Time) > pwsMaxAge {
+      continue
+    }
+
+    if geo.DistanceKm(loc, geo.Location{Lat: r.Lat, Lon: r.Lon}) <= pwsRadiusKm {
+      sum += r.Celsius
+      n++
+    }
+  }
+
+  if n == 0 {
+    return 0, 0
+  }
+
+  return sum / float64(n), n
+}
+
+// ingest accepts a JSON reading or array of readings from local stations;
+// one bad reading rejects them all.
+func (s *pwsStore) ingest(w http.ResponseWriter, r *http.Request) {
+  body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, pwsMaxBody))
+  if err != nil {
+    writeError(w, err, bodyStatus(err))
+    return
+  }
+
+  var rs []pwsReading
+  if strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+    err = json.Unmarshal(body, &rs)
+  } else {
+    rs = make([]pwsReading, 1)
+    err = json.Unmarshal(body, &rs[0])
+  }
+
+  if err != nil {
+    writeError(w, err, http.StatusBadRequest)
+    return
+  }
+
+  now := time.Now()
+  for i := range rs {
+    if rs[i].Time.IsZero() {
+      rs[i].Time = now
+    }
+
+    if err := rs[i].check(now); err != nil {
+      writeError(w, err, http.StatusBadRequest)
+      return
+    }
+  }
+
+  for _, reading := range rs {
+    if err := s.put(reading); err != nil {
+      writeError(w, err, http.StatusInternalServerError)
+      return
+    }
+  }
+
+  w.WriteHeader(http.StatusNoContent)
+}
+
+// offlineModel is the only provider in air-gapped mode: local station data
+// when there is any nearby, otherwise the climatology estimate.
+type offlineModel struct {
+  climate climatology
+  pws     *pwsStore
+}
+
+func (m offlineModel) Name() string { return "offline-model" }
+
+func (m offlineModel) Temperature(ctx context.Context, loc geo.Location) (float64, error) {
+  now := time.Now()
+  if c, n := m.pws.near(loc, now); n > 0 {
+    offlineEstimates.Inc("stations")
+    return c + 273.15, nil
+  }
+
+  offlineEstimates.Inc("climatology")
+  return m.climate.estimate(loc, now) + 273.15, nil
+}
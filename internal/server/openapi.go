@@ -0,0 +1,297 @@
+package server
+
+import (
+  "net/http"
+  "reflect"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+  "unicode"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/condition"
+)
+
+// openAPI describes the lookup endpoints, their responses generated from
+// the response structs so the spec can't drift from what is served.
+func openAPI() map[string]interface{} {
+  g := &schemas{defs: make(map[string]interface{})}
+
+  units := param("units", "query", "kelvin (default), celsius or fahrenheit")
+  format := param("format", "query", "json (default) or geojson")
+  table := param("format", "query", "json (default), geojson, xml or csv; Accept: application/xml or text/csv also picks those")
+  detail := param("detail", "query", "true to include each provider's reading")
+  fields := param("fields", "query", "comma-separated temp, condition, humidity, wind, feels_like: answer only these, asking only the providers they need")
+  lookup := []interface{}{units, table, detail, fields, param("explain", "query", "true to trace the aggregate"), param("smooth", "query", "true for the moving average"),
+    param("provenance", "query", "true to list the readings averaged; always on with signing")}
+  cities := body("a JSON array of city names", reflect.TypeOf([]string{}), g)
+  idempotent := param("Idempotency-Key", "header", "a key of the client's choosing, such as a UUID; a retry with the same key and body gets the first answer again")
+
+  return map[string]interface{}{
+    "openapi": "3.0.3",
+    "info": map[string]interface{}{
+      "title":   "weather-go",
+      "version": strconv.Itoa(responseVersion),
+    },
+    "paths": map[string]interface{}{
+      "/v1/weather": map[string]interface{}{
+        "get": operation("Current temperature at lat/lon, or at the client's home city", "TemperatureResponse", g,
+          append(lookup, param("lat", "query", "latitude, with lon"), param("lon", "query", "longitude, with lat"))...),
+      },
+      "/v1/weather/{city}": map[string]interface{}{
+        "get": operation("Current temperature in a city", "TemperatureResponse", g,
+          append(lookup, param("city", "path", `a city, optionally "city,country"`))...),
+      },
+      "/v1/astro/{city}": map[string]interface{}{
+        "get": operation("Sunrise, sunset, day length and moon phase in a city", "AstroResponse", g,
+          param("city", "path", `a city, optionally "city,country"`), param("date", "query", "YYYY-MM-DD, default today (UTC)")),
+      },
+      "/v1/nowcast/{city}": map[string]interface{}{
+        "get": operation("Precipitation minute by minute for the next hour", "NowcastResponse", g, param("city", "path", `a city, optionally "city,country"`)),
+      },
+      "/v1/uv/{city}": map[string]interface{}{
+        "get": operation("The UV index now and its risk", "UVResponse", g, param("city", "path", `a city, optionally "city,country"`)),
+      },
+      "/v1/pollen/{city}": map[string]interface{}{
+        "get": operation("Tree, grass and weed pollen levels now", "PollenResponse", g, param("city", "path", `a city, optionally "city,country"`)),
+      },
+      "/v1/marine/{location}": map[string]interface{}{
+        "get": operation("Waves, swell and sea temperature now", "MarineResponse", g,
+          param("location", "path", `"lat,lon", or a coastal city, optionally "city,country"`), units),
+      },
+      "/v1/agro/{location}": map[string]interface{}{
+        "get": operation("Soil temperature and moisture by depth, and evapotranspiration", "AgroResponse", g,
+          param("location", "path", `"lat,lon", or a city, optionally "city,country"`), units),
+      },
+      "/v1/trend/{city}": map[string]interface{}{
+        "get": operation("The temperature's change over the last 1, 3, 6 and 24 hours, and its trend", "TrendResponse", g,
+          param("city", "path", `a city, optionally "city,country"`), units),
+      },
+      "/v1/conditions/{city}": map[string]interface{}{
+        "get": operation("The weather now in words, in the client's language", "ConditionsResponse", g,
+          param("city", "path", `a city, optionally "city,country"`), param("lang", "query", "language tag, default Accept-Language"), units),
+      },
+      "/v1/weather/batch": map[string]interface{}{
+        "post": withBody(operation("Current temperature in many cities", "BatchResponse", g, units, table, detail, idempotent), cities),
+      },
+      "/v1/watchlists/{id}/weather": map[string]interface{}{
+        "get": operation("Current temperature in a watchlist's cities", "WatchlistResponse", g, param("id", "path", "watchlist id"), units, format, detail),
+      },
+      "/v1/groups/{id}/weather": map[string]interface{}{
+        "get": operation("Current temperature in a group's cities, summarized", "GroupResponse", g, param("id", "path", "group id"), units, format, detail),
+      },
+      "/v1/search": map[string]interface{}{
+        "get": operation("Places matching a partial or misspelt name, best first, for autocomplete", "SearchResponse", g,
+          param("q", "query", "what was typed so far, at least 2 letters; a trailing ,<country code> narrows it"),
+          param("limit", "query", "most results, 1 to 20; 5 by default"), param("lang", "query", "language tag to name places in")),
+      },
+      "/v1/signing-key": map[string]interface{}{
+        "get": operation("The public key answers' X-Signature is checked against", "SigningKeyResponse", g),
+      },
+      "/v1/route-weather": map[string]interface{}{
+        "post": withBody(operation("Forecast temperature at each waypoint's ETA", "RouteResponse", g, units, format, detail, idempotent),
+          body(`{"waypoints": [{"city": "...", "eta": "<RFC 3339>"}, ...]}`, reflect.TypeOf(struct {
+            Waypoints []waypoint `json:"waypoints"`
+          }{}), g)),
+      },
+    },
+    "components": map[string]interface{}{
+      "schemas": g.defs,
+      "securitySchemes": map[string]interface{}{
+        "apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+      },
+    },
+    "security": []interface{}{map[string]interface{}{"apiKey": []string{}}},
+  }
+}
+
+var responseTypes = map[string]reflect.Type{
+  "TemperatureResponse": reflect.TypeOf(TemperatureResponse{}),
+  "ForecastResponse":    reflect.TypeOf(ForecastResponse{}),
+  "BatchResponse":       reflect.TypeOf(BatchResponse{}),
+  "WatchlistResponse":   reflect.TypeOf(WatchlistResponse{}),
+  "GroupResponse":       reflect.TypeOf(GroupResponse{}),
+  "RouteResponse":       reflect.TypeOf(RouteResponse{}),
+  "AstroResponse":       reflect.TypeOf(AstroResponse{}),
+  "NowcastResponse":     reflect.TypeOf(NowcastResponse{}),
+  "ConditionsResponse":  reflect.TypeOf(ConditionsResponse{}),
+  "UVResponse":          reflect.TypeOf(UVResponse{}),
+  "PollenResponse":      reflect.TypeOf(PollenResponse{}),
+  "MarineResponse":      reflect.TypeOf(MarineResponse{}),
+  "AgroResponse":        reflect.TypeOf(AgroResponse{}),
+  "TrendResponse":       reflect.TypeOf(TrendResponse{}),
+  "SigningKeyResponse":  reflect.TypeOf(SigningKeyResponse{}),
+  "SearchResponse":      reflect.TypeOf(SearchResponse{}),
+}
+
+func operation(summary, response string, g *schemas, params ...interface{}) map[string]interface{} {
+  ok := map[string]interface{}{
+    "description": response,
+    "content": map[string]interface{}{
+      "application/json": map[string]interface{}{"schema": g.of(responseTypes[response])},
+    },
+  }
+
+  failed := func(description string) map[string]interface{} {
+    return map[string]interface{}{
+      "description": description,
+      "content": map[string]interface{}{
+        "application/json": map[string]interface{}{"schema": g.of(reflect.TypeOf(ErrorResponse{}))},
+      },
+    }
+  }
+
+  return map[string]interface{}{
+    "summary":    summary,
+    "parameters": params,
+    "responses": map[string]interface{}{
+      "200": ok,
+      "300": map[string]interface{}{"description": "the city is ambiguous, pick a candidate"},
+      "304": map[string]interface{}{"description": "the reading hasn't changed since If-None-Match"},
+      "400": failed("bad request"),
+      "401": failed("API key required"),
+      "404": failed("unknown place"),
+      "429": failed("rate limited"),
+      "502": failed("the providers failed"),
+      "504": failed("the providers didn't answer in time"),
+    },
+  }
+}
+
+func withBody(op map[string]interface{}, b map[string]interface{}) map[string]interface{} {
+  op["requestBody"] = b
+  return op
+}
+
+func body(description string, t reflect.Type, g *schemas) map[string]interface{} {
+  return map[string]interface{}{
+    "description": description,
+    "required":    true,
+    "content": map[string]interface{}{
+      "application/json": map[string]interface{}{"schema": g.of(t)},
+    },
+  }
+}
+
+func param(name, in, description string) map[string]interface{} {
+  return map[string]interface{}{"name": name, "in": in, "required": in == "path", "description": description, "schema": map[string]string{"type": "string"}}
+}
+
+// schemas turns Go types into JSON Schema as encoding/json marshals them;
+// named structs become components, referenced by name.
+type schemas struct {
+  defs map[string]interface{}
+}
+
+var (
+  timeType      = reflect.TypeOf(time.Time{})
+  conditionType = reflect.TypeOf(condition.Unknown)
+)
+
+func (g *schemas) of(t reflect.Type) map[string]interface{} {
+  for t.Kind() == reflect.Pointer {
+    t = t.Elem()
+  }
+
+  switch {
+  case t == timeType:
+    return map[string]interface{}{"type": "string", "format": "date-time"}
+  case t == conditionType:
+    return map[string]interface{}{"type": "string", "enum": condition.Names()}
+  case t.Kind() == reflect.Struct && t.Name() == "":
+    return g.object(t)
+  case t.Kind() == reflect.Struct:
+    name := componentName(t)
+    if _, ok := g.defs[name]; !ok {
+      g.defs[name] = nil // breaks cycles
+      g.defs[name] = g.object(t)
+    }
+
+    return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+  }
+
+  switch t.Kind() {
+  case reflect.Slice, reflect.Array:
+    return map[string]interface{}{"type": "array", "items": g.of(t.Elem())}
+  case reflect.Map:
+    return map[string]interface{}{"type": "object", "additionalProperties": g.of(t.Elem())}
+  case reflect.Bool:
+    return map[string]interface{}{"type": "boolean"}
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    return map[string]interface{}{"type": "integer"}
+  case reflect.Float32, reflect.Float64:
+    return map[string]interface{}{"type": "number"}
+  case reflect.String:
+    return map[string]interface{}{"type": "string"}
+  default:
+    return map[string]interface{}{}
+  }
+}
+
+// object lists the fields encoding/json would write: omitted or nullable
+// ones aren't required, embedded structs are flattened.
+func (g *schemas) object(t reflect.Type) map[string]interface{} {
+  props := make(map[string]interface{})
+  var required []string
+  g.fields(t, props, &required)
+
+  o := map[string]interface{}{"type": "object", "properties": props}
+  if len(required) > 0 {
+    o["required"] = required
+  }
+
+  return o
+}
+
+func (g *schemas) fields(t reflect.Type, props map[string]interface{}, required *[]string) {
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    tag := f.Tag.Get("json")
+    if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+      continue
+    }
+
+    name, opts, _ := strings.Cut(tag, ",")
+    if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+      g.fields(f.Type, props, required)
+      continue
+    }
+
+    if name == "" {
+      name = f.Name
+    }
+
+    s := g.of(f.Type)
+    if doc := f.Tag.Get("doc"); doc != "" {
+      if _, ref := s["$ref"]; ref {
+        // Siblings of $ref are ignored in OpenAPI 3.0.
+        s = map[string]interface{}{"allOf": []interface{}{s}}
+      }
+
+      s["description"] = doc
+    }
+
+    props[name] = s
+    if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer && f.Type.Kind() != reflect.Slice && f.Type.Kind() != reflect.Map {
+      *required = append(*required, name)
+    }
+  }
+}
+
+// componentName exports unexported type names: explanation is Explanation.
+func componentName(t reflect.Type) string {
+  r := []rune(t.Name())
+  r[0] = unicode.ToUpper(r[0])
+  return string(r)
+}
+
+var (
+  specOnce sync.Once
+  spec     map[string]interface{}
+)
+
+// openAPIHandler serves GET /openapi.json.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+  specOnce.Do(func() { spec = openAPI() })
+  writeJSON(w, http.StatusOK, spec)
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+  "fmt"
+  "math"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+// outputPolicy limits how precisely a provider's data is shown, for terms
+// that forbid redistributing its exact values. It is applied when building
+// responses; caches and history keep the exact values.
+type outputPolicy struct {
+  round         float64       // kelvin step values are rounded to
+  delay         time.Duration // individual values are only shown once this old
+  aggregateOnly bool          // individual values are never shown
+}
+
+// outputPolicies maps provider names to their policy and doubles as a
+// repeatable flag: -output.policy=wunderground=round:0.5,delay:1h,aggregate-only.
+type outputPolicies map[string]outputPolicy
+
+func (p outputPolicies) String() string {
+  var s []string
+  for name, op := range p {
+    s = append(s, fmt.Sprintf("%s=round:%g,delay:%s,aggregate-only:%t", name, op.round, op.delay, op.aggregateOnly))
+  }
+
+  sort.Strings(s)
+  return strings.Join(s, " ")
+}
+
+func (p outputPolicies) Set(v string) error {
+  name, rules, ok := strings.Cut(v, "=")
+  if !ok || name == "" {
+    return fmt.Errorf("want provider=rule,..., got %q", v)
+  }
+
+  var op outputPolicy
+  for _, rule := range strings.Split(rules, ",") {
+    key, val, _ := strings.Cut(rule, ":")
+    var err error
+    switch key {
+    case "round":
+      if op.round, err = strconv.ParseFloat(val, 64); err == nil && op.round <= 0 {
+        err = fmt.Errorf("must be positive")
+      }
+    case "delay":
+      op.delay, err = time.ParseDuration(val)
+    case "aggregate-only":
+      op.aggregateOnly = true
+    default:
+      err = fmt.Errorf("unknown rule, want round:<kelvin>, delay:<duration> or aggregate-only")
+    }
+
+    if err != nil {
+      return fmt.Errorf("output policy for %s: %q: %w", name, rule, err)
+    }
+  }
+
+  p[name] = op
+  return nil
+}
+
+func roundTo(v, step float64) float64 {
+  if step <= 0 {
+    return v
+  }
+
+  return math.Round(v/step) * step
+}
+
+// aggregate rounds an aggregate to the coarsest step of the providers
+// behind it, so it can't be used to recover a rounded provider's value.
+func (p outputPolicies) aggregate(kelvin float64, ps providers.Multi) float64 {
+  return roundTo(kelvin, p.step(ps))
+}
+
+// step is the coarsest rounding of the providers in ps.
+func (p outputPolicies) step(ps providers.Multi) float64 {
+  step := 0.0
+  for _, pr := range ps {
+    step = math.Max(step, p[pr.Name()].round)
+  }
+
+  return step
+}
+
+// readings applies the policies to live per-provider readings. Delayed
+// providers are withheld here; their values show up in history later.
+func (p outputPolicies) readings(rs []providers.Reading) []providers.Reading {
+  out := make([]providers.Reading, len(rs))
+  for i, r := range rs {
+    op := p[r.Provider]
+    switch {
+    case r.Error != "":
+    case op.aggregateOnly:
+      r.Kelvin, r.Withheld = 0, "aggregate-only"
+    case op.delay > 0:
+      r.Kelvin, r.Withheld = 0, "delayed "+op.delay.String()
+    default:
+      r.Kelvin = roundTo(r.Kelvin, op.round)
+    }
+
+    out[i] = r
+  }
+
+  return out
+}
+
+// history applies the policies to stored readings as of now.
+func (p outputPolicies) history(rs []historyReading, now time.Time) []historyReading {
+  out := make([]historyReading, len(rs))
+  for i, r := range rs {
+    values := make(map[string]float64, len(r.Providers))
+    var step float64
+    for name, k := range r.Providers {
+      op := p[name]
+      step = math.Max(step, op.round)
+      if op.aggregateOnly || now.Sub(r.Time) < op.delay {
+        continue
+      }
+
+      values[name] = roundTo(k, op.round)
+    }
+
+    out[i] = historyReading{Time: r.Time, Kelvin: roundTo(r.Kelvin, step), Providers: values}
+  }
+
+  return out
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+  "context"
+  "errors"
+  "net/http"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+var errNoPollen = errors.New("no enabled provider has pollen data for this place")
+
+var pollenCategories = [...]string{
+  providers.PollenNone:     "none",
+  providers.PollenLow:      "low",
+  providers.PollenModerate: "moderate",
+  providers.PollenHigh:     "high",
+  providers.PollenVeryHigh: "very high",
+}
+
+// pollen answers GET /v1/pollen/{city} (or ?lat=&lon=) with tree, grass and
+// weed pollen now. Each kind takes the highest provider: like a nowcast,
+// this is for not getting caught out, and providers count different
+// species.
+func (s *server) pollen(w http.ResponseWriter, r *http.Request) {
+  begin := time.Now()
+  ctx := upstream.WithTrace(r)
+
+  loc, ok := s.placeFor(ctx, w, r, requestLocation)
+  if !ok {
+    return
+  }
+
+  replies := askEach(ctx, s, loc, providers.ErrNoPollen, func(p providers.Provider) (func(context.Context, geo.Location) (providers.Pollen, error), bool) {
+    pr, ok := p.(providers.PollenReporter)
+    if !ok {
+      return nil, false
+    }
+
+    return pr.Pollen, true
+  })
+
+  resp := &PollenResponse{SchemaVersion: responseVersion, City: loc.Name, Region: loc.Region, Country: loc.Country, Lat: loc.Lat, Lon: loc.Lon, TimeZone: loc.TimeZone}
+  for _, a := range replies {
+    src := PollenSource{Provider: a.p.Name(), Omitted: a.omitted, Error: a.err, Took: a.took}
+    if a.ok {
+      src.Tree, src.Grass, src.Weed = a.v.Tree, a.v.Grass, a.v.Weed
+      resp.Tree = higherPollen(resp.Tree, a.v.Tree)
+      resp.Grass = higherPollen(resp.Grass, a.v.Grass)
+      resp.Weed = higherPollen(resp.Weed, a.v.Weed)
+      resp.ProviderCount++
+      resp.Attribution = append(resp.Attribution, providers.Attributions(providers.Multi{a.p})...)
+    }
+
+    resp.Providers = append(resp.Providers, src)
+  }
+
+  if resp.ProviderCount == 0 {
+    httpError(w, errNoPollen.Error()+replyFailures(replies), repliesStatus(replies))
+    return
+  }
+
+  resp.Took = time.Since(begin).String()
+  writeJSON(w, http.StatusOK, resp)
+}
+
+func higherPollen(cur *PollenLevel, level *int) *PollenLevel {
+  if level == nil || *level < 0 || *level >= len(pollenCategories) || (cur != nil && cur.Level >= *level) {
+    return cur
+  }
+
+  return &PollenLevel{Level: *level, Category: pollenCategories[*level]}
+}
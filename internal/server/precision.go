@@ -0,0 +1,61 @@
+package server
+
+import (
+  "fmt"
+  "math"
+  "net/http"
+  "strconv"
+)
+
+// Temperatures are shown with at most this many decimals; beyond that a
+// reading is noise.
+const maxPrecision = 6
+
+// display is how a request wants temperatures shown.
+type display struct {
+  units  string
+  digits int // decimal places
+}
+
+// requestDisplay is requestUnits and ?precision=, -response.precision by
+// default. Bad values are answered with 400 and false.
+func (s *server) requestDisplay(w http.ResponseWriter, r *http.Request) (display, bool) {
+  units, ok := requestUnits(w, r)
+  if !ok {
+    return display{}, false
+  }
+
+  d := display{units: units, digits: s.precision}
+  if p := r.URL.Query().Get("precision"); p != "" {
+    n, err := strconv.Atoi(p)
+    if err != nil || n < 0 || n > maxPrecision {
+      httpError(w, fmt.Sprintf("precision wants 0 to %d decimal places, got %q", maxPrecision, p), http.StatusBadRequest)
+      return display{}, false
+    }
+
+    d.digits = n
+  }
+
+  return d, true
+}
+
+// defaultDisplay is how temperatures are shown where no request asks,
+// such as stream events and webhooks: kelvin, -response.precision.
+func (s *server) defaultDisplay() display {
+  return display{units: "kelvin", digits: s.precision}
+}
+
+func (d display) round(v float64) float64 {
+  p := math.Pow10(d.digits)
+  return math.Round(v*p) / p
+}
+
+// rounded is the whole-degree convenience value of a temperature.
+func rounded(v *float64) *int {
+  if v == nil {
+    return nil
+  }
+
+  n := int(math.Round(*v))
+  return &n
+}
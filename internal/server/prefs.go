@@ -0,0 +1,283 @@
+package server
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net/http"
+  "regexp"
+  "strings"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+)
+
+// preferences are an API client's defaults, applied when a request leaves
+// the corresponding parameter out: units for ?units=, a language for
+// ?lang= and a home city for /v1/weather without a place.
+type preferences struct {
+  resourceMeta
+  Client   string `json:"client"`
+  Units    string `json:"units,omitempty"`
+  Lang     string `json:"lang,omitempty"`
+  HomeCity string `json:"home_city,omitempty"`
+}
+
+var langTag = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+func (p *preferences) validate() error {
+  if p.Client == "" {
+    return errors.New("preferences need a client")
+  }
+
+  if _, err := parseUnits(p.Units); err != nil {
+    return err
+  }
+
+  if p.Lang != "" && !langTag.MatchString(p.Lang) {
+    return fmt.Errorf("lang %q is not a language tag such as en or pt-BR", p.Lang)
+  }
+
+  if p.HomeCity != "" {
+    if err := geo.ParseCity(p.HomeCity).Validate(); err != nil {
+      return fmt.Errorf("home_city: %w", err)
+    }
+  }
+
+  return nil
+}
+
+// newPreferences keys preferences by client name. Clients manage their own
+// at /v1/preferences; the admin API sees everyone's.
+func newPreferences(db *store, guard func(http.HandlerFunc) http.HandlerFunc) *collection {
+  return &collection{
+    db:      db,
+    name:    "preferences",
+    base:    "/v1/admin/preferences",
+    newItem: func() resource { return &preferences{} },
+    idOf:    func(r resource) string { return r.(*preferences).Client },
+    guard:   guard,
+  }
+}
+
+type prefsCtxKey struct{}
+
+// prefsFrom returns the preferences of the request's client; the zero
+// value if it has none or is anonymous.
+func prefsFrom(ctx context.Context) preferences {
+  p, _ := ctx.Value(prefsCtxKey{}).(preferences)
+  return p
+}
+
+// withPreferences loads the client's preferences once per request.
+func (s *server) withPreferences(h http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if c, ok := clientFrom(r.Context()); ok {
+      if item, err := s.preferences.get(c.Name); err == nil && item.meta().DeletedAt == nil {
+        r = r.WithContext(context.WithValue(r.Context(), prefsCtxKey{}, *item.(*preferences)))
+      }
+    }
+
+    h.ServeHTTP(w, r)
+  })
+}
+
+// ownPreferences serves GET, PUT and DELETE /v1/preferences for the
+// calling client. PUT creates them or replaces them, checking If-Match
+// when sent.
+func (s *server) ownPreferences(w http.ResponseWriter, r *http.Request) {
+  c, ok := clientFrom(r.Context())
+  if !ok {
+    httpError(w, "preferences belong to an API client, send its key in X-API-Key", http.StatusUnauthorized)
+    return
+  }
+
+  var item resource
+  var err error
+  status := http.StatusOK
+  switch r.Method {
+  case http.MethodGet:
+    if item, err = s.preferences.get(c.Name); err == nil && item.meta().DeletedAt != nil {
+      err = errNotFound
+    }
+  case http.MethodPut:
+    p := &preferences{}
+    if err := json.NewDecoder(r.Body).Decode(p); err != nil {
+      httpError(w, "bad preferences body: "+err.Error(), bodyStatus(err))
+      return
+    }
+
+    p.Client = c.Name
+    if err := p.validate(); err != nil {
+      writeError(w, err, http.StatusUnprocessableEntity)
+      return
+    }
+
+    var created bool
+    if item, created, err = s.preferences.upsert(c.Name, r.Header.Get("If-Match"), p); created {
+      status = http.StatusCreated
+    }
+  case http.MethodDelete:
+    item, err = s.preferences.setDeleted(c.Name, r.Header.Get("If-Match"), true)
+  }
+
+  switch {
+  case errors.Is(err, errNotFound):
+    httpError(w, "no preferences stored for "+c.Name, http.StatusNotFound)
+  case errors.Is(err, errVersionMismatch):
+    w.Header().Set("ETag", item.meta().etag())
+    writeJSON(w, http.StatusPreconditionFailed, map[string]interface{}{
+      "error":   errorDetail("preferences were modified concurrently, re-read them and retry", http.StatusPreconditionFailed),
+      "current": item,
+    })
+  case err != nil:
+    writeError(w, err, http.StatusInternalServerError)
+  default:
+    w.Header().Set("ETag", item.meta().etag())
+    writeJSON(w, status, item)
+  }
+}
+
+// requestUnits is ?units=, or the client's preferred units, kelvin by
+// default. Unknown units are answered with 400 and false.
+func requestUnits(w http.ResponseWriter, r *http.Request) (string, bool) {
+  u := r.URL.Query().Get("units")
+  if u == "" {
+    u = prefsFrom(r.Context()).Units
+  }
+
+  u, err := parseUnits(u)
+  if err != nil {
+    writeError(w, err, http.StatusBadRequest)
+    return "", false
+  }
+
+  return u, true
+}
+
+func parseUnits(u string) (string, error) {
+  switch u {
+  case "", "kelvin":
+    return "kelvin", nil
+  case "celsius", "fahrenheit":
+    return u, nil
+  default:
+    return "", fmt.Errorf("unknown units %q, want kelvin, celsius or fahrenheit", u)
+  }
+}
+
+// show converts a lookup result's temperatures from kelvin to d's units
+// and precision, and says which units they are in. Explanations stay in
+// exact kelvin, the units the math was done in.
+func (r *TemperatureResponse) show(d display) {
+  r.Humidity, r.WindSpeed = d.value(r.Humidity), d.value(r.WindSpeed)
+  r.WindDir, r.WindGust = d.value(r.WindDir), d.value(r.WindGust)
+  if r.Temp == nil && r.FeelsLike == nil && len(r.Providers) == 0 {
+    return
+  }
+
+  r.Temp, r.RawTemp, r.FeelsLike = d.temp(r.Temp), d.temp(r.RawTemp), d.temp(r.FeelsLike)
+  r.TempRounded = rounded(r.Temp)
+  r.Providers = d.readings(r.Providers)
+  r.Units = d.units
+}
+
+func (r *ForecastResponse) show(d display) {
+  if r.Temp == nil && len(r.Providers) == 0 {
+    return
+  }
+
+  r.Temp = d.temp(r.Temp)
+  r.TempRounded = rounded(r.Temp)
+  r.RoadWeather = d.roadWeather(r.RoadWeather)
+  r.Providers = d.readings(r.Providers)
+  r.Units = d.units
+}
+
+func (d display) roadWeather(w RoadWeather) RoadWeather {
+  return RoadWeather{Precipitation: d.value(w.Precipitation), Snowfall: d.value(w.Snowfall), SnowDepth: d.value(w.SnowDepth), FreezingLevel: d.value(w.FreezingLevel)}
+}
+
+// show converts the conditions' temperatures, and rounds the humidity,
+// wind and road weather to d's precision.
+func (r *ConditionsResponse) show(d display) {
+  for i := range r.Providers {
+    p := &r.Providers[i]
+    p.Temp, p.Humidity, p.WindSpeed = d.temp(p.Temp), d.value(p.Humidity), d.value(p.WindSpeed)
+    p.WindDir, p.WindGust = d.value(p.WindDir), d.value(p.WindGust)
+    p.RoadWeather = d.roadWeather(p.RoadWeather)
+  }
+
+  if m := r.Metrics; m != nil {
+    m.Temp, m.DewPoint, m.FeelsLike = d.temp(m.Temp), d.temp(m.DewPoint), d.temp(m.FeelsLike)
+    m.HeatIndex, m.WindChill = d.temp(m.HeatIndex), d.temp(m.WindChill)
+    m.Humidity, m.WindSpeed = d.value(m.Humidity), d.value(m.WindSpeed)
+    m.WindDir, m.WindGust = d.value(m.WindDir), d.value(m.WindGust)
+    m.RoadWeather = d.roadWeather(m.RoadWeather)
+  }
+
+  r.Units = d.units
+}
+
+func showAll(results []*TemperatureResponse, d display) {
+  for _, res := range results {
+    res.show(d)
+  }
+}
+
+// readings copies readings with converted temperatures.
+func (d display) readings(rs []ProviderReading) []ProviderReading {
+  if rs == nil {
+    return nil
+  }
+
+  out := make([]ProviderReading, len(rs))
+  for i, r := range rs {
+    r.Temp = d.temp(r.Temp)
+    out[i] = r
+  }
+
+  return out
+}
+
+func (d display) temp(k *float64) *float64 {
+  if k == nil {
+    return nil
+  }
+
+  v := d.round(fromKelvin(*k, d.units))
+  return &v
+}
+
+// change is the difference from one temperature in kelvin to another in
+// d's units.
+func (d display) change(from, to float64) *float64 {
+  v := d.round(fromKelvin(to, d.units) - fromKelvin(from, d.units))
+  return &v
+}
+
+// value is v at d's precision, for what has no units to convert.
+func (d display) value(v *float64) *float64 {
+  if v == nil {
+    return nil
+  }
+
+  r := d.round(*v)
+  return &r
+}
+
+func fromKelvin(k float64, units string) float64 {
+  switch units {
+  case "celsius":
+    return k - 273.15
+  case "fahrenheit":
+    return (k-273.15)*9/5 + 32
+  default:
+    return k
+  }
+}
+
+// homeCity is the client's home city, for requests that name no place.
+func homeCity(ctx context.Context) string {
+  return strings.TrimSpace(prefsFrom(ctx).HomeCity)
+}
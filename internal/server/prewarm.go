@@ -0,0 +1,120 @@
+package server
+
+import (
+  "context"
+  "log"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+)
+
+var prewarmRefreshes = metrics.NewCounter("prewarm_refreshes_total", "Cache refreshes done by the pre-warmer, by result.", "result")
+
+// cityList is a repeatable flag of city queries; repeating rather than
+// splitting on commas keeps "paris,fr" intact.
+type cityList []string
+
+func (l *cityList) String() string { return strings.Join(*l, ";") }
+
+func (l *cityList) Set(v string) error {
+  *l = append(*l, v)
+  return nil
+}
+
+// prewarmer refreshes the cache for configured and popular places ahead of
+// expiry, so they never pay for an upstream round trip on a request.
+type prewarmer struct {
+  srv      *server
+  cities   []string
+  top      int
+  window   time.Duration // popularity window for the top places
+  interval time.Duration
+  workers  int
+}
+
+func (p *prewarmer) run() {
+  if len(p.cities) == 0 && p.top == 0 {
+    return
+  }
+
+  for {
+    p.round()
+    time.Sleep(p.interval)
+  }
+}
+
+func (p *prewarmer) round() {
+  ctx, cancel := context.WithTimeout(context.Background(), p.interval)
+  defer cancel()
+
+  begin := time.Now()
+  locs := p.places(ctx)
+
+  work := make(chan geo.Location)
+  var wg sync.WaitGroup
+  for i := 0; i < p.workers; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for loc := range work {
+        result := "ok"
+        if p.srv.fetch(ctx, loc, summary, true).Error != "" {
+          result = "error"
+        }
+
+        prewarmRefreshes.Inc(result)
+      }
+    }()
+  }
+
+  // Places whose reading stays fresh past the next round, such as ones
+  // restored from a snapshot, wait for it.
+  refreshed := 0
+  for _, loc := range locs {
+    if until, ok := p.srv.cache.Expiry(loc); ok && until.After(time.Now().Add(p.interval)) {
+      continue
+    }
+
+    work <- loc
+    refreshed++
+  }
+
+  close(work)
+  wg.Wait()
+
+  log.Printf("prewarm: refreshed %d places, took: %s", refreshed, time.Since(begin).String())
+}
+
+// places merges the configured cities with the current top-N, most popular
+// first, without refreshing the same place twice.
+func (p *prewarmer) places(ctx context.Context) []geo.Location {
+  seen := make(map[string]bool)
+  var locs []geo.Location
+  add := func(loc geo.Location) {
+    if key := loc.Key(); !seen[key] {
+      seen[key] = true
+      locs = append(locs, loc)
+    }
+  }
+
+  for _, city := range p.cities {
+    loc, err := geo.Resolve(ctx, p.srv.geo, geo.ParseCity(city))
+    if err != nil {
+      log.Printf("prewarm: %s: %s", city, err)
+      continue
+    }
+
+    add(loc)
+  }
+
+  if p.top > 0 {
+    for _, pc := range p.srv.popular.top(p.top, p.window) {
+      add(pc.Location)
+    }
+  }
+
+  return locs
+}
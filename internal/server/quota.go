@@ -0,0 +1,161 @@
+package server
+
+import (
+  "errors"
+  "fmt"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+var (
+  quotaRemaining = metrics.NewGauge("provider_quota_remaining", "Calls left in a provider's budget window.", "provider", "window")
+  quotaExhausted = metrics.NewCounter("provider_quota_exhausted_total", "Lookups that left a provider out because its budget ran out.", "provider")
+)
+
+var errQuotaExhausted = errors.New("every provider's call budget is used up")
+
+// limit is a call budget over a fixed window; windows start on multiples
+// of their length, so daily budgets reset at midnight UTC.
+type limit struct {
+  calls  int
+  window time.Duration
+}
+
+var quotaWindows = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}
+
+// quotaSet holds the configured budgets per provider and doubles as a
+// repeatable flag: -provider.quota=openweathermap=60/m,1000/d.
+type quotaSet map[string][]limit
+
+func (q quotaSet) String() string {
+  var s []string
+  for name, ls := range q {
+    for _, l := range ls {
+      s = append(s, fmt.Sprintf("%s=%d/%s", name, l.calls, l.window))
+    }
+  }
+
+  sort.Strings(s)
+  return strings.Join(s, ",")
+}
+
+func (q quotaSet) Set(v string) error {
+  name, budgets, ok := strings.Cut(v, "=")
+  if !ok || name == "" {
+    return fmt.Errorf("want provider=<calls>/<s|m|h|d>,..., got %q", v)
+  }
+
+  for _, b := range strings.Split(budgets, ",") {
+    raw, unit, _ := strings.Cut(b, "/")
+    calls, err := strconv.Atoi(raw)
+    window, ok := quotaWindows[unit]
+    if err != nil || calls < 0 || !ok {
+      return fmt.Errorf("quota for %s: want <calls>/<s|m|h|d>, got %q", name, b)
+    }
+
+    q[name] = append(q[name], limit{calls: calls, window: window})
+  }
+
+  return nil
+}
+
+// quotas counts upstream calls per provider against its budgets. Checking
+// and spending are separate so only calls actually made count; concurrent
+// fan-outs may overshoot a budget by a call or two each.
+type quotas struct {
+  budgets quotaSet
+  tenant  string // whose budgets they are; empty is the deployment's
+
+  mu   sync.Mutex
+  used map[string]map[time.Duration]*spent
+}
+
+type spent struct {
+  start time.Time
+  calls int
+}
+
+func newQuotas(budgets quotaSet) *quotas {
+  return &quotas{budgets: budgets, used: make(map[string]map[time.Duration]*spent)}
+}
+
+// window returns the current counter for provider and l, reset when a new
+// window has started.
+func (q *quotas) window(provider string, l limit, now time.Time) *spent {
+  ws, ok := q.used[provider]
+  if !ok {
+    ws = make(map[time.Duration]*spent)
+    q.used[provider] = ws
+  }
+
+  start := now.UTC().Truncate(l.window)
+  s, ok := ws[l.window]
+  if !ok || !s.start.Equal(start) {
+    s = &spent{start: start}
+    ws[l.window] = s
+  }
+
+  return s
+}
+
+// available drops providers whose budget is used up and names them.
+func (q *quotas) available(ps providers.Multi) (providers.Multi, []string) {
+  if len(q.budgets) == 0 {
+    return ps, nil
+  }
+
+  now := time.Now()
+
+  q.mu.Lock()
+  defer q.mu.Unlock()
+
+  var ok providers.Multi
+  var exhausted []string
+  for _, p := range ps {
+    left := true
+    for _, l := range q.budgets[p.Name()] {
+      if q.window(p.Name(), l, now).calls >= l.calls {
+        left = false
+      }
+    }
+
+    if !left {
+      quotaExhausted.Inc(p.Name())
+      exhausted = append(exhausted, p.Name())
+      continue
+    }
+
+    ok = append(ok, p)
+  }
+
+  return ok, exhausted
+}
+
+// spend charges one call to provider.
+func (q *quotas) spend(provider string) {
+  ls := q.budgets[provider]
+  if len(ls) == 0 {
+    return
+  }
+
+  now := time.Now()
+
+  q.mu.Lock()
+  defer q.mu.Unlock()
+
+  for _, l := range ls {
+    s := q.window(provider, l, now)
+    s.calls++
+    if left := float64(max(l.calls-s.calls, 0)); q.tenant != "" {
+      tenantQuotaRemaining.Set(left, q.tenant, provider, l.window.String())
+    } else {
+      quotaRemaining.Set(left, provider, l.window.String())
+    }
+  }
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+  "math"
+  "net"
+  "net/http"
+  "strconv"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+)
+
+var rateLimited = metrics.NewCounter("http_rate_limited_total", "Requests rejected by the client rate limiter.")
+
+// rateLimiter keeps a token bucket per client: rate tokens per second up to
+// burst, one per request. Authenticated clients get a bucket per API key,
+// with their own limits if configured, others one per IP. A rate of 0
+// disables limiting for everyone without limits of their own.
+type rateLimiter struct {
+  proxies proxyList
+
+  mu      sync.Mutex
+  rate    float64
+  burst   float64
+  buckets map[string]*bucket
+}
+
+type bucket struct {
+  tokens      float64
+  rate, burst float64
+  seen        time.Time
+}
+
+func newRateLimiter(rate float64, burst int, proxies proxyList) *rateLimiter {
+  l := &rateLimiter{proxies: proxies, rate: rate, burst: float64(burst), buckets: make(map[string]*bucket)}
+  go l.evict()
+  return l
+}
+
+// set changes the limits of clients without their own; buckets keep
+// their tokens.
+func (l *rateLimiter) set(rate float64, burst int) {
+  l.mu.Lock()
+  defer l.mu.Unlock()
+  l.rate, l.burst = rate, float64(burst)
+}
+
+// take spends a token for client, or says how long until one is available.
+func (l *rateLimiter) take(client string, rate, burst float64) (bool, time.Duration) {
+  now := time.Now()
+
+  l.mu.Lock()
+  defer l.mu.Unlock()
+
+  b, ok := l.buckets[client]
+  if !ok {
+    b = &bucket{tokens: burst, rate: rate, burst: burst, seen: now}
+    l.buckets[client] = b
+  }
+
+  b.tokens = math.Min(burst, b.tokens+now.Sub(b.seen).Seconds()*rate)
+  b.seen = now
+
+  if b.tokens < 1 {
+    return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+  }
+
+  b.tokens--
+  return true, 0
+}
+
+// evict forgets clients whose buckets have refilled, which is the same as
+// never having seen them.
+func (l *rateLimiter) evict() {
+  for range time.Tick(time.Minute) {
+    l.mu.Lock()
+    for client, b := range l.buckets {
+      if time.Since(b.seen).Seconds()*b.rate >= b.burst {
+        delete(l.buckets, client)
+      }
+    }
+    l.mu.Unlock()
+  }
+}
+
+// clientIP is the address the request came from: its caller, behind the
+// -trusted.proxy list. Other X-Forwarded-For is not trusted: anyone could
+// set it to get a fresh bucket.
+func (l proxyList) clientIP(r *http.Request) string {
+  if addr, err := l.caller(r); err == nil {
+    return addr.String()
+  }
+
+  host, _, err := net.SplitHostPort(r.RemoteAddr)
+  if err != nil {
+    return r.RemoteAddr
+  }
+
+  return host
+}
+
+// limit answers 429 with Retry-After once a client exceeds its rate.
+// Metric scrapes are exempt so monitoring doesn't compete with traffic.
+func (l *rateLimiter) limit(h http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    l.mu.Lock()
+    client, rate, burst := "ip:"+l.proxies.clientIP(r), l.rate, l.burst
+    l.mu.Unlock()
+
+    name := ""
+    if c, ok := clientFrom(r.Context()); ok {
+      client, name = "key:"+c.Name, c.Name
+      if c.Rate > 0 {
+        rate, burst = c.Rate, float64(max(c.Burst, 1))
+      }
+    }
+
+    if rate <= 0 || r.URL.Path == "/metrics" {
+      noteRateLimit(r, name, "off")
+      h.ServeHTTP(w, r)
+      return
+    }
+
+    if ok, wait := l.take(client, rate, burst); !ok {
+      noteRateLimit(r, name, "limited")
+      rateLimited.Inc()
+      w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+      httpError(w, "rate limit exceeded, retry in "+wait.Round(time.Millisecond).String(), http.StatusTooManyRequests)
+      return
+    }
+
+    noteRateLimit(r, name, "ok")
+    h.ServeHTTP(w, r)
+  })
+}
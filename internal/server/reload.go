@@ -0,0 +1,448 @@
+package server
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "log"
+  "maps"
+  "os"
+  "os/signal"
+  "slices"
+  "sort"
+  "strings"
+  "sync"
+  "syscall"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/cache"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+var configReloads = metrics.NewCounter("config_reloads_total", "Reloads of the -config file on SIGHUP, by result.", "result")
+
+// keyedBuiltins are the built-in providers that take an API key, by the
+// name api_keys knows them by.
+var keyedBuiltins = []string{"openweathermap", "wunderground", "meteostat", "visualcrossing", "tomorrow.io", "stormglass"}
+
+// cacheConfig is the config file's part of -cache.ttl, -cache.stale and
+// -provider.ttl, which it overrides.
+type cacheConfig struct {
+  TTL       string            `json:"ttl,omitempty"`
+  Stale     string            `json:"stale,omitempty"`
+  Providers map[string]string `json:"providers,omitempty"` // by provider, as -provider.ttl
+
+  ttl, stale *time.Duration
+  ttls       cache.TTLSet
+}
+
+func (cc *cacheConfig) compile() []string {
+  var es []string
+  parse := func(field, raw string) *time.Duration {
+    if raw == "" {
+      return nil
+    }
+
+    d, err := time.ParseDuration(raw)
+    if err != nil || d < 0 {
+      es = append(es, fmt.Sprintf("%s: want a duration such as 5m, got %q", field, raw))
+      return nil
+    }
+
+    return &d
+  }
+
+  cc.ttl, cc.stale = parse("ttl", cc.TTL), parse("stale", cc.Stale)
+  if len(cc.Providers) > 0 {
+    cc.ttls = cache.TTLSet{}
+  }
+
+  for name, raw := range cc.Providers {
+    if raw == "" {
+      es = append(es, fmt.Sprintf("providers.%s: is empty", name))
+      continue
+    }
+
+    if d := parse("providers."+name, raw); d != nil {
+      cc.ttls[name] = *d
+    }
+  }
+
+  sort.Strings(es)
+  return es
+}
+
+// rateLimitConfig overrides -ratelimit.rate and -ratelimit.burst.
+type rateLimitConfig struct {
+  Rate  *float64 `json:"rate,omitempty"`
+  Burst *int     `json:"burst,omitempty"`
+}
+
+func (rc rateLimitConfig) compile() []string {
+  var es []string
+  if rc.Rate != nil && *rc.Rate < 0 {
+    es = append(es, fmt.Sprintf("rate: can't be negative, got %g", *rc.Rate))
+  }
+
+  if rc.Burst != nil && *rc.Burst < 0 {
+    es = append(es, fmt.Sprintf("burst: can't be negative, got %d", *rc.Burst))
+  }
+
+  return es
+}
+
+// compileKeys checks api_keys: a key for each built-in provider named.
+func compileKeys(keys map[string]string) []string {
+  var es []string
+  for name, key := range keys {
+    switch {
+    case !slices.Contains(keyedBuiltins, name):
+      es = append(es, fmt.Sprintf("%s: is not a built-in provider that takes a key, want one of %s", name, strings.Join(keyedBuiltins, ", ")))
+    case key == "":
+      es = append(es, fmt.Sprintf("%s: is empty", name))
+    }
+  }
+
+  sort.Strings(es)
+  return es
+}
+
+// settings are what the config file can change without a restart.
+type settings struct {
+  keys         map[string]string // of the built-in providers, by name
+  enabled      string            // as -providers
+  shadow       string            // as -providers.shadow
+  cacheTTL     time.Duration
+  cacheStale   time.Duration
+  providerTTLs cache.TTLSet
+  rate         float64
+  burst        int
+  clients      []clientConfig
+}
+
+// with is st, from the flags, under what c sets.
+func (st settings) with(c *config) settings {
+  keys := make(map[string]string, len(st.keys)+len(c.APIKeys))
+  maps.Copy(keys, st.keys)
+  maps.Copy(keys, c.APIKeys)
+  st.keys = keys
+  if len(c.Enabled) > 0 {
+    st.enabled = strings.Join(c.Enabled, ",")
+  }
+
+  if len(c.Shadow) > 0 {
+    st.shadow = strings.Join(c.Shadow, ",")
+  }
+
+  if c.Cache.ttl != nil {
+    st.cacheTTL = *c.Cache.ttl
+  }
+
+  if c.Cache.stale != nil {
+    st.cacheStale = *c.Cache.stale
+  }
+
+  if c.Cache.ttls != nil {
+    st.providerTTLs = c.Cache.ttls
+  }
+
+  if rl := c.RateLimit; rl != nil && rl.Rate != nil {
+    st.rate = *rl.Rate
+  }
+
+  if rl := c.RateLimit; rl != nil && rl.Burst != nil {
+    st.burst = *rl.Burst
+  }
+
+  st.clients = c.Clients
+  return st
+}
+
+// providerSet is the enabled providers, which a reload swaps.
+type providerSet struct {
+  mu     sync.RWMutex
+  ps     providers.Multi
+  shadow map[string]bool // of ps, only compared with the answers, see shadows
+}
+
+func newProviderSet(ps providers.Multi, shadow map[string]bool) *providerSet {
+  return &providerSet{ps: ps, shadow: shadow}
+}
+
+func (s *providerSet) get() providers.Multi {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+  return s.ps
+}
+
+// split is get in two: the providers answers are made of, and the shadows.
+func (s *providerSet) split() (live, shadow providers.Multi) {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+
+  for _, p := range s.ps {
+    if s.shadow[p.Name()] {
+      shadow = append(shadow, p)
+    } else {
+      live = append(live, p)
+    }
+  }
+
+  return live, shadow
+}
+
+func (s *providerSet) isShadow(name string) bool {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+  return s.shadow[name]
+}
+
+func (s *providerSet) set(ps providers.Multi, shadow map[string]bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.ps, s.shadow = ps, shadow
+}
+
+// providerBuild is what the enabled providers are made of besides their
+// settings, fixed at startup.
+type providerBuild struct {
+  path     string // -config, for errors
+  oneCall  bool
+  baseURLs baseURLSet
+  use      string
+  extra    providers.Multi // the config file's own, started once
+  routing  []routeConfig
+  tenants  []tenantConfig
+}
+
+// providers is the enabled providers under st, checked as at startup: the
+// built-ins that have what they need, then the config file's own; and the
+// names of those that are shadows.
+func (b providerBuild) providers(st settings) (providers.Multi, map[string]bool, error) {
+  keys := st.keys
+  mw := providers.Multi{
+    providers.OpenWeatherMap{APIKey: keys["openweathermap"], OneCall: b.oneCall, BaseURL: b.baseURLs["openweathermap"]},
+    providers.WeatherUnderground{APIKey: keys["wunderground"], BaseURL: b.baseURLs["wunderground"]},
+    providers.OpenMeteo{BaseURL: b.baseURLs["open-meteo"], ArchiveURL: b.baseURLs["open-meteo.archive"], AirQualityURL: b.baseURLs["open-meteo.air-quality"], MarineURL: b.baseURLs["open-meteo.marine"]},
+    providers.MetNo{BaseURL: b.baseURLs["met.no"]},
+  }
+
+  if key := keys["visualcrossing"]; key != "" {
+    mw = append(mw, providers.VisualCrossing{APIKey: key, BaseURL: b.baseURLs["visualcrossing"]})
+  }
+
+  if key := keys["meteostat"]; key != "" {
+    mw = append(mw, providers.Meteostat{APIKey: key, BaseURL: b.baseURLs["meteostat"]})
+  }
+
+  if key := keys["tomorrow.io"]; key != "" {
+    mw = append(mw, providers.Tomorrow{APIKey: key, BaseURL: b.baseURLs["tomorrow.io"]})
+  }
+
+  if key := keys["stormglass"]; key != "" {
+    mw = append(mw, providers.Stormglass{APIKey: key, BaseURL: b.baseURLs["stormglass"]})
+  }
+
+  mw = append(mw, b.extra...)
+  if errs := append(unroutable(b.routing, mw), unknownTenantProviders(b.tenants, mw)...); len(errs) > 0 {
+    return nil, nil, fmt.Errorf("%s: %s", b.path, errs[0])
+  }
+
+  u, err := providers.ParseUsage(b.use, st.cacheTTL)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  if mw, err = providers.Enabled(mw, st.enabled, u); err != nil {
+    return nil, nil, fmt.Errorf("providers: %w", err)
+  }
+
+  for _, p := range mw {
+    if err := u.AllowsCaching(p, st.providerTTLs.TTL(p)); err != nil {
+      return nil, nil, fmt.Errorf("providers: %w", err)
+    }
+  }
+
+  shadow, err := shadowSet(st.shadow, mw)
+  if err != nil {
+    return nil, nil, fmt.Errorf("providers.shadow: %w", err)
+  }
+
+  return mw, shadow, nil
+}
+
+// shadowSet is the comma-separated names, each of a provider in mw; at
+// least one of mw must be left to answer with.
+func shadowSet(names string, mw providers.Multi) (map[string]bool, error) {
+  shadow := make(map[string]bool)
+  for _, n := range strings.Split(names, ",") {
+    if n = strings.TrimSpace(n); n != "" {
+      shadow[n] = true
+    }
+  }
+
+  for _, n := range slices.Sorted(maps.Keys(shadow)) {
+    if !slices.ContainsFunc(mw, func(p providers.Provider) bool { return p.Name() == n }) {
+      return nil, fmt.Errorf("%q isn't an enabled provider", n)
+    }
+  }
+
+  if len(shadow) > 0 && len(shadow) == len(mw) {
+    return nil, errors.New("every enabled provider is a shadow, leaving none to answer with")
+  }
+
+  return shadow, nil
+}
+
+// reloadable are the config file's sections a reload applies; a change
+// to any other is logged as waiting for a restart.
+var reloadable = []string{"clients", "api_keys", "enabled_providers", "shadow_providers", "cache", "ratelimit"}
+
+// reloader re-reads the -config file on SIGHUP and applies its settings.
+// A file that doesn't load, or whose providers wouldn't start, is logged
+// and the running settings kept.
+type reloader struct {
+  srv     *server
+  path    string
+  flags   settings
+  build   providerBuild
+  auth    bool    // -auth, which needs clients
+  started *config // for what takes a restart
+}
+
+func (rl *reloader) run() {
+  if rl.path == "" {
+    return
+  }
+
+  hup := make(chan os.Signal, 1)
+  signal.Notify(hup, syscall.SIGHUP)
+  for range hup {
+    if err := rl.reload(); err != nil {
+      configReloads.Inc("error")
+      log.Printf("config: reloading %s: %s; keeping the running config", rl.path, err)
+      continue
+    }
+
+    configReloads.Inc("ok")
+  }
+}
+
+func (rl *reloader) reload() error {
+  s := rl.srv
+  c, err := loadConfig(rl.path)
+  if err != nil {
+    return err
+  }
+
+  if rl.auth && len(c.Clients) == 0 {
+    return errNoClients
+  }
+
+  for i, cl := range c.Clients {
+    if _, ok := s.tenants[cl.Tenant]; cl.Tenant != "" && !ok {
+      return fmt.Errorf("%s: clients[%d].tenant: %q isn't running, new tenants take a restart", rl.path, i, cl.Tenant)
+    }
+  }
+
+  st := rl.flags.with(c)
+  var mw providers.Multi
+  var shadow map[string]bool
+  if !s.offline {
+    if mw, shadow, err = rl.build.providers(st); err != nil {
+      return err
+    }
+
+    // Plugins left out until now start first; one that fails keeps the
+    // reload from applying, as it would have kept the server from starting,
+    // and stops the others it started. Those left out now stop after the
+    // swap.
+    running := plugins(s.providers.get())
+    var started []*providers.Plugin
+    for pl := range plugins(mw) {
+      if running[pl] {
+        continue
+      }
+
+      if err := pl.Start(); err != nil {
+        for _, pl := range started {
+          pl.Stop()
+        }
+
+        return err
+      }
+
+      started = append(started, pl)
+    }
+
+    s.providers.set(mw, shadow)
+    kept := plugins(mw)
+    for pl := range running {
+      if !kept[pl] {
+        pl.Stop()
+        log.Printf("config: plugin %s stopped, no longer enabled", pl.Name())
+      }
+    }
+  }
+
+  s.cache.SetTTL(st.cacheTTL, st.cacheStale)
+  s.readings.SetTTLs(st.providerTTLs)
+  for _, t := range s.tenants {
+    t.cache.SetTTL(st.cacheTTL, st.cacheStale)
+    t.readings.SetTTLs(st.providerTTLs)
+  }
+
+  s.limiter.set(st.rate, st.burst)
+  s.auth.set(st.clients)
+
+  names := make([]string, 0, len(mw))
+  for _, p := range s.providers.get() {
+    if s.providers.isShadow(p.Name()) {
+      names = append(names, p.Name()+" (shadow)")
+    } else {
+      names = append(names, p.Name())
+    }
+  }
+
+  log.Printf("config: reloaded %s: providers %s, %d clients, cache ttl %s", rl.path, strings.Join(names, ","), len(st.clients), st.cacheTTL)
+  for _, section := range changedSections(rl.started, c) {
+    log.Printf("config: %s: %s changed, restart to apply it", rl.path, section)
+  }
+
+  return nil
+}
+
+// plugins are the plugins among ps.
+func plugins(ps providers.Multi) map[*providers.Plugin]bool {
+  pls := make(map[*providers.Plugin]bool)
+  for _, p := range ps {
+    if pl, ok := p.(*providers.Plugin); ok {
+      pls[pl] = true
+    }
+  }
+
+  return pls
+}
+
+// changedSections are the sections of b that differ from a's, other than
+// those a reload applies.
+func changedSections(a, b *config) []string {
+  sections := func(c *config) map[string]json.RawMessage {
+    raw, _ := json.Marshal(c)
+    var m map[string]json.RawMessage
+    json.Unmarshal(raw, &m)
+    return m
+  }
+
+  before, after := sections(a), sections(b)
+  var changed []string
+  for name, v := range after {
+    if !slices.Contains(reloadable, name) && string(before[name]) != string(v) {
+      changed = append(changed, name)
+    }
+  }
+
+  sort.Strings(changed)
+  return changed
+}
@@ -0,0 +1,358 @@
+package server
+
+import (
+  "bytes"
+  "context"
+  "fmt"
+  "log"
+  "strings"
+  "text/template"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+)
+
+// reportConfig is a scheduled report from the config file: the forecast
+// for a city over the next hours, a point every step hours, rendered and
+// sent to each of its channels on schedule. The schedule's times are in
+// timezone, by default the city's. Webhook is short for a webhook
+// channel.
+type reportConfig struct {
+  Name     string          `json:"name"`
+  City     string          `json:"city"`
+  Schedule string          `json:"schedule"`
+  TimeZone string          `json:"timezone,omitempty"`
+  Hours    int             `json:"hours,omitempty"`
+  Step     int             `json:"step,omitempty"`
+  Webhook  string          `json:"webhook,omitempty"`
+  Channels []channelConfig `json:"channels,omitempty"`
+  Template string          `json:"template,omitempty"`
+}
+
+// Reports forecast at most a week ahead, which is as far as providers do.
+const reportMaxHours = 7 * 24
+
+const defaultReportTemplate = `{{.Report}}: {{.City}}, {{printf "%.0f" .Low.Celsius}} to {{printf "%.0f" .High.Celsius}}°C` +
+  `{{range .Hours}}
+{{.Time.Format "Mon 15:04"}} {{printf "%.1f" .Celsius}}°C{{if .IcyRoads}}, icy roads{{end}}{{end}}`
+
+// reportData is what a report's template can refer to. Times are in the
+// report's time zone.
+type reportData struct {
+  Report    string
+  City      string
+  Time      time.Time
+  Low, High reportTemp
+  Hours     []reportHour
+}
+
+type reportTemp struct {
+  Kelvin     float64
+  Celsius    float64
+  Fahrenheit float64
+}
+
+func newReportTemp(kelvin float64) reportTemp {
+  c := kelvin - 273.15
+  return reportTemp{Kelvin: kelvin, Celsius: c, Fahrenheit: c*9/5 + 32}
+}
+
+// reportHour is one point of a report's forecast.
+type reportHour struct {
+  Time time.Time
+  reportTemp
+  Precipitation *float64 // mm in the hour
+  Snowfall      *float64 // cm in the hour
+  IcyRoads      bool
+}
+
+// report is a compiled reportConfig.
+type report struct {
+  reportConfig
+  schedule schedule
+  zone     *time.Location // nil for the city's
+  message  *template.Template
+  channels []channel
+}
+
+func (rc reportConfig) compile(n notificationsConfig) (*report, []string) {
+  var errs []string
+  if rc.Name == "" {
+    errs = append(errs, "name: is required")
+  }
+
+  if err := geo.ParseCity(rc.City).Validate(); err != nil {
+    errs = append(errs, "city: "+err.Error())
+  }
+
+  sched, err := parseSchedule(rc.Schedule)
+  if err != nil {
+    errs = append(errs, "schedule: "+err.Error())
+  }
+
+  var zone *time.Location
+  if rc.TimeZone != "" {
+    if zone, err = loadZone(rc.TimeZone); err != nil {
+      errs = append(errs, fmt.Sprintf("timezone: unknown time zone %q, want one such as Europe/Moscow", rc.TimeZone))
+    }
+  }
+
+  if rc.Hours == 0 {
+    rc.Hours = 24
+  }
+
+  if rc.Step == 0 {
+    rc.Step = 3
+  }
+
+  if rc.Hours < 0 || rc.Hours > reportMaxHours {
+    errs = append(errs, fmt.Sprintf("hours: want 1 to %d, got %d", reportMaxHours, rc.Hours))
+  }
+
+  if rc.Step < 0 || rc.Step > rc.Hours {
+    errs = append(errs, fmt.Sprintf("step: want 1 to hours, got %d", rc.Step))
+  }
+
+  var channels []channel
+  if rc.Webhook != "" {
+    c, es := newWebhookChannel(channelConfig{URL: rc.Webhook}, n)
+    for _, e := range es {
+      errs = append(errs, "webhook: "+strings.TrimPrefix(e, "url: "))
+    }
+
+    channels = append(channels, c)
+  }
+
+  for i, cc := range rc.Channels {
+    c, es := cc.compile(n)
+    for _, e := range es {
+      errs = append(errs, fmt.Sprintf("channels[%d].%s", i, e))
+    }
+
+    channels = append(channels, c)
+  }
+
+  if len(channels) == 0 {
+    errs = append(errs, "channels: needs a webhook or at least one channel")
+  }
+
+  src := rc.Template
+  if src == "" {
+    src = defaultReportTemplate
+  }
+
+  // Rendering sample data catches unknown fields, which parsing doesn't.
+  message, err := template.New("template").Option("missingkey=error").Parse(src)
+  if err == nil {
+    sample := reportData{Report: rc.Name, City: "sample", Time: time.Now(), Hours: []reportHour{{Time: time.Now()}}}
+    err = message.Execute(new(bytes.Buffer), sample)
+  }
+
+  if err != nil {
+    msg := strings.TrimPrefix(strings.TrimPrefix(err.Error(), "template: "), "template:")
+    errs = append(errs, "template: "+msg)
+  }
+
+  if len(errs) > 0 {
+    return nil, errs
+  }
+
+  return &report{reportConfig: rc, schedule: sched, zone: zone, message: message, channels: channels}, nil
+}
+
+var reportNotifications = metrics.NewCounter("report_notifications_total", "Scheduled report deliveries, by report, channel and outcome.", "report", "channel", "outcome")
+
+const reportStateBucket = "report_state"
+
+// A report the server was down for is still sent once it is back within
+// this long; later, it waits for its next time.
+const reportCatchUp = time.Hour
+
+// reportState is a report's part of the store.
+type reportState struct {
+  LastSent time.Time `json:"last_sent"` // the time it was due
+}
+
+// reporter sends the config file's reports when they are due, as far as
+// the store shows across restarts.
+type reporter struct {
+  srv     *server
+  db      *store
+  reports []*report
+
+  next map[string]time.Time // by report name; unset until its zone is known
+}
+
+func newReporter(srv *server, db *store, reports []*report) *reporter {
+  return &reporter{srv: srv, db: db, reports: reports, next: make(map[string]time.Time)}
+}
+
+func (rp *reporter) run() {
+  if len(rp.reports) == 0 {
+    return
+  }
+
+  rp.tick(time.Now())
+  for now := range time.Tick(30 * time.Second) {
+    rp.tick(now)
+  }
+}
+
+func (rp *reporter) tick(now time.Time) {
+  for _, r := range rp.reports {
+    next, ok := rp.next[r.Name]
+    if !ok {
+      if next, ok = rp.first(r, now); !ok {
+        continue
+      }
+    }
+
+    if now.Before(next) {
+      rp.next[r.Name] = next
+      continue
+    }
+
+    if err := rp.db.put(reportStateBucket, r.Name, reportState{LastSent: next.UTC()}); err != nil {
+      log.Printf("reports: %s: %s", r.Name, err)
+    }
+
+    rp.next[r.Name] = r.schedule.next(now, rp.zone(r))
+    go rp.send(r, next)
+  }
+}
+
+// first is when r is next due: its time missed while the server was down,
+// if recent, or its next one. Without a time zone of its own, that is the
+// city's, and not known until the city is found.
+func (rp *reporter) first(r *report, now time.Time) (time.Time, bool) {
+  if r.zone == nil {
+    ctx, cancel := context.WithTimeout(context.Background(), webhookClient.Timeout)
+    defer cancel()
+
+    loc, err := geo.Resolve(ctx, rp.srv.geo, geo.ParseCity(r.City))
+    if err != nil {
+      log.Printf("reports: %s: %s: %s", r.Name, r.City, err)
+      return time.Time{}, false
+    }
+
+    zone := time.UTC
+    if loc = rp.srv.zones.Locate(ctx, loc); loc.TimeZone != "" {
+      if z, err := loadZone(loc.TimeZone); err == nil {
+        zone = z
+      }
+    }
+
+    r.zone = zone
+  }
+
+  log.Printf("reports: %s: %s, in %s", r.Name, r.Schedule, r.zone)
+
+  next := r.schedule.next(now, r.zone)
+  var st reportState
+  if ok, err := rp.db.get(reportStateBucket, r.Name, &st); err == nil && ok {
+    if missed := r.schedule.next(st.LastSent, r.zone); missed.Before(next) && now.Sub(missed) < reportCatchUp {
+      return missed, true
+    }
+  }
+
+  return next, true
+}
+
+func (rp *reporter) zone(r *report) *time.Location {
+  if r.zone == nil {
+    return time.UTC
+  }
+
+  return r.zone
+}
+
+// send forecasts r's city from at and delivers it to every channel; one
+// failing doesn't keep the others from being tried.
+func (rp *reporter) send(r *report, at time.Time) {
+  s := rp.srv
+  ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+  defer cancel()
+
+  forecasts, data, err := rp.forecast(ctx, r, at)
+  if err != nil {
+    reportNotifications.Inc(r.Name, "forecast", "error")
+    log.Printf("reports: %s: %s: %s", r.Name, r.City, err)
+    return
+  }
+
+  var msg bytes.Buffer
+  if err := r.message.Execute(&msg, data); err != nil {
+    reportNotifications.Inc(r.Name, "template", "error")
+    log.Printf("reports: %s: %s", r.Name, err)
+    return
+  }
+
+  d := s.defaultDisplay()
+  for _, f := range forecasts {
+    f.show(d)
+  }
+
+  n := notification{Rule: r.Name, City: data.City, Message: msg.String(), Forecast: forecasts}
+  for _, c := range r.channels {
+    if err := c.send(ctx, n); err != nil {
+      reportNotifications.Inc(r.Name, c.kind(), "error")
+      log.Printf("reports: %s: %s: %s", r.Name, c.kind(), err)
+      continue
+    }
+
+    reportNotifications.Inc(r.Name, c.kind(), "ok")
+    log.Printf("reports: %s: sent %s for %s", r.Name, c.kind(), data.City)
+  }
+}
+
+// forecast is r's city every step hours from at, as route-weather gives a
+// waypoint's, and the same for its template.
+func (rp *reporter) forecast(ctx context.Context, r *report, at time.Time) ([]*ForecastResponse, reportData, error) {
+  s := rp.srv
+  loc, err := geo.Resolve(ctx, s.geo, geo.ParseCity(r.City))
+  if err != nil {
+    return nil, reportData{}, err
+  }
+
+  loc = s.zones.Locate(ctx, loc)
+  var etas []time.Time
+  for h := 0; h <= r.Hours; h += r.Step {
+    etas = append(etas, at.Add(time.Duration(h)*time.Hour))
+  }
+
+  active := s.activeFor(ctx, loc)
+  asked := time.Now().UTC()
+  rs, steps := s.forecastsAt(ctx, loc, active, etas)
+
+  zone := rp.zone(r)
+  data := reportData{Report: r.Name, City: loc.Name, Time: at.In(zone)}
+  var forecasts []*ForecastResponse
+  for i, eta := range etas {
+    res := &ForecastResponse{SchemaVersion: responseVersion, Query: r.City, ETA: eta}
+    res.at(loc)
+    if status, err := s.averageForecast(res, loc, active, rs[i], steps[i], asked); err != nil {
+      res.Error, res.Status = err.Error(), status
+      forecasts = append(forecasts, res)
+      continue
+    }
+
+    hour := reportHour{Time: eta.In(zone), reportTemp: newReportTemp(*res.Temp), Precipitation: res.Precipitation, Snowfall: res.Snowfall, IcyRoads: res.IcyRoadReason != ""}
+    if len(data.Hours) == 0 || hour.Kelvin < data.Low.Kelvin {
+      data.Low = hour.reportTemp
+    }
+
+    if len(data.Hours) == 0 || hour.Kelvin > data.High.Kelvin {
+      data.High = hour.reportTemp
+    }
+
+    data.Hours = append(data.Hours, hour)
+    forecasts = append(forecasts, res)
+  }
+
+  if len(data.Hours) == 0 {
+    return nil, reportData{}, fmt.Errorf("no forecast: %s", forecasts[0].Error)
+  }
+
+  return forecasts, data, nil
+}
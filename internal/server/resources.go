@@ -0,0 +1,559 @@
+package server
+
+import (
+  "context"
+  "crypto/rand"
+  "encoding/hex"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "log"
+  "net/http"
+  "strconv"
+  "sync"
+  "time"
+)
+
+// resourceMeta is common to every API-managed resource.
+type resourceMeta struct {
+  ID        string     `json:"id"`
+  Version   int        `json:"version"` // bumped on every change, exposed as the ETag
+  Created   time.Time  `json:"created"`
+  Updated   time.Time  `json:"updated"`
+  DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+func (m *resourceMeta) meta() *resourceMeta { return m }
+
+func (m *resourceMeta) etag() string { return `"v` + strconv.Itoa(m.Version) + `"` }
+
+type resource interface {
+  meta() *resourceMeta
+  validate() error
+}
+
+// redacter is a resource holding values its responses mustn't show, such
+// as credentials; redacted is a copy without them.
+type redacter interface {
+  redacted() resource
+}
+
+// shown is item as responses have it.
+func shown(item resource) resource {
+  if r, ok := item.(redacter); ok {
+    return r.redacted()
+  }
+
+  return item
+}
+
+var (
+  errNotFound        = errors.New("not found")
+  errExists          = errors.New("already exists")
+  errVersionMismatch = errors.New("modified concurrently")
+  errOwnerLimit      = errors.New("keeps the most allowed")
+)
+
+// collection is a bucket of resources of one kind with the usual CRUD
+// endpoints. Deletes are soft: the resource keeps its data, gets a
+// deleted_at stamp and can be restored until the janitor purges it.
+//
+// Changes are optimistic: every resource carries a version served as its
+// ETag, and PUT must send it back in If-Match (DELETE and restore may), so
+// two operators editing the same resource can't silently overwrite each
+// other; the loser gets 412 and the current version.
+//
+// With owner, each resource belongs to the API client that created it:
+// clients see and change only their own, anonymous callers only the
+// unowned, and the admin token everyone's under /v1/admin/<name>.
+type collection struct {
+  db      *store
+  name    string // bucket and URL segment, e.g. "subscriptions"
+  base    string // URL prefix, "/v1/" + name unless set
+  newItem func() resource
+  idOf    func(resource) string // natural key; random IDs when nil
+  guard   func(http.HandlerFunc) http.HandlerFunc
+
+  owner      func(resource) *string // the owning client's name in a resource
+  perOwner   int                    // most live resources a client may keep; 0 is no limit
+  adminGuard func(http.HandlerFunc) http.HandlerFunc
+
+  mu sync.Mutex // serializes read-check-write cycles
+}
+
+func (c *collection) prefix() string {
+  if c.base != "" {
+    return c.base
+  }
+
+  return "/v1/" + c.name
+}
+
+func (c *collection) get(id string) (resource, error) {
+  item := c.newItem()
+  ok, err := c.db.get(c.name, id, item)
+  if err != nil {
+    return nil, err
+  }
+
+  if !ok {
+    return nil, errNotFound
+  }
+
+  return item, nil
+}
+
+// list returns live resources, or only the soft-deleted ones.
+func (c *collection) list(deleted bool) ([]resource, error) {
+  items := []resource{}
+  for _, id := range c.db.keys(c.name, "") {
+    item, err := c.get(id)
+    if err != nil {
+      return nil, err
+    }
+
+    if (item.meta().DeletedAt != nil) == deleted {
+      items = append(items, item)
+    }
+  }
+
+  return items, nil
+}
+
+// owned counts owner's live resources.
+func (c *collection) owned(owner string) int {
+  items, _ := c.list(false)
+  n := 0
+  for _, item := range items {
+    if *c.owner(item) == owner {
+      n++
+    }
+  }
+
+  return n
+}
+
+func (c *collection) save(item resource) error {
+  return c.db.put(c.name, item.meta().ID, item)
+}
+
+// create stores a new resource at version 1.
+func (c *collection) create(item resource) error {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  return c.insert(item)
+}
+
+// createOwned is create for a client, failing with errOwnerLimit once
+// owner keeps perOwner resources. Counting and storing are under one
+// lock, so parallel creates can't all pass the count.
+func (c *collection) createOwned(owner string, item resource) error {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  if c.perOwner > 0 && c.owned(owner) >= c.perOwner {
+    return errOwnerLimit
+  }
+
+  return c.insert(item)
+}
+
+// insert is create under c.mu.
+func (c *collection) insert(item resource) error {
+  id := randomHex(8)
+  if c.idOf != nil {
+    id = c.idOf(item)
+    if _, err := c.get(id); err == nil {
+      return errExists
+    }
+  }
+
+  now := time.Now().UTC()
+  *item.meta() = resourceMeta{ID: id, Version: 1, Created: now, Updated: now}
+  return c.save(item)
+}
+
+// update replaces the resource's data if it is still at the version the
+// caller has seen; ifMatch "" skips the check.
+func (c *collection) update(id, ifMatch string, item resource) (resource, error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  old, err := c.check(id, ifMatch)
+  if err != nil {
+    return old, err
+  }
+
+  if old.meta().DeletedAt != nil {
+    return old, errDeleted
+  }
+
+  if c.idOf != nil && c.idOf(item) != id {
+    return old, errRenamed
+  }
+
+  if c.owner != nil && *c.owner(item) == "" {
+    *c.owner(item) = *c.owner(old)
+  }
+
+  m := item.meta()
+  *m = *old.meta()
+  m.Version++
+  m.Updated = time.Now().UTC()
+  return item, c.save(item)
+}
+
+// upsert creates id or replaces it, restoring it first if it was deleted;
+// ifMatch is checked against an existing resource as in update.
+func (c *collection) upsert(id, ifMatch string, item resource) (resource, bool, error) {
+  old, err := c.get(id)
+  switch {
+  case errors.Is(err, errNotFound):
+    return item, true, c.create(item)
+  case err != nil:
+    return nil, false, err
+  case old.meta().DeletedAt != nil:
+    if old, err = c.setDeleted(id, ifMatch, false); err != nil {
+      return old, false, err
+    }
+
+    ifMatch = old.meta().etag()
+  }
+
+  item, err = c.update(id, ifMatch, item)
+  return item, false, err
+}
+
+var (
+  errDeleted = errors.New("is deleted, restore it first")
+  errRenamed = errors.New("can't be renamed, create a new one instead")
+)
+
+func (c *collection) setDeleted(id, ifMatch string, deleted bool) (resource, error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  item, err := c.check(id, ifMatch)
+  if err != nil {
+    return item, err
+  }
+
+  m := item.meta()
+  if (m.DeletedAt != nil) == deleted {
+    return item, nil // already there; don't restart the retention clock
+  }
+
+  now := time.Now().UTC()
+  m.Version++
+  m.Updated = now
+  m.DeletedAt = nil
+  if deleted {
+    m.DeletedAt = &now
+  }
+
+  return item, c.save(item)
+}
+
+// check loads id and compares its ETag with ifMatch, if given.
+func (c *collection) check(id, ifMatch string) (resource, error) {
+  item, err := c.get(id)
+  if err != nil {
+    return nil, err
+  }
+
+  if ifMatch != "" && ifMatch != "*" && ifMatch != item.meta().etag() {
+    return item, errVersionMismatch
+  }
+
+  return item, nil
+}
+
+// purge permanently removes resources soft-deleted before cutoff.
+func (c *collection) purge(cutoff time.Time) (int, error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  items, err := c.list(true)
+  if err != nil {
+    return 0, err
+  }
+
+  n := 0
+  for _, item := range items {
+    if item.meta().DeletedAt.Before(cutoff) {
+      if err := c.db.delete(c.name, item.meta().ID); err != nil {
+        return n, err
+      }
+
+      n++
+    }
+  }
+
+  return n, nil
+}
+
+func (c *collection) register(mux *http.ServeMux) {
+  routes := func(base string, guard func(http.HandlerFunc) http.HandlerFunc) {
+    handle := func(pattern string, h http.HandlerFunc) {
+      if guard != nil {
+        h = guard(h)
+      }
+
+      mux.HandleFunc(pattern, h)
+    }
+
+    handle("GET "+base, c.handleList)
+    handle("POST "+base, c.handleCreate)
+    handle("GET "+base+"/{id}", c.handleGet)
+    handle("PUT "+base+"/{id}", c.handleUpdate)
+    handle("DELETE "+base+"/{id}", c.handleDelete)
+    handle("POST "+base+"/{id}/restore", c.handleRestore)
+  }
+
+  routes(c.prefix(), c.guard)
+  if c.owner != nil {
+    routes("/v1/admin/"+c.name, func(h http.HandlerFunc) http.HandlerFunc { return c.adminGuard(allOwners(h)) })
+  }
+}
+
+type allOwnersCtxKey struct{}
+
+// allOwners marks a request as the admin's, who sees every client's
+// resources.
+func allOwners(h http.HandlerFunc) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    h(w, r.WithContext(context.WithValue(r.Context(), allOwnersCtxKey{}, true)))
+  }
+}
+
+// caller is whose resources r may see and change, "" for anonymous ones;
+// all is true when it may see everyone's.
+func (c *collection) caller(r *http.Request) (owner string, all bool) {
+  if c.owner == nil || r.Context().Value(allOwnersCtxKey{}) != nil {
+    return "", true
+  }
+
+  if cl, ok := clientFrom(r.Context()); ok {
+    return cl.Name, false
+  }
+
+  return "", false
+}
+
+func (c *collection) visible(r *http.Request, item resource) bool {
+  owner, all := c.caller(r)
+  return all || *c.owner(item) == owner
+}
+
+// handleList serves live resources; ?deleted=true lists the restorable ones.
+func (c *collection) handleList(w http.ResponseWriter, r *http.Request) {
+  items, err := c.list(r.URL.Query().Get("deleted") == "true")
+  if err != nil {
+    writeError(w, err, http.StatusInternalServerError)
+    return
+  }
+
+  mine := items[:0]
+  for _, item := range items {
+    if c.visible(r, item) {
+      mine = append(mine, shown(item))
+    }
+  }
+
+  writeJSON(w, http.StatusOK, mine)
+}
+
+func (c *collection) handleGet(w http.ResponseWriter, r *http.Request) {
+  item, ok := c.lookup(w, r)
+  if !ok {
+    return
+  }
+
+  w.Header().Set("ETag", item.meta().etag())
+
+  if item.meta().DeletedAt != nil {
+    writeJSON(w, http.StatusGone, map[string]interface{}{
+      "error":   errorDetail(c.name+" "+item.meta().ID+" is deleted", http.StatusGone),
+      "restore": "POST " + r.URL.Path + "/restore",
+      "item":    shown(item),
+    })
+    return
+  }
+
+  writeJSON(w, http.StatusOK, shown(item))
+}
+
+func (c *collection) handleCreate(w http.ResponseWriter, r *http.Request) {
+  item, ok := c.decode(w, r)
+  if !ok {
+    return
+  }
+
+  create := c.create
+  owner, all := c.caller(r)
+  if !all {
+    *c.owner(item) = owner
+    create = func(item resource) error { return c.createOwned(owner, item) }
+  }
+
+  if err := create(item); err != nil {
+    switch {
+    case errors.Is(err, errExists):
+      httpError(w, c.name+" "+c.idOf(item)+" already exists, update it instead", http.StatusConflict)
+    case errors.Is(err, errOwnerLimit):
+      httpError(w, fmt.Sprintf("%s already has %d %s, the most allowed; delete some first", owner, c.perOwner, c.name), http.StatusForbidden)
+    default:
+      writeError(w, err, http.StatusInternalServerError)
+    }
+
+    return
+  }
+
+  w.Header().Set("Location", r.URL.Path+"/"+item.meta().ID)
+  w.Header().Set("ETag", item.meta().etag())
+  writeJSON(w, http.StatusCreated, shown(item))
+}
+
+func (c *collection) handleUpdate(w http.ResponseWriter, r *http.Request) {
+  ifMatch := r.Header.Get("If-Match")
+  if ifMatch == "" {
+    httpError(w, "PUT needs If-Match with the ETag from GET "+r.URL.Path, http.StatusPreconditionRequired)
+    return
+  }
+
+  if _, ok := c.lookup(w, r); !ok {
+    return
+  }
+
+  item, ok := c.decode(w, r)
+  if !ok {
+    return
+  }
+
+  if owner, all := c.caller(r); !all {
+    *c.owner(item) = owner
+  }
+
+  c.respond(w, r)(c.update(r.PathValue("id"), ifMatch, item))
+}
+
+func (c *collection) handleDelete(w http.ResponseWriter, r *http.Request) {
+  c.handleSetDeleted(w, r, true)
+}
+
+func (c *collection) handleRestore(w http.ResponseWriter, r *http.Request) {
+  c.handleSetDeleted(w, r, false)
+}
+
+func (c *collection) handleSetDeleted(w http.ResponseWriter, r *http.Request, deleted bool) {
+  if _, ok := c.lookup(w, r); !ok {
+    return
+  }
+
+  c.respond(w, r)(c.setDeleted(r.PathValue("id"), r.Header.Get("If-Match"), deleted))
+}
+
+// respond writes the outcome of a change, mapping conflicts to their status.
+func (c *collection) respond(w http.ResponseWriter, r *http.Request) func(resource, error) {
+  return func(item resource, err error) {
+    name := c.name + " " + r.PathValue("id")
+    switch {
+    case errors.Is(err, errNotFound):
+      httpError(w, name+" not found", http.StatusNotFound)
+    case errors.Is(err, errVersionMismatch):
+      w.Header().Set("ETag", item.meta().etag())
+      writeJSON(w, http.StatusPreconditionFailed, map[string]interface{}{
+        "error":   errorDetail(name+" was modified concurrently, re-read it and retry", http.StatusPreconditionFailed),
+        "current": shown(item),
+      })
+    case errors.Is(err, errDeleted):
+      httpError(w, name+" "+err.Error(), http.StatusConflict)
+    case errors.Is(err, errRenamed):
+      httpError(w, name+" "+err.Error(), http.StatusUnprocessableEntity)
+    case err != nil:
+      writeError(w, err, http.StatusInternalServerError)
+    default:
+      w.Header().Set("ETag", item.meta().etag())
+      writeJSON(w, http.StatusOK, shown(item))
+    }
+  }
+}
+
+// lookup loads the resource r names, answering 404 for missing ones and,
+// so as not to tell what exists, for other clients'.
+func (c *collection) lookup(w http.ResponseWriter, r *http.Request) (resource, bool) {
+  item, err := c.get(r.PathValue("id"))
+  if err == nil && !c.visible(r, item) {
+    err = errNotFound
+  }
+
+  if errors.Is(err, errNotFound) {
+    httpError(w, c.name+" "+r.PathValue("id")+" not found", http.StatusNotFound)
+    return nil, false
+  }
+
+  if err != nil {
+    writeError(w, err, http.StatusInternalServerError)
+    return nil, false
+  }
+
+  return item, true
+}
+
+func (c *collection) decode(w http.ResponseWriter, r *http.Request) (resource, bool) {
+  item := c.newItem()
+  if err := json.NewDecoder(r.Body).Decode(item); err != nil {
+    httpError(w, "bad "+c.name+" body: "+err.Error(), bodyStatus(err))
+    return nil, false
+  }
+
+  if err := item.validate(); err != nil {
+    writeError(w, err, http.StatusUnprocessableEntity)
+    return nil, false
+  }
+
+  return item, true
+}
+
+func randomHex(n int) string {
+  b := make([]byte, n)
+  rand.Read(b)
+  return hex.EncodeToString(b)
+}
+
+// janitor purges soft-deleted resources once they are older than retention.
+func janitor(retention time.Duration, cs ...*collection) {
+  for range time.Tick(time.Hour) {
+    cutoff := time.Now().Add(-retention)
+    for _, c := range cs {
+      n, err := c.purge(cutoff)
+      if err != nil {
+        log.Printf("janitor: %s: %s", c.name, err)
+        continue
+      }
+
+      if n > 0 {
+        log.Printf("janitor: purged %d deleted %s", n, c.name)
+      }
+    }
+  }
+}
+
+// duration marshals as a Go duration string such as "15m".
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+  return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+  var s string
+  if err := json.Unmarshal(b, &s); err != nil {
+    return fmt.Errorf("duration must be a string like \"15m\": %w", err)
+  }
+
+  v, err := time.ParseDuration(s)
+  *d = duration(v)
+  return err
+}
@@ -0,0 +1,606 @@
+package server
+
+import (
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/cache"
+  "github.com/im-kulikov/weather-go-external-api/internal/condition"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// responseVersion is the version of the response schemas below, served in
+// every response and as the version of /openapi.json. Adding fields keeps
+// it; renaming, removing or retyping one bumps it.
+const responseVersion = 2
+
+// TemperatureResponse is the current temperature at one place: the answer
+// of /v1/weather and each entry of the batch, watchlist, group and bbox
+// endpoints, stream events and webhook readings. Failed lookups keep the
+// place, as far as it was resolved, and say why in error and status.
+type TemperatureResponse struct {
+  SchemaVersion  int                    `json:"schema_version" doc:"version of this schema"`
+  Query          string                 `json:"query,omitempty" doc:"the city as asked, in batches and watchlists"`
+  City           string                 `json:"city,omitempty" doc:"resolved place name"`
+  Region         string                 `json:"region,omitempty" doc:"state or province, when the geocoder says"`
+  Country        string                 `json:"country,omitempty" doc:"ISO 3166-1 alpha-2 code, when the geocoder says"`
+  Lat            *float64               `json:"lat,omitempty"`
+  Lon            *float64               `json:"lon,omitempty"`
+  TimeZone       string                 `json:"timezone,omitempty" doc:"IANA time zone of the place, such as Europe/Oslo"`
+  Temp           *float64               `json:"temp,omitempty" doc:"aggregate temperature in units"`
+  TempRounded    *int                   `json:"temp_rounded,omitempty" doc:"temp rounded to a whole degree"`
+  RawTemp        *float64               `json:"raw_temp,omitempty" doc:"the reading before smoothing, with smooth=true"`
+  Units          string                 `json:"units,omitempty" doc:"kelvin, celsius or fahrenheit"`
+  LocalTime      *time.Time             `json:"local_time,omitempty" doc:"the time at the place now, with its UTC offset"`
+  Timestamp      *time.Time             `json:"timestamp,omitempty" doc:"when the providers were asked"`
+  ObservedAt     *time.Time             `json:"observed_at,omitempty" doc:"the oldest observation averaged into temp, of those the providers date"`
+  DataAge        string                 `json:"data_age,omitempty" doc:"how long ago observed_at was"`
+  ProviderCount  int                    `json:"provider_count,omitempty" doc:"providers averaged into temp"`
+  Condition      *ConditionInfo         `json:"condition,omitempty" doc:"the condition most providers report; absent when none says"`
+  Humidity       *float64               `json:"humidity,omitempty" doc:"relative, %, with fields=humidity"`
+  WindSpeed      *float64               `json:"wind_speed,omitempty" doc:"m/s, with fields=wind"`
+  WindDir        *float64               `json:"wind_direction,omitempty" doc:"degrees, with fields=wind, as in ConditionMetrics"`
+  WindGust       *float64               `json:"wind_gust,omitempty" doc:"m/s, with fields=wind"`
+  FeelsLike      *float64               `json:"feels_like,omitempty" doc:"in units, with fields=feels_like"`
+  Cached         bool                   `json:"cached,omitempty" doc:"served from the cache"`
+  Stale          bool                   `json:"stale,omitempty" doc:"served from an expired cache entry"`
+  Degraded       bool                   `json:"degraded,omitempty" doc:"every provider failed: temp is an expired cache entry's or extrapolated from history, data_age old"`
+  StaleReason    string                 `json:"stale_reason,omitempty"`
+  Age            string                 `json:"age,omitempty" doc:"age of a stale or bbox reading"`
+  Cache          *CacheInfo             `json:"cache,omitempty" doc:"the cache entry a cached answer came from"`
+  From           string                 `json:"from,omitempty" doc:"bbox cells: the cached place the value was taken from"`
+  Source         string                 `json:"source,omitempty" doc:"offline-model in offline mode, history when extrapolated from it"`
+  QuotaExhausted []string               `json:"quota_exhausted,omitempty" doc:"providers left out because their call budget ran out"`
+  Providers      []ProviderReading      `json:"providers,omitempty" doc:"each provider's reading, with detail=true or explain=true"`
+  Explain        *explanation           `json:"explain,omitempty" doc:"how temp came about, with explain=true"`
+  Attribution    []upstream.Attribution `json:"attribution,omitempty" doc:"credit to show with the data"`
+  Candidates     []candidate            `json:"candidates,omitempty" doc:"places an ambiguous city could be"`
+  Error          string                 `json:"error,omitempty"`
+  Code           string                 `json:"code,omitempty" doc:"machine-readable error, as in ErrorDetail"`
+  Status         int                    `json:"status,omitempty" doc:"HTTP status of a failed lookup"`
+  Failures       []ProviderError        `json:"failures,omitempty" doc:"the providers that failed, when temp couldn't be produced"`
+  Outage         *Outage                `json:"outage,omitempty" doc:"set with failures while most providers fail the same way"`
+  Provenance     []ProvenanceSource     `json:"provenance,omitempty" doc:"the readings temp was averaged from, with provenance=true or signing on"`
+  Time           *time.Time             `json:"time,omitempty" doc:"stream events: when the event was sent"`
+  Took           string                 `json:"took,omitempty"`
+
+  sources []cache.Source // what Provenance is made of
+}
+
+// ProvenanceSource is one provider's reading as it came into an aggregate,
+// for auditing where a temperature came from.
+type ProvenanceSource struct {
+  Provider   string     `json:"provider"`
+  Kelvin     *float64   `json:"kelvin,omitempty" doc:"the value the provider reported, before weighting; absent when withheld"`
+  Withheld   string     `json:"withheld,omitempty" doc:"why the value isn't shown, as in ProviderReading"`
+  Weight     float64    `json:"weight,omitempty" doc:"its weight in the average"`
+  ObservedAt *time.Time `json:"observed_at,omitempty" doc:"when the provider says it was measured"`
+  FetchedAt  time.Time  `json:"fetched_at" doc:"when the service got it from the provider"`
+}
+
+// SigningKeyResponse is the public half of the -sign.key answers are
+// signed with.
+type SigningKeyResponse struct {
+  Algorithm string `json:"algorithm" doc:"ed25519"`
+  KeyID     string `json:"key_id" doc:"as in the X-Signature-Key header"`
+  PublicKey string `json:"public_key" doc:"the raw 32-byte key, base64"`
+  PEM       string `json:"pem" doc:"the same as a PKIX PEM block"`
+}
+
+// SearchResponse answers GET /v1/search: the places matching what was
+// typed so far, best first.
+type SearchResponse struct {
+  SchemaVersion int            `json:"schema_version" doc:"version of this schema"`
+  Query         string         `json:"query"`
+  Lang          string         `json:"lang,omitempty" doc:"language names were asked for in"`
+  Results       []SearchResult `json:"results" doc:"empty when nothing matches"`
+  Took          string         `json:"took"`
+}
+
+type SearchResult struct {
+  Name       string  `json:"name"`
+  Region     string  `json:"region,omitempty"`
+  Country    string  `json:"country,omitempty"`
+  Lat        float64 `json:"lat"`
+  Lon        float64 `json:"lon"`
+  Population int     `json:"population,omitempty" doc:"where the geocoder knows it"`
+  Href       string  `json:"href" doc:"the place's weather"`
+}
+
+// ForecastResponse is the temperature expected at a route waypoint at its
+// ETA.
+type ForecastResponse struct {
+  SchemaVersion int                    `json:"schema_version" doc:"version of this schema"`
+  Query         string                 `json:"query,omitempty" doc:"the waypoint's city as asked"`
+  ETA           time.Time              `json:"eta"`
+  City          string                 `json:"city,omitempty"`
+  Region        string                 `json:"region,omitempty"`
+  Country       string                 `json:"country,omitempty"`
+  Lat           *float64               `json:"lat,omitempty"`
+  Lon           *float64               `json:"lon,omitempty"`
+  TimeZone      string                 `json:"timezone,omitempty"`
+  Temp          *float64               `json:"temp,omitempty" doc:"forecast interpolated to eta, in units"`
+  TempRounded   *int                   `json:"temp_rounded,omitempty" doc:"temp rounded to a whole degree"`
+  Units         string                 `json:"units,omitempty" doc:"kelvin, celsius or fahrenheit"`
+  Timestamp     *time.Time             `json:"timestamp,omitempty" doc:"when the forecasts were fetched"`
+  ProviderCount int                    `json:"provider_count,omitempty" doc:"forecasts averaged into temp"`
+  IcyRoadRisk   *bool                  `json:"icy_road_risk,omitempty" doc:"whether roads may be icy at eta, by temp and precipitation"`
+  IcyRoadReason string                 `json:"icy_road_reason,omitempty"`
+  Providers     []ProviderReading      `json:"providers,omitempty" doc:"each provider's forecast, with detail=true"`
+  Attribution   []upstream.Attribution `json:"attribution,omitempty"`
+  Candidates    []candidate            `json:"candidates,omitempty"`
+  Error         string                 `json:"error,omitempty"`
+  Status        int                    `json:"status,omitempty"`
+  RoadWeather   `doc:"the forecasts' mean for the hour nearest eta"`
+}
+
+// ProviderReading is one provider's answer, as shown to clients.
+type ProviderReading struct {
+  Provider  string         `json:"provider"`
+  Temp      *float64       `json:"temp,omitempty" doc:"in the response's units; absent when failed or withheld"`
+  Error     string         `json:"error,omitempty"`
+  Withheld  string         `json:"withheld,omitempty" doc:"why an output policy hides the value"`
+  Excluded  string         `json:"excluded,omitempty" doc:"why it was left out of the average"`
+  Carried   bool           `json:"carried,omitempty" doc:"last reading reused by adaptive sampling"`
+  Cached    bool           `json:"cached,omitempty" doc:"reused from the provider cache, within its TTL"`
+  Weight    float64        `json:"weight,omitempty" doc:"effective weight in the average"`
+  Condition condition.Code `json:"condition,omitempty" doc:"absent when failed, withheld or the provider doesn't say"`
+  Observed  *time.Time     `json:"observed_at,omitempty" doc:"when the provider observed it, or the model step it is of"`
+  Took      string         `json:"took"`
+}
+
+// ConditionInfo is a normalized condition and the icon to show for it.
+type ConditionInfo struct {
+  Code condition.Code `json:"code"`
+  Icon string         `json:"icon" doc:"path of the icon, a night one after dark"`
+}
+
+// CacheInfo describes the cache entry behind a cached answer.
+type CacheInfo struct {
+  StoredAt  time.Time `json:"stored_at"`
+  ExpiresAt time.Time `json:"expires_at" doc:"when it stops being fresh; stale answers are past it"`
+}
+
+func newTemperatureResponse() *TemperatureResponse {
+  return &TemperatureResponse{SchemaVersion: responseVersion}
+}
+
+// failedLookup is a lookup that didn't get as far as the providers.
+func failedLookup(status int, err error) *TemperatureResponse {
+  return newTemperatureResponse().fail(status, err)
+}
+
+func (r *TemperatureResponse) fail(status int, err error) *TemperatureResponse {
+  r.Error, r.Code, r.Status = err.Error(), errorCode(err, status), status
+  return r
+}
+
+// at sets the place a response is about.
+func (r *TemperatureResponse) at(loc geo.Location) {
+  r.City, r.Region, r.Country, r.Lat, r.Lon, r.TimeZone = loc.Name, loc.Region, loc.Country, &loc.Lat, &loc.Lon, loc.TimeZone
+}
+
+func (r *ForecastResponse) at(loc geo.Location) {
+  r.City, r.Region, r.Country, r.Lat, r.Lon, r.TimeZone = loc.Name, loc.Region, loc.Country, &loc.Lat, &loc.Lon, loc.TimeZone
+}
+
+// loadedZones are time zones by name; time/tzdata, imported in main.go,
+// backs them on hosts without a zoneinfo database.
+var loadedZones sync.Map
+
+// localTime is t at loc, nil when the place's time zone isn't known.
+func localTime(loc geo.Location, t time.Time) *time.Time {
+  if loc.TimeZone == "" {
+    return nil
+  }
+
+  tz, err := loadZone(loc.TimeZone)
+  if err != nil {
+    return nil
+  }
+
+  local := t.In(tz).Truncate(time.Second)
+  return &local
+}
+
+func loadZone(name string) (*time.Location, error) {
+  z, ok := loadedZones.Load(name)
+  if !ok {
+    tz, err := time.LoadLocation(name)
+    if err != nil {
+      return nil, err
+    }
+
+    z, _ = loadedZones.LoadOrStore(name, tz)
+  }
+
+  return z.(*time.Location), nil
+}
+
+// providerReadings shows readings as policies left them.
+func providerReadings(rs []providers.Reading) []ProviderReading {
+  out := make([]ProviderReading, len(rs))
+  for i, r := range rs {
+    out[i] = ProviderReading{Provider: r.Provider, Error: r.Error, Withheld: r.Withheld, Excluded: r.Excluded, Carried: r.Carried, Cached: r.Cached, Weight: r.Weight, Took: r.Took.String()}
+    if r.Error == "" && r.Withheld == "" {
+      k := r.Kelvin
+      out[i].Temp, out[i].Condition = &k, r.Condition
+    }
+
+    if !r.Observed.IsZero() && r.Error == "" {
+      t := r.Observed.UTC()
+      out[i].Observed = &t
+    }
+  }
+
+  return out
+}
+
+// observedAt is the oldest observation of the readings averaged, so the
+// aggregate is at least as fresh as it says; zero when none is dated.
+func observedAt(rs []providers.Reading) time.Time {
+  var oldest time.Time
+  for _, r := range rs {
+    if r.Error == "" && r.Excluded == "" && !r.Observed.IsZero() && (oldest.IsZero() || r.Observed.Before(oldest)) {
+      oldest = r.Observed
+    }
+  }
+
+  return oldest
+}
+
+// sourcesOf is the readings that went into an average, for provenance.
+func sourcesOf(rs []providers.Reading) []cache.Source {
+  var sources []cache.Source
+  for _, r := range rs {
+    if r.Error == "" && r.Excluded == "" {
+      sources = append(sources, cache.Source{Provider: r.Provider, Kelvin: r.Kelvin, Weight: r.Weight, Observed: r.Observed, Fetched: r.Fetched})
+    }
+  }
+
+  return sources
+}
+
+// averaged counts the readings that went into an average.
+func averaged(rs []providers.Reading) int {
+  n := 0
+  for _, r := range rs {
+    if r.Error == "" && r.Excluded == "" {
+      n++
+    }
+  }
+
+  return n
+}
+
+func kelvinPtr(k float64) *float64 { return &k }
+
+// BatchResponse answers POST /v1/weather/batch, one result per city in
+// request order.
+type BatchResponse struct {
+  SchemaVersion int                    `json:"schema_version" doc:"version of this schema"`
+  Results       []*TemperatureResponse `json:"results"`
+  Took          string                 `json:"took"`
+}
+
+// GroupResponse answers GET /v1/groups/{id}/weather: a batch of the
+// group's cities and a summary across them.
+type GroupResponse struct {
+  SchemaVersion int                    `json:"schema_version" doc:"version of this schema"`
+  Group         string                 `json:"group"`
+  Summary       groupSummary           `json:"summary"`
+  Results       []*TemperatureResponse `json:"results"`
+  Took          string                 `json:"took"`
+}
+
+// WatchlistResponse answers GET /v1/watchlists/{id}/weather.
+type WatchlistResponse struct {
+  SchemaVersion int                    `json:"schema_version" doc:"version of this schema"`
+  Watchlist     string                 `json:"watchlist"`
+  Results       []*TemperatureResponse `json:"results"`
+  Took          string                 `json:"took"`
+}
+
+// RouteResponse answers POST /v1/route-weather, one forecast per waypoint
+// in request order.
+type RouteResponse struct {
+  SchemaVersion int                 `json:"schema_version" doc:"version of this schema"`
+  Waypoints     []*ForecastResponse `json:"waypoints"`
+  Took          string              `json:"took"`
+}
+
+// AstroResponse answers GET /v1/astro/{city}. Times are UTC.
+type AstroResponse struct {
+  SchemaVersion int                    `json:"schema_version" doc:"version of this schema"`
+  City          string                 `json:"city"`
+  Region        string                 `json:"region,omitempty"`
+  Country       string                 `json:"country,omitempty"`
+  Lat           float64                `json:"lat"`
+  Lon           float64                `json:"lon"`
+  TimeZone      string                 `json:"timezone,omitempty"`
+  Date          string                 `json:"date" doc:"the UTC day, YYYY-MM-DD"`
+  Sunrise       *time.Time             `json:"sunrise,omitempty" doc:"absent in polar day and night"`
+  Sunset        *time.Time             `json:"sunset,omitempty"`
+  SolarNoon     time.Time              `json:"solar_noon"`
+  DayLength     string                 `json:"day_length"`
+  Polar         string                 `json:"polar,omitempty" doc:"day when the sun doesn't set, night when it doesn't rise"`
+  Moon          MoonInfo               `json:"moon"`
+  Attribution   []upstream.Attribution `json:"attribution,omitempty"`
+}
+
+// MoonInfo is the phase of the moon at solar noon.
+type MoonInfo struct {
+  Phase        string  `json:"phase" doc:"new moon, waxing crescent, first quarter, waxing gibbous, full moon, waning gibbous, last quarter or waning crescent"`
+  Age          float64 `json:"age" doc:"days since the last new moon"`
+  Illumination float64 `json:"illumination" doc:"lit fraction of the disc, 0 to 1"`
+}
+
+// NowcastResponse answers GET /v1/nowcast/{city}: precipitation minute by
+// minute for the next hour, the wettest provider's outlook at each.
+type NowcastResponse struct {
+  SchemaVersion int                    `json:"schema_version" doc:"version of this schema"`
+  City          string                 `json:"city"`
+  Region        string                 `json:"region,omitempty"`
+  Country       string                 `json:"country,omitempty"`
+  Lat           float64                `json:"lat"`
+  Lon           float64                `json:"lon"`
+  TimeZone      string                 `json:"timezone,omitempty"`
+  Minutes       []NowcastMinute        `json:"minutes"`
+  Summary       NowcastSummary         `json:"summary"`
+  Providers     []NowcastSource        `json:"providers"`
+  Attribution   []upstream.Attribution `json:"attribution,omitempty"`
+  Took          string                 `json:"took"`
+}
+
+// NowcastMinute is the outlook for one minute.
+type NowcastMinute struct {
+  Time        time.Time `json:"time"`
+  Probability float64   `json:"probability" doc:"highest chance of precipitation any provider gives, 0 to 1"`
+  Rate        float64   `json:"rate" doc:"highest precipitation rate any provider expects, mm/h"`
+  Rain        bool      `json:"rain" doc:"any provider expects precipitation"`
+}
+
+// NowcastSummary is the hour at a glance.
+type NowcastSummary struct {
+  RainExpected   bool       `json:"rain_expected"`
+  StartsAt       *time.Time `json:"starts_at,omitempty" doc:"first minute with precipitation"`
+  EndsAt         *time.Time `json:"ends_at,omitempty" doc:"first dry minute after it; absent when it lasts the hour"`
+  MaxRate        float64    `json:"max_rate" doc:"mm/h"`
+  MaxProbability float64    `json:"max_probability"`
+}
+
+// NowcastSource is one provider's part in a nowcast.
+type NowcastSource struct {
+  Provider string `json:"provider"`
+  Points   int    `json:"points"`
+  Omitted  string `json:"omitted,omitempty" doc:"why the provider has no nowcast here"`
+  Error    string `json:"error,omitempty"`
+  Took     string `json:"took,omitempty"`
+}
+
+// UVResponse answers GET /v1/uv/{city}: the UV index now, the mean of the
+// providers that have one.
+type UVResponse struct {
+  SchemaVersion int                    `json:"schema_version" doc:"version of this schema"`
+  City          string                 `json:"city"`
+  Region        string                 `json:"region,omitempty"`
+  Country       string                 `json:"country,omitempty"`
+  Lat           float64                `json:"lat"`
+  Lon           float64                `json:"lon"`
+  TimeZone      string                 `json:"timezone,omitempty"`
+  UVIndex       float64                `json:"uv_index"`
+  Risk          string                 `json:"risk" doc:"the WHO's low, moderate, high, very high or extreme"`
+  ProviderCount int                    `json:"provider_count"`
+  Providers     []UVSource             `json:"providers"`
+  Attribution   []upstream.Attribution `json:"attribution,omitempty"`
+  Took          string                 `json:"took"`
+}
+
+// UVSource is one provider's part in a UV index.
+type UVSource struct {
+  Provider string   `json:"provider"`
+  UVIndex  *float64 `json:"uv_index,omitempty"`
+  Omitted  string   `json:"omitted,omitempty" doc:"why the provider has no UV index here"`
+  Error    string   `json:"error,omitempty"`
+  Took     string   `json:"took,omitempty"`
+}
+
+// PollenResponse answers GET /v1/pollen/{city}: pollen levels now by kind
+// of plant, the highest any provider gives.
+type PollenResponse struct {
+  SchemaVersion int                    `json:"schema_version" doc:"version of this schema"`
+  City          string                 `json:"city"`
+  Region        string                 `json:"region,omitempty"`
+  Country       string                 `json:"country,omitempty"`
+  Lat           float64                `json:"lat"`
+  Lon           float64                `json:"lon"`
+  TimeZone      string                 `json:"timezone,omitempty"`
+  Tree          *PollenLevel           `json:"tree,omitempty"`
+  Grass         *PollenLevel           `json:"grass,omitempty"`
+  Weed          *PollenLevel           `json:"weed,omitempty"`
+  ProviderCount int                    `json:"provider_count"`
+  Providers     []PollenSource         `json:"providers"`
+  Attribution   []upstream.Attribution `json:"attribution,omitempty"`
+  Took          string                 `json:"took"`
+}
+
+// PollenLevel is one kind of plant's pollen.
+type PollenLevel struct {
+  Level    int    `json:"level" doc:"0 none to 4 very high"`
+  Category string `json:"category" doc:"none, low, moderate, high or very high"`
+}
+
+// PollenSource is one provider's part in pollen levels.
+type PollenSource struct {
+  Provider string `json:"provider"`
+  Tree     *int   `json:"tree,omitempty"`
+  Grass    *int   `json:"grass,omitempty"`
+  Weed     *int   `json:"weed,omitempty"`
+  Omitted  string `json:"omitted,omitempty" doc:"why the provider has no pollen data here"`
+  Error    string `json:"error,omitempty"`
+  Took     string `json:"took,omitempty"`
+}
+
+// MarineResponse answers GET /v1/marine/{location}: the sea now, the mean
+// of the providers that cover the point.
+type MarineResponse struct {
+  SchemaVersion  int                    `json:"schema_version" doc:"version of this schema"`
+  City           string                 `json:"city"`
+  Region         string                 `json:"region,omitempty"`
+  Country        string                 `json:"country,omitempty"`
+  Lat            float64                `json:"lat"`
+  Lon            float64                `json:"lon"`
+  TimeZone       string                 `json:"timezone,omitempty"`
+  Units          string                 `json:"units" doc:"of sea_temp"`
+  WaveHeight     *float64               `json:"wave_height,omitempty" doc:"significant height of wind waves and swell together, m"`
+  WavePeriod     *float64               `json:"wave_period,omitempty" doc:"s"`
+  WaveDirection  *float64               `json:"wave_direction,omitempty" doc:"degrees clockwise from north the waves come from"`
+  SwellHeight    *float64               `json:"swell_height,omitempty" doc:"m"`
+  SwellPeriod    *float64               `json:"swell_period,omitempty" doc:"s"`
+  SwellDirection *float64               `json:"swell_direction,omitempty" doc:"degrees clockwise from north the swell comes from"`
+  SeaTemp        *float64               `json:"sea_temp,omitempty" doc:"at the surface"`
+  ProviderCount  int                    `json:"provider_count"`
+  Providers      []MarineSource         `json:"providers"`
+  Attribution    []upstream.Attribution `json:"attribution,omitempty"`
+  Took           string                 `json:"took"`
+}
+
+// MarineSource is one provider's part in sea conditions.
+type MarineSource struct {
+  Provider    string   `json:"provider"`
+  WaveHeight  *float64 `json:"wave_height,omitempty"`
+  SwellHeight *float64 `json:"swell_height,omitempty"`
+  SeaTemp     *float64 `json:"sea_temp,omitempty"`
+  Omitted     string   `json:"omitted,omitempty" doc:"why the provider has no marine data here"`
+  Error       string   `json:"error,omitempty"`
+  Took        string   `json:"took,omitempty"`
+}
+
+// AgroResponse answers GET /v1/agro/{location}: the soil now, by depth.
+type AgroResponse struct {
+  SchemaVersion      int                    `json:"schema_version" doc:"version of this schema"`
+  City               string                 `json:"city"`
+  Region             string                 `json:"region,omitempty"`
+  Country            string                 `json:"country,omitempty"`
+  Lat                float64                `json:"lat"`
+  Lon                float64                `json:"lon"`
+  TimeZone           string                 `json:"timezone,omitempty"`
+  Units              string                 `json:"units" doc:"of soil_temp"`
+  SoilTemp           []SoilLevel            `json:"soil_temp" doc:"shallowest first"`
+  SoilMoisture       []SoilLevel            `json:"soil_moisture" doc:"shallowest first, % of the soil's volume that is water"`
+  Evapotranspiration *float64               `json:"evapotranspiration,omitempty" doc:"FAO-56 reference (ET0) over the local day, mm"`
+  ProviderCount      int                    `json:"provider_count"`
+  Providers          []AgroSource           `json:"providers"`
+  Attribution        []upstream.Attribution `json:"attribution,omitempty"`
+  Took               string                 `json:"took"`
+}
+
+// SoilLevel is a value at one depth.
+type SoilLevel struct {
+  Depth         string   `json:"depth_cm" doc:"below the surface: a point such as 6 or a layer such as 9-27"`
+  Value         *float64 `json:"value"`
+  ProviderCount int      `json:"provider_count"`
+}
+
+// AgroSource is one provider's part in soil conditions.
+type AgroSource struct {
+  Provider           string   `json:"provider"`
+  Depths             int      `json:"depths,omitempty" doc:"temperatures and moistures it gave"`
+  Evapotranspiration *float64 `json:"evapotranspiration,omitempty"`
+  Omitted            string   `json:"omitted,omitempty" doc:"why the provider has no soil data here"`
+  Error              string   `json:"error,omitempty"`
+  Took               string   `json:"took,omitempty"`
+}
+
+// TrendResponse answers GET /v1/trend/{city}: how the temperature has
+// moved, from the stored history.
+type TrendResponse struct {
+  SchemaVersion int           `json:"schema_version" doc:"version of this schema"`
+  City          string        `json:"city"`
+  Region        string        `json:"region,omitempty"`
+  Country       string        `json:"country,omitempty"`
+  Lat           float64       `json:"lat"`
+  Lon           float64       `json:"lon"`
+  TimeZone      string        `json:"timezone,omitempty"`
+  Temp          *float64      `json:"temp" doc:"the latest stored reading, in units"`
+  Units         string        `json:"units"`
+  Time          time.Time     `json:"time" doc:"when the latest reading was taken"`
+  Trend         string        `json:"trend,omitempty" doc:"rising, falling or steady over the last 3 hours"`
+  Changes       []TrendChange `json:"changes" doc:"over the last 1, 3, 6 and 24 hours"`
+  Took          string        `json:"took"`
+}
+
+// TrendChange is the change over one span.
+type TrendChange struct {
+  Window string     `json:"window" doc:"1h, 3h, 6h or 24h"`
+  Change *float64   `json:"change,omitempty" doc:"from the reading at the start of the span to the latest, in units; absent without one"`
+  From   *time.Time `json:"from,omitempty" doc:"when the reading at the start of the span was taken"`
+  Trend  string     `json:"trend,omitempty" doc:"rising, falling or steady, under 0.2 K an hour"`
+}
+
+// ConditionsResponse answers GET /v1/conditions/{city}: the weather now in
+// words, in the client's language.
+type ConditionsResponse struct {
+  SchemaVersion int                    `json:"schema_version" doc:"version of this schema"`
+  City          string                 `json:"city"`
+  Region        string                 `json:"region,omitempty"`
+  Country       string                 `json:"country,omitempty"`
+  Lat           float64                `json:"lat"`
+  Lon           float64                `json:"lon"`
+  TimeZone      string                 `json:"timezone,omitempty"`
+  Code          condition.Code         `json:"code" doc:"normalized condition most providers report"`
+  Text          string                 `json:"text" doc:"the condition in words"`
+  Lang          string                 `json:"lang" doc:"language of text, also sent as Content-Language"`
+  TextFrom      string                 `json:"text_from" doc:"provider whose words text is, or local for the built-in translations"`
+  Icon          string                 `json:"icon" doc:"path of the icon, a night one after dark"`
+  Units         string                 `json:"units" doc:"of the temperatures in metrics and providers"`
+  Metrics       *ConditionMetrics      `json:"metrics,omitempty" doc:"absent when no provider measured the temperature"`
+  Providers     []ConditionSource      `json:"providers"`
+  Attribution   []upstream.Attribution `json:"attribution,omitempty"`
+  Took          string                 `json:"took"`
+}
+
+// ConditionMetrics is the providers' mean temperature, humidity and wind,
+// and how the air feels by them.
+type ConditionMetrics struct {
+  Temp      *float64 `json:"temp"`
+  Humidity  *float64 `json:"humidity,omitempty" doc:"relative, %"`
+  WindSpeed *float64 `json:"wind_speed,omitempty" doc:"m/s"`
+  WindDir   *float64 `json:"wind_direction,omitempty" doc:"degrees clockwise from north the wind blows from, averaged as vectors; absent in calm air or when the providers disagree"`
+  WindGust  *float64 `json:"wind_gust,omitempty" doc:"m/s, the providers' mean gust, at least wind_speed"`
+  DewPoint  *float64 `json:"dew_point,omitempty" doc:"needs the humidity"`
+  FeelsLike *float64 `json:"feels_like" doc:"the wind chill or heat index where either applies, the temperature in between"`
+  HeatIndex *float64 `json:"heat_index,omitempty" doc:"from 80 °F (26.7 °C), with the humidity"`
+  WindChill *float64 `json:"wind_chill,omitempty" doc:"at 10 °C (50 °F) and below, in wind over 4.8 km/h"`
+
+  IcyRoadRisk   bool   `json:"icy_road_risk" doc:"whether roads may be icy, by the temperature and precipitation"`
+  IcyRoadReason string `json:"icy_road_reason,omitempty" doc:"why, such as wet roads near freezing"`
+  RoadWeather
+}
+
+// RoadWeather is what falls and lies on the ground, for providers that say
+// (Open-Meteo, MET Norway); all of it is absent from the others.
+type RoadWeather struct {
+  Precipitation *float64 `json:"precipitation,omitempty" doc:"mm, snow melted, over the provider's current step: Open-Meteo's last 15 minutes, MET Norway's next hour, a forecast's hour"`
+  Snowfall      *float64 `json:"snowfall,omitempty" doc:"cm over the same step; MET Norway's is its precipitation when it forecasts snow"`
+  SnowDepth     *float64 `json:"snow_depth,omitempty" doc:"m on the ground, Open-Meteo only"`
+  FreezingLevel *float64 `json:"freezing_level,omitempty" doc:"m above sea level where the air is at 0 °C, Open-Meteo only"`
+}
+
+// ConditionSource is one provider's description.
+type ConditionSource struct {
+  Provider  string         `json:"provider"`
+  Code      condition.Code `json:"code,omitempty"`
+  Text      string         `json:"text,omitempty" doc:"the provider's own words, for those that have them"`
+  Lang      string         `json:"lang,omitempty"`
+  Temp      *float64       `json:"temp,omitempty"`
+  Humidity  *float64       `json:"humidity,omitempty" doc:"relative, %"`
+  WindSpeed *float64       `json:"wind_speed,omitempty" doc:"m/s"`
+  WindDir   *float64       `json:"wind_direction,omitempty" doc:"degrees clockwise from north the wind blows from"`
+  WindGust  *float64       `json:"wind_gust,omitempty" doc:"m/s"`
+  Error     string         `json:"error,omitempty"`
+  Took      string         `json:"took"`
+  RoadWeather
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "math"
+  "net/http"
+  "sort"
+  "strconv"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/aggregate"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// waypoint is one stop of a route: a city or coordinates, and when the
+// traveller expects to be there.
+type waypoint struct {
+  City string    `json:"city,omitempty"`
+  Lat  *float64  `json:"lat,omitempty"`
+  Lon  *float64  `json:"lon,omitempty"`
+  ETA  time.Time `json:"eta"`
+}
+
+// ETAs may be this much in the past, for a waypoint being passed right now.
+const routeGrace = time.Hour
+
+var errNoForecasters = errors.New("no enabled provider publishes forecasts")
+
+// routeWeather answers POST /route-weather with the temperature expected
+// at each waypoint at its ETA: every forecasting provider's hourly
+// forecast, interpolated to the ETA, and their weighted average. Waypoints
+// report their own errors, like batch entries.
+func (s *server) routeWeather(w http.ResponseWriter, r *http.Request) {
+  begin := time.Now()
+  ctx := upstream.WithTrace(r)
+
+  format, ok := responseFormat(w, r, "geojson")
+  if !ok {
+    return
+  }
+
+  d, ok := s.requestDisplay(w, r)
+  if !ok {
+    return
+  }
+
+  var req struct {
+    Waypoints []waypoint `json:"waypoints"`
+  }
+
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    httpError(w, `want {"waypoints": [{"city": "...", "eta": "<RFC 3339>"}, ...]}: `+err.Error(), bodyStatus(err))
+    return
+  }
+
+  if len(req.Waypoints) == 0 || len(req.Waypoints) > s.batchMax {
+    httpError(w, fmt.Sprintf("want 1 to %d waypoints, got %d", s.batchMax, len(req.Waypoints)), http.StatusBadRequest)
+    return
+  }
+
+  results := make([]*ForecastResponse, len(req.Waypoints))
+  sem := make(chan struct{}, s.batchConcurrency)
+
+  var wg sync.WaitGroup
+  for i, wp := range req.Waypoints {
+    wg.Add(1)
+    sem <- struct{}{}
+
+    go func() {
+      defer func() { <-sem; wg.Done() }()
+      results[i] = s.waypointWeather(ctx, wp, detailOf(r))
+    }()
+  }
+
+  wg.Wait()
+  for _, res := range results {
+    res.show(d)
+  }
+
+  took := time.Since(begin).String()
+
+  if format == "geojson" {
+    writeGeoJSON(w, http.StatusOK, featureCollection(results, map[string]interface{}{"took": took}))
+    return
+  }
+
+  writeJSON(w, http.StatusOK, RouteResponse{SchemaVersion: responseVersion, Waypoints: results, Took: took})
+}
+
+func (s *server) waypointWeather(ctx context.Context, wp waypoint, detail detailLevel) *ForecastResponse {
+  res := &ForecastResponse{SchemaVersion: responseVersion, Query: wp.City, ETA: wp.ETA}
+  fail := func(status int, err error) *ForecastResponse {
+    res.Error, res.Status = err.Error(), status
+    return res
+  }
+
+  now := time.Now()
+  switch {
+  case wp.ETA.IsZero():
+    return fail(http.StatusBadRequest, errors.New("eta is required"))
+  case wp.ETA.Before(now.Add(-routeGrace)):
+    return fail(http.StatusBadRequest, errors.New("eta is in the past"))
+  case wp.ETA.After(now.Add(s.routeHorizon)):
+    return fail(http.StatusBadRequest, fmt.Errorf("eta is more than %s ahead", s.routeHorizon))
+  }
+
+  loc, err := s.waypointLocation(ctx, wp)
+  var amb *geo.AmbiguousError
+  if errors.As(err, &amb) {
+    res.Candidates = candidates(amb)
+    return fail(http.StatusMultipleChoices, err)
+  }
+
+  if err != nil {
+    return fail(locationStatus(err), err)
+  }
+
+  res.at(s.zones.Locate(ctx, loc))
+
+  active := s.activeFor(ctx, loc)
+  at := time.Now().UTC()
+  rs, steps := s.forecastAt(ctx, loc, active, wp.ETA)
+  if detail > summary {
+    res.Providers, res.Units = providerReadings(s.policies.readings(rs)), "kelvin"
+  }
+
+  if status, err := s.averageForecast(res, loc, active, rs, steps, at); err != nil {
+    return fail(status, err)
+  }
+
+  return res
+}
+
+// averageForecast sets res to the weighted average of the forecasts in rs
+// and the road weather of their steps, or says why there is none: the
+// providers that answered are averaged.
+func (s *server) averageForecast(res *ForecastResponse, loc geo.Location, active providers.Multi, rs []providers.Reading, steps map[string]providers.ForecastPoint, at time.Time) (int, error) {
+  var ok []providers.Reading
+  var road []RoadWeather
+  for _, r := range rs {
+    if r.Error == "" {
+      p := steps[r.Provider]
+      ok = append(ok, r)
+      road = append(road, RoadWeather{Precipitation: p.Precipitation, Snowfall: p.Snowfall, SnowDepth: p.SnowDepth, FreezingLevel: p.FreezingLevel})
+    }
+  }
+
+  if len(ok) == 0 {
+    err := errNoForecasters
+    if len(rs) > 0 {
+      err = fmt.Errorf("%s: %s", rs[0].Provider, rs[0].Error)
+    }
+
+    return http.StatusBadGateway, err
+  }
+
+  s.weights.AssignIn(s.learner.region(loc), ok)
+  kelvin, err := aggregate.Average(ok)
+  if err != nil {
+    return http.StatusInternalServerError, err
+  }
+
+  res.Temp, res.Units = kelvinPtr(s.policies.aggregate(kelvin, active)), "kelvin"
+  res.Timestamp, res.ProviderCount = &at, len(ok)
+  res.RoadWeather = meanRoadWeather(road)
+  res.IcyRoadReason = icyRoads(kelvin, res.RoadWeather)
+  icy := res.IcyRoadReason != ""
+  res.IcyRoadRisk = &icy
+  res.Attribution = providers.Attributions(active)
+  return 0, nil
+}
+
+func (s *server) waypointLocation(ctx context.Context, wp waypoint) (geo.Location, error) {
+  if wp.Lat != nil || wp.Lon != nil {
+    if wp.Lat == nil || wp.Lon == nil {
+      return geo.Location{}, fmt.Errorf("%w: want both lat and lon", geo.ErrBadCoordinates)
+    }
+
+    return geo.Coordinates(strconv.FormatFloat(*wp.Lat, 'f', -1, 64), strconv.FormatFloat(*wp.Lon, 'f', -1, 64))
+  }
+
+  if wp.City == "" {
+    return geo.Location{}, errNoLocation
+  }
+
+  return geo.Resolve(ctx, s.geo, geo.ParseCity(wp.City))
+}
+
+// forecastAt asks every forecasting provider in active about loc, each
+// reading being its forecast interpolated to eta; along with each one's
+// step nearest eta, for what can't be interpolated.
+func (s *server) forecastAt(ctx context.Context, loc geo.Location, active providers.Multi, eta time.Time) ([]providers.Reading, map[string]providers.ForecastPoint) {
+  rs, steps := s.forecastsAt(ctx, loc, active, []time.Time{eta})
+  return rs[0], steps[0]
+}
+
+// forecastsAt is forecastAt for each of etas, ascending, asking each
+// provider once for all of them.
+func (s *server) forecastsAt(ctx context.Context, loc geo.Location, active providers.Multi, etas []time.Time) ([][]providers.Reading, []map[string]providers.ForecastPoint) {
+  hours := max(int(math.Ceil(time.Until(etas[len(etas)-1]).Hours()))+2, 2)
+
+  rs := make([][]providers.Reading, len(etas))
+  steps := make([]map[string]providers.ForecastPoint, len(etas))
+  for i := range etas {
+    steps[i] = make(map[string]providers.ForecastPoint)
+  }
+
+  var mu sync.Mutex
+  var wg sync.WaitGroup
+  for _, p := range active {
+    f, ok := p.(providers.Forecaster)
+    if !ok {
+      continue
+    }
+
+    s.quotasFor(ctx).spend(p.Name())
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+
+      begin := time.Now()
+      ps, err := f.Forecast(ctx, loc, hours)
+      took := time.Since(begin)
+
+      mu.Lock()
+      defer mu.Unlock()
+      for i, eta := range etas {
+        r := providers.Reading{Provider: p.Name(), Took: took}
+        err := err
+        if err == nil {
+          r.Kelvin, err = interpolate(ps, eta)
+        }
+
+        if err != nil {
+          r.Error, r.Err = err.Error(), err
+        } else {
+          steps[i][p.Name()] = nearest(ps, eta)
+        }
+
+        rs[i] = append(rs[i], r)
+      }
+    }()
+  }
+
+  wg.Wait()
+
+  // Each provider was called once, as the first eta's reading shows.
+  s.healthFor(ctx).record(rs[0])
+  for _, r := range rs {
+    sort.Slice(r, func(i, j int) bool { return r[i].Provider < r[j].Provider })
+  }
+
+  return rs, steps
+}
+
+// nearest is the point of ps closest to t; ps isn't empty.
+func nearest(ps []providers.ForecastPoint, t time.Time) providers.ForecastPoint {
+  best := ps[0]
+  for _, p := range ps[1:] {
+    if p.Valid.Sub(t).Abs() < best.Valid.Sub(t).Abs() {
+      best = p
+    }
+  }
+
+  return best
+}
+
+// interpolate is the forecast at t, linear between the hourly points
+// around it. A t before the first point, such as a waypoint being passed
+// now, gets the first point.
+func interpolate(ps []providers.ForecastPoint, t time.Time) (float64, error) {
+  if len(ps) == 0 {
+    return 0, errors.New("empty forecast")
+  }
+
+  sort.Slice(ps, func(i, j int) bool { return ps[i].Valid.Before(ps[j].Valid) })
+  if !t.After(ps[0].Valid) {
+    return ps[0].Kelvin, nil
+  }
+
+  for i := 1; i < len(ps); i++ {
+    if t.After(ps[i].Valid) {
+      continue
+    }
+
+    a, b := ps[i-1], ps[i]
+    frac := float64(t.Sub(a.Valid)) / float64(b.Valid.Sub(a.Valid))
+    return a.Kelvin + frac*(b.Kelvin-a.Kelvin), nil
+  }
+
+  return 0, fmt.Errorf("forecast ends at %s", ps[len(ps)-1].Valid.Format(time.RFC3339))
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "regexp"
+  "strings"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+var errNotRouted = errors.New("the routing rules leave no enabled provider for this country")
+
+// routeConfig is one of the config file's "routing" rules: places in its
+// countries are asked only its providers, or every provider but those it
+// excludes. A country is in one rule at most; places no rule lists, and
+// coordinates, whose country isn't known, are asked every provider.
+type routeConfig struct {
+  Countries []string `json:"countries"`
+  Providers []string `json:"providers,omitempty"`
+  Exclude   []string `json:"exclude,omitempty"`
+}
+
+// providerRoute is a compiled routeConfig.
+type providerRoute struct {
+  countries map[string]bool
+  only      map[string]bool // nil when the rule excludes instead
+  exclude   map[string]bool
+}
+
+func (rc routeConfig) compile() (providerRoute, []string) {
+  var errs []string
+  if len(rc.Countries) == 0 {
+    errs = append(errs, "countries: needs at least one country")
+  }
+
+  r := providerRoute{countries: make(map[string]bool), exclude: make(map[string]bool)}
+  for i, cc := range rc.Countries {
+    cc = strings.ToUpper(strings.TrimSpace(cc))
+    if !countryCode.MatchString(cc) {
+      errs = append(errs, fmt.Sprintf("countries[%d]: want an ISO 3166-1 alpha-2 code such as NO, got %q", i, rc.Countries[i]))
+    }
+
+    r.countries[cc] = true
+  }
+
+  switch {
+  case len(rc.Providers) > 0 && len(rc.Exclude) > 0:
+    errs = append(errs, "providers: a rule lists providers or excludes them, not both")
+  case len(rc.Providers) > 0:
+    r.only = make(map[string]bool)
+    for _, name := range rc.Providers {
+      r.only[name] = true
+    }
+  case len(rc.Exclude) > 0:
+    for _, name := range rc.Exclude {
+      r.exclude[name] = true
+    }
+  default:
+    errs = append(errs, "providers: needs the providers to ask, or those to exclude")
+  }
+
+  return r, errs
+}
+
+// names is every provider the rule mentions.
+func (rc routeConfig) names() []string {
+  return append(append([]string(nil), rc.Providers...), rc.Exclude...)
+}
+
+func (r providerRoute) allows(name string) bool {
+  if r.only != nil {
+    return r.only[name]
+  }
+
+  return !r.exclude[name]
+}
+
+// routeFor is the rule for loc's country, if there is one.
+func (s *server) routeFor(loc geo.Location) (providerRoute, bool) {
+  for _, r := range s.routing {
+    if loc.Country != "" && r.countries[strings.ToUpper(loc.Country)] {
+      return r, true
+    }
+  }
+
+  return providerRoute{}, false
+}
+
+// providersFor is activeProviders narrowed to the caller's tenant, if it
+// has one, and to those routed to loc.
+func (s *server) providersFor(ctx context.Context, loc geo.Location) providers.Multi {
+  return s.narrow(ctx, loc, s.activeProviders())
+}
+
+// activeFor is providersFor less those whose circuit is open or quota is
+// spent, for the caller's tenant: the providers to ask about loc.
+func (s *server) activeFor(ctx context.Context, loc geo.Location) providers.Multi {
+  active, _ := s.available(ctx, s.providersFor(ctx, loc))
+  return active
+}
+
+// shadowsFor is activeFor for the shadow providers.
+func (s *server) shadowsFor(ctx context.Context, loc geo.Location) providers.Multi {
+  shadows, _ := s.available(ctx, s.narrow(ctx, loc, s.shadowProviders()))
+  return shadows
+}
+
+// available is ps less those whose circuit is open, and then those whose
+// quota is spent, which it names.
+func (s *server) available(ctx context.Context, ps providers.Multi) (active providers.Multi, exhausted []string) {
+  return s.quotasFor(ctx).available(s.healthFor(ctx).available(ps))
+}
+
+func (s *server) narrow(ctx context.Context, loc geo.Location, ps providers.Multi) providers.Multi {
+  active := s.tenantFor(ctx).providers(ps)
+  r, ok := s.routeFor(loc)
+  if !ok {
+    return active
+  }
+
+  routed := make(providers.Multi, 0, len(active))
+  for _, p := range active {
+    if r.allows(p.Name()) {
+      routed = append(routed, p)
+    }
+  }
+
+  return routed
+}
+
+// unroutable lists the providers the routing rules name that aren't
+// configured, most likely typos.
+func unroutable(rules []routeConfig, configured providers.Multi) []string {
+  known := make(map[string]bool, len(configured))
+  for _, p := range configured {
+    known[p.Name()] = true
+  }
+
+  var errs []string
+  for i, rc := range rules {
+    for _, name := range rc.names() {
+      if !known[name] {
+        errs = append(errs, fmt.Sprintf("routing[%d]: no provider is named %q", i, name))
+      }
+    }
+  }
+
+  return errs
+}
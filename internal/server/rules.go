@@ -0,0 +1,266 @@
+package server
+
+import (
+  "bytes"
+  "context"
+  "fmt"
+  "log"
+  "strconv"
+  "strings"
+  "text/template"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+)
+
+// ruleConfig is an alert rule from the config file: when the condition
+// holds for one of the cities, the rendered message is sent to each of its
+// channels, at most once per cooldown while it keeps holding. Webhook is
+// short for a webhook channel.
+type ruleConfig struct {
+  Name     string          `json:"name"`
+  Cities   []string        `json:"cities"`
+  When     string          `json:"when"`
+  Webhook  string          `json:"webhook,omitempty"`
+  Channels []channelConfig `json:"channels,omitempty"`
+  Template string          `json:"template,omitempty"`
+  Cooldown duration        `json:"cooldown,omitempty"`
+}
+
+const defaultRuleTemplate = `{{.Rule}}: {{.City}} is {{printf "%.1f" .Celsius}}°C`
+
+// ruleData is what a rule's template can refer to.
+type ruleData struct {
+  Rule       string
+  City       string
+  Kelvin     float64
+  Celsius    float64
+  Fahrenheit float64
+  Time       time.Time
+}
+
+func newRuleData(name, city string, kelvin float64, t time.Time) ruleData {
+  c := kelvin - 273.15
+  return ruleData{Rule: name, City: city, Kelvin: kelvin, Celsius: c, Fahrenheit: c*9/5 + 32, Time: t}
+}
+
+// rule is a compiled ruleConfig.
+type rule struct {
+  ruleConfig
+  when     *ruleExpr
+  message  *template.Template
+  channels []channel
+}
+
+func (rc ruleConfig) compile(n notificationsConfig) (*rule, []string) {
+  var errs []string
+  if rc.Name == "" {
+    errs = append(errs, "name: is required")
+  }
+
+  if len(rc.Cities) == 0 {
+    errs = append(errs, "cities: needs at least one city")
+  }
+
+  for i, c := range rc.Cities {
+    if strings.TrimSpace(c) == "" {
+      errs = append(errs, fmt.Sprintf("cities[%d]: is empty", i))
+    }
+  }
+
+  when, err := compileExpr(rc.When)
+  if err != nil {
+    errs = append(errs, "when: "+err.Error())
+  }
+
+  var channels []channel
+  if rc.Webhook != "" {
+    c, es := newWebhookChannel(channelConfig{URL: rc.Webhook}, n)
+    for _, e := range es {
+      errs = append(errs, "webhook: "+strings.TrimPrefix(e, "url: "))
+    }
+
+    channels = append(channels, c)
+  }
+
+  for i, cc := range rc.Channels {
+    c, es := cc.compile(n)
+    for _, e := range es {
+      errs = append(errs, fmt.Sprintf("channels[%d].%s", i, e))
+    }
+
+    channels = append(channels, c)
+  }
+
+  if len(channels) == 0 {
+    errs = append(errs, "channels: needs a webhook or at least one channel")
+  }
+
+  if rc.Cooldown < 0 {
+    errs = append(errs, "cooldown: can't be negative")
+  }
+
+  src := rc.Template
+  if src == "" {
+    src = defaultRuleTemplate
+  }
+
+  // Rendering sample data catches unknown fields, which parsing doesn't.
+  message, err := template.New("template").Option("missingkey=error").Parse(src)
+  if err == nil {
+    err = message.Execute(new(bytes.Buffer), newRuleData(rc.Name, "sample", 273.15, time.Now()))
+  }
+
+  if err != nil {
+    // Leaves the template's line:col, e.g. "template: 1:12: executing ...".
+    msg := strings.TrimPrefix(strings.TrimPrefix(err.Error(), "template: "), "template:")
+    errs = append(errs, "template: "+msg)
+  }
+
+  if len(errs) > 0 {
+    return nil, errs
+  }
+
+  return &rule{ruleConfig: rc, when: when, message: message, channels: channels}, nil
+}
+
+var ruleNotifications = metrics.NewCounter("rule_notifications_total", "Alert rule notifications, by rule, channel and outcome.", "rule", "channel", "outcome")
+
+// alerter evaluates the rules every interval against the current readings:
+// the config file's and those stored through the API, each stored one as
+// its owner so that client's tenant and quotas apply.
+type alerter struct {
+  srv      *server
+  rules    []*rule
+  stored   *collection
+  interval time.Duration
+
+  compiled map[string]*rule // stored rules by id@version
+}
+
+func newAlerter(srv *server, rules []*rule, stored *collection, interval time.Duration) *alerter {
+  return &alerter{srv: srv, rules: rules, stored: stored, interval: interval, compiled: make(map[string]*rule)}
+}
+
+func (a *alerter) run() {
+  for {
+    a.evaluate()
+    time.Sleep(a.interval)
+  }
+}
+
+func (a *alerter) evaluate() {
+  ctx, cancel := context.WithTimeout(context.Background(), a.interval)
+  defer cancel()
+
+  seen := make(map[string]bool)
+  for _, r := range a.rules {
+    a.check(ctx, "config:"+r.Name, r, seen)
+  }
+
+  items, err := a.stored.list(false)
+  if err != nil {
+    log.Printf("rules: %s", err)
+    return // keep the state of rules that weren't evaluated
+  }
+
+  compiled := make(map[string]*rule, len(items))
+  for _, item := range items {
+    sr := item.(*storedRule)
+    key := sr.ID + "@" + strconv.Itoa(sr.Version)
+    r, ok := a.compiled[key]
+    if !ok {
+      var errs []string
+      if r, errs = sr.ruleConfig.compile(sr.notifications); r == nil {
+        // Valid when stored; the notifications config may have changed since.
+        log.Printf("rules: %s (%s): %s", sr.Name, sr.ID, strings.Join(errs, "; "))
+        continue
+      }
+    }
+
+    compiled[key] = r
+    ctx := ctx
+    if sr.Owner != "" {
+      c, ok := a.srv.auth.named(sr.Owner)
+      if !ok {
+        log.Printf("rules: %s (%s): owner %s is no longer a client", sr.Name, sr.ID, sr.Owner)
+        continue
+      }
+
+      ctx = context.WithValue(ctx, clientCtxKey{}, c)
+    }
+
+    a.check(ctx, sr.ID, r, seen)
+  }
+
+  a.compiled = compiled
+
+  // What is left is of rules or cities since removed.
+  for _, k := range a.stored.db.keys(ruleStateBucket, "") {
+    if !seen[k] {
+      a.stored.db.delete(ruleStateBucket, k)
+    }
+  }
+}
+
+// check evaluates r, known in the state by key, for each of its cities.
+func (a *alerter) check(ctx context.Context, key string, r *rule, seen map[string]bool) {
+  db := a.stored.db
+  for _, city := range r.Cities {
+    stateKey := key + "/" + city
+    seen[stateKey] = true
+    res := a.srv.lookupCity(ctx, city, summary)
+    if res.Temp == nil {
+      log.Printf("rules: %s: %s: %s", r.Name, city, res.Error)
+      continue
+    }
+
+    kelvin := *res.Temp
+    var st ruleState
+    firing, err := db.get(ruleStateBucket, stateKey, &st)
+    if err != nil {
+      log.Printf("rules: %s: %s: %s", r.Name, city, err)
+    }
+
+    holds := r.when.holds(kelvin)
+    due := holds && (!firing || time.Since(st.LastFired) >= time.Duration(r.Cooldown))
+    switch {
+    case !holds && firing:
+      err = db.delete(ruleStateBucket, stateKey)
+    case due:
+      err = db.put(ruleStateBucket, stateKey, ruleState{LastFired: time.Now().UTC()})
+    }
+
+    if err != nil {
+      log.Printf("rules: %s: %s: %s", r.Name, city, err)
+    }
+
+    if due {
+      res.show(a.srv.defaultDisplay())
+      a.notify(ctx, r, city, kelvin, res)
+    }
+  }
+}
+
+// notify sends to every channel of r; one failing doesn't keep the others
+// from being tried.
+func (a *alerter) notify(ctx context.Context, r *rule, city string, kelvin float64, reading *TemperatureResponse) {
+  var msg bytes.Buffer
+  if err := r.message.Execute(&msg, newRuleData(r.Name, city, kelvin, time.Now())); err != nil {
+    ruleNotifications.Inc(r.Name, "template", "error")
+    log.Printf("rules: %s: %s", r.Name, err)
+    return
+  }
+
+  n := notification{Rule: r.Name, City: city, Message: msg.String(), Reading: reading}
+  for _, c := range r.channels {
+    if err := c.send(ctx, n); err != nil {
+      ruleNotifications.Inc(r.Name, c.kind(), "error")
+      log.Printf("rules: %s: %s: %s", r.Name, c.kind(), err)
+      continue
+    }
+
+    ruleNotifications.Inc(r.Name, c.kind(), "ok")
+    log.Printf("rules: %s: notified %s for %s", r.Name, c.kind(), city)
+  }
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+  "errors"
+  "fmt"
+  "net/http"
+  "strings"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+)
+
+// Most rules an API client may keep at /v1/rules.
+const rulesPerClient = 50
+
+// The bucket of every rule's state per city: present while the rule holds
+// there, so neither a restart nor a redeploy sends an alert again before
+// its cooldown.
+const ruleStateBucket = "rule_state"
+
+// storedRule is an alert rule managed through the API rather than the
+// config file, owned by the client that created it. It is evaluated with
+// the config file's and sends with the same notifications accounts.
+type storedRule struct {
+  resourceMeta
+  Owner string `json:"owner,omitempty"`
+  ruleConfig
+
+  notifications notificationsConfig
+}
+
+func (sr *storedRule) validate() error {
+  _, errs := sr.ruleConfig.compile(sr.notifications)
+  for i, c := range sr.Cities {
+    if strings.TrimSpace(c) == "" {
+      continue // compile has said so
+    }
+
+    if err := geo.ParseCity(c).Validate(); err != nil {
+      errs = append(errs, fmt.Sprintf("cities[%d]: %s", i, err))
+    }
+  }
+
+  if len(errs) > 0 {
+    return errors.New(strings.Join(errs, "; "))
+  }
+
+  return nil
+}
+
+func newRules(db *store, n notificationsConfig, adminGuard func(http.HandlerFunc) http.HandlerFunc) *collection {
+  return &collection{
+    db:         db,
+    name:       "rules",
+    newItem:    func() resource { return &storedRule{notifications: n} },
+    owner:      func(r resource) *string { return &r.(*storedRule).Owner },
+    perOwner:   rulesPerClient,
+    adminGuard: adminGuard,
+  }
+}
+
+// ruleState is a rule holding for a city.
+type ruleState struct {
+  LastFired time.Time `json:"last_fired"`
+}
+
+type ruleCityState struct {
+  City       string     `json:"city"`
+  Holding    bool       `json:"holding"`
+  LastFired  *time.Time `json:"last_fired,omitempty"`
+  QuietUntil *time.Time `json:"quiet_until,omitempty"` // no alert before, though it holds
+}
+
+// ruleStates serves GET /v1/rules/{id}/state: for each of the rule's
+// cities, whether it holds there as of the last evaluation, when it last
+// notified and, within the cooldown, until when it stays quiet.
+func (s *server) ruleStates(w http.ResponseWriter, r *http.Request) {
+  item, ok := s.rules.lookup(w, r)
+  if !ok {
+    return
+  }
+
+  sr := item.(*storedRule)
+  states := make([]ruleCityState, 0, len(sr.Cities))
+  for _, city := range sr.Cities {
+    cs := ruleCityState{City: city}
+    var st ruleState
+    if held, err := s.rules.db.get(ruleStateBucket, sr.ID+"/"+city, &st); err == nil && held {
+      cs.Holding, cs.LastFired = true, &st.LastFired
+      if quiet := st.LastFired.Add(time.Duration(sr.Cooldown)); quiet.After(time.Now()) {
+        cs.QuietUntil = &quiet
+      }
+    }
+
+    states = append(states, cs)
+  }
+
+  w.Header().Set("ETag", sr.etag())
+  writeJSON(w, http.StatusOK, map[string]interface{}{"id": sr.ID, "name": sr.Name, "cities": states})
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+  "errors"
+  "fmt"
+  "sort"
+  "strings"
+  "time"
+)
+
+// schedule is when a report is sent, written "every <interval>", such as
+// "every 6h", or "every <days> at <times>", such as "every day at 07:00" or
+// "every mon,thu at 08:30,18:00". Days are day, weekday, weekend or
+// weekday names; times are HH:MM in the report's time zone. Intervals are
+// counted from midnight UTC.
+type schedule struct {
+  every time.Duration // or the days and times below
+  days  [7]bool       // by time.Weekday
+  times []int         // minutes after midnight, ascending
+}
+
+// scheduleDays is the weekdays a day of a schedule stands for.
+func scheduleDays(name string) ([]time.Weekday, bool) {
+  switch name {
+  case "day":
+    return []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}, true
+  case "weekday":
+    return []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, true
+  case "weekend":
+    return []time.Weekday{time.Saturday, time.Sunday}, true
+  }
+
+  for d := time.Sunday; d <= time.Saturday; d++ {
+    if full := strings.ToLower(d.String()); name == full || name == full[:3] {
+      return []time.Weekday{d}, true
+    }
+  }
+
+  return nil, false
+}
+
+var errScheduleSyntax = errors.New(`want "every <interval>" or "every <days> at <HH:MM>", such as "every day at 07:00"`)
+
+func parseSchedule(src string) (schedule, error) {
+  src = strings.ToLower(strings.Join(strings.Fields(src), " "))
+  src = strings.ReplaceAll(src, ", ", ",")
+  words := strings.Fields(src)
+  if len(words) < 2 || words[0] != "every" {
+    return schedule{}, errScheduleSyntax
+  }
+
+  var s schedule
+  switch {
+  case len(words) == 2:
+    if words[1] == "hour" {
+      words[1] = "1h"
+    }
+
+    every, err := time.ParseDuration(words[1])
+    if err != nil {
+      return schedule{}, errScheduleSyntax
+    }
+
+    if every < time.Minute {
+      return schedule{}, fmt.Errorf("every %s is too often, at least 1m apart", every)
+    }
+
+    s.every = every
+    return s, nil
+  case len(words) != 4 || words[2] != "at":
+    return schedule{}, errScheduleSyntax
+  }
+
+  for _, name := range strings.Split(words[1], ",") {
+    days, ok := scheduleDays(name)
+    if !ok {
+      return schedule{}, fmt.Errorf("unknown day %q, want day, weekday, weekend or a weekday such as mon", name)
+    }
+
+    for _, d := range days {
+      s.days[d] = true
+    }
+  }
+
+  for _, at := range strings.Split(words[3], ",") {
+    t, err := time.Parse("15:04", at)
+    if err != nil {
+      return schedule{}, fmt.Errorf("time %q: want HH:MM, such as 07:00", at)
+    }
+
+    s.times = append(s.times, t.Hour()*60+t.Minute())
+  }
+
+  sort.Ints(s.times)
+  return s, nil
+}
+
+// next is the first time after after that s is due, in tz.
+func (s schedule) next(after time.Time, tz *time.Location) time.Time {
+  if s.every > 0 {
+    return after.Truncate(s.every).Add(s.every)
+  }
+
+  t := after.In(tz)
+  for d := 0; d <= 7; d++ {
+    day := time.Date(t.Year(), t.Month(), t.Day()+d, 0, 0, 0, 0, tz)
+    if !s.days[day.Weekday()] {
+      continue
+    }
+
+    for _, m := range s.times {
+      // A time the clocks skip is normalized to the one after.
+      if at := time.Date(day.Year(), day.Month(), day.Day(), m/60, m%60, 0, 0, tz); at.After(after) {
+        return at
+      }
+    }
+  }
+
+  return time.Time{} // no days: parseSchedule doesn't make one
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+  "net/http"
+  "strconv"
+  "time"
+  "unicode/utf8"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// Bounds of a search: fewer letters than minSearchRunes match half the
+// world, and no list a user picks from needs more than maxSearchResults.
+const (
+  minSearchRunes   = 2
+  maxSearchResults = 20
+)
+
+// search answers GET /v1/search?q=spring with the places the user may be
+// typing, best first, each linked to its weather, so a UI can offer them
+// before asking /v1/weather. Names are in the language of ?lang=, the
+// client's preference or Accept-Language, where the geocoder has them.
+func (s *server) search(w http.ResponseWriter, r *http.Request) {
+  begin := time.Now()
+  ctx := upstream.WithTrace(r)
+
+  q := geo.ParseCity(r.URL.Query().Get("q"))
+  if utf8.RuneCountInString(q.Name) < minSearchRunes {
+    httpError(w, "q must be at least "+strconv.Itoa(minSearchRunes)+" letters of a place name", http.StatusBadRequest)
+    return
+  }
+
+  if l := r.URL.Query().Get("lang"); l != "" && !langTag.MatchString(l) {
+    httpError(w, "lang wants a language tag such as en or pt-BR, got "+l, http.StatusBadRequest)
+    return
+  }
+
+  limit := 5
+  if v := r.URL.Query().Get("limit"); v != "" {
+    var err error
+    if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxSearchResults {
+      httpError(w, "limit must be 1 to "+strconv.Itoa(maxSearchResults), http.StatusBadRequest)
+      return
+    }
+  }
+
+  // An Accept-Language a browser made up is no reason to fail a search.
+  for _, l := range requestedLanguages(r) {
+    if langTag.MatchString(l) {
+      q.Lang = l
+      break
+    }
+  }
+
+  locs, err := geo.Search(ctx, s.geo, q, limit)
+  if err != nil {
+    writeError(w, err, locationStatus(err))
+    return
+  }
+
+  resp := SearchResponse{SchemaVersion: responseVersion, Query: q.String(), Lang: q.Lang, Results: make([]SearchResult, 0, len(locs))}
+  for _, l := range locs {
+    resp.Results = append(resp.Results, SearchResult{
+      Name:       l.Name,
+      Region:     l.Region,
+      Country:    l.Country,
+      Lat:        l.Lat,
+      Lon:        l.Lon,
+      Population: l.Population,
+      Href:       "/v1/weather?lat=" + l.LatString() + "&lon=" + l.LonString(),
+    })
+  }
+
+  resp.Took = time.Since(begin).String()
+  w.Header().Set("Vary", "Accept-Language, X-API-Key")
+  writeJSON(w, http.StatusOK, resp)
+}
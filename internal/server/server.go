@@ -0,0 +1,750 @@
+package server
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net/http"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/aggregate"
+  "github.com/im-kulikov/weather-go-external-api/internal/cache"
+  "github.com/im-kulikov/weather-go-external-api/internal/condition"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+type server struct {
+  geo       geo.Geocoder
+  ipdb      *geo.IPDatabase // nil without -geoip.db
+  zones     *geo.TimeZones  // nil offline or with -geocoder.timezones=false
+  proxies   proxyList
+  providers *providerSet // swapped by a config reload
+  offline   bool
+  pws       *pwsStore
+
+  subscriptions *collection
+  watchlists    *collection
+  groups        *collection
+  overrides     *collection
+  preferences   *collection
+  rules         *collection
+
+  cache      *cache.Readings
+  readings   *cache.ProviderReadings // each provider's, below cache
+  popular    *popularity
+  analytics  *analytics
+  history    *history
+  archive    *archiveCache
+  sinks      []sink // history and any time-series exports
+  policies   outputPolicies
+  outliers   aggregate.Outliers
+  staleness  aggregate.Staleness
+  sampler    *aggregate.Sampler
+  fanout     providers.ErrorPolicy // explanations collect all regardless
+  weights    *aggregate.Weights
+  learner    *weightLearner
+  limiter    *rateLimiter
+  auth       *clientAuth
+  slo        *sloTracker
+  smoother   *smoother
+  routing    []providerRoute // the config file's, by country
+  quotas     *quotas
+  health     *providerHealth
+  tenants    map[string]*tenant // the config file's, by name
+  feeds      *feeds
+  shadows    *shadows
+  stopping   chan struct{} // closed when shutting down, which ends streams
+
+  swrWait      time.Duration
+  degradedAge  time.Duration // -degraded.history
+  budget       requestBudget
+  limits       limits
+  idempotency  *idempotency
+  flights      flights
+  gzip         bool
+  cors         *cors
+  access       *accessLog // nil without -access.log
+  cacheHeaders cacheHeaders
+  chaos        *upstream.Chaos // nil without -chaos
+  unknown      *tombstones
+  signer       *signer // nil without -sign.key
+  adminGuard   func(http.HandlerFunc) http.HandlerFunc
+
+  batchConcurrency int
+  batchMax         int
+  bboxMaxCells     int
+  bboxUpstream     int
+  routeHorizon     time.Duration
+  precision        int // default decimal places of temperatures
+}
+
+var errNoLocation = errors.New("city or lat/lon required")
+
+// routes is the API surface. Everything lives under /v1/; the original
+// unversioned paths stay as aliases so existing consumers keep working.
+// The mux answers unknown paths with 404 and wrong methods with 405, in the
+// error envelope by muxErrors.
+func (s *server) routes() *http.ServeMux {
+  mux := http.NewServeMux()
+
+  for _, prefix := range []string{"/v1", ""} {
+    mux.HandleFunc("GET "+prefix+"/weather", s.weather)
+    mux.HandleFunc("GET "+prefix+"/weather/{city}", s.weather)
+    mux.HandleFunc("GET "+prefix+"/weather/bbox", s.weatherBox)
+    mux.HandleFunc("GET "+prefix+"/search", s.search)
+    mux.HandleFunc("POST "+prefix+"/weather/batch", s.slo.shed(s.batch))
+    mux.HandleFunc("POST "+prefix+"/route-weather", s.slo.shed(s.routeWeather))
+
+    if s.offline {
+      mux.HandleFunc("POST "+prefix+"/pws", s.stationAuth(s.pws.ingest))
+    }
+
+    mux.HandleFunc("GET "+prefix+"/providers", s.providerList)
+    mux.HandleFunc("GET "+prefix+"/astro", s.astronomy)
+    mux.HandleFunc("GET "+prefix+"/astro/{city}", s.astronomy)
+    mux.HandleFunc("GET "+prefix+"/nowcast", s.nowcast)
+    mux.HandleFunc("GET "+prefix+"/nowcast/{city}", s.nowcast)
+    mux.HandleFunc("GET "+prefix+"/uv", s.uv)
+    mux.HandleFunc("GET "+prefix+"/uv/{city}", s.uv)
+    mux.HandleFunc("GET "+prefix+"/pollen", s.pollen)
+    mux.HandleFunc("GET "+prefix+"/pollen/{city}", s.pollen)
+    mux.HandleFunc("GET "+prefix+"/marine", s.marine)
+    mux.HandleFunc("GET "+prefix+"/marine/{location}", s.marine)
+    mux.HandleFunc("GET "+prefix+"/agro", s.agro)
+    mux.HandleFunc("GET "+prefix+"/agro/{location}", s.agro)
+    mux.HandleFunc("GET "+prefix+"/trend", s.trend)
+    mux.HandleFunc("GET "+prefix+"/trend/{city}", s.trend)
+    mux.HandleFunc("GET "+prefix+"/conditions", s.conditions)
+    mux.HandleFunc("GET "+prefix+"/conditions/{city}", s.conditions)
+  }
+
+  s.subscriptions.register(mux)
+  s.watchlists.register(mux)
+  s.groups.register(mux)
+  s.overrides.register(mux)
+  s.preferences.register(mux)
+  s.rules.register(mux)
+  mux.HandleFunc("GET /v1/rules/{id}/state", s.ruleStates)
+  mux.HandleFunc("GET /v1/admin/rules/{id}/state", s.adminGuard(allOwners(s.ruleStates)))
+  for _, method := range []string{"GET", "PUT", "DELETE"} {
+    mux.HandleFunc(method+" /v1/preferences", s.ownPreferences)
+  }
+
+  mux.HandleFunc("POST /v1/admin/bulk", s.adminGuard(s.bulk))
+  mux.HandleFunc("GET /v1/admin/providers", s.adminGuard(s.adminProviders))
+  mux.HandleFunc("GET /v1/admin/analytics", s.adminGuard(s.adminAnalytics))
+  mux.HandleFunc("POST /v1/admin/providers/{name}/{action}", s.adminGuard(s.adminProviderAction))
+  mux.HandleFunc("GET /v1/admin/chaos", s.adminGuard(s.adminChaos))
+  mux.HandleFunc("PUT /v1/admin/chaos", s.adminGuard(s.adminChaos))
+  mux.HandleFunc("GET /v1/admin/weights", s.adminGuard(s.adminWeights))
+  mux.HandleFunc("POST /v1/admin/weights/learn", s.adminGuard(s.adminWeights))
+  mux.HandleFunc("GET /v1/watchlists/{id}/weather", s.watchlistWeather)
+  mux.HandleFunc("GET /v1/groups/{id}/weather", s.groupWeather)
+  mux.HandleFunc("GET /v1/history", s.historyHandler)
+  mux.HandleFunc("GET /v1/history/{city}", s.historyHandler)
+  mux.HandleFunc("GET /v1/export", s.export)
+  mux.HandleFunc("GET /v1/export/{city}", s.export)
+  mux.HandleFunc("GET /v1/stats/top-cities", s.topCities)
+  mux.HandleFunc("GET /v1/stats/verification", s.verification)
+  mux.HandleFunc("GET /v1/stats/slo", s.sloHandler)
+  mux.HandleFunc("GET /v1/stream", s.stream)
+  mux.HandleFunc("GET /v1/stream/{city}", s.stream)
+
+  mux.HandleFunc("GET /icons/{file}", icon)
+  mux.HandleFunc("GET /metrics", metrics.Handler)
+  mux.HandleFunc("GET /status", s.status)
+  mux.HandleFunc("GET /openapi.json", openAPIHandler)
+  mux.HandleFunc("GET /v1/signing-key", s.signingKey)
+  mux.HandleFunc("GET /{$}", dashboard)
+
+  return mux
+}
+
+// handler is the routes behind the client-facing middleware: clients are
+// authenticated first so rate limits can apply per API key, answers are
+// signed before they are compressed, and the access log sees every answer
+// as it went out.
+func (s *server) handler() http.Handler {
+  h := s.slo.track(s.auth.authenticate(s.limiter.limit(s.idempotency.handle(s.withPreferences(muxErrors(s.routes()))))))
+  h = s.signer.sign(h)
+  if s.gzip {
+    h = compress(h)
+  }
+
+  return s.access.handle(s.cors.handle(s.limits.handle(h)))
+}
+
+// detailLevel is how much of the aggregation a lookup shows.
+type detailLevel int
+
+const (
+  summary   detailLevel = iota
+  readings         // ?detail=true: each provider's reading
+  explained        // ?explain=true: readings and how they became the aggregate
+)
+
+func detailOf(r *http.Request) detailLevel {
+  switch q := r.URL.Query(); {
+  case q.Get("explain") == "true":
+    return explained
+  case q.Get("detail") == "true":
+    return readings
+  default:
+    return summary
+  }
+}
+
+// answer is what the providers said about a place, or the cache for them.
+type answer struct {
+  kelvin    float64
+  err       error
+  readings  []providers.Reading
+  credit    []upstream.Attribution
+  explain   *explanation
+  at        time.Time      // when the providers were asked
+  observed  time.Time      // of the oldest reading averaged, see observedAt
+  count     int            // readings averaged into kelvin
+  condition condition.Code // the readings' consensus
+  sources   []cache.Source // the readings averaged, for provenance
+}
+
+// cachedAnswer is what the cache remembers of an answer.
+func cachedAnswer(e cache.Entry) answer {
+  return answer{kelvin: e.Kelvin, credit: e.Credit, at: e.Stored, observed: e.Observed, count: e.Providers, condition: e.Condition, sources: e.Sources}
+}
+
+// fanOut queries the providers and, when they agree on an aggregate, caches
+// and publishes it. Every provider is waited for so history gets each
+// one's value; background refreshes (fresh) may sample a subset of them.
+// The shadow providers are asked too, and compared with the aggregate once
+// they answer, see shadows. It runs once per flight, see ask.
+func (s *server) fanOut(ctx context.Context, loc geo.Location, active providers.Multi, exhausted []string, fresh, explain bool) answer {
+  a := answer{at: time.Now()}
+  shadowed := s.shadow(ctx, loc)
+  policy := s.fanout
+  if explain {
+    // An explanation is of every provider's part, so it waits for them.
+    policy = providers.CollectAll
+  }
+
+  a.readings = s.readingsFor(ctx).Readings(active, loc, func(ask providers.Multi) []providers.Reading {
+    if fresh {
+      return s.sampler.Readings(ctx, ask, loc, policy)
+    }
+
+    return ask.Gather(ctx, loc, policy)
+  })
+
+  s.healthFor(ctx).record(a.readings)
+
+  for _, r := range a.readings {
+    if !r.Reused() && !errors.Is(r.Err, upstream.ErrBusy) {
+      s.quotasFor(ctx).spend(r.Provider)
+    }
+  }
+
+  s.staleness.Exclude(a.readings, a.at)
+  outliers := s.outliers.Exclude(a.readings)
+  s.weights.AssignIn(s.learner.region(loc), a.readings)
+  a.kelvin, a.err = aggregate.Average(a.readings)
+  if explain {
+    // Before Learn, so the trace shows the weights as they were applied.
+    a.explain = s.explain(ctx, loc, a.readings, outliers, exhausted, a.kelvin, a.err, active)
+  }
+
+  if a.err != nil {
+    s.unknown.bury(loc.Key(), a.readings)
+  }
+
+  if a.err == nil {
+    s.weights.Learn(a.readings)
+    a.credit = providers.Attributions(active)
+    a.count = averaged(a.readings)
+    a.condition = consensus(a.readings)
+    a.observed = observedAt(a.readings)
+    a.sources = sourcesOf(a.readings)
+    s.cacheFor(ctx).Put(cache.Entry{Loc: loc, Kelvin: a.kelvin, Providers: a.count, Condition: a.condition, Credit: a.credit, Observed: a.observed, Sources: a.sources, Stored: a.at})
+    s.publish(ctx, loc, newHistoryReading(a.kelvin, a.readings))
+  }
+
+  if shadowed != nil {
+    go s.shadows.compare(shadowed, a)
+  }
+
+  return a
+}
+
+// lookup fans out to the providers for a resolved location; it backs both
+// the single-city and the batch endpoints. Beyond summary every provider's
+// reading, latency and error is included, even when the aggregate failed.
+func (s *server) lookup(ctx context.Context, loc geo.Location, detail detailLevel) *TemperatureResponse {
+  s.popular.record(loc)
+  resp := s.fetch(ctx, loc, detail, false)
+  s.analytics.record(ctx, loc, resp.Cached)
+  return resp
+}
+
+// fetch is lookup with control over the cache: fresh skips reading it but
+// still stores the new reading, for background refreshers.
+func (s *server) fetch(ctx context.Context, loc geo.Location, detail detailLevel, fresh bool) *TemperatureResponse {
+  begin := time.Now()
+
+  loc = s.zones.Locate(ctx, loc)
+  resp := newTemperatureResponse()
+  resp.at(loc)
+  resp.LocalTime = localTime(loc, begin)
+
+  // Detail requests are for debugging providers, so they skip the aggregate
+  // cache; a provider's reading still comes from its own cache within its
+  // TTL, marked cached.
+  var a answer
+  enabled := s.providersFor(ctx, loc)
+  healthy := s.healthFor(ctx).available(enabled)
+  active, exhausted := s.quotasFor(ctx).available(healthy)
+  resp.QuotaExhausted = exhausted
+
+  c := s.cacheFor(ctx)
+  e, cached := c.Get(loc)
+  if !cached && !fresh && s.slo.degraded() {
+    if e, cached = c.Stale(loc); cached {
+      resp.Stale, resp.Age = true, age(e)
+    }
+  }
+
+  var buried error
+  if !cached && !fresh && detail == summary {
+    buried = s.unknown.buried(loc.Key())
+  }
+
+  switch {
+  case cached && !fresh && detail == summary:
+    a = cachedAnswer(e)
+    resp.Cached = true
+  case buried != nil:
+    a.err = buried
+  case len(active) == 0 && len(exhausted) > 0:
+    a.err = errQuotaExhausted
+  case len(healthy) == 0 && len(enabled) > 0:
+    a.err = errCircuitsOpen
+  case len(enabled) == 0 && len(s.activeProviders()) > 0:
+    a.err = errNotRouted
+  case len(active) == 0:
+    a.err = providers.ErrNoProviders
+  case !fresh && detail == summary && s.swrWait > 0:
+    var why string
+    if e, cached = c.Stale(loc); cached {
+      a, why, resp.Degraded = s.revalidate(ctx, loc, active, e)
+    } else {
+      a = s.ask(ctx, loc, active, exhausted, false, false)
+    }
+
+    if why != "" {
+      resp.Cached, resp.Stale, resp.StaleReason, resp.Age = true, true, why, age(e)
+    }
+  default:
+    a = s.ask(ctx, loc, active, exhausted, fresh, detail == explained)
+  }
+
+  if a.err != nil && overran(ctx, "fanout") {
+    a.err = fmt.Errorf("%w: the providers haven't answered within %s", errBudgetExhausted, s.budget.total)
+    if e, cached = c.Stale(loc); cached {
+      a = cachedAnswer(e)
+      resp.Cached, resp.Stale, resp.StaleReason, resp.Age = true, true, "request budget exhausted", age(e)
+      resp.Degraded = true
+    }
+  }
+
+  // Every provider failing leaves what the server still has: an expired
+  // entry if the cache kept one, or else the history.
+  if a.err != nil && !fresh && detail == summary && lookupStatus(a.err) >= http.StatusBadGateway {
+    why := "providers failed: " + a.err.Error()
+    if e, cached = c.Stale(loc); cached {
+      a = cachedAnswer(e)
+      resp.Cached, resp.Stale, resp.StaleReason, resp.Age, resp.Degraded = true, true, why, age(e), true
+    } else if h, ok := s.extrapolate(loc); ok {
+      a = h
+      resp.StaleReason, resp.Source, resp.Degraded = why, "history", true
+    }
+  }
+
+  rs, temp, credit, err := a.readings, a.kelvin, a.credit, a.err
+  resp.Explain = a.explain
+  resp.Took = time.Since(begin).String()
+
+  if detail > summary {
+    resp.Providers, resp.Units = providerReadings(s.policies.readings(rs)), "kelvin"
+  }
+
+  if resp.Explain == nil && detail == explained {
+    // Nobody was asked; all there is to explain is why.
+    resp.Explain = s.explain(ctx, loc, nil, "", exhausted, 0, err, active)
+  }
+
+  if err != nil {
+    resp.Failures, resp.Outage = providerErrors(rs), s.healthFor(ctx).outage()
+    return resp.fail(lookupStatus(err), err)
+  }
+
+  resp.Temp = kelvinPtr(s.policies.aggregate(temp, active))
+  resp.Units = "kelvin"
+  resp.ProviderCount = a.count
+  resp.sources = a.sources
+  resp.Condition = conditionAt(a.condition, loc, time.Now())
+  if !a.at.IsZero() {
+    at := a.at.UTC()
+    resp.Timestamp = &at
+  }
+
+  if !a.observed.IsZero() {
+    observed := a.observed.UTC()
+    resp.ObservedAt, resp.DataAge = &observed, time.Since(observed).Round(time.Second).String()
+  } else if resp.Degraded {
+    resp.DataAge = time.Since(a.at).Round(time.Second).String()
+  }
+
+  if resp.Degraded {
+    source := "cache"
+    if resp.Source == "history" {
+      source = "history"
+    }
+
+    degradedAnswers.Inc(source)
+  }
+
+  if resp.Cached {
+    resp.Cache = &CacheInfo{StoredAt: e.Stored.UTC(), ExpiresAt: c.Expires(e).UTC()}
+  }
+
+  if a, ok := geo.Attribution(s.geo, loc); ok {
+    // Capped so the append never writes into a cached slice.
+    credit = append(credit[:len(credit):len(credit)], a)
+  }
+
+  resp.Attribution = credit
+  if s.offline {
+    resp.Source = "offline-model"
+  }
+
+  return resp
+}
+
+func (s *server) weather(w http.ResponseWriter, r *http.Request) {
+  begin := time.Now()
+  ctx, cancel := s.budget.start(upstream.WithTrace(r))
+  defer cancel()
+
+  format, ok := responseFormat(w, r, "geojson", "xml", "csv")
+  if !ok {
+    return
+  }
+
+  d, ok := s.requestDisplay(w, r)
+  if !ok {
+    return
+  }
+
+  fields, err := parseFields(r.URL.Query().Get("fields"))
+  if err != nil {
+    writeError(w, err, http.StatusBadRequest)
+    return
+  }
+
+  loc, err := s.geocodeWithin(ctx, func(ctx context.Context) (geo.Location, error) { return requestLocation(ctx, r, s.geo) })
+  if errors.Is(err, errNoLocation) && s.ipdb != nil {
+    if loc, err = s.locateCaller(r); err == nil {
+      // The answer depends on who asks, so shared caches mustn't keep it.
+      w.Header().Set("Cache-Control", "private")
+    }
+  }
+
+  var amb *geo.AmbiguousError
+  if errors.As(err, &amb) {
+    writeCandidates(w, amb)
+    return
+  }
+
+  if err != nil {
+    writeError(w, err, locationStatus(err))
+    return
+  }
+
+  detail := detailOf(r)
+
+  var resp *TemperatureResponse
+  if fields.needs(fromReadings) {
+    resp = s.lookup(ctx, loc, detail)
+  } else {
+    loc = s.zones.Locate(ctx, loc)
+    resp = newTemperatureResponse()
+    resp.at(loc)
+    resp.LocalTime = localTime(loc, begin)
+    if a, ok := geo.Attribution(s.geo, loc); ok {
+      resp.Attribution = []upstream.Attribution{a}
+    }
+  }
+
+  if fields.needs(fromConditions) && resp.Error == "" {
+    s.measure(ctx, loc, resp)
+  }
+
+  if r.URL.Query().Get("smooth") == "true" {
+    s.smooth(resp, loc)
+  }
+
+  resp.only(fields)
+  resp.show(d)
+  if s.signer != nil || r.URL.Query().Get("provenance") == "true" {
+    resp.Provenance = s.provenance(resp.sources)
+  }
+
+  s.cacheHeaders.set(w, r, resp)
+
+  status := http.StatusOK
+  if resp.Error != "" {
+    status = resp.Status
+    if detail == summary {
+      writeFailedLookup(w, resp)
+      return
+    }
+  }
+
+  if status == http.StatusOK && detail == summary {
+    etag := readingETag(resp, format)
+    w.Header().Set("ETag", etag)
+    if notModified(r, etag) {
+      w.WriteHeader(http.StatusNotModified)
+      return
+    }
+  }
+
+  resp.Took = time.Since(begin).String()
+
+  switch format {
+  case "geojson":
+    writeGeoJSON(w, status, feature(resp))
+    return
+  case "xml":
+    writeXML(w, status, "weather", resp)
+    return
+  case "csv":
+    writeCSV(w, status, []*TemperatureResponse{resp})
+    return
+  }
+
+  w.Header().Set("Content-Type", "application/json; charset=utf-8")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(resp)
+}
+
+// batch answers POST /weather/batch with a JSON array of cities. Cities are
+// looked up with bounded concurrency and every entry reports its own
+// outcome, so one unknown city doesn't fail the whole dashboard.
+func (s *server) batch(w http.ResponseWriter, r *http.Request) {
+  begin := time.Now()
+  ctx := upstream.WithTrace(r)
+
+  format, ok := responseFormat(w, r, "geojson", "xml", "csv")
+  if !ok {
+    return
+  }
+
+  d, ok := s.requestDisplay(w, r)
+  if !ok {
+    return
+  }
+
+  var cities []string
+  if err := json.NewDecoder(r.Body).Decode(&cities); err != nil {
+    httpError(w, "want a JSON array of city names: "+err.Error(), bodyStatus(err))
+    return
+  }
+
+  if len(cities) == 0 || len(cities) > s.batchMax {
+    httpError(w, fmt.Sprintf("want 1 to %d cities, got %d", s.batchMax, len(cities)), http.StatusBadRequest)
+    return
+  }
+
+  results := s.lookupAll(ctx, cities, detailOf(r))
+  showAll(results, d)
+  took := time.Since(begin).String()
+
+  switch format {
+  case "geojson":
+    writeGeoJSON(w, http.StatusOK, featureCollection(results, map[string]interface{}{"schema_version": responseVersion, "took": took}))
+    return
+  case "csv":
+    writeCSV(w, http.StatusOK, results)
+    return
+  }
+
+  resp := BatchResponse{SchemaVersion: responseVersion, Results: results, Took: took}
+  if format == "xml" {
+    writeXML(w, http.StatusOK, "batch", resp)
+    return
+  }
+
+  w.Header().Set("Content-Type", "application/json; charset=utf-8")
+  json.NewEncoder(w).Encode(resp)
+}
+
+// lookupAll resolves and queries each city, at most batchConcurrency at a
+// time, keeping results in request order.
+func (s *server) lookupAll(ctx context.Context, cities []string, detail detailLevel) []*TemperatureResponse {
+  ctx, cancel := s.budget.start(ctx)
+  defer cancel()
+
+  results := make([]*TemperatureResponse, len(cities))
+  sem := make(chan struct{}, s.batchConcurrency)
+
+  var wg sync.WaitGroup
+  for i, city := range cities {
+    wg.Add(1)
+    sem <- struct{}{}
+
+    go func(i int, city string) {
+      defer func() { <-sem; wg.Done() }()
+
+      res := s.lookupCity(ctx, city, detail)
+      res.Query = city
+      results[i] = res
+    }(i, city)
+  }
+
+  wg.Wait()
+  return results
+}
+
+func (s *server) lookupCity(ctx context.Context, city string, detail detailLevel) *TemperatureResponse {
+  if strings.TrimSpace(city) == "" {
+    return failedLookup(http.StatusBadRequest, errNoLocation)
+  }
+
+  loc, err := s.geocodeWithin(ctx, func(ctx context.Context) (geo.Location, error) { return geo.Resolve(ctx, s.geo, geo.ParseCity(city)) })
+  var amb *geo.AmbiguousError
+  if errors.As(err, &amb) {
+    res := failedLookup(http.StatusMultipleChoices, amb)
+    res.Candidates = candidates(amb)
+    return res
+  }
+
+  if err != nil {
+    return failedLookup(locationStatus(err), err)
+  }
+
+  return s.lookup(ctx, loc, detail)
+}
+
+// requestLocation accepts either /weather/{city} or /weather?lat=..&lon=..
+// The mux has already percent-decoded the city, so "new%20york" and
+// "s%C3%A3o%20paulo" arrive as plain UTF-8.
+func requestLocation(ctx context.Context, r *http.Request, g geo.Geocoder) (geo.Location, error) {
+  q := r.URL.Query()
+  if q.Get("lat") != "" || q.Get("lon") != "" {
+    return geo.Coordinates(q.Get("lat"), q.Get("lon"))
+  }
+
+  city := r.PathValue("city")
+  if strings.TrimSpace(city) == "" {
+    city = homeCity(r.Context())
+  }
+
+  if strings.TrimSpace(city) == "" {
+    return geo.Location{}, errNoLocation
+  }
+
+  return geo.Resolve(ctx, g, geo.ParseCity(city))
+}
+
+type candidate struct {
+  geo.Location
+  Href string `json:"href"`
+}
+
+// candidates links each ambiguous match to its coordinate query.
+func candidates(amb *geo.AmbiguousError) []candidate {
+  cs := make([]candidate, 0, len(amb.Candidates))
+  for _, l := range amb.Candidates {
+    cs = append(cs, candidate{Location: l, Href: "/v1/weather?lat=" + l.LatString() + "&lon=" + l.LonString()})
+  }
+
+  return cs
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+  w.Header().Set("Content-Type", "application/json; charset=utf-8")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(v)
+}
+
+// writeCandidates answers an ambiguous query with 300 Multiple Choices and
+// a coordinate link per candidate the client can follow instead.
+func writeCandidates(w http.ResponseWriter, amb *geo.AmbiguousError) {
+  writeJSON(w, http.StatusMultipleChoices, map[string]interface{}{
+    "error":      ErrorDetail{Code: errorCode(amb, http.StatusMultipleChoices), Message: amb.Error(), Status: http.StatusMultipleChoices},
+    "candidates": candidates(amb),
+  })
+}
+
+func locationStatus(err error) int {
+  if errors.Is(err, geo.ErrLocationNotFound) {
+    return http.StatusNotFound
+  }
+
+  if errors.Is(err, errBudgetExhausted) {
+    return http.StatusGatewayTimeout
+  }
+
+  if errors.Is(err, errNoLocation) || errors.Is(err, geo.ErrBadCoordinates) || errors.Is(err, geo.ErrBadCity) {
+    return http.StatusBadRequest
+  }
+
+  return upstreamStatus(err)
+}
+
+// validCities checks the cities of a watchlist or group when it is saved,
+// rather than on every lookup.
+func validCities(cities []string) error {
+  for _, c := range cities {
+    if err := geo.ParseCity(c).Validate(); err != nil {
+      return fmt.Errorf("city %q: %w", c, err)
+    }
+  }
+
+  return nil
+}
+
+// lookupStatus is the status of a failed lookup: providers that don't know
+// the place make it a 404, a spent budget a 504, providers out of quota,
+// with open circuits or at their concurrency cap a 503, and providers
+// disabled or not routed here fail on our side; anything else is the
+// providers failing, a 502, or a 504 when they didn't answer in time.
+func lookupStatus(err error) int {
+  switch {
+  case errors.Is(err, providers.ErrCityNotFound):
+    return http.StatusNotFound
+  case errors.Is(err, errBudgetExhausted):
+    return http.StatusGatewayTimeout
+  case errors.Is(err, errQuotaExhausted), errors.Is(err, errCircuitsOpen), errors.Is(err, upstream.ErrBusy):
+    return http.StatusServiceUnavailable
+  case errors.Is(err, errNotRouted), errors.Is(err, providers.ErrNoProviders):
+    return http.StatusInternalServerError
+  default:
+    return upstreamStatus(err)
+  }
+}
+
+// upstreamStatus is 504 for an upstream that timed out and 502 for any
+// other failing.
+func upstreamStatus(err error) int {
+  if providers.Failure(err) == upstream.FailureTimeout {
+    return http.StatusGatewayTimeout
+  }
+
+  return http.StatusBadGateway
+}
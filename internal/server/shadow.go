@@ -0,0 +1,133 @@
+package server
+
+import (
+  "context"
+  "errors"
+  "math"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+var (
+  shadowComparisons = metrics.NewCounter("shadow_comparisons_total", "Shadow provider readings compared with the answer they were left out of.", "provider")
+  shadowDeviation   = metrics.NewCounter("shadow_deviation_kelvin_total", "Sum of shadow providers' distance from the answer, K; over shadow_comparisons_total, the mean.", "provider")
+  shadowBias        = metrics.NewGauge("shadow_bias_kelvin", "Shadow providers' mean reading minus the answer, K; negative reads colder.", "provider")
+)
+
+// shadows keeps how the readings of each shadow provider, -providers.shadow,
+// compared with the answers they were left out of. Shadows are asked
+// alongside every fan-out for the current temperature, but in the
+// background: a slow or failing one never holds up or fails the answer.
+// Their calls count towards their health and quota like any other's.
+type shadows struct {
+  mu sync.Mutex
+  by map[string]*shadowStats
+}
+
+type shadowStats struct {
+  compared  int
+  deviation float64 // sum of |reading - answer|, K
+  bias      float64 // sum of reading - answer, K
+  last      *shadowComparison
+}
+
+type shadowComparison struct {
+  Kelvin float64   `json:"temp"`
+  Answer float64   `json:"answer"`
+  At     time.Time `json:"at"`
+}
+
+// shadowReport is a shadow provider's part of /v1/admin/providers; its
+// latency and errors are in its health.
+type shadowReport struct {
+  Compared      int               `json:"compared"`
+  MeanDeviation *float64          `json:"mean_deviation,omitempty"` // K
+  MeanBias      *float64          `json:"mean_bias,omitempty"`      // K
+  Last          *shadowComparison `json:"last,omitempty"`
+}
+
+func newShadows() *shadows { return &shadows{by: make(map[string]*shadowStats)} }
+
+// shadow starts asking the shadow providers for loc and returns where their
+// readings will be, or nil when there are none to ask. The fan-out's ctx
+// ends with it, so they get their own, keeping its values.
+func (s *server) shadow(ctx context.Context, loc geo.Location) <-chan []providers.Reading {
+  ask := s.shadowsFor(ctx, loc)
+  if len(ask) == 0 {
+    return nil
+  }
+
+  ch := make(chan []providers.Reading, 1)
+  go func() {
+    ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), flightTimeout)
+    defer cancel()
+
+    rs := s.readingsFor(ctx).Readings(ask, loc, func(ask providers.Multi) []providers.Reading {
+      return ask.Gather(ctx, loc, providers.CollectAll)
+    })
+
+    s.healthFor(ctx).record(rs)
+    for _, r := range rs {
+      if !r.Reused() && !errors.Is(r.Err, upstream.ErrBusy) {
+        s.quotasFor(ctx).spend(r.Provider)
+      }
+    }
+
+    ch <- rs
+  }()
+
+  return ch
+}
+
+// compare waits for the shadow readings and notes how each fresh one
+// compares with a, once a has an aggregate.
+func (sh *shadows) compare(readings <-chan []providers.Reading, a answer) {
+  rs := <-readings
+  if a.err != nil {
+    return
+  }
+
+  sh.mu.Lock()
+  defer sh.mu.Unlock()
+
+  for _, r := range rs {
+    if r.Error != "" || r.Reused() {
+      continue
+    }
+
+    st, ok := sh.by[r.Provider]
+    if !ok {
+      st = &shadowStats{}
+      sh.by[r.Provider] = st
+    }
+
+    diff := r.Kelvin - a.kelvin
+    st.compared++
+    st.deviation += math.Abs(diff)
+    st.bias += diff
+    st.last = &shadowComparison{Kelvin: r.Kelvin, Answer: a.kelvin, At: a.at.UTC()}
+
+    shadowComparisons.Inc(r.Provider)
+    shadowDeviation.Add(math.Abs(diff), r.Provider)
+    shadowBias.Set(st.bias/float64(st.compared), r.Provider)
+  }
+}
+
+func (sh *shadows) report(name string) shadowReport {
+  sh.mu.Lock()
+  defer sh.mu.Unlock()
+
+  st, ok := sh.by[name]
+  if !ok {
+    return shadowReport{}
+  }
+
+  dev, bias := st.deviation/float64(st.compared), st.bias/float64(st.compared)
+  last := *st.last
+  return shadowReport{Compared: st.compared, MeanDeviation: &dev, MeanBias: &bias, Last: &last}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+  "context"
+  "errors"
+  "log"
+  "net/http"
+  "os"
+  "os/signal"
+  "syscall"
+  "time"
+)
+
+// shutdown is what stopping the server takes, in order.
+type shutdown struct {
+  srv      *server
+  hs       *http.Server
+  grace    time.Duration // for requests in flight
+  snapshot string        // -cache.snapshot, "" for none
+  db       *store
+}
+
+// run serves until SIGINT or SIGTERM, then stops in order: no new requests,
+// and those in flight, streams included, get grace to finish; the sinks
+// and analytics write out what they buffered; the cache is snapshotted for
+// the next warm start; and the store, which the steps before write to, is
+// closed last.
+func (sd shutdown) run() error {
+  served := make(chan error, 1)
+  go func() { served <- serve(sd.hs) }()
+
+  stop := make(chan os.Signal, 1)
+  signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+  select {
+  case err := <-served:
+    return err
+  case sig := <-stop:
+    log.Printf("shutting down on %s", sig)
+  }
+
+  begin := time.Now()
+  ctx, cancel := context.WithTimeout(context.Background(), sd.grace)
+  defer cancel()
+
+  sd.hs.RegisterOnShutdown(func() { close(sd.srv.stopping) })
+  if err := sd.hs.Shutdown(ctx); err != nil {
+    log.Printf("shutdown: requests still in flight after %s: %s", sd.grace, err)
+  }
+
+  if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+    log.Printf("shutdown: %s", err)
+  }
+
+  for _, k := range sd.srv.sinks {
+    if f, ok := k.(flusher); ok {
+      f.flush()
+    }
+  }
+
+  sd.srv.analytics.flush()
+
+  if sd.snapshot != "" {
+    if n, err := sd.srv.cache.Snapshot(sd.snapshot); err != nil {
+      log.Printf("cache snapshot: %s: %s", sd.snapshot, err)
+    } else {
+      log.Printf("cache snapshot: saved %d entries", n)
+    }
+  }
+
+  if err := sd.db.close(); err != nil {
+    log.Printf("store: %s", err)
+  }
+
+  log.Printf("stopped, took: %s", time.Since(begin).String())
+  return nil
+}
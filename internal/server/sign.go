@@ -0,0 +1,154 @@
+package server
+
+import (
+  "crypto/ed25519"
+  "crypto/sha256"
+  "crypto/x509"
+  "encoding/base64"
+  "encoding/hex"
+  "encoding/pem"
+  "errors"
+  "fmt"
+  "net/http"
+  "os"
+  "strings"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/cache"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+// signer signs answers with -sign.key, so consumers that must show a
+// reading wasn't altered after it left the service can check it against
+// the public key at /v1/signing-key.
+type signer struct {
+  key ed25519.PrivateKey
+  id  string // the first 8 bytes of the public key's SHA-256, hex
+}
+
+// loadSigner reads an Ed25519 private key in PKCS #8 PEM, as
+// `openssl genpkey -algorithm ed25519` writes it.
+func loadSigner(path string) (*signer, error) {
+  raw, err := os.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+
+  block, _ := pem.Decode(raw)
+  if block == nil || block.Type != "PRIVATE KEY" {
+    return nil, fmt.Errorf("%s: want a PKCS #8 PEM private key", path)
+  }
+
+  k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+  if err != nil {
+    return nil, fmt.Errorf("%s: %w", path, err)
+  }
+
+  key, ok := k.(ed25519.PrivateKey)
+  if !ok {
+    return nil, fmt.Errorf("%s: want an Ed25519 key, got %T", path, k)
+  }
+
+  sum := sha256.Sum256(key.Public().(ed25519.PublicKey))
+  return &signer{key: key, id: hex.EncodeToString(sum[:8])}, nil
+}
+
+// sign adds X-Signature, the Ed25519 signature of the body as sent before
+// any Content-Encoding, and X-Signature-Key, the key it was made with.
+// Streams, exports and the admin API are left alone, as is every answer
+// without a signer.
+func (sg *signer) sign(h http.Handler) http.Handler {
+  if sg == nil {
+    return h
+  }
+
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodHead || strings.HasPrefix(r.URL.Path, "/v1/stream") || strings.HasPrefix(r.URL.Path, "/v1/export") || strings.HasPrefix(r.URL.Path, "/v1/admin/") {
+      h.ServeHTTP(w, r)
+      return
+    }
+
+    sw := &signedWriter{ResponseWriter: w, status: http.StatusOK}
+    h.ServeHTTP(sw, r)
+
+    if len(sw.body) > 0 {
+      w.Header().Set("X-Signature", base64.StdEncoding.EncodeToString(ed25519.Sign(sg.key, sw.body)))
+      w.Header().Set("X-Signature-Key", sg.id)
+    }
+
+    w.WriteHeader(sw.status)
+    w.Write(sw.body)
+  })
+}
+
+// signedWriter holds the whole answer back until it can be signed.
+type signedWriter struct {
+  http.ResponseWriter
+  status int
+  body   []byte
+  wrote  bool
+}
+
+func (w *signedWriter) WriteHeader(status int) {
+  if !w.wrote {
+    w.status, w.wrote = status, true
+  }
+}
+
+func (w *signedWriter) Write(b []byte) (int, error) {
+  w.wrote = true
+  w.body = append(w.body, b...)
+  return len(b), nil
+}
+
+var errNotSigning = errors.New("response signing is off; start with -sign.key to use it")
+
+// signingKey answers GET /v1/signing-key with the public key answers are
+// signed with.
+func (s *server) signingKey(w http.ResponseWriter, r *http.Request) {
+  if s.signer == nil {
+    writeError(w, errNotSigning, http.StatusNotFound)
+    return
+  }
+
+  pub := s.signer.key.Public().(ed25519.PublicKey)
+  der, err := x509.MarshalPKIXPublicKey(pub)
+  if err != nil {
+    writeError(w, err, http.StatusInternalServerError)
+    return
+  }
+
+  writeJSON(w, http.StatusOK, SigningKeyResponse{
+    Algorithm: "ed25519",
+    KeyID:     s.signer.id,
+    PublicKey: base64.StdEncoding.EncodeToString(pub),
+    PEM:       string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+  })
+}
+
+// provenance is sources as the output policies let them be shown: a
+// provider whose readings are aggregate-only or delayed is listed without
+// its value.
+func (s *server) provenance(sources []cache.Source) []ProvenanceSource {
+  rs := make([]providers.Reading, len(sources))
+  for i, src := range sources {
+    rs[i] = providers.Reading{Provider: src.Provider, Kelvin: src.Kelvin}
+  }
+
+  out := make([]ProvenanceSource, len(sources))
+  for i, r := range s.policies.readings(rs) {
+    src := sources[i]
+    p := ProvenanceSource{Provider: src.Provider, Withheld: r.Withheld, Weight: src.Weight, FetchedAt: src.Fetched.UTC()}
+    if r.Withheld == "" {
+      p.Kelvin = kelvinPtr(r.Kelvin)
+    }
+
+    if !src.Observed.IsZero() {
+      observed := src.Observed.UTC()
+      p.ObservedAt = &observed
+    }
+
+    out[i] = p
+  }
+
+  return out
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+  "bytes"
+  "context"
+  "fmt"
+  "log"
+  "math"
+  "net/http"
+  "sort"
+  "strings"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// sink receives every aggregate fetched upstream, with its provider values
+// and the ctx of the lookup that fetched it.
+type sink interface {
+  send(ctx context.Context, loc geo.Location, r historyReading)
+}
+
+// flusher is a sink that buffers; flush writes out what it holds, for
+// shutdown.
+type flusher interface {
+  flush()
+}
+
+func (s *server) publish(ctx context.Context, loc geo.Location, r historyReading) {
+  for _, k := range s.sinks {
+    k.send(ctx, loc, r)
+  }
+}
+
+var (
+  sinkPoints = metrics.NewCounter("sink_points_total", "Points shipped to time-series sinks, by outcome.", "sink", "outcome")
+  sinkClient = &http.Client{Timeout: 10 * time.Second}
+  influxEsc  = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
+
+// influxSink ships readings to InfluxDB in line protocol, one temperature
+// point per provider plus the aggregate and the providers' spread, so
+// disagreement can be graphed next to the temperature. Points are batched
+// in the background; when InfluxDB can't keep up they are dropped rather
+// than slowing requests down.
+type influxSink struct {
+  url   string // full write URL, e.g. http://influx:8086/api/v2/write?org=o&bucket=weather
+  token string
+
+  points  chan string
+  flushes chan chan struct{}
+  batch   int
+  every   time.Duration
+}
+
+func newInfluxSink(url, token string, batch int, every time.Duration) *influxSink {
+  k := &influxSink{url: url, token: token, points: make(chan string, 16*batch), flushes: make(chan chan struct{}), batch: batch, every: every}
+  go k.run()
+  return k
+}
+
+func (k *influxSink) send(ctx context.Context, loc geo.Location, r historyReading) {
+  tags := "city=" + influxTag(loc.Name)
+  if loc.Country != "" {
+    tags += ",country=" + influxTag(loc.Country)
+  }
+
+  ts := r.Time.UnixNano()
+  lines := []string{fmt.Sprintf("temperature,%s,provider=aggregate kelvin=%g,spread=%g %d", tags, r.Kelvin, spread(r.Providers), ts)}
+
+  names := make([]string, 0, len(r.Providers))
+  for name := range r.Providers {
+    names = append(names, name)
+  }
+
+  sort.Strings(names)
+  for _, name := range names {
+    lines = append(lines, fmt.Sprintf("temperature,%s,provider=%s kelvin=%g %d", tags, influxTag(name), r.Providers[name], ts))
+  }
+
+  for _, l := range lines {
+    select {
+    case k.points <- l:
+    default:
+      sinkPoints.Inc("influx", "dropped")
+    }
+  }
+}
+
+func influxTag(v string) string {
+  if v == "" {
+    return "unknown"
+  }
+
+  return influxEsc.Replace(v)
+}
+
+// spread is the gap between the highest and lowest provider.
+func spread(values map[string]float64) float64 {
+  if len(values) == 0 {
+    return 0
+  }
+
+  lo, hi := math.Inf(1), math.Inf(-1)
+  for _, v := range values {
+    lo, hi = math.Min(lo, v), math.Max(hi, v)
+  }
+
+  return hi - lo
+}
+
+// flush writes every point queued so far.
+func (k *influxSink) flush() {
+  done := make(chan struct{})
+  k.flushes <- done
+  <-done
+}
+
+func (k *influxSink) run() {
+  t := time.NewTicker(k.every)
+  defer t.Stop()
+
+  var buf []string
+  for {
+    select {
+    case p := <-k.points:
+      if buf = append(buf, p); len(buf) < k.batch {
+        continue
+      }
+    case <-t.C:
+      if len(buf) == 0 {
+        continue
+      }
+    case done := <-k.flushes:
+      for queued := true; queued; {
+        select {
+        case p := <-k.points:
+          buf = append(buf, p)
+        default:
+          queued = false
+        }
+      }
+
+      if len(buf) > 0 {
+        k.write(buf)
+        buf = buf[:0]
+      }
+
+      close(done)
+      continue
+    }
+
+    k.write(buf)
+    buf = buf[:0]
+  }
+}
+
+func (k *influxSink) write(points []string) {
+  ctx, cancel := context.WithTimeout(context.Background(), k.every)
+  defer cancel()
+
+  body := bytes.NewBufferString(strings.Join(points, "\n") + "\n")
+  req, err := http.NewRequestWithContext(ctx, "POST", k.url, body)
+  if err != nil {
+    log.Printf("sink: influx: %s", err)
+    return
+  }
+
+  req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+  req.Header.Set("User-Agent", upstream.UserAgent())
+  if k.token != "" {
+    req.Header.Set("Authorization", "Token "+k.token)
+  }
+
+  resp, err := sinkClient.Do(req)
+  if err == nil {
+    resp.Body.Close()
+    if resp.StatusCode/100 != 2 {
+      err = fmt.Errorf("%s", resp.Status)
+    }
+  }
+
+  if err != nil {
+    sinkPoints.Add(float64(len(points)), "influx", "error")
+    log.Printf("sink: influx: %d points: %s", len(points), err)
+    return
+  }
+
+  sinkPoints.Add(float64(len(points)), "influx", "ok")
+}
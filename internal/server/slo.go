@@ -0,0 +1,248 @@
+package server
+
+import (
+  "fmt"
+  "math"
+  "net/http"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+)
+
+var sloDegraded = metrics.NewGauge("slo_degraded", "1 while the error budget is nearly spent and the server serves stale and sheds batches.")
+
+// Latency histogram buckets grow by 25%, from 1ms to about a minute.
+const (
+  latencyBuckets = 50
+  latencyBase    = time.Millisecond
+  latencyGrowth  = 1.25
+)
+
+func latencyBucket(d time.Duration) int {
+  if d <= latencyBase {
+    return 0
+  }
+
+  return min(int(math.Ceil(math.Log(float64(d)/float64(latencyBase))/math.Log(latencyGrowth))), latencyBuckets-1)
+}
+
+func latencyBound(i int) time.Duration {
+  return time.Duration(float64(latencyBase) * math.Pow(latencyGrowth, float64(i)))
+}
+
+// sloMinute is the traffic of one minute.
+type sloMinute struct {
+  minute   int64
+  requests int
+  errors   int
+  latency  [latencyBuckets]int
+}
+
+// sloTracker measures the server's own availability (non-5xx share) and
+// p95 latency over sliding windows made of per-minute slots. With protect
+// set it degrades once less than protectBelow of the error budget over the
+// first window is left: expired cached readings are served and batch
+// requests shed, to save upstream capacity for single lookups.
+type sloTracker struct {
+  availability float64 // target share of successful requests
+  latency      time.Duration
+  windows      []time.Duration // the first one drives degradation
+  protect      bool
+  protectBelow float64
+
+  mu      sync.Mutex
+  minutes []sloMinute
+
+  checked    time.Time // degraded is re-evaluated at most every sloRecheck
+  isDegraded bool
+}
+
+const sloRecheck = 10 * time.Second
+
+func newSLOTracker(availability float64, latency time.Duration, windows []time.Duration, protect bool, protectBelow float64) *sloTracker {
+  longest := time.Minute
+  for _, w := range windows {
+    longest = max(longest, w)
+  }
+
+  return &sloTracker{
+    availability: availability,
+    latency:      latency,
+    windows:      windows,
+    protect:      protect,
+    protectBelow: protectBelow,
+    minutes:      make([]sloMinute, int(longest/time.Minute)),
+  }
+}
+
+func (t *sloTracker) record(status int, took time.Duration) {
+  minute := time.Now().Unix() / 60
+
+  t.mu.Lock()
+  defer t.mu.Unlock()
+
+  m := &t.minutes[minute%int64(len(t.minutes))]
+  if m.minute != minute {
+    *m = sloMinute{minute: minute}
+  }
+
+  m.requests++
+  if status >= 500 {
+    m.errors++
+  }
+
+  m.latency[latencyBucket(took)]++
+}
+
+type sloWindow struct {
+  Window          string  `json:"window"`
+  Requests        int     `json:"requests"`
+  Errors          int     `json:"errors"`
+  Availability    float64 `json:"availability"`
+  P95             string  `json:"p95"`
+  P95Met          bool    `json:"p95_met"`
+  BudgetRemaining float64 `json:"error_budget_remaining"` // share of allowed errors not yet spent
+}
+
+func (t *sloTracker) window(w time.Duration) sloWindow {
+  now := time.Now().Unix() / 60
+  span := int64(w / time.Minute)
+
+  t.mu.Lock()
+  var total sloMinute
+  for _, m := range t.minutes {
+    if m.requests > 0 && now-m.minute < span {
+      total.requests += m.requests
+      total.errors += m.errors
+      for i, n := range m.latency {
+        total.latency[i] += n
+      }
+    }
+  }
+  t.mu.Unlock()
+
+  sw := sloWindow{Window: w.String(), Requests: total.requests, Errors: total.errors, Availability: 1, BudgetRemaining: 1}
+  if total.requests == 0 {
+    sw.P95, sw.P95Met = "0s", true
+    return sw
+  }
+
+  sw.Availability = 1 - float64(total.errors)/float64(total.requests)
+  if budget := (1 - t.availability) * float64(total.requests); budget > 0 {
+    sw.BudgetRemaining = math.Max(0, 1-float64(total.errors)/budget)
+  } else if total.errors > 0 {
+    sw.BudgetRemaining = 0
+  }
+
+  seen, p95 := 0, 0
+  for i, n := range total.latency {
+    if seen += n; float64(seen) >= 0.95*float64(total.requests) {
+      p95 = i
+      break
+    }
+  }
+
+  sw.P95 = latencyBound(p95).String()
+  sw.P95Met = latencyBound(p95) <= t.latency
+  return sw
+}
+
+// degraded reports whether the conservative mode is on.
+func (t *sloTracker) degraded() bool {
+  if !t.protect || len(t.windows) == 0 {
+    return false
+  }
+
+  t.mu.Lock()
+  if time.Since(t.checked) < sloRecheck {
+    defer t.mu.Unlock()
+    return t.isDegraded
+  }
+  t.mu.Unlock()
+
+  on := t.window(t.windows[0]).BudgetRemaining < t.protectBelow
+
+  t.mu.Lock()
+  t.checked, t.isDegraded = time.Now(), on
+  t.mu.Unlock()
+
+  if on {
+    sloDegraded.Set(1)
+  } else {
+    sloDegraded.Set(0)
+  }
+
+  return on
+}
+
+type statusWriter struct {
+  http.ResponseWriter
+  status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+  w.status = status
+  w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// track feeds every API request into the tracker. Streams and exports are
+// left out: their duration is the client's choice, not a latency.
+func (t *sloTracker) track(h http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if r.URL.Path == "/metrics" || strings.HasPrefix(r.URL.Path, "/v1/stream") || strings.HasPrefix(r.URL.Path, "/v1/export") {
+      h.ServeHTTP(w, r)
+      return
+    }
+
+    begin := time.Now()
+    sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+    h.ServeHTTP(sw, r)
+    t.record(sw.status, time.Since(begin))
+  })
+}
+
+// shed turns batch requests away while degraded.
+func (t *sloTracker) shed(h http.HandlerFunc) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    if t.degraded() {
+      w.Header().Set("Retry-After", "60")
+      httpError(w, "batch requests are paused while the service is degraded, query cities one by one", http.StatusServiceUnavailable)
+      return
+    }
+
+    h(w, r)
+  }
+}
+
+// parseWindows reads a comma-separated list of window durations.
+func parseWindows(v string) ([]time.Duration, error) {
+  var ws []time.Duration
+  for _, raw := range strings.Split(v, ",") {
+    w, err := time.ParseDuration(strings.TrimSpace(raw))
+    if err != nil || w < time.Minute {
+      return nil, fmt.Errorf("SLO window %q must be a duration of at least 1m", raw)
+    }
+
+    ws = append(ws, w)
+  }
+
+  return ws, nil
+}
+
+// sloHandler serves GET /v1/stats/slo.
+func (s *server) sloHandler(w http.ResponseWriter, r *http.Request) {
+  ws := make([]sloWindow, 0, len(s.slo.windows))
+  for _, win := range s.slo.windows {
+    ws = append(ws, s.slo.window(win))
+  }
+
+  writeJSON(w, http.StatusOK, map[string]interface{}{
+    "targets":  map[string]interface{}{"availability": s.slo.availability, "p95": s.slo.latency.String()},
+    "degraded": s.slo.degraded(),
+    "windows":  ws,
+  })
+}
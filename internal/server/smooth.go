@@ -0,0 +1,81 @@
+package server
+
+import (
+  "context"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+)
+
+// smoother keeps an exponential moving average of each place's aggregate,
+// fed from every upstream fetch, to take the jitter out of values shown
+// continuously. A gap longer than smoothReset starts the average afresh
+// rather than dragging in a stale value.
+type smoother struct {
+  alpha float64 // weight of the newest reading; 0 disables smoothing
+
+  mu  sync.Mutex
+  ema map[string]smoothed
+}
+
+type smoothed struct {
+  kelvin float64
+  at     time.Time
+}
+
+const smoothReset = time.Hour
+
+func newSmoother(alpha float64) *smoother {
+  return &smoother{alpha: alpha, ema: make(map[string]smoothed)}
+}
+
+func (s *smoother) send(ctx context.Context, loc geo.Location, r historyReading) {
+  if s.alpha <= 0 {
+    return
+  }
+
+  key := loc.Key()
+
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  v := r.Kelvin
+  if prev, ok := s.ema[key]; ok && r.Time.Sub(prev.at) < smoothReset {
+    v = s.alpha*r.Kelvin + (1-s.alpha)*prev.kelvin
+  }
+
+  s.ema[key] = smoothed{kelvin: v, at: r.Time}
+
+  for k, e := range s.ema {
+    if r.Time.Sub(e.at) > smoothReset {
+      delete(s.ema, k)
+    }
+  }
+}
+
+func (s *smoother) value(loc geo.Location) (float64, bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  e, ok := s.ema[loc.Key()]
+  return e.kelvin, ok && time.Since(e.at) < smoothReset
+}
+
+// smooth replaces the temperature of a lookup result by its moving average,
+// keeping the reading itself as raw_temp.
+func (s *server) smooth(resp *TemperatureResponse, loc geo.Location) {
+  if resp.Temp == nil || s.smoother.alpha <= 0 {
+    return
+  }
+
+  if v, ok := s.smoother.value(loc); ok {
+    v = s.policies.aggregate(v, s.activeProviders())
+    resp.RawTemp, resp.Temp = resp.Temp, &v
+
+    if e := resp.Explain; e != nil {
+      e.Smoothing = &explainedSmooth{Alpha: s.smoother.alpha, Raw: *resp.RawTemp, Smoothed: v}
+      e.Result = &v
+    }
+  }
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+  "container/heap"
+  "hash/fnv"
+  "net/http"
+  "sort"
+  "strconv"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+)
+
+// Sketch dimensions: 4×2048 counters overestimate a city's count by at most
+// ~0.1% of the slot's total queries with 98% probability.
+const (
+  sketchDepth = 4
+  sketchWidth = 2048
+  sketchTopK  = 64 // heavy hitters tracked per slot
+)
+
+// countMin is a count-min sketch; estimates never undercount.
+type countMin [sketchDepth][sketchWidth]uint32
+
+func sketchIndexes(key string) [sketchDepth]uint32 {
+  h := fnv.New64a()
+  h.Write([]byte(key))
+  sum := h.Sum64()
+  h1, h2 := uint32(sum), uint32(sum>>32)|1
+
+  var idx [sketchDepth]uint32
+  for i := range idx {
+    idx[i] = (h1 + uint32(i)*h2) % sketchWidth
+  }
+
+  return idx
+}
+
+func (c *countMin) add(key string) uint32 {
+  est := ^uint32(0)
+  for row, i := range sketchIndexes(key) {
+    c[row][i]++
+    est = min(est, c[row][i])
+  }
+
+  return est
+}
+
+func (c *countMin) estimate(key string) uint32 {
+  est := ^uint32(0)
+  for row, i := range sketchIndexes(key) {
+    est = min(est, c[row][i])
+  }
+
+  return est
+}
+
+// hitters is a min-heap of the slot's most queried keys, so the least
+// popular of them is the one replaced by a newcomer.
+type hitters struct {
+  items []*hitter
+  index map[string]*hitter
+}
+
+type hitter struct {
+  key   string
+  count uint32
+  pos   int
+}
+
+func (h *hitters) Len() int           { return len(h.items) }
+func (h *hitters) Less(i, j int) bool { return h.items[i].count < h.items[j].count }
+func (h *hitters) Swap(i, j int) {
+  h.items[i], h.items[j] = h.items[j], h.items[i]
+  h.items[i].pos, h.items[j].pos = i, j
+}
+
+func (h *hitters) Push(x any) {
+  it := x.(*hitter)
+  it.pos = len(h.items)
+  h.items = append(h.items, it)
+}
+
+func (h *hitters) Pop() any {
+  it := h.items[len(h.items)-1]
+  h.items = h.items[:len(h.items)-1]
+  return it
+}
+
+func (h *hitters) offer(key string, count uint32) {
+  if it, ok := h.index[key]; ok {
+    it.count = count
+    heap.Fix(h, it.pos)
+    return
+  }
+
+  if len(h.items) < sketchTopK {
+    it := &hitter{key: key, count: count}
+    heap.Push(h, it)
+    h.index[key] = it
+    return
+  }
+
+  if low := h.items[0]; count > low.count {
+    delete(h.index, low.key)
+    low.key, low.count = key, count
+    h.index[key] = low
+    heap.Fix(h, 0)
+  }
+}
+
+// statsSlot covers one hour of queries.
+type statsSlot struct {
+  hour   int64
+  sketch countMin
+  top    hitters
+}
+
+// popularity counts queries per place in hourly slots, each a count-min
+// sketch plus a heap of its heavy hitters, so memory stays fixed however
+// many distinct places are asked for. A window sums the sketches of the
+// slots it spans and ranks the union of their heavy hitters.
+type popularity struct {
+  mu     sync.Mutex
+  slots  []statsSlot
+  places map[string]geo.Location // last seen location of each tracked key
+}
+
+const statsSlotSize = time.Hour
+
+func newPopularity(keep time.Duration) *popularity {
+  n := int(keep / statsSlotSize)
+  if n < 1 {
+    n = 1
+  }
+
+  return &popularity{slots: make([]statsSlot, n), places: make(map[string]geo.Location)}
+}
+
+func (p *popularity) maxWindow() time.Duration {
+  return time.Duration(len(p.slots)) * statsSlotSize
+}
+
+func (p *popularity) record(loc geo.Location) {
+  key := loc.Key()
+  hour := time.Now().Unix() / int64(statsSlotSize/time.Second)
+
+  p.mu.Lock()
+  defer p.mu.Unlock()
+
+  s := &p.slots[hour%int64(len(p.slots))]
+  if s.hour != hour {
+    *s = statsSlot{hour: hour, top: hitters{index: make(map[string]*hitter)}}
+    p.forget()
+  }
+
+  s.top.offer(key, s.sketch.add(key))
+  if _, tracked := s.top.index[key]; tracked {
+    p.places[key] = loc
+  }
+}
+
+// forget drops locations no slot tracks any more.
+func (p *popularity) forget() {
+  for key := range p.places {
+    tracked := false
+    for i := range p.slots {
+      if _, ok := p.slots[i].top.index[key]; ok {
+        tracked = true
+        break
+      }
+    }
+
+    if !tracked {
+      delete(p.places, key)
+    }
+  }
+}
+
+type placeCount struct {
+  geo.Location
+  Count uint32 `json:"count"` // estimate, never an undercount
+}
+
+// top returns the n most queried places over the trailing window.
+func (p *popularity) top(n int, window time.Duration) []placeCount {
+  now := time.Now().Unix() / int64(statsSlotSize/time.Second)
+  span := int64((window + statsSlotSize - 1) / statsSlotSize)
+
+  p.mu.Lock()
+  defer p.mu.Unlock()
+
+  var live []*statsSlot
+  for i := range p.slots {
+    if s := &p.slots[i]; s.top.index != nil && now-s.hour < span {
+      live = append(live, s)
+    }
+  }
+
+  counts := make(map[string]uint32)
+  for _, s := range live {
+    for key := range s.top.index {
+      if _, done := counts[key]; done {
+        continue
+      }
+
+      for _, t := range live {
+        counts[key] += t.sketch.estimate(key)
+      }
+    }
+  }
+
+  pcs := make([]placeCount, 0, len(counts))
+  for key, c := range counts {
+    pcs = append(pcs, placeCount{Location: p.places[key], Count: c})
+  }
+
+  sort.Slice(pcs, func(i, j int) bool { return pcs[i].Count > pcs[j].Count })
+  if len(pcs) > n {
+    pcs = pcs[:n]
+  }
+
+  return pcs
+}
+
+// topCities serves GET /v1/stats/top-cities?window=24h&n=10.
+func (s *server) topCities(w http.ResponseWriter, r *http.Request) {
+  q := r.URL.Query()
+
+  window := 24 * time.Hour
+  if v := q.Get("window"); v != "" {
+    var err error
+    if window, err = time.ParseDuration(v); err != nil || window <= 0 || window > s.popular.maxWindow() {
+      httpError(w, "window must be a duration up to "+s.popular.maxWindow().String(), http.StatusBadRequest)
+      return
+    }
+  }
+
+  n := 10
+  if v := q.Get("n"); v != "" {
+    var err error
+    if n, err = strconv.Atoi(v); err != nil || n < 1 || n > sketchTopK {
+      httpError(w, "n must be 1 to "+strconv.Itoa(sketchTopK), http.StatusBadRequest)
+      return
+    }
+  }
+
+  writeJSON(w, http.StatusOK, map[string]interface{}{
+    "window": window.String(),
+    "cities": s.popular.top(n, window),
+  })
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+  "fmt"
+  "html/template"
+  "net/http"
+  "strings"
+  "time"
+)
+
+// providerStatus is one row of the /status scoreboard.
+type providerStatus struct {
+  Name    string       `json:"name"`
+  Enabled bool         `json:"enabled"`
+  Shadow  bool         `json:"shadow,omitempty"`
+  Status  string       `json:"status"` // ok, degraded, down, idle or disabled
+  Health  healthReport `json:"health"`
+}
+
+// rate sorts providers into the scoreboard's buckets: down when its
+// circuit is open or most calls fail, degraded when it is being retried,
+// fails more than 1 call in 20 or is slower than the latency SLO.
+func (s *server) rate(enabled bool, h healthReport) string {
+  switch {
+  case !enabled:
+    return "disabled"
+  case h.Circuit.State == "open" || (h.SuccessRate != nil && *h.SuccessRate < 0.5):
+    return "down"
+  case h.Calls == 0:
+    return "idle"
+  case h.Circuit.State == "half-open" || *h.SuccessRate < 0.95:
+    return "degraded"
+  }
+
+  if p95, err := time.ParseDuration(h.P95); err == nil && p95 > s.slo.latency {
+    return "degraded"
+  }
+
+  return "ok"
+}
+
+// status answers GET /status with every provider's rolling success rate,
+// latency and last error, and any outage: JSON, or an HTML page for
+// browsers and with ?format=html.
+func (s *server) status(w http.ResponseWriter, r *http.Request) {
+  active := make(map[string]bool)
+  for _, p := range append(s.activeProviders(), s.shadowProviders()...) {
+    active[p.Name()] = true
+  }
+
+  ps := s.providers.get()
+  rows := make([]providerStatus, 0, len(ps))
+  for _, p := range ps {
+    h := s.health.report(p.Name())
+    rows = append(rows, providerStatus{Name: p.Name(), Enabled: active[p.Name()], Shadow: s.providers.isShadow(p.Name()), Status: s.rate(active[p.Name()], h), Health: h})
+  }
+
+  outage := s.health.outage()
+  f := r.URL.Query().Get("format")
+  if f == "html" || (f == "" && strings.Contains(r.Header.Get("Accept"), "text/html")) {
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    statusPage.Execute(w, map[string]interface{}{"Providers": rows, "Outage": outage, "Now": time.Now().UTC().Format(time.RFC3339)})
+    return
+  }
+
+  resp := map[string]interface{}{"providers": rows}
+  if outage != nil {
+    resp["outage"] = outage
+  }
+
+  writeJSON(w, http.StatusOK, resp)
+}
+
+var statusPage = template.Must(template.New("status").Funcs(template.FuncMap{
+  "percent": func(v *float64) string {
+    if v == nil {
+      return "-"
+    }
+
+    return fmt.Sprintf("%.1f%%", *v*100)
+  },
+}).Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>weather-go providers</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: .4em .8em; border-bottom: 1px solid #ddd; text-align: left; }
+.ok { color: #1a7f37; } .degraded { color: #bf8700; } .down { color: #cf222e; } .idle, .disabled { color: #6e7781; }
+</style>
+</head>
+<body>
+<h1>Providers</h1>
+{{with .Outage}}<p class="down">Outage since {{.Since.Format "15:04:05"}}: {{len .Providers}} providers failing with {{.Reason}}.</p>
+{{end}}<table>
+<tr><th>Provider</th><th>Status</th><th>Success</th><th>Calls</th><th>p50</th><th>p95</th><th>Circuit</th><th>Last error</th></tr>
+{{range .Providers}}<tr>
+<td>{{.Name}}{{if .Shadow}} (shadow){{end}}</td>
+<td class="{{.Status}}">{{.Status}}</td>
+<td>{{percent .Health.SuccessRate}}</td>
+<td>{{.Health.Calls}} in {{.Health.Window}}</td>
+<td>{{or .Health.P50 "-"}}</td>
+<td>{{or .Health.P95 "-"}}</td>
+<td>{{.Health.Circuit.State}}</td>
+<td>{{with .Health.LastErrorAt}}{{.Format "15:04:05"}}: {{end}}{{.Health.LastError}}</td>
+</tr>
+{{end}}</table>
+<p>{{.Now}}, refreshes every 10s. <a href="/status?format=json">JSON</a></p>
+</body>
+</html>
+`))
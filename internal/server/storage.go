@@ -0,0 +1,96 @@
+package server
+
+import (
+  "encoding/json"
+  "fmt"
+  "log"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/storage"
+)
+
+// kv is what history and forecast verification need of where they keep
+// readings: the embedded store has it, and backendKV gives it to any
+// storage backend.
+type kv interface {
+  put(bucket, key string, v interface{}) error
+  get(bucket, key string, v interface{}) (bool, error)
+  delete(bucket, key string) error
+  keys(bucket, prefix string) []string
+}
+
+// storageConfig is the config file's "storage": where the aggregate cache
+// and the history are kept, see storage.Open. -cache.storage and
+// -history.storage win over it.
+type storageConfig struct {
+  Cache   string `json:"cache,omitempty"`   // memory by default
+  History string `json:"history,omitempty"` // the embedded store by default
+}
+
+func (c storageConfig) compile() []string {
+  var errs []string
+  if c.Cache != "" {
+    if err := storage.Check(c.Cache); err != nil {
+      errs = append(errs, "cache: "+err.Error())
+    }
+  }
+
+  if c.History != "" && c.History != "store" {
+    if err := storage.Check(c.History); err != nil {
+      errs = append(errs, "history: "+err.Error())
+    }
+  }
+
+  return errs
+}
+
+// openHistoryStorage is db for "store" or an empty spec, and the backend
+// spec names otherwise.
+func openHistoryStorage(spec string, db *store) (kv, error) {
+  if spec == "" || spec == "store" {
+    return db, nil
+  }
+
+  b, err := storage.Open(spec)
+  if err != nil {
+    return nil, fmt.Errorf("history: %w", err)
+  }
+
+  return backendKV{b}, nil
+}
+
+// backendKV stores values as JSON in a storage backend.
+type backendKV struct {
+  b storage.Backend
+}
+
+func (s backendKV) put(bucket, key string, v interface{}) error {
+  raw, err := json.Marshal(v)
+  if err != nil {
+    return err
+  }
+
+  return s.b.Put(bucket, key, raw)
+}
+
+func (s backendKV) get(bucket, key string, v interface{}) (bool, error) {
+  raw, ok, err := s.b.Get(bucket, key)
+  if err != nil || !ok {
+    return false, err
+  }
+
+  return true, json.Unmarshal(raw, v)
+}
+
+func (s backendKV) delete(bucket, key string) error {
+  return s.b.Delete(bucket, key)
+}
+
+// keys lists none when the backend fails; the failure is logged.
+func (s backendKV) keys(bucket, prefix string) []string {
+  ks, err := s.b.Keys(bucket, prefix)
+  if err != nil {
+    log.Printf("storage: %s", err)
+  }
+
+  return ks
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+  "bufio"
+  "encoding/json"
+  "fmt"
+  "io"
+  "log"
+  "os"
+  "sort"
+  "strings"
+  "sync"
+)
+
+// store is a small embedded key/value store grouped in buckets. Mutations
+// are appended to a JSON-lines log which is replayed on open and compacted
+// to one line per key on demand; an empty path keeps everything in memory.
+type store struct {
+  path string
+
+  mu      sync.RWMutex
+  f       *os.File
+  buckets map[string]map[string]json.RawMessage
+}
+
+type storeRecord struct {
+  Op     string          `json:"op"` // put or del
+  Bucket string          `json:"b"`
+  Key    string          `json:"k"`
+  Value  json.RawMessage `json:"v,omitempty"`
+}
+
+// openStore replays the log at path and keeps it open for appends.
+func openStore(path string) (*store, error) {
+  s, err := loadStore(path)
+  if err != nil || path == "" {
+    return s, err
+  }
+
+  if s.f, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600); err != nil {
+    return nil, err
+  }
+
+  return s, nil
+}
+
+// loadStore reads the log at path without opening it for writing, which is
+// safe next to a running server.
+func loadStore(path string) (*store, error) {
+  s := &store{path: path, buckets: make(map[string]map[string]json.RawMessage)}
+  if path == "" {
+    return s, nil
+  }
+
+  f, err := os.Open(path)
+  if os.IsNotExist(err) {
+    return s, nil
+  }
+
+  if err != nil {
+    return nil, err
+  }
+
+  defer f.Close()
+
+  return s, s.replay(f)
+}
+
+func (s *store) replay(r io.Reader) error {
+  sc := bufio.NewScanner(r)
+  sc.Buffer(make([]byte, 64*1024), 16<<20)
+
+  for line := 1; sc.Scan(); line++ {
+    var rec storeRecord
+    if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+      // A crash mid-append leaves a torn last line; anything else is corruption.
+      if !sc.Scan() {
+        log.Printf("store: ignoring torn record at %s:%d", s.path, line)
+        return nil
+      }
+
+      return fmt.Errorf("store %s:%d: %w", s.path, line, err)
+    }
+
+    s.apply(rec)
+  }
+
+  return sc.Err()
+}
+
+func (s *store) apply(rec storeRecord) {
+  b, ok := s.buckets[rec.Bucket]
+  if !ok {
+    b = make(map[string]json.RawMessage)
+    s.buckets[rec.Bucket] = b
+  }
+
+  switch rec.Op {
+  case "put":
+    b[rec.Key] = rec.Value
+  case "del":
+    delete(b, rec.Key)
+  }
+}
+
+func (s *store) write(rec storeRecord) error {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if s.f != nil {
+    line, err := json.Marshal(rec)
+    if err != nil {
+      return err
+    }
+
+    if _, err := s.f.Write(append(line, '\n')); err != nil {
+      return err
+    }
+  }
+
+  s.apply(rec)
+  return nil
+}
+
+func (s *store) put(bucket, key string, v interface{}) error {
+  raw, err := json.Marshal(v)
+  if err != nil {
+    return err
+  }
+
+  return s.write(storeRecord{Op: "put", Bucket: bucket, Key: key, Value: raw})
+}
+
+func (s *store) delete(bucket, key string) error {
+  return s.write(storeRecord{Op: "del", Bucket: bucket, Key: key})
+}
+
+// get decodes the value at bucket/key into v and reports whether it exists.
+func (s *store) get(bucket, key string, v interface{}) (bool, error) {
+  s.mu.RLock()
+  raw, ok := s.buckets[bucket][key]
+  s.mu.RUnlock()
+
+  if !ok {
+    return false, nil
+  }
+
+  return true, json.Unmarshal(raw, v)
+}
+
+// keys lists the keys of a bucket starting with prefix, sorted.
+func (s *store) keys(bucket, prefix string) []string {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+
+  var ks []string
+  for k := range s.buckets[bucket] {
+    if strings.HasPrefix(k, prefix) {
+      ks = append(ks, k)
+    }
+  }
+
+  sort.Strings(ks)
+  return ks
+}
+
+func (s *store) bucketNames() []string {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+
+  names := make([]string, 0, len(s.buckets))
+  for b, kv := range s.buckets {
+    if len(kv) > 0 {
+      names = append(names, b)
+    }
+  }
+
+  sort.Strings(names)
+  return names
+}
+
+// snapshot writes the current state as a compact log, one put per key.
+func (s *store) snapshot(w io.Writer) error {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+
+  return s.writeSnapshot(w)
+}
+
+func (s *store) writeSnapshot(w io.Writer) error {
+  enc := json.NewEncoder(w)
+  for _, b := range sortedKeys(s.buckets) {
+    kv := s.buckets[b]
+    keys := make([]string, 0, len(kv))
+    for k := range kv {
+      keys = append(keys, k)
+    }
+
+    sort.Strings(keys)
+
+    for _, k := range keys {
+      if err := enc.Encode(storeRecord{Op: "put", Bucket: b, Key: k, Value: kv[k]}); err != nil {
+        return err
+      }
+    }
+  }
+
+  return nil
+}
+
+func sortedKeys(m map[string]map[string]json.RawMessage) []string {
+  ks := make([]string, 0, len(m))
+  for k := range m {
+    ks = append(ks, k)
+  }
+
+  sort.Strings(ks)
+  return ks
+}
+
+// compact rewrites the log to the current state so it doesn't grow forever.
+func (s *store) compact() error {
+  if s.path == "" {
+    return nil
+  }
+
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  tmp := s.path + ".compact"
+  f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+  if err != nil {
+    return err
+  }
+
+  if err := s.writeSnapshot(f); err != nil {
+    f.Close()
+    return err
+  }
+
+  if err := f.Close(); err != nil {
+    return err
+  }
+
+  if err := os.Rename(tmp, s.path); err != nil {
+    return err
+  }
+
+  if s.f == nil {
+    return nil
+  }
+
+  s.f.Close()
+  s.f, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o600)
+  return err
+}
+
+func (s *store) close() error {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if s.f == nil {
+    return nil
+  }
+
+  err := s.f.Close()
+  s.f = nil
+  return err
+}
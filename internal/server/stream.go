@@ -0,0 +1,71 @@
+package server
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net/http"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+var streamSubscribers = metrics.NewGauge("stream_subscribers", "Clients connected to /v1/stream.")
+
+// stream serves GET /v1/stream/{city} (or ?lat=&lon=) as Server-Sent Events,
+// one "reading" event per poll. With -smooth.alpha the temperature is the
+// moving average and raw_temp the reading.
+func (s *server) stream(w http.ResponseWriter, r *http.Request) {
+  flusher, ok := w.(http.Flusher)
+  if !ok {
+    httpError(w, "streaming unsupported", http.StatusInternalServerError)
+    return
+  }
+
+  loc, err := requestLocation(upstream.WithTrace(r), r, s.geo)
+  var amb *geo.AmbiguousError
+  if errors.As(err, &amb) {
+    writeCandidates(w, amb)
+    return
+  }
+
+  if err != nil {
+    writeError(w, err, locationStatus(err))
+    return
+  }
+
+  updates, unsubscribe := s.feeds.subscribe(loc, s.feeds.interval, summary)
+  defer unsubscribe()
+
+  streamSubscribers.Add(1)
+  defer streamSubscribers.Add(-1)
+
+  w.Header().Set("Content-Type", "text/event-stream")
+  w.Header().Set("Cache-Control", "no-cache")
+  w.Header().Set("X-Accel-Buffering", "no")
+  fmt.Fprintf(w, "retry: %d\n\n", s.feeds.interval.Milliseconds())
+  flusher.Flush()
+
+  for {
+    select {
+    case <-r.Context().Done():
+      return
+    case <-s.stopping:
+      return
+    case reading := <-updates:
+      s.smooth(reading, loc)
+      reading.show(s.defaultDisplay())
+      data, err := json.Marshal(reading)
+      if err != nil {
+        return
+      }
+
+      if _, err := fmt.Fprintf(w, "event: reading\ndata: %s\n\n", data); err != nil {
+        return
+      }
+
+      flusher.Flush()
+    }
+  }
+}
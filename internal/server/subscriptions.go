@@ -0,0 +1,208 @@
+package server
+
+import (
+  "bytes"
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "log"
+  "net/http"
+  "net/url"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// subscription asks for the reading of a city to be POSTed to a webhook
+// every interval.
+type subscription struct {
+  resourceMeta
+  City     string   `json:"city"`
+  URL      string   `json:"url"`
+  Interval duration `json:"interval"`
+}
+
+func (s *subscription) validate() error {
+  if s.City == "" {
+    return errors.New("subscription needs a city")
+  }
+
+  if err := geo.ParseCity(s.City).Validate(); err != nil {
+    return err
+  }
+
+  u, err := url.Parse(s.URL)
+  if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+    return fmt.Errorf("subscription url %q must be an absolute http(s) URL", s.URL)
+  }
+
+  if time.Duration(s.Interval) < time.Minute {
+    return errors.New("subscription interval must be at least 1m")
+  }
+
+  return nil
+}
+
+// watchlist is a named list of cities fetched together.
+type watchlist struct {
+  resourceMeta
+  Name   string   `json:"name"`
+  Cities []string `json:"cities"`
+}
+
+func (wl *watchlist) validate() error {
+  if wl.Name == "" {
+    return errors.New("watchlist needs a name")
+  }
+
+  if len(wl.Cities) == 0 {
+    return errors.New("watchlist needs at least one city")
+  }
+
+  return validCities(wl.Cities)
+}
+
+func newSubscriptions(db *store) *collection {
+  return &collection{db: db, name: "subscriptions", newItem: func() resource { return &subscription{} }}
+}
+
+func newWatchlists(db *store) *collection {
+  return &collection{db: db, name: "watchlists", newItem: func() resource { return &watchlist{} }}
+}
+
+// watchlistWeather serves GET /v1/watchlists/{id}/weather through the batch
+// machinery.
+func (s *server) watchlistWeather(w http.ResponseWriter, r *http.Request) {
+  format, ok := responseFormat(w, r, "geojson")
+  if !ok {
+    return
+  }
+
+  d, ok := s.requestDisplay(w, r)
+  if !ok {
+    return
+  }
+
+  item, ok := s.watchlists.lookup(w, r)
+  if !ok {
+    return
+  }
+
+  if item.meta().DeletedAt != nil {
+    httpError(w, "watchlist "+item.meta().ID+" is deleted", http.StatusGone)
+    return
+  }
+
+  begin := time.Now()
+  wl := item.(*watchlist)
+  if len(wl.Cities) > s.batchMax {
+    httpError(w, fmt.Sprintf("watchlist has %d cities, over the batch limit of %d", len(wl.Cities), s.batchMax), http.StatusUnprocessableEntity)
+    return
+  }
+
+  results := s.lookupAll(upstream.WithTrace(r), wl.Cities, detailOf(r))
+  showAll(results, d)
+  s.cacheHeaders.set(w, r, results...)
+  took := time.Since(begin).String()
+
+  if format == "geojson" {
+    writeGeoJSON(w, http.StatusOK, featureCollection(results, map[string]interface{}{"watchlist": wl.Name, "took": took}))
+    return
+  }
+
+  writeJSON(w, http.StatusOK, WatchlistResponse{SchemaVersion: responseVersion, Watchlist: wl.Name, Results: results, Took: took})
+}
+
+var (
+  webhookClient     = &http.Client{Timeout: 10 * time.Second}
+  webhookDeliveries = metrics.NewCounter("webhook_deliveries_total", "Subscription webhook deliveries by outcome.", "outcome")
+)
+
+// dispatcher delivers due subscriptions. Last delivery times are kept in
+// memory, so a restart delivers every subscription once right away.
+type dispatcher struct {
+  srv  *server
+  subs *collection
+
+  mu   sync.Mutex
+  last map[string]time.Time
+}
+
+func newDispatcher(srv *server, subs *collection) *dispatcher {
+  return &dispatcher{srv: srv, subs: subs, last: make(map[string]time.Time)}
+}
+
+func (d *dispatcher) run() {
+  for now := range time.Tick(30 * time.Second) {
+    d.tick(now)
+  }
+}
+
+func (d *dispatcher) tick(now time.Time) {
+  items, err := d.subs.list(false)
+  if err != nil {
+    log.Printf("dispatcher: %s", err)
+    return
+  }
+
+  for _, item := range items {
+    sub := item.(*subscription)
+
+    d.mu.Lock()
+    due := now.Sub(d.last[sub.ID]) >= time.Duration(sub.Interval)
+    if due {
+      d.last[sub.ID] = now
+    }
+    d.mu.Unlock()
+
+    if due {
+      go d.deliver(sub)
+    }
+  }
+}
+
+func (d *dispatcher) deliver(sub *subscription) {
+  ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+  defer cancel()
+
+  reading := d.srv.lookupCity(ctx, sub.City, summary)
+  reading.show(d.srv.defaultDisplay())
+  body, err := json.Marshal(map[string]interface{}{
+    "subscription": sub.ID,
+    "reading":      reading,
+  })
+  if err != nil {
+    log.Printf("dispatcher: %s: %s", sub.ID, err)
+    return
+  }
+
+  req, err := http.NewRequestWithContext(ctx, "POST", sub.URL, bytes.NewReader(body))
+  if err != nil {
+    log.Printf("dispatcher: %s: %s", sub.ID, err)
+    return
+  }
+
+  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set("User-Agent", upstream.UserAgent())
+
+  resp, err := webhookClient.Do(req)
+  if err != nil {
+    webhookDeliveries.Inc("error")
+    log.Printf("dispatcher: %s: %s", sub.ID, err)
+    return
+  }
+
+  resp.Body.Close()
+
+  if resp.StatusCode >= 300 {
+    webhookDeliveries.Inc("rejected")
+    log.Printf("dispatcher: %s: %s answered %s", sub.ID, sub.URL, resp.Status)
+    return
+  }
+
+  webhookDeliveries.Inc("ok")
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+  "log"
+  "net/http"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+var providerSunsetDays = metrics.NewGauge("provider_sunset_days", "Days until a provider's API is announced to stop answering; negative once it has.", "provider")
+
+// watchSunsets warns about providers whose API is retired or sunsets
+// within warn, at startup and then daily, so the end of a provider shows
+// up in the logs well before it shows up as failed readings.
+func watchSunsets(ps providers.Multi, warn time.Duration) {
+  check := func() {
+    now := time.Now()
+    for _, p := range ps {
+      l, ok := providers.SunsetOf(p)
+      if !ok {
+        continue
+      }
+
+      providerSunsetDays.Set(l.Sunset.Sub(now).Hours()/24, p.Name())
+      if msg := l.Warning(p.Name(), now, warn); msg != "" {
+        log.Printf("providers: %s", msg)
+      }
+    }
+  }
+
+  check()
+  for range time.Tick(24 * time.Hour) {
+    check()
+  }
+}
+
+// providerList answers GET /v1/providers with every configured provider,
+// whether an override switched it off or it is a shadow, its terms, credit
+// and planned sunset.
+func (s *server) providerList(w http.ResponseWriter, r *http.Request) {
+  active := make(map[string]bool)
+  for _, p := range append(s.activeProviders(), s.shadowProviders()...) {
+    active[p.Name()] = true
+  }
+
+  now := time.Now()
+  ps := s.providers.get()
+  list := make([]map[string]interface{}, 0, len(ps))
+  for _, p := range ps {
+    item := map[string]interface{}{"name": p.Name(), "enabled": active[p.Name()]}
+    if s.providers.isShadow(p.Name()) {
+      item["shadow"] = true
+    }
+
+    if _, ok := p.(providers.Forecaster); ok {
+      item["forecasts"] = true
+    }
+
+    if _, ok := p.(providers.UVReporter); ok {
+      item["uv"] = true
+    }
+
+    if _, ok := p.(providers.PollenReporter); ok {
+      item["pollen"] = true
+    }
+
+    if _, ok := p.(providers.MarineReporter); ok {
+      item["marine"] = true
+    }
+
+    if _, ok := p.(providers.SoilReporter); ok {
+      item["soil"] = true
+    }
+
+    if l, ok := p.(providers.Licensed); ok {
+      t := l.Terms()
+      terms := map[string]interface{}{"commercial": t.Commercial}
+      if t.MaxCache > 0 {
+        terms["max_cache"] = t.MaxCache.String()
+      }
+
+      item["terms"] = terms
+    }
+
+    if credit := providers.Attributions(providers.Multi{p}); len(credit) > 0 {
+      item["attribution"] = credit[0]
+    }
+
+    if l, ok := providers.SunsetOf(p); ok {
+      d := map[string]interface{}{
+        "sunset":    l.Sunset.Format(time.DateOnly),
+        "days_left": max(int(l.Sunset.Sub(now).Hours()/24), 0),
+        "retired":   !now.Before(l.Sunset),
+      }
+
+      if l.Notice != "" {
+        d["notice"] = l.Notice
+      }
+
+      if l.URL != "" {
+        d["url"] = l.URL
+      }
+
+      item["deprecation"] = d
+    }
+
+    list = append(list, item)
+  }
+
+  writeJSON(w, http.StatusOK, map[string]interface{}{"providers": list})
+}
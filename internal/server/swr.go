@@ -0,0 +1,42 @@
+package server
+
+import (
+  "context"
+  "fmt"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/cache"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+// revalidate refreshes an expired entry, waiting up to -cache.swr.wait for
+// the providers. If they fail or are slower than that, the stale entry is
+// answered with the reason, and a slow refresh goes on in the background to
+// update the cache for the next request. Requests for the same place join
+// one refresh. Stale answers the providers failed are degraded.
+func (s *server) revalidate(ctx context.Context, loc geo.Location, active providers.Multi, e cache.Entry) (answer, string, bool) {
+  stale := cachedAnswer(e)
+  f := s.fly(ctx, loc, active, nil, false, false)
+
+  t := time.NewTimer(s.swrWait)
+  defer t.Stop()
+
+  select {
+  case <-f.done:
+    if f.a.err == nil {
+      return f.a, "", false
+    }
+
+    return stale, "providers failed: " + f.a.err.Error(), true
+  case <-t.C:
+    return stale, fmt.Sprintf("providers slower than %s", s.swrWait), false
+  case <-ctx.Done():
+    return answer{err: ctx.Err()}, "", false
+  }
+}
+
+// age is how old a cached reading is, to the second.
+func age(e cache.Entry) string {
+  return time.Since(e.Stored).Round(time.Second).String()
+}
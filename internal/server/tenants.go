@@ -0,0 +1,183 @@
+package server
+
+import (
+  "context"
+  "fmt"
+  "sort"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/cache"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+var tenantQuotaRemaining = metrics.NewGauge("tenant_quota_remaining", "Calls left in a tenant's budget window for a provider.", "tenant", "provider", "window")
+
+// tenantConfig is a team sharing the deployment on its own upstream
+// accounts: the config file's "tenants". Its clients name it in their
+// "tenant" and get only its providers, called with its keys and counted
+// against its budgets; providers it has no key for use the deployment's.
+type tenantConfig struct {
+  Name      string            `json:"name"`
+  Providers []string          `json:"providers,omitempty"` // enabled for it; empty is all of the deployment's
+  APIKeys   map[string]string `json:"api_keys,omitempty"`  // by provider
+  Quotas    map[string]string `json:"quotas,omitempty"`    // by provider, <calls>/<s|m|h|d>,... as -provider.quota
+
+  budgets quotaSet
+}
+
+func (tc *tenantConfig) compile() []string {
+  var es []string
+  if !groupName.MatchString(tc.Name) {
+    es = append(es, fmt.Sprintf("name: %q must be lowercase letters, digits, '.', '_' or '-'", tc.Name))
+  }
+
+  for name, key := range tc.APIKeys {
+    if key == "" {
+      es = append(es, fmt.Sprintf("api_keys.%s: is empty", name))
+    }
+  }
+
+  tc.budgets = quotaSet{}
+  for name, q := range tc.Quotas {
+    if err := tc.budgets.Set(name + "=" + q); err != nil {
+      es = append(es, "quotas: "+err.Error())
+    }
+  }
+
+  sort.Strings(es)
+  return es
+}
+
+// unknownTenantProviders lists what the tenants name that the deployment
+// doesn't have, or that takes no key when given one.
+func unknownTenantProviders(tcs []tenantConfig, configured providers.Multi) []string {
+  known := make(map[string]providers.Provider, len(configured))
+  for _, p := range configured {
+    known[p.Name()] = p
+  }
+
+  var errs []string
+  for i, tc := range tcs {
+    for _, name := range tc.Providers {
+      if known[name] == nil {
+        errs = append(errs, fmt.Sprintf("tenants[%d].providers: %q is not a configured provider", i, name))
+      }
+    }
+
+    for name := range tc.APIKeys {
+      if _, ok := known[name].(providers.Keyed); !ok {
+        errs = append(errs, fmt.Sprintf("tenants[%d].api_keys: %q is not a configured provider that takes a key", i, name))
+      }
+    }
+  }
+
+  sort.Strings(errs)
+  return errs
+}
+
+// tenant keeps what a tenant's lookups mustn't share with the rest: its
+// budgets, its circuits, since a bad key of its own is its problem, and
+// the caches, so no one else's calls answer for it, nor its for them.
+type tenant struct {
+  name     string
+  enabled  map[string]bool // nil is every provider
+  keys     map[string]string
+  quotas   *quotas
+  health   *providerHealth
+  cache    *cache.Readings
+  readings *cache.ProviderReadings
+}
+
+// newTenants builds the tenants in tcs, their caches in memory with the
+// deployment's TTLs, their circuits with its breaker settings.
+func newTenants(tcs []tenantConfig, ttl, staleFor time.Duration, ttls cache.TTLSet, health func() *providerHealth) map[string]*tenant {
+  ts := make(map[string]*tenant, len(tcs))
+  for _, tc := range tcs {
+    t := &tenant{
+      name:     tc.Name,
+      keys:     tc.APIKeys,
+      quotas:   newQuotas(tc.budgets),
+      health:   health(),
+      cache:    cache.New(ttl, staleFor, nil),
+      readings: cache.NewProviderReadings(ttls),
+    }
+
+    t.quotas.tenant = tc.Name
+    if len(tc.Providers) > 0 {
+      t.enabled = make(map[string]bool, len(tc.Providers))
+      for _, name := range tc.Providers {
+        t.enabled[name] = true
+      }
+    }
+
+    ts[tc.Name] = t
+  }
+
+  return ts
+}
+
+// providers narrows ps to what t may use, on its keys; nil t leaves them.
+func (t *tenant) providers(ps providers.Multi) providers.Multi {
+  if t == nil {
+    return ps
+  }
+
+  mine := make(providers.Multi, 0, len(ps))
+  for _, p := range ps {
+    if t.enabled != nil && !t.enabled[p.Name()] {
+      continue
+    }
+
+    if key, ok := t.keys[p.Name()]; ok {
+      p = p.(providers.Keyed).WithAPIKey(key)
+    }
+
+    mine = append(mine, p)
+  }
+
+  return mine
+}
+
+// tenantFor is the tenant of the client making the request ctx belongs to,
+// nil for clients of none and anonymous requests.
+func (s *server) tenantFor(ctx context.Context) *tenant {
+  c, ok := clientFrom(ctx)
+  if !ok || c.Tenant == "" {
+    return nil
+  }
+
+  return s.tenants[c.Tenant]
+}
+
+func (s *server) quotasFor(ctx context.Context) *quotas {
+  if t := s.tenantFor(ctx); t != nil {
+    return t.quotas
+  }
+
+  return s.quotas
+}
+
+func (s *server) healthFor(ctx context.Context) *providerHealth {
+  if t := s.tenantFor(ctx); t != nil {
+    return t.health
+  }
+
+  return s.health
+}
+
+func (s *server) cacheFor(ctx context.Context) *cache.Readings {
+  if t := s.tenantFor(ctx); t != nil {
+    return t.cache
+  }
+
+  return s.cache
+}
+
+func (s *server) readingsFor(ctx context.Context) *cache.ProviderReadings {
+  if t := s.tenantFor(ctx); t != nil {
+    return t.readings
+  }
+
+  return s.readings
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+  "crypto/tls"
+  "log"
+  "net/http"
+  "os"
+  "sync"
+  "time"
+)
+
+// certFiles serves the -tls.cert/-tls.key pair and picks up renewals made
+// by an external tool: the files are re-read when the certificate changes,
+// checked at most once a minute.
+type certFiles struct {
+  cert, key string
+
+  mu      sync.Mutex
+  loaded  *tls.Certificate
+  modTime time.Time
+  checked time.Time
+}
+
+func loadCertFiles(cert, key string) (*certFiles, error) {
+  c := &certFiles{cert: cert, key: key}
+  return c, c.reload()
+}
+
+func (c *certFiles) reload() error {
+  fi, err := os.Stat(c.cert)
+  if err != nil {
+    return err
+  }
+
+  c.checked = time.Now()
+  if c.loaded != nil && fi.ModTime().Equal(c.modTime) {
+    return nil
+  }
+
+  pair, err := tls.LoadX509KeyPair(c.cert, c.key)
+  if err != nil {
+    return err
+  }
+
+  c.loaded, c.modTime = &pair, fi.ModTime()
+  return nil
+}
+
+func (c *certFiles) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  if time.Since(c.checked) > time.Minute {
+    // A half-written renewal keeps the old certificate until the next check.
+    if err := c.reload(); err != nil {
+      log.Printf("tls: reloading %s: %s", c.cert, err)
+    }
+  }
+
+  return c.loaded, nil
+}
+
+// newHTTPServer serves h on addr, over HTTPS when getCert is set. HTTP/2
+// is negotiated with TLS clients; plain HTTP stays HTTP/1.1. Connections
+// whose headers take longer than headerTimeout to arrive are closed, so
+// slow clients can't hold them open by the thousand.
+func newHTTPServer(addr string, h http.Handler, headerTimeout time.Duration, getCert func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *http.Server {
+  hs := &http.Server{Addr: addr, Handler: h, ReadHeaderTimeout: headerTimeout}
+  if getCert != nil {
+    hs.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: getCert}
+  }
+
+  return hs
+}
+
+// serve listens until hs is shut down.
+func serve(hs *http.Server) error {
+  if hs.TLSConfig == nil {
+    return hs.ListenAndServe()
+  }
+
+  return hs.ListenAndServeTLS("", "")
+}
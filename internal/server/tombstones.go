@@ -0,0 +1,81 @@
+package server
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+var tombstoneHits = metrics.NewCounter("lookups_tombstoned_total", "Lookups answered 404 because every provider had said the place doesn't exist.")
+
+// Past this many tombstones, burying one first clears the expired.
+const sweepTombstones = 10000
+
+// tombstones remembers the places every provider said don't exist, for
+// -cache.notfound.ttl, so a client retrying a typo is answered 404 at once
+// rather than asking them all again.
+type tombstones struct {
+  ttl time.Duration // 0 remembers none
+
+  mu    sync.Mutex
+  until map[string]time.Time // by location key
+}
+
+func newTombstones(ttl time.Duration) *tombstones {
+  return &tombstones{ttl: ttl, until: make(map[string]time.Time)}
+}
+
+// bury remembers key when every reading that was asked says the city
+// doesn't exist.
+func (t *tombstones) bury(key string, rs []providers.Reading) {
+  if t.ttl <= 0 || !unknownToAll(rs) {
+    return
+  }
+
+  now := time.Now()
+  t.mu.Lock()
+  defer t.mu.Unlock()
+
+  if len(t.until) >= sweepTombstones {
+    for k, until := range t.until {
+      if !now.Before(until) {
+        delete(t.until, k)
+      }
+    }
+  }
+
+  t.until[key] = now.Add(t.ttl)
+}
+
+// buried is the error to answer a lookup of key with while it is
+// remembered, nil otherwise.
+func (t *tombstones) buried(key string) error {
+  t.mu.Lock()
+  until, ok := t.until[key]
+  t.mu.Unlock()
+
+  if !ok || !time.Now().Before(until) {
+    return nil
+  }
+
+  tombstoneHits.Inc()
+  return fmt.Errorf("%w: no provider knows this place, remembered until %s", providers.ErrCityNotFound, until.UTC().Format(time.RFC3339))
+}
+
+// unknownToAll is every provider having answered not found. One that a
+// fail-fast fan-out cancelled never answered, so it doesn't agree: else a
+// single provider behind on its data would bury a real city for everyone.
+func unknownToAll(rs []providers.Reading) bool {
+  for _, r := range rs {
+    if !errors.Is(r.Err, providers.ErrCityNotFound) || errors.Is(r.Err, providers.ErrCancelled) || errors.Is(r.Err, context.Canceled) {
+      return false
+    }
+  }
+
+  return len(rs) > 0
+}
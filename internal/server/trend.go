@@ -0,0 +1,105 @@
+package server
+
+import (
+  "errors"
+  "fmt"
+  "math"
+  "net/http"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+var errNoTrend = errors.New("no reading of this place stored in the last hour; its history builds up as it is looked up or pre-warmed")
+
+// The spans a trend gives the change over. The trend itself is over 3
+// hours, the span forecasters read a tendency over.
+var trendWindows = []time.Duration{time.Hour, 3 * time.Hour, 6 * time.Hour, 24 * time.Hour}
+
+const trendWindow = 3 * time.Hour
+
+// A change of less than this an hour is steady.
+const steadyRate = 0.2 // K
+
+// trend answers GET /v1/trend/{city} (or ?lat=&lon=) with how much the
+// temperature has changed over each of trendWindows, from the stored
+// history, so clients can show a trend arrow without keeping readings of
+// their own.
+func (s *server) trend(w http.ResponseWriter, r *http.Request) {
+  begin := time.Now()
+  ctx := upstream.WithTrace(r)
+
+  d, ok := s.requestDisplay(w, r)
+  if !ok {
+    return
+  }
+
+  loc, ok := s.placeFor(ctx, w, r, requestLocation)
+  if !ok {
+    return
+  }
+
+  longest := trendWindows[len(trendWindows)-1]
+  rs, err := s.history.series(loc, begin.Add(-longest-trendMatch(longest)))
+  if err != nil {
+    writeError(w, err, http.StatusInternalServerError)
+    return
+  }
+
+  rs = s.policies.history(rs, begin)
+  if len(rs) == 0 || begin.Sub(rs[len(rs)-1].Time) > time.Hour {
+    httpError(w, errNoTrend.Error(), http.StatusNotFound)
+    return
+  }
+
+  now := rs[len(rs)-1]
+  resp := &TrendResponse{SchemaVersion: responseVersion, City: loc.Name, Region: loc.Region, Country: loc.Country, Lat: loc.Lat, Lon: loc.Lon, TimeZone: loc.TimeZone,
+    Temp: d.temp(&now.Kelvin), Units: d.units, Time: now.Time}
+  for _, window := range trendWindows {
+    c := TrendChange{Window: fmt.Sprintf("%dh", int(window.Hours()))}
+    if then, ok := nearestReading(rs, now.Time.Add(-window), trendMatch(window)); ok && then.Time.Before(now.Time) {
+      delta := now.Kelvin - then.Kelvin
+      c.Change, c.From, c.Trend = d.change(then.Kelvin, now.Kelvin), &then.Time, tendency(delta, now.Time.Sub(then.Time))
+      if window == trendWindow {
+        resp.Trend = c.Trend
+      }
+    }
+
+    resp.Changes = append(resp.Changes, c)
+  }
+
+  resp.Took = time.Since(begin).String()
+  writeJSON(w, http.StatusOK, resp)
+}
+
+// trendMatch is how far from the start of window a reading may be to stand
+// for it: a quarter of it, up to half an hour.
+func trendMatch(window time.Duration) time.Duration {
+  return min(window/4, 30*time.Minute)
+}
+
+// nearestReading is the reading of rs nearest t, within within.
+func nearestReading(rs []historyReading, t time.Time, within time.Duration) (historyReading, bool) {
+  var near historyReading
+  found := false
+  for _, r := range rs {
+    if off := r.Time.Sub(t).Abs(); off <= within && (!found || off < near.Time.Sub(t).Abs()) {
+      near, found = r, true
+    }
+  }
+
+  return near, found
+}
+
+// tendency is rising, falling or steady for a change of delta kelvin over
+// span.
+func tendency(delta float64, span time.Duration) string {
+  switch {
+  case math.Abs(delta) < steadyRate*span.Hours():
+    return "steady"
+  case delta > 0:
+    return "rising"
+  default:
+    return "falling"
+  }
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "math"
+  "net/http"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+var errNoUV = errors.New("no enabled provider has a UV index for this place")
+
+// uv answers GET /v1/uv/{city} (or ?lat=&lon=) with the UV index now, the
+// mean of the providers', and the WHO's risk category for it.
+func (s *server) uv(w http.ResponseWriter, r *http.Request) {
+  begin := time.Now()
+  ctx := upstream.WithTrace(r)
+
+  loc, ok := s.placeFor(ctx, w, r, requestLocation)
+  if !ok {
+    return
+  }
+
+  replies := askEach(ctx, s, loc, providers.ErrNoUV, func(p providers.Provider) (func(context.Context, geo.Location) (float64, error), bool) {
+    u, ok := p.(providers.UVReporter)
+    if !ok {
+      return nil, false
+    }
+
+    return u.UV, true
+  })
+
+  resp := &UVResponse{SchemaVersion: responseVersion, City: loc.Name, Region: loc.Region, Country: loc.Country, Lat: loc.Lat, Lon: loc.Lon, TimeZone: loc.TimeZone}
+  sum := 0.0
+  for _, a := range replies {
+    src := UVSource{Provider: a.p.Name(), Omitted: a.omitted, Error: a.err, Took: a.took}
+    if a.ok {
+      v := math.Round(a.v*10) / 10
+      src.UVIndex = &v
+      sum += a.v
+      resp.ProviderCount++
+      resp.Attribution = append(resp.Attribution, providers.Attributions(providers.Multi{a.p})...)
+    }
+
+    resp.Providers = append(resp.Providers, src)
+  }
+
+  if resp.ProviderCount == 0 {
+    httpError(w, errNoUV.Error()+replyFailures(replies), repliesStatus(replies))
+    return
+  }
+
+  resp.UVIndex = math.Round(sum/float64(resp.ProviderCount)*10) / 10
+  resp.Risk = uvRisk(resp.UVIndex)
+  resp.Took = time.Since(begin).String()
+  writeJSON(w, http.StatusOK, resp)
+}
+
+// uvRisk is the WHO's exposure category for a UV index.
+func uvRisk(index float64) string {
+  switch i := math.Round(index); {
+  case i < 3:
+    return "low"
+  case i < 6:
+    return "moderate"
+  case i < 8:
+    return "high"
+  case i < 11:
+    return "very high"
+  default:
+    return "extreme"
+  }
+}
+
+// placeFor is the place resolve finds for a UV, pollen or marine request,
+// with its time zone; when there is none it has answered the request
+// already.
+func (s *server) placeFor(ctx context.Context, w http.ResponseWriter, r *http.Request, resolve func(context.Context, *http.Request, geo.Geocoder) (geo.Location, error)) (geo.Location, bool) {
+  loc, err := resolve(ctx, r, s.geo)
+  var amb *geo.AmbiguousError
+  if errors.As(err, &amb) {
+    writeCandidates(w, amb)
+    return geo.Location{}, false
+  }
+
+  if err != nil {
+    writeError(w, err, locationStatus(err))
+    return geo.Location{}, false
+  }
+
+  return s.zones.Locate(ctx, loc), true
+}
+
+// reply is one provider's answer to askEach.
+type reply[T any] struct {
+  p                  providers.Provider
+  v                  T
+  ok                 bool
+  omitted, err, took string
+}
+
+// askEach asks every available provider that has the reading method picks
+// out, in parallel. Providers answering none are omitted rather than
+// failing; as with nowcasts, the outcome doesn't count against a provider's
+// health, since these are separate products of its API.
+func askEach[T any](ctx context.Context, s *server, loc geo.Location, none error, method func(providers.Provider) (func(context.Context, geo.Location) (T, error), bool)) []reply[T] {
+  active := s.activeFor(ctx, loc)
+
+  var replies []reply[T]
+  var reads []func(context.Context, geo.Location) (T, error)
+  for _, p := range active {
+    if read, ok := method(p); ok {
+      replies = append(replies, reply[T]{p: p})
+      reads = append(reads, read)
+    }
+  }
+
+  var wg sync.WaitGroup
+  for i := range replies {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+
+      begin := time.Now()
+      v, err := reads[i](ctx, loc)
+      a := &replies[i]
+      switch {
+      case errors.Is(err, none):
+        a.omitted = err.Error()
+      case err != nil:
+        a.err, a.took = err.Error(), time.Since(begin).String()
+      default:
+        a.v, a.ok, a.took = v, true, time.Since(begin).String()
+      }
+    }()
+  }
+
+  wg.Wait()
+
+  for _, a := range replies {
+    if a.omitted == "" {
+      s.quotasFor(ctx).spend(a.p.Name())
+    }
+  }
+
+  return replies
+}
+
+// repliesStatus is 502 when a provider failed and 404 when they all had
+// nothing.
+func repliesStatus[T any](replies []reply[T]) int {
+  for _, a := range replies {
+    if a.err != "" {
+      return http.StatusBadGateway
+    }
+  }
+
+  return http.StatusNotFound
+}
+
+func replyFailures[T any](replies []reply[T]) string {
+  msg := ""
+  for _, a := range replies {
+    if a.err != "" {
+      msg += fmt.Sprintf("; %s: %s", a.p.Name(), a.err)
+    }
+  }
+
+  return msg
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+  "context"
+  "fmt"
+  "log"
+  "math"
+  "net/http"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+)
+
+var forecastsIssued = metrics.NewCounter("forecasts_issued_total", "Forecasts stored for verification, by provider and outcome.", "provider", "outcome")
+
+// issuedForecast is a forecast point waiting for its observation.
+type issuedForecast struct {
+  Provider string    `json:"provider"`
+  Issued   time.Time `json:"issued"`
+  Valid    time.Time `json:"valid"`
+  Kelvin   float64   `json:"temp"`
+}
+
+// skill accumulates forecast errors for one provider and lead time.
+type skill struct {
+  N      int     `json:"n"`
+  AbsErr float64 `json:"abs_err"` // sum of |forecast - observed|
+  Err    float64 `json:"err"`     // sum of forecast - observed
+}
+
+const (
+  forecastBucket     = "forecasts"
+  verificationBucket = "verification"
+)
+
+// A forecast is verified by the first observation within this of its
+// valid time; without one by then it is dropped unscored.
+const verifyWindow = 30 * time.Minute
+
+// verifier issues forecasts for places that are being observed and scores
+// them against the aggregate once their time comes. Forecasts wait in the
+// "forecasts" bucket keyed by place and valid time, so a prefix scan finds
+// the due ones; scores accumulate in the "verification" bucket.
+type verifier struct {
+  srv   *server
+  every time.Duration // how often a place gets a new forecast; 0 disables
+  hours int
+
+  mu     sync.Mutex
+  issued map[string]time.Time
+}
+
+func newVerifier(srv *server, every time.Duration, hours int) *verifier {
+  return &verifier{srv: srv, every: every, hours: hours, issued: make(map[string]time.Time)}
+}
+
+func (v *verifier) send(ctx context.Context, loc geo.Location, obs historyReading) {
+  if v.every <= 0 {
+    return
+  }
+
+  v.score(loc, obs)
+
+  key := loc.Key()
+  v.mu.Lock()
+  due := obs.Time.Sub(v.issued[key]) >= v.every
+  if due {
+    v.issued[key] = obs.Time
+  }
+  v.mu.Unlock()
+
+  if due {
+    go v.issue(context.WithoutCancel(ctx), loc)
+  }
+}
+
+// issue asks loc's providers for a forecast as the caller whose lookup
+// made it due, whose circuits and quotas it goes by; ctx is that lookup's,
+// without its end.
+func (v *verifier) issue(ctx context.Context, loc geo.Location) {
+  ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+  defer cancel()
+
+  now := time.Now().UTC()
+  for _, p := range v.srv.activeFor(ctx, loc) {
+    f, ok := p.(providers.Forecaster)
+    if !ok {
+      continue
+    }
+
+    v.srv.quotasFor(ctx).spend(p.Name())
+    ps, err := f.Forecast(ctx, loc, v.hours)
+    if err != nil {
+      forecastsIssued.Inc(p.Name(), "error")
+      log.Printf("verify: %s: %s: %s", p.Name(), loc.Name, err)
+      continue
+    }
+
+    for _, pt := range ps {
+      if !pt.Valid.After(now) {
+        continue
+      }
+
+      fc := issuedForecast{Provider: p.Name(), Issued: now, Valid: pt.Valid, Kelvin: pt.Kelvin}
+      k := strings.Join([]string{loc.Key(), pt.Valid.Format(historyTimeFormat), p.Name(), now.Format(historyTimeFormat)}, "/")
+      if err := v.srv.history.db.put(forecastBucket, k, fc); err != nil {
+        log.Printf("verify: %s", err)
+        return
+      }
+    }
+
+    forecastsIssued.Inc(p.Name(), "ok")
+  }
+}
+
+// score verifies the forecasts for loc that are due at obs.
+func (v *verifier) score(loc geo.Location, obs historyReading) {
+  db := v.srv.history.db
+  from := obs.Time.Add(-verifyWindow).Format(historyTimeFormat)
+  until := obs.Time.Add(verifyWindow).Format(historyTimeFormat)
+
+  for _, k := range db.keys(forecastBucket, loc.Key()+"/") {
+    valid := strings.Split(k, "/")[1]
+    if valid > until {
+      break
+    }
+
+    if valid >= from {
+      var fc issuedForecast
+      if _, err := db.get(forecastBucket, k, &fc); err != nil {
+        log.Printf("verify: %s", err)
+        continue
+      }
+
+      lead := int(math.Round(fc.Valid.Sub(fc.Issued).Hours()))
+      v.record(fc.Provider, lead, fc.Kelvin-obs.Kelvin)
+    }
+
+    // Scored, or too late to ever be.
+    if err := db.delete(forecastBucket, k); err != nil {
+      log.Printf("verify: %s", err)
+    }
+  }
+}
+
+func (v *verifier) record(provider string, lead int, err float64) {
+  db := v.srv.history.db
+  k := fmt.Sprintf("%s/%03d", provider, lead)
+
+  v.mu.Lock()
+  defer v.mu.Unlock()
+
+  var sk skill
+  if _, e := db.get(verificationBucket, k, &sk); e != nil {
+    log.Printf("verify: %s", e)
+    return
+  }
+
+  sk.N++
+  sk.AbsErr += math.Abs(err)
+  sk.Err += err
+  if e := db.put(verificationBucket, k, sk); e != nil {
+    log.Printf("verify: %s", e)
+  }
+}
+
+type leadSkill struct {
+  Lead int     `json:"lead_hours"`
+  N    int     `json:"n"`
+  MAE  float64 `json:"mae"`  // kelvin
+  Bias float64 `json:"bias"` // kelvin, positive when forecasts run warm
+}
+
+// verification serves GET /v1/stats/verification: MAE and bias of each
+// provider's forecasts by lead time, against the observed aggregate.
+func (s *server) verification(w http.ResponseWriter, r *http.Request) {
+  db := s.history.db
+  providers := make(map[string][]leadSkill)
+  for _, k := range db.keys(verificationBucket, "") {
+    var sk skill
+    if _, err := db.get(verificationBucket, k, &sk); err != nil || sk.N == 0 {
+      continue
+    }
+
+    var lead int
+    provider, raw, _ := strings.Cut(k, "/")
+    fmt.Sscanf(raw, "%d", &lead)
+    providers[provider] = append(providers[provider], leadSkill{
+      Lead: lead,
+      N:    sk.N,
+      MAE:  sk.AbsErr / float64(sk.N),
+      Bias: sk.Err / float64(sk.N),
+    })
+  }
+
+  names := make([]string, 0, len(providers))
+  for name := range providers {
+    names = append(names, name)
+  }
+
+  sort.Strings(names)
+
+  out := make([]map[string]interface{}, 0, len(names))
+  for _, name := range names {
+    out = append(out, map[string]interface{}{"provider": name, "leads": providers[name]})
+  }
+
+  writeJSON(w, http.StatusOK, map[string]interface{}{"verification": out})
+}
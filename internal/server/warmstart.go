@@ -0,0 +1,30 @@
+package server
+
+import (
+  "log"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/cache"
+)
+
+// warmStart restores the aggregate cache from the snapshot at path, so a
+// restart answers hot places from cache rather than calling every provider
+// for all of them at once, then snapshots it every interval; shutdown takes
+// the last one.
+func warmStart(c *cache.Readings, path string, every time.Duration) {
+  begin := time.Now()
+  n, err := c.Restore(path)
+  if err != nil {
+    log.Printf("cache snapshot: %s: %s", path, err)
+  } else {
+    log.Printf("cache snapshot: restored %d entries, took: %s", n, time.Since(begin).String())
+  }
+
+  go func() {
+    for range time.Tick(every) {
+      if _, err := c.Snapshot(path); err != nil {
+        log.Printf("cache snapshot: %s: %s", path, err)
+      }
+    }
+  }()
+}
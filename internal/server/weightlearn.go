@@ -0,0 +1,201 @@
+package server
+
+import (
+  "log"
+  "math"
+  "net/http"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/aggregate"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+)
+
+// Fewer readings than this in a region say too little about a provider
+// there; it keeps its recent weight.
+const learnMinSamples = 20
+
+// weightLearner works out, every -weights.learn, how far each provider
+// usually is from the consensus in each region from the stored history,
+// and has the weights follow that: a provider that runs warm over the
+// Alps needn't count less in Oslo. Regions are -weights.learn.cell degree
+// squares of latitude and longitude.
+type weightLearner struct {
+  srv    *server
+  every  time.Duration // 0 disables it
+  window time.Duration
+  cell   float64
+
+  mu     sync.Mutex
+  report learnedWeights
+}
+
+// learnedWeights is the last learning run, as the admin API shows it.
+type learnedWeights struct {
+  At       time.Time                             `json:"learned_at"`
+  Took     string                                `json:"took"`
+  Window   string                                `json:"window"`
+  Cell     float64                               `json:"cell_degrees"`
+  Readings int                                   `json:"readings"`
+  Regions  map[string]map[string]learnedProvider `json:"regions"`
+}
+
+type learnedProvider struct {
+  Deviation float64 `json:"deviation"` // mean |reading - median|, K
+  Samples   int     `json:"samples"`
+  Weight    float64 `json:"weight"` // effective, with the static weight
+}
+
+func newWeightLearner(srv *server, every, window time.Duration, cell float64) *weightLearner {
+  return &weightLearner{srv: srv, every: every, window: window, cell: cell}
+}
+
+// region is the cell loc is in, named by its south-west corner: "50,10"
+// for Oslo with 10° cells. Empty without learning.
+func (l *weightLearner) region(loc geo.Location) string {
+  if l == nil || l.every <= 0 {
+    return ""
+  }
+
+  corner := func(v float64) string {
+    return strconv.FormatFloat(math.Floor(v/l.cell)*l.cell, 'f', -1, 64)
+  }
+
+  return corner(loc.Lat) + "," + corner(loc.Lon)
+}
+
+func (l *weightLearner) run() {
+  if l.every <= 0 {
+    return
+  }
+
+  for {
+    l.learn()
+    time.Sleep(l.every)
+  }
+}
+
+// learn scans the readings stored within the window and replaces the
+// learned deviations with what they show.
+func (l *weightLearner) learn() learnedWeights {
+  begin := time.Now()
+  from := begin.Add(-l.window)
+
+  type sum struct {
+    dev float64
+    n   int
+  }
+
+  sums := make(map[string]map[string]*sum)
+  readings := 0
+  err := l.srv.history.scanAll(from, func(place string, r historyReading) error {
+    if len(r.Providers) < 2 {
+      return nil
+    }
+
+    lat, lon, ok := strings.Cut(place, ",")
+    la, errLat := strconv.ParseFloat(lat, 64)
+    lo, errLon := strconv.ParseFloat(lon, 64)
+    if !ok || errLat != nil || errLon != nil {
+      return nil
+    }
+
+    values := make([]float64, 0, len(r.Providers))
+    for _, k := range r.Providers {
+      values = append(values, k)
+    }
+
+    consensus := aggregate.Median(values)
+    region := l.region(geo.Location{Lat: la, Lon: lo})
+    if sums[region] == nil {
+      sums[region] = make(map[string]*sum)
+    }
+
+    for name, k := range r.Providers {
+      s := sums[region][name]
+      if s == nil {
+        s = &sum{}
+        sums[region][name] = s
+      }
+
+      s.dev += math.Abs(k - consensus)
+      s.n++
+    }
+
+    readings++
+    return nil
+  })
+
+  if err != nil {
+    log.Printf("weights: learning: %s", err)
+    return l.last()
+  }
+
+  devs := make(map[string]map[string]float64, len(sums))
+  for region, byProvider := range sums {
+    for name, s := range byProvider {
+      if s.n < learnMinSamples {
+        continue
+      }
+
+      if devs[region] == nil {
+        devs[region] = make(map[string]float64)
+      }
+
+      devs[region][name] = s.dev / float64(s.n)
+    }
+  }
+
+  l.srv.weights.SetLearned(devs)
+
+  report := learnedWeights{At: begin.UTC(), Took: time.Since(begin).String(), Window: l.window.String(), Cell: l.cell, Readings: readings, Regions: make(map[string]map[string]learnedProvider, len(devs))}
+  for region, byProvider := range devs {
+    report.Regions[region] = make(map[string]learnedProvider, len(byProvider))
+    for name, dev := range byProvider {
+      report.Regions[region][name] = learnedProvider{Deviation: dev, Samples: sums[region][name].n, Weight: l.srv.weights.Weight(region, name)}
+    }
+  }
+
+  l.mu.Lock()
+  l.report = report
+  l.mu.Unlock()
+
+  log.Printf("weights: learned from %d readings in %d regions, took: %s", readings, len(devs), report.Took)
+  return report
+}
+
+func (l *weightLearner) last() learnedWeights {
+  l.mu.Lock()
+  defer l.mu.Unlock()
+
+  return l.report
+}
+
+// adminWeights serves GET /v1/admin/weights: every provider's static and
+// recent weight and, with -weights.learn, the last run's per region. POST
+// /v1/admin/weights/learn relearns now, say after importing history.
+func (s *server) adminWeights(w http.ResponseWriter, r *http.Request) {
+  devs := s.weights.Recent()
+  ps := s.providers.get()
+  recent := make(map[string]map[string]float64, len(ps))
+  for _, p := range ps {
+    static, _ := s.weights.Basis(p.Name())
+    recent[p.Name()] = map[string]float64{"static": static, "deviation": devs[p.Name()], "weight": s.weights.Weight("", p.Name())}
+  }
+
+  resp := map[string]interface{}{"dynamic": s.weights.Dynamic(), "recent": recent}
+  if s.learner != nil && s.learner.every > 0 {
+    if r.Method == http.MethodPost {
+      resp["learned"] = s.learner.learn()
+    } else {
+      resp["learned"] = s.learner.last()
+    }
+  } else if r.Method == http.MethodPost {
+    httpError(w, "weight learning is off; start with -weights.learn to use it", http.StatusNotFound)
+    return
+  }
+
+  writeJSON(w, http.StatusOK, resp)
+}
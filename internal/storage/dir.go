@@ -0,0 +1,116 @@
+package storage
+
+import (
+  "errors"
+  "net/url"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+)
+
+// tempPrefix marks values being written; escaped keys never start with it.
+const tempPrefix = ".put-"
+
+// Dir keeps a file per value, in a directory per bucket, so values survive
+// restarts and can be shared over a network filesystem. Files are written
+// to a temporary name and renamed, so readers never see half a value.
+type Dir struct {
+  root string
+}
+
+// OpenDir creates root unless it exists.
+func OpenDir(root string) (*Dir, error) {
+  if err := os.MkdirAll(root, 0o700); err != nil {
+    return nil, err
+  }
+
+  return &Dir{root: root}, nil
+}
+
+// file is where bucket/key is kept; keys are escaped into one path
+// segment, so one with slashes is still one file.
+func (d *Dir) file(bucket, key string) string {
+  return filepath.Join(d.root, url.PathEscape(bucket), fileName(key))
+}
+
+func fileName(key string) string {
+  name := url.PathEscape(key)
+  if strings.HasPrefix(name, ".") {
+    name = "%2E" + name[1:]
+  }
+
+  return name
+}
+
+func (d *Dir) Put(bucket, key string, value []byte) error {
+  dir := filepath.Join(d.root, url.PathEscape(bucket))
+  if err := os.MkdirAll(dir, 0o700); err != nil {
+    return err
+  }
+
+  f, err := os.CreateTemp(dir, tempPrefix+"*")
+  if err != nil {
+    return err
+  }
+
+  _, err = f.Write(value)
+  if cerr := f.Close(); err == nil {
+    err = cerr
+  }
+
+  if err == nil {
+    err = os.Rename(f.Name(), d.file(bucket, key))
+  }
+
+  if err != nil {
+    os.Remove(f.Name())
+  }
+
+  return err
+}
+
+func (d *Dir) Get(bucket, key string) ([]byte, bool, error) {
+  v, err := os.ReadFile(d.file(bucket, key))
+  if errors.Is(err, os.ErrNotExist) {
+    return nil, false, nil
+  }
+
+  return v, err == nil, err
+}
+
+func (d *Dir) Delete(bucket string, keys ...string) error {
+  for _, k := range keys {
+    if err := os.Remove(d.file(bucket, k)); err != nil && !errors.Is(err, os.ErrNotExist) {
+      return err
+    }
+  }
+
+  return nil
+}
+
+func (d *Dir) Keys(bucket, prefix string) ([]string, error) {
+  entries, err := os.ReadDir(filepath.Join(d.root, url.PathEscape(bucket)))
+  if errors.Is(err, os.ErrNotExist) {
+    return nil, nil
+  }
+
+  if err != nil {
+    return nil, err
+  }
+
+  var ks []string
+  for _, e := range entries {
+    if e.IsDir() || strings.HasPrefix(e.Name(), tempPrefix) {
+      continue
+    }
+
+    k, err := url.PathUnescape(e.Name())
+    if err == nil && strings.HasPrefix(k, prefix) {
+      ks = append(ks, k)
+    }
+  }
+
+  sort.Strings(ks)
+  return ks, nil
+}
@@ -0,0 +1,315 @@
+package storage
+
+import (
+  "bufio"
+  "crypto/tls"
+  "errors"
+  "fmt"
+  "io"
+  "net"
+  "net/url"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+const (
+  redisTimeout       = 2 * time.Second
+  defaultRedisPrefix = "weather-go:"
+)
+
+// Redis keeps values as plain string keys, <prefix><bucket>:<key>, so
+// several instances can share a cache. It speaks RESP over one connection,
+// dialed on first use and redialed after any error; commands are
+// serialized. https://redis.io/docs/reference/protocol-spec/
+type Redis struct {
+  addr     string
+  secure   bool
+  username string
+  password string
+  db       int
+  prefix   string
+
+  mu   sync.Mutex
+  conn net.Conn
+  r    *bufio.Reader
+}
+
+// NewRedis is a client of the server at u: redis:// or rediss://, with an
+// optional password, database number and prefix, see Open.
+func NewRedis(u *url.URL) (*Redis, error) {
+  db, err := redisDB(u)
+  if err != nil {
+    return nil, err
+  }
+
+  port := u.Port()
+  if port == "" {
+    port = "6379"
+  }
+
+  c := &Redis{addr: net.JoinHostPort(u.Hostname(), port), secure: u.Scheme == "rediss", db: db, prefix: defaultRedisPrefix}
+  if u.User != nil {
+    c.username = u.User.Username()
+    c.password, _ = u.User.Password()
+  }
+
+  if p, ok := u.Query()["prefix"]; ok {
+    c.prefix = p[0]
+  }
+
+  return c, nil
+}
+
+func redisDB(u *url.URL) (int, error) {
+  p := strings.Trim(u.Path, "/")
+  if p == "" {
+    return 0, nil
+  }
+
+  db, err := strconv.Atoi(p)
+  if err != nil || db < 0 {
+    return 0, fmt.Errorf("want a database number as the path, got %q", u.Path)
+  }
+
+  return db, nil
+}
+
+func (c *Redis) key(bucket, key string) string {
+  return c.prefix + bucket + ":" + key
+}
+
+func (c *Redis) Put(bucket, key string, value []byte) error {
+  _, err := c.do("SET", c.key(bucket, key), string(value))
+  return err
+}
+
+func (c *Redis) Get(bucket, key string) ([]byte, bool, error) {
+  v, err := c.do("GET", c.key(bucket, key))
+  if err != nil || v == nil {
+    return nil, false, err
+  }
+
+  b, ok := v.([]byte)
+  if !ok {
+    return nil, false, fmt.Errorf("redis: GET answered %T", v)
+  }
+
+  return b, true, nil
+}
+
+func (c *Redis) Delete(bucket string, keys ...string) error {
+  if len(keys) == 0 {
+    return nil
+  }
+
+  args := []string{"DEL"}
+  for _, k := range keys {
+    args = append(args, c.key(bucket, k))
+  }
+
+  _, err := c.do(args...)
+  return err
+}
+
+// Keys walks the matching keys with SCAN, which doesn't block the server
+// the way KEYS does.
+func (c *Redis) Keys(bucket, prefix string) ([]string, error) {
+  full := c.key(bucket, "")
+  pattern := globEscape.Replace(full+prefix) + "*"
+
+  var ks []string
+  cursor := "0"
+  for {
+    v, err := c.do("SCAN", cursor, "MATCH", pattern, "COUNT", "1000")
+    if err != nil {
+      return nil, err
+    }
+
+    page, ok := v.([]interface{})
+    if !ok || len(page) != 2 {
+      return nil, fmt.Errorf("redis: SCAN answered %v", v)
+    }
+
+    next, _ := page[0].([]byte)
+    found, _ := page[1].([]interface{})
+    for _, k := range found {
+      if b, ok := k.([]byte); ok {
+        ks = append(ks, strings.TrimPrefix(string(b), full))
+      }
+    }
+
+    if cursor = string(next); cursor == "0" || cursor == "" {
+      break
+    }
+  }
+
+  // SCAN may return a key more than once.
+  sort.Strings(ks)
+  return compactStrings(ks), nil
+}
+
+var globEscape = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+func compactStrings(s []string) []string {
+  out := s[:0]
+  for i, v := range s {
+    if i == 0 || v != s[i-1] {
+      out = append(out, v)
+    }
+  }
+
+  return out
+}
+
+// do sends one command and reads its reply: nil for a missing value,
+// []byte, int64, string or []interface{} otherwise, and errors the server
+// answers with as errors.
+func (c *Redis) do(args ...string) (interface{}, error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  if err := c.connect(); err != nil {
+    return nil, err
+  }
+
+  v, err := c.exchange(args)
+  var rerr redisError
+  if err != nil && !errors.As(err, &rerr) {
+    c.drop()
+  }
+
+  return v, err
+}
+
+// connect dials, authenticates and selects the database unless connected;
+// the caller holds mu.
+func (c *Redis) connect() error {
+  if c.conn != nil {
+    return nil
+  }
+
+  d := &net.Dialer{Timeout: redisTimeout}
+  var conn net.Conn
+  var err error
+  if c.secure {
+    host, _, _ := net.SplitHostPort(c.addr)
+    conn, err = tls.DialWithDialer(d, "tcp", c.addr, &tls.Config{ServerName: host})
+  } else {
+    conn, err = d.Dial("tcp", c.addr)
+  }
+
+  if err != nil {
+    return fmt.Errorf("redis: %w", err)
+  }
+
+  c.conn, c.r = conn, bufio.NewReader(conn)
+
+  var setup [][]string
+  switch {
+  case c.username != "" && c.password != "":
+    setup = append(setup, []string{"AUTH", c.username, c.password})
+  case c.password != "":
+    setup = append(setup, []string{"AUTH", c.password})
+  }
+
+  if c.db != 0 {
+    setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+  }
+
+  for _, cmd := range setup {
+    if _, err := c.exchange(cmd); err != nil {
+      c.drop()
+      return fmt.Errorf("redis: %s: %w", cmd[0], err)
+    }
+  }
+
+  return nil
+}
+
+func (c *Redis) drop() {
+  if c.conn != nil {
+    c.conn.Close()
+  }
+
+  c.conn, c.r = nil, nil
+}
+
+func (c *Redis) exchange(args []string) (interface{}, error) {
+  c.conn.SetDeadline(time.Now().Add(redisTimeout))
+
+  cmd := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+  for _, a := range args {
+    cmd = append(cmd, "$"+strconv.Itoa(len(a))+"\r\n"...)
+    cmd = append(cmd, a...)
+    cmd = append(cmd, "\r\n"...)
+  }
+
+  if _, err := c.conn.Write(cmd); err != nil {
+    return nil, fmt.Errorf("redis: %w", err)
+  }
+
+  return c.reply()
+}
+
+// redisError is an error reply: the command failed, the connection is
+// fine.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func (c *Redis) reply() (interface{}, error) {
+  line, err := c.r.ReadString('\n')
+  if err != nil {
+    return nil, fmt.Errorf("redis: %w", err)
+  }
+
+  line = strings.TrimSuffix(line, "\r\n")
+  if line == "" {
+    return nil, errors.New("redis: empty reply")
+  }
+
+  kind, rest := line[0], line[1:]
+  switch kind {
+  case '+':
+    return rest, nil
+  case '-':
+    return nil, redisError(rest)
+  case ':':
+    n, err := strconv.ParseInt(rest, 10, 64)
+    return n, err
+  case '$':
+    n, err := strconv.Atoi(rest)
+    if err != nil || n < 0 {
+      return nil, err
+    }
+
+    b := make([]byte, n+2)
+    if _, err := io.ReadFull(c.r, b); err != nil {
+      return nil, fmt.Errorf("redis: %w", err)
+    }
+
+    return b[:n], nil
+  case '*':
+    n, err := strconv.Atoi(rest)
+    if err != nil || n < 0 {
+      return nil, err
+    }
+
+    vs := make([]interface{}, n)
+    for i := range vs {
+      if vs[i], err = c.reply(); err != nil {
+        var rerr redisError
+        if !errors.As(err, &rerr) {
+          return nil, err
+        }
+      }
+    }
+
+    return vs, nil
+  default:
+    return nil, fmt.Errorf("redis: unexpected reply %q", line)
+  }
+}
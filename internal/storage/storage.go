@@ -0,0 +1,136 @@
+// Package storage is where the aggregate cache and the reading history keep
+// their values: in memory, in a directory or in Redis, picked by URL so
+// each environment can choose its own.
+package storage
+
+import (
+  "fmt"
+  "net/url"
+  "sort"
+  "strings"
+  "sync"
+)
+
+// Backend keeps opaque values by bucket and key. Values of the built-in
+// backends are kept until deleted; expiry is the callers' business.
+type Backend interface {
+  Put(bucket, key string, value []byte) error
+  // Get reports whether the key exists; a missing key is not an error.
+  Get(bucket, key string) ([]byte, bool, error)
+  Delete(bucket string, keys ...string) error
+  // Keys lists the keys of a bucket starting with prefix, sorted.
+  Keys(bucket, prefix string) ([]string, error)
+}
+
+// Check reports whether Open would accept spec, without opening it.
+func Check(spec string) error {
+  _, err := parse(spec)
+  return err
+}
+
+// Open is the backend spec names: "memory", a directory as
+// file:///var/lib/weather-go/cache, or a Redis server as
+// redis://[:password@]host[:6379][/db][?prefix=weather-go:], rediss://
+// for TLS.
+func Open(spec string) (Backend, error) {
+  u, err := parse(spec)
+  if err != nil {
+    return nil, err
+  }
+
+  switch u.Scheme {
+  case "file":
+    return OpenDir(u.Path)
+  case "redis", "rediss":
+    return NewRedis(u)
+  default:
+    return NewMemory(), nil
+  }
+}
+
+func parse(spec string) (*url.URL, error) {
+  if spec == "memory" {
+    return &url.URL{Scheme: "memory"}, nil
+  }
+
+  u, err := url.Parse(spec)
+  if err != nil {
+    return nil, fmt.Errorf("storage %q: %w", spec, err)
+  }
+
+  switch u.Scheme {
+  case "file":
+    if u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+      return nil, fmt.Errorf("storage %q: want an absolute directory such as file:///var/lib/weather-go", spec)
+    }
+  case "redis", "rediss":
+    if u.Host == "" {
+      return nil, fmt.Errorf("storage %q: want a Redis server such as redis://localhost:6379/0", spec)
+    }
+
+    if _, err := redisDB(u); err != nil {
+      return nil, fmt.Errorf("storage %q: %w", spec, err)
+    }
+  default:
+    return nil, fmt.Errorf("storage %q: want memory, file:///<dir>, redis:// or rediss://", spec)
+  }
+
+  return u, nil
+}
+
+// Memory keeps values in the process, lost on restart.
+type Memory struct {
+  mu      sync.RWMutex
+  buckets map[string]map[string][]byte
+}
+
+func NewMemory() *Memory {
+  return &Memory{buckets: make(map[string]map[string][]byte)}
+}
+
+func (m *Memory) Put(bucket, key string, value []byte) error {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  b, ok := m.buckets[bucket]
+  if !ok {
+    b = make(map[string][]byte)
+    m.buckets[bucket] = b
+  }
+
+  b[key] = value
+  return nil
+}
+
+func (m *Memory) Get(bucket, key string) ([]byte, bool, error) {
+  m.mu.RLock()
+  v, ok := m.buckets[bucket][key]
+  m.mu.RUnlock()
+
+  return v, ok, nil
+}
+
+func (m *Memory) Delete(bucket string, keys ...string) error {
+  m.mu.Lock()
+  for _, k := range keys {
+    delete(m.buckets[bucket], k)
+  }
+  m.mu.Unlock()
+
+  return nil
+}
+
+func (m *Memory) Keys(bucket, prefix string) ([]string, error) {
+  m.mu.RLock()
+  defer m.mu.RUnlock()
+
+  var ks []string
+  for k := range m.buckets[bucket] {
+    if strings.HasPrefix(k, prefix) {
+      ks = append(ks, k)
+    }
+  }
+
+  sort.Strings(ks)
+  return ks, nil
+}
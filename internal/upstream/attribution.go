@@ -0,0 +1,16 @@
+package upstream
+
+// Attribution is the credit a data source asks for wherever its data is
+// shown; clients are expected to display it next to the reading.
+type Attribution struct {
+  Source  string `json:"source"`
+  Text    string `json:"text"`
+  URL     string `json:"url"`
+  License string `json:"license,omitempty"`
+}
+
+// Attributed is implemented by providers and geocoders whose terms require
+// attribution. Sources without it simply aren't listed.
+type Attributed interface {
+  Attribution() Attribution
+}
@@ -0,0 +1,165 @@
+package upstream
+
+import (
+  "bytes"
+  "fmt"
+  "io"
+  "math/rand/v2"
+  "net/http"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+)
+
+var chaosFaults = metrics.NewCounter("upstream_chaos_faults_total", "Faults injected into upstream calls, by host and kind.", "host", "fault")
+
+// Faults is how often Chaos gets in the way of a call: each rate is the
+// chance, 0 to 1, that a call is delayed, fails or comes back malformed.
+type Faults struct {
+  Latency       time.Duration // added to delayed calls
+  LatencyRate   float64
+  ErrorRate     float64 // answered with 503 without reaching the upstream
+  MalformedRate float64 // the upstream's body, cut in half
+}
+
+// ParseFaults reads "latency=2s@0.2,error=0.1,malformed=0.05": delay a
+// fifth of calls by two seconds, fail a tenth and garble one in twenty.
+// Faults left out don't happen.
+func ParseFaults(spec string) (Faults, error) {
+  var f Faults
+  for _, part := range strings.Split(spec, ",") {
+    name, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+    if !ok {
+      return Faults{}, fmt.Errorf("chaos: want <fault>=<rate>, got %q", part)
+    }
+
+    var err error
+    switch name {
+    case "latency":
+      delay, rate, found := strings.Cut(v, "@")
+      if !found {
+        return Faults{}, fmt.Errorf("chaos: want latency=<duration>@<rate>, got %q", v)
+      }
+
+      if f.Latency, err = time.ParseDuration(delay); err == nil && f.Latency < 0 {
+        err = fmt.Errorf("negative latency %s", delay)
+      }
+
+      if err == nil {
+        f.LatencyRate, err = parseRate(rate)
+      }
+    case "error":
+      f.ErrorRate, err = parseRate(v)
+    case "malformed":
+      f.MalformedRate, err = parseRate(v)
+    default:
+      return Faults{}, fmt.Errorf("chaos: unknown fault %q, want latency, error or malformed", name)
+    }
+
+    if err != nil {
+      return Faults{}, fmt.Errorf("chaos: %s: %w", name, err)
+    }
+  }
+
+  return f, nil
+}
+
+func parseRate(v string) (float64, error) {
+  rate, err := strconv.ParseFloat(v, 64)
+  if err != nil || rate < 0 || rate > 1 {
+    return 0, fmt.Errorf("want a rate from 0 to 1, got %q", v)
+  }
+
+  return rate, nil
+}
+
+func (f Faults) String() string {
+  return fmt.Sprintf("latency=%s@%g,error=%g,malformed=%g", f.Latency, f.LatencyRate, f.ErrorRate, f.MalformedRate)
+}
+
+// Chaos is a transport that injects faults into upstream calls, so
+// circuit breakers, timeouts and partial failures can be tried in staging
+// without waiting for a provider to misbehave. Calls pick each fault on
+// their own, so one may be both delayed and fail.
+type Chaos struct {
+  next http.RoundTripper
+
+  mu     sync.Mutex
+  faults Faults
+}
+
+// NewChaos injects f into the calls it forwards to next (the default
+// transport if nil).
+func NewChaos(next http.RoundTripper, f Faults) *Chaos {
+  if next == nil {
+    next = http.DefaultTransport
+  }
+
+  return &Chaos{next: next, faults: f}
+}
+
+// Faults is what c injects now.
+func (c *Chaos) Faults() Faults {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  return c.faults
+}
+
+// Set changes what c injects from the next call on.
+func (c *Chaos) Set(f Faults) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  c.faults = f
+}
+
+func (c *Chaos) RoundTrip(req *http.Request) (*http.Response, error) {
+  f := c.Faults()
+  host := req.URL.Host
+
+  if f.Latency > 0 && rand.Float64() < f.LatencyRate {
+    chaosFaults.Inc(host, "latency")
+    select {
+    case <-time.After(f.Latency):
+    case <-req.Context().Done():
+      return nil, req.Context().Err()
+    }
+  }
+
+  if rand.Float64() < f.ErrorRate {
+    chaosFaults.Inc(host, "error")
+    body := "chaos: injected failure"
+    return &http.Response{
+      Status:        "503 Service Unavailable",
+      StatusCode:    http.StatusServiceUnavailable,
+      Proto:         "HTTP/1.1",
+      ProtoMajor:    1,
+      ProtoMinor:    1,
+      Header:        http.Header{"Content-Type": {"text/plain"}},
+      Body:          io.NopCloser(strings.NewReader(body)),
+      ContentLength: int64(len(body)),
+      Request:       req,
+    }, nil
+  }
+
+  resp, err := c.next.RoundTrip(req)
+  if err != nil || rand.Float64() >= f.MalformedRate {
+    return resp, err
+  }
+
+  chaosFaults.Inc(host, "malformed")
+  body, err := io.ReadAll(resp.Body)
+  resp.Body.Close()
+  if err != nil {
+    return nil, err
+  }
+
+  body = body[:len(body)/2]
+  resp.Body, resp.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+  resp.Header.Del("Content-Length")
+  return resp, nil
+}
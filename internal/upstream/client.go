@@ -0,0 +1,87 @@
+package upstream
+
+import (
+  "context"
+  "fmt"
+  "net"
+  "net/http"
+  "net/http/httptrace"
+  "net/url"
+  "strconv"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+)
+
+var connections = metrics.NewCounter("upstream_connections_total", "Connections upstream calls went out on, by whether an idle one was reused.", "host", "reused")
+
+// ClientConfig tunes the transport of the shared Client. Providers call
+// the same few hosts over and over, so idle connections are kept per host
+// and each phase of a call has its own deadline; a hung upstream fails
+// its reading instead of holding a fan-out until the request's context
+// gives up.
+type ClientConfig struct {
+  Timeout       time.Duration // a whole call, body included; 0 is no limit
+  DialTimeout   time.Duration // TCP connect and TLS handshake, each
+  HeaderTimeout time.Duration // waiting for the response headers
+  IdleConns     int           // idle connections kept per host
+  IdleTimeout   time.Duration // how long an idle connection is kept
+  KeepAlive     time.Duration // TCP keep-alive period; 0 disables keep-alives and pooling
+  Proxy         string        // proxy URL; empty uses HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+}
+
+// DefaultClientConfig is what Client starts with.
+var DefaultClientConfig = ClientConfig{
+  Timeout:       10 * time.Second,
+  DialTimeout:   5 * time.Second,
+  HeaderTimeout: 10 * time.Second,
+  IdleConns:     16,
+  IdleTimeout:   90 * time.Second,
+  KeepAlive:     30 * time.Second,
+}
+
+// Transport builds the transport c describes.
+func (c ClientConfig) Transport() (*http.Transport, error) {
+  proxy := http.ProxyFromEnvironment
+  if c.Proxy != "" {
+    u, err := url.Parse(c.Proxy)
+    if err != nil || u.Host == "" {
+      return nil, fmt.Errorf("proxy: want a URL such as http://proxy:3128, got %q", c.Proxy)
+    }
+
+    proxy = http.ProxyURL(u)
+  }
+
+  keepAlive := c.KeepAlive
+  if keepAlive == 0 {
+    keepAlive = -1 // net.Dialer's "off"
+  }
+
+  dialer := &net.Dialer{Timeout: c.DialTimeout, KeepAlive: keepAlive}
+  return &http.Transport{
+    Proxy:                 proxy,
+    DialContext:           dialer.DialContext,
+    ForceAttemptHTTP2:     true,
+    TLSHandshakeTimeout:   c.DialTimeout,
+    ResponseHeaderTimeout: c.HeaderTimeout,
+    ExpectContinueTimeout: time.Second,
+    MaxIdleConns:          0, // bounded per host instead
+    MaxIdleConnsPerHost:   c.IdleConns,
+    IdleConnTimeout:       c.IdleTimeout,
+    DisableKeepAlives:     c.KeepAlive == 0,
+  }, nil
+}
+
+func newClient(c ClientConfig) *http.Client {
+  t, _ := c.Transport()
+  return &http.Client{Transport: t, Timeout: c.Timeout}
+}
+
+// countConnections records whether the call reused a pooled connection.
+func countConnections(ctx context.Context, host string) context.Context {
+  return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+    GotConn: func(info httptrace.GotConnInfo) {
+      connections.Inc(host, strconv.FormatBool(info.Reused))
+    },
+  })
+}
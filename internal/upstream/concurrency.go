@@ -0,0 +1,112 @@
+package upstream
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+)
+
+var (
+  inFlight            = metrics.NewGauge("upstream_in_flight", "Calls to a capped provider holding one of its slots.", "provider")
+  queued              = metrics.NewGauge("upstream_queued", "Calls waiting for a capped provider's slot.", "provider")
+  concurrencyRejected = metrics.NewCounter("upstream_concurrency_rejected_total", "Calls turned away because the provider had its cap in flight the whole wait.", "provider")
+)
+
+// ErrBusy is a call that didn't get one of its provider's slots in time.
+var ErrBusy = errors.New("provider busy")
+
+// ConcurrencySet caps the calls in flight per provider and doubles as a
+// repeatable flag: -provider.concurrency=openweathermap=4.
+type ConcurrencySet map[string]int
+
+func (c ConcurrencySet) String() string {
+  s := make([]string, 0, len(c))
+  for name, n := range c {
+    s = append(s, name+"="+strconv.Itoa(n))
+  }
+
+  sort.Strings(s)
+  return strings.Join(s, ",")
+}
+
+func (c ConcurrencySet) Set(v string) error {
+  name, calls, ok := strings.Cut(v, "=")
+  n, err := strconv.Atoi(calls)
+  if !ok || name == "" || err != nil || n <= 0 {
+    return fmt.Errorf("want provider=<calls in flight>, a positive number, got %q", v)
+  }
+
+  c[name] = n
+  return nil
+}
+
+// Limits holds a semaphore per capped provider. A call over the cap waits
+// up to Wait for a slot before failing with ErrBusy, at once when Wait is
+// 0; the caller's deadline still applies.
+type Limits struct {
+  Wait  time.Duration
+  slots map[string]chan struct{}
+}
+
+// Concurrency is what Endpoint calls take slots from; nil caps none.
+var Concurrency *Limits
+
+// NewLimits caps each provider in caps, the others not at all.
+func NewLimits(caps ConcurrencySet, wait time.Duration) *Limits {
+  l := &Limits{Wait: wait, slots: make(map[string]chan struct{}, len(caps))}
+  for name, n := range caps {
+    l.slots[name] = make(chan struct{}, n)
+  }
+
+  return l
+}
+
+// acquire takes one of provider's slots, returning how to give it back.
+func (l *Limits) acquire(ctx context.Context, provider string) (func(), error) {
+  var slots chan struct{}
+  if l != nil {
+    slots = l.slots[provider]
+  }
+
+  if slots == nil {
+    return func() {}, nil
+  }
+
+  release := func() {
+    <-slots
+    inFlight.Add(-1, provider)
+  }
+
+  select {
+  case slots <- struct{}{}:
+    inFlight.Add(1, provider)
+    return release, nil
+  default:
+  }
+
+  if l.Wait > 0 {
+    queued.Add(1, provider)
+    defer queued.Add(-1, provider)
+
+    t := time.NewTimer(l.Wait)
+    defer t.Stop()
+
+    select {
+    case slots <- struct{}{}:
+      inFlight.Add(1, provider)
+      return release, nil
+    case <-ctx.Done():
+      return nil, ctx.Err()
+    case <-t.C:
+    }
+  }
+
+  concurrencyRejected.Inc(provider)
+  return nil, fmt.Errorf("%w: %d calls to %s already in flight, none finished within %s", ErrBusy, cap(slots), provider, l.Wait)
+}
@@ -0,0 +1,68 @@
+package upstream
+
+import (
+  "context"
+  "errors"
+  "net"
+  "net/http"
+  "syscall"
+)
+
+// Failure is why a call to an upstream failed, as far as can be told from
+// this end: its name didn't resolve, it couldn't be reached, it didn't
+// answer in time, it answered with an error, or with something that isn't
+// what was asked for.
+type Failure string
+
+const (
+  FailureDNS         Failure = "dns"
+  FailureConnect     Failure = "connect" // refused, reset, unreachable or timed out connecting
+  FailureTimeout     Failure = "timeout" // connected, but no answer in time
+  FailureServer      Failure = "server_error"
+  FailureAuth        Failure = "auth"
+  FailureRateLimited Failure = "rate_limited"
+  FailureRejected    Failure = "rejected" // any other 4xx
+  FailureParse       Failure = "parse"
+  FailureOther       Failure = "other"
+)
+
+// ErrMalformed is an answer that couldn't be decoded, or that decoded but
+// lacks what was asked for.
+var ErrMalformed = errors.New("malformed response")
+
+// Classify is the Failure err is; "" for nil.
+func Classify(err error) Failure {
+  var (
+    dns *net.DNSError
+    se  *StatusError
+    op  *net.OpError
+    ne  net.Error
+  )
+
+  switch {
+  case err == nil:
+    return ""
+  case errors.As(err, &dns):
+    return FailureDNS
+  case errors.As(err, &se):
+    switch {
+    case se.Status == http.StatusUnauthorized || se.Status == http.StatusForbidden:
+      return FailureAuth
+    case se.Status == http.StatusTooManyRequests:
+      return FailureRateLimited
+    case se.Status >= 500:
+      return FailureServer
+    default:
+      return FailureRejected
+    }
+  case errors.Is(err, ErrMalformed):
+    return FailureParse
+  case errors.As(err, &op) && (op.Op == "dial" || op.Op == "proxyconnect"),
+    errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+    return FailureConnect
+  case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+    return FailureTimeout
+  default:
+    return FailureOther
+  }
+}
@@ -0,0 +1,201 @@
+package upstream
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "net/http"
+  "net/url"
+  "os"
+  "strings"
+  "sync"
+)
+
+// Fixtures is a transport that records upstream exchanges to a file, or
+// replays them from it, so providers can be exercised without network
+// access or live keys. Secrets are replaced by {secret} in recorded URLs,
+// which makes fixtures safe to commit and lets them replay under any key.
+type Fixtures struct {
+  path    string
+  next    http.RoundTripper // nil when replaying
+  secrets []string
+
+  mu      sync.Mutex
+  entries []fixture
+  served  map[string]int // replay position of each request
+}
+
+type fixture struct {
+  Method      string `json:"method"`
+  URL         string `json:"url"`
+  Status      int    `json:"status"`
+  ContentType string `json:"content_type,omitempty"`
+  Body        string `json:"body"`
+}
+
+// Query parameters that carry credentials, whatever their value.
+var secretParams = []string{"appid", "apikey", "api_key", "key", "token", "access_token"}
+
+// RecordFixtures forwards requests to next (the default transport if nil)
+// and writes every exchange to path, replacing what was there.
+func RecordFixtures(path string, next http.RoundTripper, secrets ...string) *Fixtures {
+  if next == nil {
+    next = http.DefaultTransport
+  }
+
+  return &Fixtures{path: path, next: next, secrets: secrets}
+}
+
+// ReplayFixtures answers requests from the exchanges recorded in path,
+// redacting the same secrets as when recording. Repeated requests get the
+// recorded responses in order, the last one once they run out; a request
+// never recorded fails.
+func ReplayFixtures(path string, secrets ...string) (*Fixtures, error) {
+  raw, err := os.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+
+  f := &Fixtures{path: path, secrets: secrets, served: make(map[string]int)}
+  if err := json.Unmarshal(raw, &f.entries); err != nil {
+    return nil, fmt.Errorf("%s: %w", path, err)
+  }
+
+  return f, nil
+}
+
+func (f *Fixtures) RoundTrip(req *http.Request) (*http.Response, error) {
+  u := f.redact(req.URL)
+  if f.next == nil {
+    return f.replay(req, u)
+  }
+
+  resp, err := f.next.RoundTrip(req)
+  if err != nil {
+    return nil, err
+  }
+
+  body, err := io.ReadAll(resp.Body)
+  resp.Body.Close()
+  if err != nil {
+    return nil, err
+  }
+
+  resp.Body = io.NopCloser(bytes.NewReader(body))
+
+  f.mu.Lock()
+  defer f.mu.Unlock()
+
+  f.entries = append(f.entries, fixture{
+    Method:      req.Method,
+    URL:         u,
+    Status:      resp.StatusCode,
+    ContentType: resp.Header.Get("Content-Type"),
+    Body:        string(body),
+  })
+
+  if err := f.save(); err != nil {
+    return nil, fmt.Errorf("fixtures %s: %w", f.path, err)
+  }
+
+  return resp, nil
+}
+
+func (f *Fixtures) replay(req *http.Request, u string) (*http.Response, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+
+  key := req.Method + " " + u
+  var matches []fixture
+  for _, e := range f.entries {
+    if e.Method+" "+e.URL == key {
+      matches = append(matches, e)
+    }
+  }
+
+  if len(matches) == 0 {
+    return nil, fmt.Errorf("fixtures %s: nothing recorded for %s", f.path, key)
+  }
+
+  e := matches[min(f.served[key], len(matches)-1)]
+  f.served[key]++
+
+  header := http.Header{}
+  if e.ContentType != "" {
+    header.Set("Content-Type", e.ContentType)
+  }
+
+  return &http.Response{
+    Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+    StatusCode:    e.Status,
+    Proto:         "HTTP/1.1",
+    ProtoMajor:    1,
+    ProtoMinor:    1,
+    Header:        header,
+    Body:          io.NopCloser(strings.NewReader(e.Body)),
+    ContentLength: int64(len(e.Body)),
+    Request:       req,
+  }, nil
+}
+
+// save rewrites the whole file, so it is valid JSON after every request
+// and an interrupted recording keeps what it has.
+func (f *Fixtures) save() error {
+  var buf bytes.Buffer
+  enc := json.NewEncoder(&buf)
+  enc.SetEscapeHTML(false)
+  enc.SetIndent("", "  ")
+  if err := enc.Encode(f.entries); err != nil {
+    return err
+  }
+
+  tmp := f.path + ".tmp"
+  if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+    return err
+  }
+
+  return os.Rename(tmp, f.path)
+}
+
+// redact is the URL as recorded: credentials in known query parameters
+// and the configured secrets anywhere else, such as in a path, replaced.
+func (f *Fixtures) redact(u *url.URL) string { return redactURL(u, f.secrets) }
+
+func redactURL(u *url.URL, secrets []string) string {
+  c := *u
+  q := c.Query()
+  for _, p := range secretParams {
+    for k := range q {
+      if strings.EqualFold(k, p) {
+        q.Set(k, "{secret}")
+      }
+    }
+  }
+
+  c.RawQuery = q.Encode()
+
+  s := strings.ReplaceAll(c.String(), "%7Bsecret%7D", "{secret}")
+  for _, secret := range secrets {
+    if secret != "" {
+      s = strings.ReplaceAll(s, url.PathEscape(secret), "{secret}")
+    }
+  }
+
+  return s
+}
+
+// Redact hides credentials in the URL of a failed call's error, which
+// would otherwise reach responses, logs and /status: known query
+// parameters always, and secrets wherever they appear.
+func Redact(err error, secrets ...string) error {
+  var ue *url.Error
+  if errors.As(err, &ue) {
+    if u, perr := url.Parse(ue.URL); perr == nil {
+      ue.URL = redactURL(u, secrets)
+    }
+  }
+
+  return err
+}
@@ -0,0 +1,88 @@
+package upstream
+
+import (
+  "crypto/sha256"
+  "crypto/tls"
+  "encoding/base64"
+  "fmt"
+  "net/http"
+  "strings"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/metrics"
+)
+
+var pinFailures = metrics.NewCounter("upstream_tls_pin_failures_total", "Upstream TLS connections rejected by certificate pinning.", "host")
+
+// PinSet maps an upstream host to the SPKI pins it may present. It doubles
+// as a repeatable flag: -tls.pin=api.open-meteo.com=sha256/<base64>.
+type PinSet map[string][]string
+
+func (p PinSet) String() string {
+  var s []string
+  for host, pins := range p {
+    for _, pin := range pins {
+      s = append(s, host+"="+pin)
+    }
+  }
+
+  return strings.Join(s, ",")
+}
+
+func (p PinSet) Set(v string) error {
+  host, pin, ok := strings.Cut(v, "=")
+  if !ok || host == "" {
+    return fmt.Errorf("want host=sha256/<base64>, got %q", v)
+  }
+
+  raw, ok := strings.CutPrefix(pin, "sha256/")
+  if b, err := base64.StdEncoding.DecodeString(raw); !ok || err != nil || len(b) != sha256.Size {
+    return fmt.Errorf("pin for %s must be sha256/<base64 of 32 bytes>, got %q", host, pin)
+  }
+
+  host = strings.ToLower(host)
+  p[host] = append(p[host], pin)
+  return nil
+}
+
+func spkiPin(raw []byte) string {
+  sum := sha256.Sum256(raw)
+  return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// verify runs after the normal chain verification, so pinning only ever
+// narrows what is trusted. Any certificate in the verified chain may match,
+// which allows pinning an intermediate instead of a short-lived leaf.
+func (p PinSet) verify(cs tls.ConnectionState) error {
+  host := strings.ToLower(cs.ServerName)
+  pins, ok := p[host]
+  if !ok {
+    return nil
+  }
+
+  for _, chain := range cs.VerifiedChains {
+    for _, cert := range chain {
+      got := spkiPin(cert.RawSubjectPublicKeyInfo)
+      for _, want := range pins {
+        if got == want {
+          return nil
+        }
+      }
+    }
+  }
+
+  pinFailures.Inc(host)
+
+  var leaf string
+  if len(cs.PeerCertificates) > 0 {
+    leaf = spkiPin(cs.PeerCertificates[0].RawSubjectPublicKeyInfo)
+  }
+
+  return fmt.Errorf("tls pin mismatch for %s: server presented leaf %s, none of its chain matches the %d configured pin(s)", host, leaf, len(pins))
+}
+
+// PinnedTransport is base with pin verification enabled.
+func PinnedTransport(base *http.Transport, p PinSet) *http.Transport {
+  t := base.Clone()
+  t.TLSClientConfig = &tls.Config{VerifyConnection: p.verify}
+  return t
+}
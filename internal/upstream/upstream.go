@@ -0,0 +1,197 @@
+// Package upstream is how the server talks to third-party APIs: a shared
+// client, endpoints that apply the User-Agent, required headers and trace
+// propagation to every call, certificate pinning and source attribution.
+package upstream
+
+import (
+  "context"
+  "crypto/rand"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io"
+  "net/http"
+  "net/url"
+  "strings"
+)
+
+// Version is stamped at build time:
+// go build -ldflags "-X github.com/im-kulikov/weather-go-external-api/internal/upstream.Version=1.2.3" ./cmd/weather-go
+var Version = "dev"
+
+// UserAgent identifies the server to upstreams, as their terms ask.
+func UserAgent() string {
+  return "weather-go-external-api/" + Version + " (+https://github.com/im-kulikov/weather-go-external-api)"
+}
+
+// Client is shared by every provider and geocoder, so calls to the same
+// upstream host reuse its pooled connections.
+var Client = newClient(DefaultClientConfig)
+
+// Endpoint describes how to talk to one upstream API. Every outbound call
+// goes through it, so User-Agent, required headers and trace propagation
+// are applied the same way for all providers.
+type Endpoint struct {
+  Base     string      // scheme://host[/prefix], no trailing slash
+  Header   http.Header // headers this upstream requires on every call
+  Provider string      // whose -provider.concurrency cap calls count against
+}
+
+// At is the endpoint on another base URL, such as a staging mirror, a
+// proxy or a test server; an empty base keeps the endpoint's own.
+func (e Endpoint) At(base string) Endpoint {
+  if base != "" {
+    e.Base = strings.TrimSuffix(base, "/")
+  }
+
+  return e
+}
+
+// Request builds a GET of path against the endpoint.
+func (e Endpoint) Request(ctx context.Context, path string, query url.Values) (*http.Request, error) {
+  u := e.Base + path
+  if len(query) > 0 {
+    u += "?" + query.Encode()
+  }
+
+  req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+  if err != nil {
+    return nil, err
+  }
+
+  req.Header.Set("User-Agent", UserAgent())
+  req.Header.Set("Accept", "application/json")
+  for k, v := range e.Header {
+    req.Header[k] = v
+  }
+
+  InjectTrace(ctx, req.Header)
+  return req, nil
+}
+
+// GetJSON performs a GET against the endpoint and decodes the body into v.
+func (e Endpoint) GetJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+  req, err := e.Request(ctx, path, query)
+  if err != nil {
+    return err
+  }
+
+  release, err := Concurrency.acquire(ctx, e.Provider)
+  if err != nil {
+    return err
+  }
+
+  defer release()
+
+  resp, err := Client.Do(req.WithContext(countConnections(ctx, req.URL.Host)))
+  if err != nil {
+    return Redact(err)
+  }
+
+  // Whatever the decoder leaves unread is drained so the connection can
+  // go back to the pool.
+  defer func() {
+    io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+    resp.Body.Close()
+  }()
+
+  if resp.StatusCode < 200 || resp.StatusCode > 299 {
+    return statusError(resp)
+  }
+
+  if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+    return fmt.Errorf("%w: %w", ErrMalformed, err)
+  }
+
+  return nil
+}
+
+// StatusError is an upstream answer outside 2xx. Its body is not decoded,
+// so an error page can't pass for a reading.
+type StatusError struct {
+  Status  int
+  Message string // the upstream's own explanation, if it gave one
+}
+
+func (e *StatusError) Error() string {
+  msg := fmt.Sprintf("upstream answered %d %s", e.Status, http.StatusText(e.Status))
+  if e.Message != "" {
+    msg += ": " + e.Message
+  }
+
+  return msg
+}
+
+// statusError reads what an upstream said about its failure: the message
+// field APIs commonly use in JSON error bodies, or else the start of the
+// body.
+func statusError(resp *http.Response) *StatusError {
+  body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+  e := &StatusError{Status: resp.StatusCode}
+
+  var d map[string]interface{}
+  if json.Unmarshal(body, &d) == nil {
+    for _, k := range []string{"message", "reason", "error", "detail", "description"} {
+      if msg, ok := d[k].(string); ok && msg != "" {
+        e.Message = msg
+        return e
+      }
+    }
+  }
+
+  msg := strings.TrimSpace(string(body))
+  if i := strings.IndexByte(msg, '\n'); i >= 0 {
+    msg = msg[:i]
+  }
+
+  if len(msg) > 200 {
+    msg = msg[:200] + "..."
+  }
+
+  e.Message = msg
+  return e
+}
+
+type traceKey struct{}
+
+// traceContext is the W3C trace-context of the incoming request.
+type traceContext struct {
+  TraceID string // 32 hex chars
+  spanID  string // 16 hex chars
+  flags   string
+}
+
+func randomHex(n int) string {
+  b := make([]byte, n)
+  rand.Read(b)
+  return hex.EncodeToString(b)
+}
+
+// WithTrace continues the caller's trace when it sent a valid traceparent
+// header and starts a new one otherwise.
+func WithTrace(r *http.Request) context.Context {
+  tc := traceContext{TraceID: randomHex(16), spanID: randomHex(8), flags: "01"}
+
+  parts := strings.Split(r.Header.Get("traceparent"), "-")
+  if len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 && len(parts[3]) == 2 {
+    tc = traceContext{TraceID: parts[1], spanID: parts[2], flags: parts[3]}
+  }
+
+  return context.WithValue(r.Context(), traceKey{}, tc)
+}
+
+// TraceID is the trace of the request ctx belongs to, if any.
+func TraceID(ctx context.Context) string {
+  tc, _ := ctx.Value(traceKey{}).(traceContext)
+  return tc.TraceID
+}
+
+// InjectTrace propagates the trace to an upstream call as a child span.
+func InjectTrace(ctx context.Context, h http.Header) {
+  tc, ok := ctx.Value(traceKey{}).(traceContext)
+  if !ok {
+    return
+  }
+
+  h.Set("traceparent", "00-"+tc.TraceID+"-"+randomHex(8)+"-"+tc.flags)
+}
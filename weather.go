@@ -0,0 +1,124 @@
+// Package weather looks up the current temperature of a city averaged over
+// several weather providers, the same way the weather-go server does:
+//
+//	c := weather.New(weather.OpenMeteo(), weather.MetNo())
+//	kelvin, err := c.Temperature(ctx, "Oslo,NO")
+package weather
+
+import (
+  "context"
+  "net/http"
+  "time"
+
+  "github.com/im-kulikov/weather-go-external-api/internal/aggregate"
+  "github.com/im-kulikov/weather-go-external-api/internal/geo"
+  "github.com/im-kulikov/weather-go-external-api/internal/providers"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+type (
+  // Provider reports the temperature in kelvin at a location.
+  Provider = providers.Provider
+
+  // Geocoder turns a city query into candidate locations.
+  Geocoder = geo.Geocoder
+
+  // Location is a resolved place.
+  Location = geo.Location
+
+  // Reading is one provider's answer, successful or not.
+  Reading = providers.Reading
+
+  // Attribution credits the source of a reading.
+  Attribution = upstream.Attribution
+
+  // AmbiguousError is returned when several distinct places match a city
+  // equally well; qualify it with a country, e.g. "Paris,US".
+  AmbiguousError = geo.AmbiguousError
+)
+
+var (
+  ErrLocationNotFound = geo.ErrLocationNotFound
+  ErrBadCoordinates   = geo.ErrBadCoordinates
+  ErrBadCity          = geo.ErrBadCity
+  ErrNoProviders      = providers.ErrNoProviders
+
+  // Provider errors wrap these when an upstream rejects the key, doesn't
+  // know the place or throttles us.
+  ErrUnauthorized = providers.ErrUnauthorized
+  ErrCityNotFound = providers.ErrCityNotFound
+  ErrRateLimited  = providers.ErrRateLimited
+)
+
+func OpenWeatherMap(apiKey string) Provider     { return providers.OpenWeatherMap{APIKey: apiKey} }
+func WeatherUnderground(apiKey string) Provider { return providers.WeatherUnderground{APIKey: apiKey} }
+func OpenMeteo() Provider                       { return providers.OpenMeteo{} }
+func MetNo() Provider                           { return providers.MetNo{} }
+
+// UseHTTPClient makes the built-in providers and geocoders call out with
+// hc's transport and timeout, e.g. to go through a proxy. By default they
+// share a client that pools connections per host and times calls out
+// after 10s.
+func UseHTTPClient(hc *http.Client) {
+  upstream.Client.Transport, upstream.Client.Timeout = hc.Transport, hc.Timeout
+}
+
+// Nominatim is the OpenStreetMap geocoder, the default one.
+func Nominatim() Geocoder { return geo.NominatimGeocoder{} }
+
+// OWMGeocoder is the openweathermap.org geocoding API.
+func OWMGeocoder(apiKey string) Geocoder { return geo.OWMGeocoder{APIKey: apiKey} }
+
+// Client averages the temperature reported by its providers.
+type Client struct {
+  Providers []Provider
+  Geocoder  Geocoder
+}
+
+// New is a client of the given providers that resolves cities with
+// Nominatim, caching them for a day.
+func New(ps ...Provider) *Client {
+  return &Client{Providers: ps, Geocoder: geo.NewCachedGeocoder(Nominatim(), 24*time.Hour)}
+}
+
+// Resolve returns the best match for a city, given as "name" or
+// "name,country".
+func (c *Client) Resolve(ctx context.Context, city string) (Location, error) {
+  g := c.Geocoder
+  if g == nil {
+    g = Nominatim()
+  }
+
+  return geo.Resolve(ctx, g, geo.ParseCity(city))
+}
+
+// Temperature is the average temperature in kelvin in city.
+func (c *Client) Temperature(ctx context.Context, city string) (float64, error) {
+  loc, err := c.Resolve(ctx, city)
+  if err != nil {
+    return 0, err
+  }
+
+  return c.TemperatureAt(ctx, loc)
+}
+
+// TemperatureAt is the average temperature in kelvin at loc; any failed
+// provider fails the average and cancels the providers still working.
+func (c *Client) TemperatureAt(ctx context.Context, loc Location) (float64, error) {
+  if len(c.Providers) == 0 {
+    return 0, ErrNoProviders
+  }
+
+  return aggregate.Average(providers.Multi(c.Providers).Gather(ctx, loc, providers.FailFast))
+}
+
+// Readings queries every provider at loc and waits for all of them.
+func (c *Client) Readings(ctx context.Context, loc Location) []Reading {
+  return providers.Multi(c.Providers).Readings(ctx, loc)
+}
+
+// Attributions credits the providers, as their terms require wherever
+// their data is shown.
+func (c *Client) Attributions() []Attribution {
+  return providers.Attributions(c.Providers)
+}
@@ -0,0 +1,117 @@
+// Package weathertest helps test code built on package weather without
+// network access or live API keys: fake providers that play a script of
+// values, errors and latencies, and fixtures that record real upstream
+// responses once and replay them afterwards.
+//
+//	p := weathertest.Script("flaky", weathertest.Value(283.15), weathertest.Fail(errors.New("503")))
+//	c := weather.New(p, weathertest.Fixed("steady", 284))
+//
+//	stop, err := weathertest.Replay("testdata/oslo.json")
+//	defer stop()
+package weathertest
+
+import (
+  "context"
+  "net/http"
+  "sync"
+  "time"
+
+  weather "github.com/im-kulikov/weather-go-external-api"
+  "github.com/im-kulikov/weather-go-external-api/internal/upstream"
+)
+
+// Step is one scripted answer of a fake provider.
+type Step struct {
+  Kelvin  float64
+  Err     error
+  Latency time.Duration // waited before answering, or until the context ends
+}
+
+// Value, Fail and Slow build steps.
+func Value(kelvin float64) Step { return Step{Kelvin: kelvin} }
+func Fail(err error) Step       { return Step{Err: err} }
+
+func Slow(s Step, latency time.Duration) Step {
+  s.Latency = latency
+  return s
+}
+
+// Fake is a provider that answers the i-th call with the i-th step of its
+// script, repeating the last step once the script runs out.
+type Fake struct {
+  name  string
+  steps []Step
+
+  mu    sync.Mutex
+  calls []weather.Location
+}
+
+// Script is a fake provider playing steps.
+func Script(name string, steps ...Step) *Fake {
+  if len(steps) == 0 {
+    steps = []Step{{}}
+  }
+
+  return &Fake{name: name, steps: steps}
+}
+
+// Fixed always reports kelvin.
+func Fixed(name string, kelvin float64) *Fake { return Script(name, Value(kelvin)) }
+
+// Failing always fails with err.
+func Failing(name string, err error) *Fake { return Script(name, Fail(err)) }
+
+func (f *Fake) Name() string { return f.name }
+
+func (f *Fake) Temperature(ctx context.Context, loc weather.Location) (float64, error) {
+  f.mu.Lock()
+  step := f.steps[min(len(f.calls), len(f.steps)-1)]
+  f.calls = append(f.calls, loc)
+  f.mu.Unlock()
+
+  if step.Latency > 0 {
+    t := time.NewTimer(step.Latency)
+    defer t.Stop()
+
+    select {
+    case <-t.C:
+    case <-ctx.Done():
+      return 0, ctx.Err()
+    }
+  }
+
+  return step.Kelvin, step.Err
+}
+
+// Calls are the locations the fake was asked about, in order.
+func (f *Fake) Calls() []weather.Location {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+
+  return append([]weather.Location(nil), f.calls...)
+}
+
+// Record sends upstream calls of the built-in providers and geocoders to
+// the network as usual and writes every exchange to path. Secrets, such as
+// API keys, are replaced by {secret} in the recorded URLs. stop puts the
+// previous transport back.
+func Record(path string, secrets ...string) (stop func()) {
+  return use(upstream.RecordFixtures(path, upstream.Client.Transport, secrets...))
+}
+
+// Replay answers upstream calls from a file written by Record; calls it
+// doesn't have fail. Pass the same secrets as when recording.
+func Replay(path string, secrets ...string) (stop func(), err error) {
+  f, err := upstream.ReplayFixtures(path, secrets...)
+  if err != nil {
+    return nil, err
+  }
+
+  return use(f), nil
+}
+
+func use(t http.RoundTripper) func() {
+  prev := upstream.Client.Transport
+  upstream.Client.Transport = t
+  return func() { upstream.Client.Transport = prev }
+}